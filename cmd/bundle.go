@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// bundleCmd constructs the `bundle` subcommand
+func bundleCmd() *cobra.Command {
+	presetNames := config.ValidPresets()
+
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Build a bundle of artifacts for provisioning machines without network access.",
+		Long: `Build a bundle of artifacts for provisioning machines without network access.
+
+Downloads every snap (with its assertions) and every deb (with its dependencies) that
+'concierge prepare' would install for the configuration or preset, including those for the
+providers and Juju, and writes them into a tarball along with a manifest. Optionally, the OCI
+images used by the k8s provider and the Juju agent binaries are included too.
+
+Extract the tarball on the offline machine, and pass the directory to 'concierge prepare' with
+'--artifacts'.
+		`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; all of these are registered on this command below, so the
+			// error is unreachable.
+			configFile, _ := flags.GetString("config")
+			preset, _ := flags.GetString("preset")
+			output, _ := flags.GetString("output")
+			images, _ := flags.GetBool("images")
+			agents, _ := flags.GetBool("agents")
+
+			if len(preset) > 0 && len(configFile) > 0 {
+				return fmt.Errorf("cannot proceed with both preset and configuration file specified")
+			}
+
+			conf, err := config.NewConfig(cmd, flags)
+			if err != nil {
+				return fmt.Errorf("failed to configure concierge: %w", err)
+			}
+
+			mgr, err := concierge.NewManager(conf)
+			if err != nil {
+				return err
+			}
+
+			return mgr.Bundle(concierge.BundleOptions{Output: output, Images: images, Agents: agents})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("config", "c", "", "path to a specific config file to use")
+	flags.StringP("preset", "p", "", "config preset to use ("+strings.Join(presetNames, " | ")+")")
+	flags.StringP("output", "o", "concierge-bundle.tar.gz", "path of the bundle tarball to write")
+	flags.Bool("images", false, "include the OCI images used by the k8s provider (requires k8s and skopeo)")
+	flags.Bool("agents", false, "include the Juju agent binaries for juju.agent-version")
+	flags.Duration("store-cache-ttl", 0, "cache snap store metadata on disk for this long, e.g. '1h' (disabled by default)")
+	flags.Bool("refresh-store-cache", false, "ignore cached snap store metadata, replacing it with fresh lookups")
+
+	return cmd
+}
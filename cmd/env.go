@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// envCmd constructs the `env` subcommand
+func envCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print environment variables describing the machine prepared by `concierge`.",
+		Long: `Print environment variables describing the machine prepared by 'concierge'.
+
+Reports the Juju controller of each provider, the Juju model, the kubeconfig path and
+context, the endpoints of the providers and the versions of the installed tools, so
+that test frameworks and CI jobs need not hard-code them. For example:
+
+  eval "$(sudo concierge env)"
+  sudo concierge env --format github >> "$GITHUB_ENV"
+		`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+
+			format, _ := cmd.Flags().GetString("format")
+			if !slices.Contains(concierge.EnvFormats, format) {
+				return fmt.Errorf("unknown format '%s', must be one of: %s", format, strings.Join(concierge.EnvFormats, ", "))
+			}
+
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// The environment describes the cached config from prepare, so only the
+			// logging flags are needed here.
+			verbose, _ := flags.GetBool("verbose")
+			trace, _ := flags.GetBool("trace")
+			format, _ := flags.GetString("format")
+
+			mgr, err := concierge.NewManager(&config.Config{Verbose: verbose, Trace: trace})
+			if err != nil {
+				return err
+			}
+
+			env, err := mgr.Environment()
+			if err != nil {
+				return err
+			}
+
+			out, err := env.Render(format)
+			if err != nil {
+				return err
+			}
+
+			fmt.Print(out)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("format", "f", "sh", "output format ("+strings.Join(concierge.EnvFormats, " | ")+")")
+
+	return cmd
+}
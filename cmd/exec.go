@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// execCmd constructs the `exec` subcommand
+func execCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec -- <command> [args...]",
+		Short: "Run a command with access to the providers set up by `concierge prepare`.",
+		Long: `Run a command with access to the providers set up by 'concierge prepare'.
+
+The command runs as the user that 'prepare' set up, with the groups of the enabled
+providers (such as 'lxd' and 'microk8s'), even if the user's session started before
+they were added to them. KUBECONFIG, JUJU_DATA and any configured proxy are set in
+the command's environment. The command's exit code is returned.
+		`,
+		Args:          cobra.MinimumNArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// The command runs against the cached config from prepare, so only the
+			// logging flags are needed here.
+			verbose, _ := flags.GetBool("verbose")
+			trace, _ := flags.GetBool("trace")
+
+			mgr, err := concierge.NewManager(&config.Config{Verbose: verbose, Trace: trace})
+			if err != nil {
+				return err
+			}
+
+			err = mgr.Exec(args)
+			if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
+				os.Exit(exitErr.ExitCode())
+			}
+			return err
+		},
+	}
+
+	// Flags after the command belong to it, even without a "--" separator.
+	cmd.Flags().SetInterspersed(false)
+
+	return cmd
+}
@@ -7,6 +7,7 @@ import (
 	"os/user"
 
 	"github.com/canonical/concierge/internal/securitylog"
+	"github.com/canonical/concierge/internal/system"
 	"github.com/spf13/pflag"
 )
 
@@ -23,12 +24,13 @@ func Execute() {
 	// audit stream stays separate from concierge's stderr; falls back to stderr
 	// if syslog is unreachable.
 	securitylog.ConfigureDefault(fmt.Sprintf("concierge@%s", version))
+	securitylog.SetRedactor(system.Redact)
 
 	cmd := rootCmd()
 
 	err := cmd.Execute()
 	if err != nil {
-		slog.Error("concierge failed", "error", err.Error())
+		slog.Error("concierge failed", "error", system.Redact(err.Error()))
 		os.Exit(1)
 	}
 }
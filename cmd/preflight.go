@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// preflightCmd constructs the `preflight` subcommand
+func preflightCmd() *cobra.Command {
+	presetNames := config.ValidPresets()
+
+	cmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "Check the machine meets the requirements of the configuration.",
+		Long: `Check the machine meets the requirements of the configuration.
+
+Runs the same checks as 'concierge prepare' does before making any changes: free disk space,
+memory, CPUs, architecture, Ubuntu release, systemd, snapd seeding, cgroup v2 and kernel modules.
+Requirements are derived from the providers enabled in the configuration or preset.
+
+Exits with an error if any check fails.
+		`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; "config" and "preset" are both registered on this command
+			// below, so the error is unreachable.
+			configFile, _ := flags.GetString("config")
+			preset, _ := flags.GetString("preset")
+
+			if len(preset) > 0 && len(configFile) > 0 {
+				return fmt.Errorf("cannot proceed with both preset and configuration file specified")
+			}
+
+			conf, err := config.NewConfig(cmd, flags)
+			if err != nil {
+				return fmt.Errorf("failed to configure concierge: %w", err)
+			}
+
+			mgr, err := concierge.NewManager(conf)
+			if err != nil {
+				return err
+			}
+
+			report := mgr.Preflight()
+			fmt.Print(report)
+
+			if failures := report.Failures(); len(failures) > 0 {
+				return fmt.Errorf("%d preflight check(s) failed", len(failures))
+			}
+
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("config", "c", "", "path to a specific config file to use")
+	flags.StringP("preset", "p", "", "config preset to use ("+strings.Join(presetNames, " | ")+")")
+
+	return cmd
+}
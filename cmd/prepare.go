@@ -84,7 +84,7 @@ More information at https://github.com/canonical/concierge.
 	flags.StringSlice(
 		"extra-snaps",
 		[]string{},
-		"comma-separated list of extra snaps to install. E.g. 'astral-uv/latest/edge,jhack'",
+		"comma-separated list of extra snaps to install, optionally with @<revision> and +hold/+devmode. E.g. 'astral-uv/latest/edge,jhack+hold'",
 	)
 
 	flags.StringSlice(
@@ -93,7 +93,14 @@ More information at https://github.com/canonical/concierge.
 		"comma-separated list of extra debs to install. E.g. 'make,python3-tox'",
 	)
 
+	flags.String("artifacts", "", "directory of snap and deb files, described by a manifest, to install without network access")
+
+	flags.Duration("store-cache-ttl", 0, "cache snap store metadata on disk for this long, e.g. '1h' (disabled by default)")
+	flags.Bool("refresh-store-cache", false, "ignore cached snap store metadata, replacing it with fresh lookups")
+	flags.Duration("hold-refreshes", 0, "hold snap auto-refreshes for this long once snapd is ready, e.g. '2h'")
+
 	flags.Bool("dry-run", false, "show what would be done without making changes")
+	flags.Bool("skip-preflight", false, "skip checking the machine meets the requirements of the configuration")
 
 	return cmd
 }
@@ -34,6 +34,10 @@ tools from the [snap store](https://snapcraft.io) or the Ubuntu archive.
 	cmd.AddCommand(restoreCmd())
 	cmd.AddCommand(prepareCmd())
 	cmd.AddCommand(statusCmd())
+	cmd.AddCommand(preflightCmd())
+	cmd.AddCommand(bundleCmd())
+	cmd.AddCommand(execCmd())
+	cmd.AddCommand(envCmd())
 
 	return cmd
 }
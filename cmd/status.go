@@ -15,7 +15,7 @@ func statusCmd() *cobra.Command {
 		Short: "Report the status of `concierge` on the machine.",
 		Long: `Report the status of 'concierge' on the machine.
 
-Reports one of 'provisioning', 'succeeded' or 'failed'.
+Reports one of 'provisioning', 'succeeded', 'failed' or 'restored'.
 		`,
 		SilenceErrors: true,
 		SilenceUsage:  true,
@@ -0,0 +1,118 @@
+// Package ci integrates concierge's output with the CI systems that it commonly runs
+// in, reporting each step of a run in the form that the CI system presents best.
+package ci
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+// Run describes a `concierge prepare` or `concierge restore` run once it is over.
+type Run struct {
+	// Action is either "prepare" or "restore".
+	Action   string
+	Duration time.Duration
+	// Err is the error that the run failed with, if any.
+	Err         error
+	Steps       []Step
+	Tools       []Tool
+	Controllers []Controller
+	// Outputs are the values that later steps of a CI job may use, in order.
+	Outputs []Output
+}
+
+// Step is a part of a run, such as installing the snaps or preparing a provider.
+type Step struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// Tool is a tool installed by concierge, and its version.
+type Tool struct {
+	Name    string
+	Version string
+}
+
+// Controller is a Juju controller bootstrapped by concierge.
+type Controller struct {
+	Provider string
+	Name     string
+	Model    string
+}
+
+// Output is a named value that later steps of a CI job may use.
+type Output struct {
+	Name  string
+	Value string
+}
+
+// Reporter reports the progress and result of a run to a CI system. Steps are
+// reported in groups of those that run concurrently, such as the providers, so
+// that their logs are kept together: each group is started, then ended, before
+// the next starts.
+type Reporter interface {
+	// StartGroup reports that a group of steps has started.
+	StartGroup(name string)
+	// EndGroup reports that a group of steps has ended, and which of them failed.
+	EndGroup(name string, steps []Step)
+	// Finish reports the result of the run.
+	Finish(run *Run) error
+}
+
+// Detect returns the Reporter for the CI system that concierge is running in, or
+// nil if it is not running in one that it integrates with.
+func Detect() Reporter {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return NewGitHub(os.Stderr, os.Getenv("GITHUB_STEP_SUMMARY"), os.Getenv("GITHUB_OUTPUT"))
+	case os.Getenv("GITLAB_CI") == "true":
+		return NewGitLab(os.Stderr)
+	default:
+		return nil
+	}
+}
+
+// failedCommand returns the command that caused an error, if it was caused by one.
+func failedCommand(err error) string {
+	var cmdErr *system.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Command
+	}
+	return ""
+}
+
+// errorMessage returns the message of an error with any secrets redacted, since
+// errors may quote configuration or the output of commands.
+func errorMessage(err error) string {
+	return system.Redact(err.Error())
+}
+
+// result describes the outcome of a run in a few words.
+func (r *Run) result() string {
+	if r.Err != nil {
+		return "failed"
+	}
+	return "succeeded"
+}
+
+// failed reports whether any of the run's steps failed.
+func (r *Run) failed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// roundDuration rounds a duration for display.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,166 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NewGitHub constructs a new GitHub reporter, which writes workflow commands to out,
+// and the step summary and outputs to the files at the specified paths, if set.
+func NewGitHub(out io.Writer, summaryPath, outputPath string) *GitHub {
+	return &GitHub{
+		out:         out,
+		summaryPath: summaryPath,
+		outputPath:  outputPath,
+	}
+}
+
+// GitHub reports a run to GitHub Actions. Each group of steps is a collapsible log
+// group, and a failed step is annotated with its error. Once the run is over, a
+// Markdown summary is added to the job's step summary, and its outputs are set as
+// step outputs.
+type GitHub struct {
+	out         io.Writer
+	summaryPath string
+	outputPath  string
+}
+
+// StartGroup opens a log group for a group of steps.
+func (g *GitHub) StartGroup(name string) {
+	fmt.Fprintf(g.out, "::group::%s\n", escapeData(name))
+}
+
+// EndGroup closes the log group, annotating each failed step with its error.
+func (g *GitHub) EndGroup(name string, steps []Step) {
+	fmt.Fprintln(g.out, "::endgroup::")
+	for _, step := range steps {
+		if step.Err != nil {
+			g.annotate(step.Name, step.Err)
+		}
+	}
+}
+
+// Finish annotates the run with its error, unless a failed step was already
+// annotated with it, then writes the step summary and outputs.
+func (g *GitHub) Finish(run *Run) error {
+	if run.Err != nil && !run.failed() {
+		g.annotate(run.Action, run.Err)
+	}
+
+	if g.summaryPath != "" {
+		err := appendFile(g.summaryPath, githubSummary(run))
+		if err != nil {
+			return fmt.Errorf("failed to write step summary: %w", err)
+		}
+	}
+
+	if g.outputPath != "" {
+		err := appendFile(g.outputPath, githubOutputs(run))
+		if err != nil {
+			return fmt.Errorf("failed to write step outputs: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// annotate emits an error annotation, naming the failed command if there was one.
+func (g *GitHub) annotate(name string, err error) {
+	title := "concierge: " + name + " failed"
+	if command := failedCommand(err); command != "" {
+		title += ": " + command
+	}
+	fmt.Fprintf(g.out, "::error title=%s::%s\n", escapeProperty(title), escapeData(errorMessage(err)))
+}
+
+// githubSummary renders the run as Markdown, for the job's step summary.
+func githubSummary(run *Run) string {
+	b := strings.Builder{}
+
+	fmt.Fprintf(&b, "### `concierge %s` %s in %s\n\n", run.Action, run.result(), roundDuration(run.Duration))
+	if run.Err != nil {
+		fmt.Fprintf(&b, "> [!CAUTION]\n> %s\n\n", escapeMarkdown(errorMessage(run.Err)))
+	}
+
+	if len(run.Steps) > 0 {
+		b.WriteString("| Step | Result | Duration |\n| --- | --- | --- |\n")
+		for _, step := range run.Steps {
+			result := "✅"
+			if step.Err != nil {
+				result = "❌"
+				if command := failedCommand(step.Err); command != "" {
+					result += " `" + escapeMarkdown(command) + "`"
+				}
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", escapeMarkdown(step.Name), result, roundDuration(step.Duration))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(run.Controllers) > 0 {
+		b.WriteString("#### Juju controllers\n\n| Provider | Controller | Model |\n| --- | --- | --- |\n")
+		for _, c := range run.Controllers {
+			fmt.Fprintf(&b, "| %s | `%s` | `%s` |\n", c.Provider, c.Name, c.Model)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(run.Tools) > 0 {
+		b.WriteString("#### Installed tools\n\n| Tool | Version |\n| --- | --- |\n")
+		for _, tool := range run.Tools {
+			fmt.Fprintf(&b, "| %s | %s |\n", tool.Name, escapeMarkdown(tool.Version))
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// githubOutputs renders the run's result and outputs in the format of $GITHUB_OUTPUT.
+func githubOutputs(run *Run) string {
+	outputs := append([]Output{
+		{Name: "result", Value: run.result()},
+		{Name: "duration", Value: fmt.Sprintf("%d", int(run.Duration.Seconds()))},
+	}, run.Outputs...)
+
+	b := strings.Builder{}
+	for _, o := range outputs {
+		if strings.Contains(o.Value, "\n") {
+			fmt.Fprintf(&b, "%s<<CONCIERGE_EOF\n%s\nCONCIERGE_EOF\n", o.Name, o.Value)
+		} else {
+			fmt.Fprintf(&b, "%s=%s\n", o.Name, o.Value)
+		}
+	}
+	return b.String()
+}
+
+// appendFile appends to a file that the CI system provides, which already exists.
+func appendFile(filePath, contents string) error {
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property of a workflow command, such as its title.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// escapeMarkdown keeps text from breaking out of a Markdown table cell or quote.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", `\|`, "\r", "", "\n", " ").Replace(s)
+}
@@ -0,0 +1,124 @@
+package ci
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestGitHubGroups(t *testing.T) {
+	out := bytes.Buffer{}
+	g := NewGitHub(&out, "", "")
+
+	g.StartGroup("Packages")
+	g.EndGroup("Packages", []Step{{Name: "Snap packages"}, {Name: "Apt packages"}})
+	g.StartGroup("Providers")
+	g.EndGroup("Providers", []Step{
+		{Name: "Provider k8s"},
+		{Name: "Provider lxd", Err: fmt.Errorf("failed to init lxd: %w", &system.CommandError{Command: "lxd init --auto", Err: fmt.Errorf("exit status 1")})},
+	})
+
+	expected := "::group::Packages\n::endgroup::\n" +
+		"::group::Providers\n::endgroup::\n" +
+		"::error title=concierge%3A Provider lxd failed%3A lxd init --auto::failed to init lxd: command 'lxd init --auto' failed: exit status 1\n"
+	if out.String() != expected {
+		t.Fatalf("expected: %v, got: %v", expected, out.String())
+	}
+}
+
+func TestGitHubFinish(t *testing.T) {
+	dir := t.TempDir()
+	summaryPath := path.Join(dir, "summary.md")
+	outputPath := path.Join(dir, "output")
+	for _, p := range []string{summaryPath, outputPath} {
+		if err := os.WriteFile(p, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := bytes.Buffer{}
+	g := NewGitHub(&out, summaryPath, outputPath)
+
+	err := g.Finish(&Run{
+		Action:      "prepare",
+		Duration:    90 * time.Second,
+		Steps:       []Step{{Name: "Snap packages", Duration: 12 * time.Second}},
+		Tools:       []Tool{{Name: "juju", Version: "3.6.8"}},
+		Controllers: []Controller{{Provider: "lxd", Name: "concierge-lxd", Model: "testing"}},
+		Outputs:     []Output{{Name: "lxd-controller", Value: "concierge-lxd"}},
+	})
+	if err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+
+	if out.String() != "" {
+		t.Fatalf("expected: %v, got: %v", "", out.String())
+	}
+
+	summary, _ := os.ReadFile(summaryPath)
+	expectedSummary := "### `concierge prepare` succeeded in 1m30s\n\n" +
+		"| Step | Result | Duration |\n| --- | --- | --- |\n| Snap packages | ✅ | 12s |\n\n" +
+		"#### Juju controllers\n\n| Provider | Controller | Model |\n| --- | --- | --- |\n| lxd | `concierge-lxd` | `testing` |\n\n" +
+		"#### Installed tools\n\n| Tool | Version |\n| --- | --- |\n| juju | 3.6.8 |\n\n"
+	if string(summary) != expectedSummary {
+		t.Fatalf("expected: %v, got: %v", expectedSummary, string(summary))
+	}
+
+	outputs, _ := os.ReadFile(outputPath)
+	expectedOutputs := "result=succeeded\nduration=90\nlxd-controller=concierge-lxd\n"
+	if string(outputs) != expectedOutputs {
+		t.Fatalf("expected: %v, got: %v", expectedOutputs, string(outputs))
+	}
+}
+
+func TestGitHubFinishFailed(t *testing.T) {
+	out := bytes.Buffer{}
+	g := NewGitHub(&out, "", "")
+
+	err := g.Finish(&Run{Action: "prepare", Err: fmt.Errorf("preflight checks failed:\n1 check failed")})
+	if err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+
+	expected := "::error title=concierge%3A prepare failed::preflight checks failed:%0A1 check failed\n"
+	if out.String() != expected {
+		t.Fatalf("expected: %v, got: %v", expected, out.String())
+	}
+
+	// A failure already annotated on its step is not annotated again.
+	out.Reset()
+	stepErr := fmt.Errorf("exit status 1")
+	err = g.Finish(&Run{Action: "prepare", Err: stepErr, Steps: []Step{{Name: "Juju", Err: stepErr}}})
+	if err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+	if out.String() != "" {
+		t.Fatalf("expected: %v, got: %v", "", out.String())
+	}
+}
+
+func TestGitHubRedactsErrors(t *testing.T) {
+	system.RegisterSecret("hunter2-registry-password")
+	t.Cleanup(system.ResetSecrets)
+
+	out := bytes.Buffer{}
+	g := NewGitHub(&out, "", "")
+
+	g.EndGroup("Providers", []Step{{Name: "Provider k8s", Err: fmt.Errorf("failed to log in with 'hunter2-registry-password'")}})
+
+	expected := "::endgroup::\n::error title=concierge%3A Provider k8s failed::failed to log in with '[REDACTED]'\n"
+	if out.String() != expected {
+		t.Fatalf("expected: %v, got: %v", expected, out.String())
+	}
+
+	summary := githubSummary(&Run{Action: "prepare", Err: fmt.Errorf("client-key-data: c2VjcmV0a2V5")})
+	if strings.Contains(summary, "c2VjcmV0a2V5") {
+		t.Fatalf("expected client key to be redacted from summary, got: %q", summary)
+	}
+}
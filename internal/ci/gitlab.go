@@ -0,0 +1,94 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// gitlabSectionChars are the characters that are not allowed in a section name.
+var gitlabSectionChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// NewGitLab constructs a new GitLab reporter, which writes to out.
+func NewGitLab(out io.Writer) *GitLab {
+	return &GitLab{out: out, now: time.Now}
+}
+
+// GitLab reports a run to GitLab CI. Each group of steps is a collapsed section of the
+// job log, and each failed step is printed with its error after the section, so that
+// it stays visible. Once the run is over, its summary is printed in a section of its
+// own.
+type GitLab struct {
+	out io.Writer
+	now func() time.Time
+}
+
+// StartGroup opens a collapsed section for a group of steps.
+func (g *GitLab) StartGroup(name string) {
+	fmt.Fprintf(g.out, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", g.now().Unix(), gitlabSection(name), name)
+}
+
+// EndGroup closes the section, printing the error of each failed step.
+func (g *GitLab) EndGroup(name string, steps []Step) {
+	fmt.Fprintf(g.out, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", g.now().Unix(), gitlabSection(name))
+	for _, step := range steps {
+		if step.Err != nil {
+			g.printError(step.Name, step.Err)
+		}
+	}
+}
+
+// Finish prints the run's error, unless a failed step already printed it, then
+// prints a summary of the run.
+func (g *GitLab) Finish(run *Run) error {
+	if run.Err != nil && !run.failed() {
+		g.printError(run.Action, run.Err)
+	}
+
+	name := "concierge_summary"
+	fmt.Fprintf(g.out, "\x1b[0Ksection_start:%d:%s\r\x1b[0Kconcierge %s %s in %s\n", g.now().Unix(), name, run.Action, run.result(), roundDuration(run.Duration))
+	fmt.Fprint(g.out, gitlabSummary(run))
+	fmt.Fprintf(g.out, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", g.now().Unix(), name)
+
+	return nil
+}
+
+// printError prints an error in red, naming the failed command if there was one.
+func (g *GitLab) printError(name string, err error) {
+	message := fmt.Sprintf("ERROR: %s failed: %s", name, errorMessage(err))
+	if command := failedCommand(err); command != "" {
+		message += "\nCommand: " + command
+	}
+	fmt.Fprintf(g.out, "\x1b[31;1m%s\x1b[0m\n", message)
+}
+
+// gitlabSummary renders the run as plain text, for the summary section.
+func gitlabSummary(run *Run) string {
+	b := strings.Builder{}
+
+	for _, step := range run.Steps {
+		result := "ok"
+		if step.Err != nil {
+			result = "failed"
+		}
+		fmt.Fprintf(&b, "  %-40s %-7s %s\n", step.Name, result, roundDuration(step.Duration))
+	}
+
+	for _, c := range run.Controllers {
+		fmt.Fprintf(&b, "  Juju controller %s on %s, model %s\n", c.Name, c.Provider, c.Model)
+	}
+
+	for _, tool := range run.Tools {
+		fmt.Fprintf(&b, "  %s %s\n", tool.Name, tool.Version)
+	}
+
+	return b.String()
+}
+
+// gitlabSection returns the name of the section for a group of steps, which GitLab requires to
+// consist only of lowercase letters, numbers and "_", "." and "-".
+func gitlabSection(name string) string {
+	return "concierge_" + gitlabSectionChars.ReplaceAllString(strings.ToLower(name), "_")
+}
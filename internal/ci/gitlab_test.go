@@ -0,0 +1,70 @@
+package ci
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestGitLabGroups(t *testing.T) {
+	out := bytes.Buffer{}
+	g := NewGitLab(&out)
+	g.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	g.StartGroup("Providers")
+	g.EndGroup("Providers", []Step{
+		{Name: "Provider lxd"},
+		{Name: "Provider microk8s", Err: &system.CommandError{Command: "microk8s status --wait-ready", Err: fmt.Errorf("exit status 1")}},
+	})
+
+	expected := "\x1b[0Ksection_start:1700000000:concierge_providers[collapsed=true]\r\x1b[0KProviders\n" +
+		"\x1b[0Ksection_end:1700000000:concierge_providers\r\x1b[0K\n" +
+		"\x1b[31;1mERROR: Provider microk8s failed: command 'microk8s status --wait-ready' failed: exit status 1\nCommand: microk8s status --wait-ready\x1b[0m\n"
+	if out.String() != expected {
+		t.Fatalf("expected: %q, got: %q", expected, out.String())
+	}
+}
+
+func TestGitLabFinish(t *testing.T) {
+	out := bytes.Buffer{}
+	g := NewGitLab(&out)
+	g.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	err := g.Finish(&Run{
+		Action:      "prepare",
+		Duration:    90 * time.Second,
+		Steps:       []Step{{Name: "Juju", Duration: 2 * time.Minute}},
+		Tools:       []Tool{{Name: "juju", Version: "3.6.8"}},
+		Controllers: []Controller{{Provider: "lxd", Name: "concierge-lxd", Model: "testing"}},
+	})
+	if err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+
+	expected := "\x1b[0Ksection_start:1700000000:concierge_summary\r\x1b[0Kconcierge prepare succeeded in 1m30s\n" +
+		fmt.Sprintf("  %-40s %-7s %s\n", "Juju", "ok", "2m0s") +
+		"  Juju controller concierge-lxd on lxd, model testing\n" +
+		"  juju 3.6.8\n" +
+		"\x1b[0Ksection_end:1700000000:concierge_summary\r\x1b[0K\n"
+	if out.String() != expected {
+		t.Fatalf("expected: %q, got: %q", expected, out.String())
+	}
+}
+
+func TestGitLabRedactsErrors(t *testing.T) {
+	system.RegisterSecret("hunter2-registry-password")
+	t.Cleanup(system.ResetSecrets)
+
+	out := bytes.Buffer{}
+	g := NewGitLab(&out)
+
+	g.printError("Provider k8s", fmt.Errorf("failed to log in with 'hunter2-registry-password'"))
+
+	expected := "\x1b[31;1mERROR: Provider k8s failed: failed to log in with '[REDACTED]'\x1b[0m\n"
+	if out.String() != expected {
+		t.Fatalf("expected: %q, got: %q", expected, out.String())
+	}
+}
@@ -0,0 +1,185 @@
+package concierge
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/canonical/concierge/internal/juju"
+	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// BundleOptions configures the contents of an offline provisioning bundle.
+type BundleOptions struct {
+	// Output is the path of the tarball to write.
+	Output string
+	// Images includes the OCI images that the k8s provider runs, which it side-loads
+	// into containerd.
+	Images bool
+	// Agents includes the Juju agent binaries of the configured agent version.
+	Agents bool
+}
+
+// Bundle downloads every snap and deb that the plan would install, and optionally
+// the OCI images and Juju agent binaries it needs, then writes them into a tarball
+// along with a manifest. Once extracted, the tarball is an artifacts directory that
+// `concierge prepare --artifacts` can provision a machine from without network access.
+func (p *Plan) Bundle(opts BundleOptions) error {
+	err := p.validate()
+	if err != nil {
+		return fmt.Errorf("failed to validate plan: %w", err)
+	}
+
+	// The bundle is staged in a directory alongside the tarball.
+	dir := opts.Output + ".d"
+	err = p.system.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	manifest, err := p.downloadArtifacts(dir, opts)
+	if err != nil {
+		return err
+	}
+
+	contents, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifacts manifest: %w", err)
+	}
+
+	err = p.system.WriteFile(path.Join(dir, packages.ManifestFile), contents, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write artifacts manifest: %w", err)
+	}
+
+	_, err = p.system.Run(system.NewCommand("tar", []string{"-czf", opts.Output, "-C", dir, "."}))
+	if err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	err = p.system.RemovePath(dir)
+	if err != nil {
+		return fmt.Errorf("failed to remove bundle directory: %w", err)
+	}
+
+	slog.Info("Wrote offline provisioning bundle", "path", opts.Output, "snaps", len(manifest.Snaps), "debs", len(manifest.Debs))
+	return nil
+}
+
+// downloadArtifacts downloads the contents of the bundle into dir, returning the
+// manifest that describes them.
+func (p *Plan) downloadArtifacts(dir string, opts BundleOptions) (*packages.Manifest, error) {
+	snaps, debs := p.packages()
+	manifest := &packages.Manifest{}
+
+	var err error
+	manifest.Snaps, err = packages.NewSnapHandler(p.system, snaps).Download(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snaps: %w", err)
+	}
+
+	manifest.Debs, err = packages.NewDebHandler(p.system, debs).Download(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download debs: %w", err)
+	}
+
+	if opts.Images {
+		manifest.Images, err = p.downloadImages(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download OCI images: %w", err)
+		}
+	}
+
+	if opts.Agents {
+		manifest.Agents, err = p.downloadAgents(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download Juju agent binaries: %w", err)
+		}
+	}
+
+	return manifest, nil
+}
+
+// packages returns every snap and deb that the plan installs, including those
+// installed for the providers and Juju.
+func (p *Plan) packages() ([]*system.Snap, []*packages.Deb) {
+	snaps := append([]*system.Snap{}, p.Snaps...)
+	debs := append([]*packages.Deb{}, p.Debs...)
+
+	for _, provider := range p.Providers {
+		providerSnaps, providerDebs := provider.Packages()
+		snaps = append(snaps, providerSnaps...)
+		debs = append(debs, providerDebs...)
+	}
+
+	if !p.config.Juju.Disable {
+		snaps = append(snaps, juju.NewJujuHandler(p.config, p.system, p.Providers).Snaps()...)
+	}
+
+	return snaps, debs
+}
+
+// downloadImages saves the OCI images that the k8s provider runs as archives with
+// `skopeo`, in the docker-archive format, which keeps the name of the image so that
+// containerd imports it under that name. The images are listed by `k8s list-images`,
+// so the k8s snap must be installed on the machine building the bundle.
+func (p *Plan) downloadImages(dir string) ([]packages.ImageArtifact, error) {
+	images := []packages.ImageArtifact{}
+	if !p.config.Providers.K8s.Enable {
+		slog.Warn("Skipping OCI images, since the k8s provider is not enabled")
+		return images, nil
+	}
+
+	err := p.system.MkdirAll(path.Join(dir, "images"), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create images directory: %w", err)
+	}
+
+	cmd := system.NewCommand("k8s", []string{"list-images"})
+	cmd.ReadOnly = true
+	output, err := p.system.Run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list k8s images: %w", err)
+	}
+
+	for _, image := range strings.Fields(string(output)) {
+		file := path.Join("images", strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image)+".tar")
+
+		cmd := system.NewCommand("skopeo", []string{"copy", "docker://" + image, "docker-archive:" + path.Join(dir, file) + ":" + image})
+		_, err := system.RunWithRetries(p.system, cmd, 5*time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download image '%s': %w", image, err)
+		}
+
+		images = append(images, packages.ImageArtifact{Name: image, File: file})
+		slog.Info("Downloaded OCI image", "image", image)
+	}
+
+	return images, nil
+}
+
+// downloadAgents downloads the Juju agent binaries of the configured agent version,
+// along with their metadata, into the "agents" subdirectory of dir.
+func (p *Plan) downloadAgents(dir string) (string, error) {
+	if p.config.Juju.Disable {
+		return "", fmt.Errorf("juju is disabled")
+	}
+	if p.config.Juju.AgentVersion == "" {
+		return "", fmt.Errorf("juju.agent-version must be set to bundle agent binaries")
+	}
+
+	cmd := system.NewCommand("juju", []string{
+		"sync-agent-binary", "--agent-version", p.config.Juju.AgentVersion, "--local-dir", path.Join(dir, "agents"),
+	})
+	_, err := system.RunWithRetries(p.system, cmd, 5*time.Minute)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Downloaded Juju agent binaries", "version", p.config.Juju.AgentVersion)
+	return "agents", nil
+}
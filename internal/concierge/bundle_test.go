@@ -0,0 +1,109 @@
+package concierge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPlanBundle(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juju.Channel = "3.6/stable"
+	cfg.Juju.AgentVersion = "3.6.8"
+	cfg.Providers.LXD.Enable = true
+	cfg.Providers.LXD.Channel = "5.21/stable"
+	cfg.Host.Snaps = map[string]config.SnapConfig{"jq": {}}
+
+	r := system.NewMockSystem()
+	for _, download := range []struct{ command, file, meta string }{
+		{"snap download jq --target-directory bundle.tar.gz.d/snaps", "jq_6", "base: core22"},
+		{"snap download lxd --target-directory bundle.tar.gz.d/snaps --channel 5.21/stable", "lxd_33110", "base: core22"},
+		{"snap download juju --target-directory bundle.tar.gz.d/snaps --channel 3.6/stable", "juju_29241", "base: core24"},
+		{"snap download snapd --target-directory bundle.tar.gz.d/snaps", "snapd_24505", "type: snapd"},
+		{"snap download core22 --target-directory bundle.tar.gz.d/snaps", "core22_1981", "type: base"},
+		{"snap download core24 --target-directory bundle.tar.gz.d/snaps", "core24_1055", "type: base"},
+	} {
+		r.MockCommandReturn(download.command, []byte("snap ack "+download.file+".assert\nsnap install "+download.file+".snap\n"), nil)
+		r.MockCommandReturn("unsquashfs -cat bundle.tar.gz.d/snaps/"+download.file+".snap meta/snap.yaml", []byte(download.meta+"\n"), nil)
+	}
+
+	err := NewPlan(cfg, r).Bundle(BundleOptions{Output: "bundle.tar.gz", Agents: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expectedCommands := []string{
+		"snap download jq --target-directory bundle.tar.gz.d/snaps",
+		"snap download lxd --target-directory bundle.tar.gz.d/snaps --channel 5.21/stable",
+		"snap download juju --target-directory bundle.tar.gz.d/snaps --channel 3.6/stable",
+		"unsquashfs -cat bundle.tar.gz.d/snaps/jq_6.snap meta/snap.yaml",
+		"snap download snapd --target-directory bundle.tar.gz.d/snaps",
+		"snap download core22 --target-directory bundle.tar.gz.d/snaps",
+		"unsquashfs -cat bundle.tar.gz.d/snaps/lxd_33110.snap meta/snap.yaml",
+		"unsquashfs -cat bundle.tar.gz.d/snaps/juju_29241.snap meta/snap.yaml",
+		"snap download core24 --target-directory bundle.tar.gz.d/snaps",
+		"unsquashfs -cat bundle.tar.gz.d/snaps/snapd_24505.snap meta/snap.yaml",
+		"unsquashfs -cat bundle.tar.gz.d/snaps/core22_1981.snap meta/snap.yaml",
+		"unsquashfs -cat bundle.tar.gz.d/snaps/core24_1055.snap meta/snap.yaml",
+		"juju sync-agent-binary --agent-version 3.6.8 --local-dir bundle.tar.gz.d/agents",
+		"tar -czf bundle.tar.gz -C bundle.tar.gz.d .",
+	}
+	if !reflect.DeepEqual(expectedCommands, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, r.ExecutedCommands)
+	}
+
+	var manifest packages.Manifest
+	err = yaml.Unmarshal([]byte(r.CreatedFiles["bundle.tar.gz.d/manifest.yaml"]), &manifest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	names := []string{}
+	for _, s := range manifest.Snaps {
+		names = append(names, s.Name+"="+s.Revision)
+	}
+	if expected := []string{"jq=6", "lxd=33110", "juju=29241", "snapd=24505", "core22=1981", "core24=1055"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("expected: %v, got: %v", expected, names)
+	}
+	if manifest.Agents != "agents" {
+		t.Fatalf("expected: %v, got: %v", "agents", manifest.Agents)
+	}
+
+	if !reflect.DeepEqual([]string{"bundle.tar.gz.d"}, r.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", []string{"bundle.tar.gz.d"}, r.RemovedPaths)
+	}
+}
+
+func TestPlanDownloadImages(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+
+	r := system.NewMockSystem()
+	r.MockCommandReturn("k8s list-images", []byte("ghcr.io/canonical/coredns:1.11.3\nghcr.io/canonical/metrics-server:0.7.0\n"), nil)
+
+	images, err := NewPlan(cfg, r).downloadImages("bundle.d")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expectedCommands := []string{
+		"k8s list-images",
+		"skopeo copy docker://ghcr.io/canonical/coredns:1.11.3 docker-archive:bundle.d/images/ghcr.io_canonical_coredns_1.11.3.tar:ghcr.io/canonical/coredns:1.11.3",
+		"skopeo copy docker://ghcr.io/canonical/metrics-server:0.7.0 docker-archive:bundle.d/images/ghcr.io_canonical_metrics-server_0.7.0.tar:ghcr.io/canonical/metrics-server:0.7.0",
+	}
+	if !reflect.DeepEqual(expectedCommands, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, r.ExecutedCommands)
+	}
+
+	expectedImages := []packages.ImageArtifact{
+		{Name: "ghcr.io/canonical/coredns:1.11.3", File: "images/ghcr.io_canonical_coredns_1.11.3.tar"},
+		{Name: "ghcr.io/canonical/metrics-server:0.7.0", File: "images/ghcr.io_canonical_metrics-server_0.7.0.tar"},
+	}
+	if !reflect.DeepEqual(expectedImages, images) {
+		t.Fatalf("expected: %v, got: %v", expectedImages, images)
+	}
+}
@@ -0,0 +1,111 @@
+package concierge
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+// caCertificatesDir is the directory under which the host's trust store picks up
+// additional CA certificates. `update-ca-certificates` searches it recursively, so
+// concierge keeps its certificates in a subdirectory that can be removed wholesale.
+const caCertificatesDir = "/usr/local/share/ca-certificates/concierge"
+
+// configureCACertificates adds the configured CA certificates to the host's trust
+// store, and to snapd's, so that downloads through a TLS-intercepting proxy succeed.
+// This happens before any packages are installed. The providers and Juju install
+// the certificates into their own trust stores as they are prepared.
+func (p *Plan) configureCACertificates() error {
+	if len(p.config.Host.CACertificates) == 0 {
+		return nil
+	}
+
+	certs, err := system.LoadCACertificates(p.system, p.config.Host.CACertificates)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Installing CA certificates", "count", len(certs))
+
+	err = p.system.MkdirAll(caCertificatesDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", caCertificatesDir, err)
+	}
+
+	snapArgs := []string{"set", "system"}
+	names := []string{}
+
+	for _, cert := range certs {
+		certPath := path.Join(caCertificatesDir, cert.Name+".crt")
+		err = p.system.WriteFile(certPath, cert.PEM, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write CA certificate '%s': %w", certPath, err)
+		}
+
+		snapArgs = append(snapArgs, fmt.Sprintf("store-certs.%s=%s", cert.Name, cert.PEM))
+		names = append(names, cert.Name)
+	}
+
+	// Record what was installed before changing the trust stores, so that a
+	// failure part way through is still cleaned up by restore.
+	p.recordCACertificates(names)
+
+	_, err = p.system.Run(system.NewCommand("update-ca-certificates", []string{}))
+	if err != nil {
+		return fmt.Errorf("failed to update host CA certificates: %w", err)
+	}
+
+	_, err = system.RunExclusive(p.system, system.NewCommand("snap", snapArgs))
+	if err != nil {
+		return fmt.Errorf("failed to add CA certificates to snapd: %w", err)
+	}
+
+	return nil
+}
+
+// recordCACertificates adds the names of installed certificates to the runtime
+// state, ignoring any recorded by a previous run.
+func (p *Plan) recordCACertificates(names []string) {
+	for _, name := range names {
+		if !slices.Contains(p.config.State.CACertificates, name) {
+			p.config.State.CACertificates = append(p.config.State.CACertificates, name)
+		}
+	}
+}
+
+// restoreCACertificates removes the CA certificates recorded in the runtime state
+// from the host's and snapd's trust stores.
+func (p *Plan) restoreCACertificates() error {
+	names := p.config.State.CACertificates
+	if len(names) == 0 {
+		return nil
+	}
+
+	err := p.system.RemovePath(caCertificatesDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove '%s': %w", caCertificatesDir, err)
+	}
+
+	_, err = p.system.Run(system.NewCommand("update-ca-certificates", []string{}))
+	if err != nil {
+		return fmt.Errorf("failed to update host CA certificates: %w", err)
+	}
+
+	snapArgs := []string{"unset", "system"}
+	for _, name := range names {
+		snapArgs = append(snapArgs, "store-certs."+name)
+	}
+
+	_, err = system.RunExclusive(p.system, system.NewCommand("snap", snapArgs))
+	if err != nil {
+		return fmt.Errorf("failed to remove CA certificates from snapd: %w", err)
+	}
+
+	p.config.State.CACertificates = nil
+
+	slog.Info("Removed CA certificates", "count", len(names))
+	return nil
+}
@@ -0,0 +1,97 @@
+package concierge
+
+import (
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestConfigureCACertificates(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Host.CACertificates = []string{"corp-ca.pem", system.MockCACertificate}
+
+	sys := system.NewMockSystem()
+	sys.MockFile("corp-ca.pem", []byte(system.MockCACertificate))
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.configureCACertificates(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := map[string]string{
+		path.Join(caCertificatesDir, "concierge-0.crt"): system.MockCACertificate,
+		path.Join(caCertificatesDir, "concierge-1.crt"): system.MockCACertificate,
+	}
+	if !reflect.DeepEqual(expectedFiles, sys.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, sys.CreatedFiles)
+	}
+
+	snapSet := system.NewCommand("snap", []string{
+		"set", "system",
+		"store-certs.concierge-0=" + system.MockCACertificate,
+		"store-certs.concierge-1=" + system.MockCACertificate,
+	})
+	expectedCommands := []string{"update-ca-certificates", snapSet.CommandString()}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedState := []string{"concierge-0", "concierge-1"}
+	if !reflect.DeepEqual(expectedState, cfg.State.CACertificates) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.CACertificates)
+	}
+
+	// Running prepare again must not duplicate the recorded certificates.
+	if err := plan.configureCACertificates(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedState, cfg.State.CACertificates) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.CACertificates)
+	}
+}
+
+func TestRestoreCACertificates(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.State.CACertificates = []string{"concierge-0", "concierge-1"}
+
+	sys := system.NewMockSystem()
+	if err := NewPlan(cfg, sys).restoreCACertificates(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"update-ca-certificates",
+		"snap unset system store-certs.concierge-0 store-certs.concierge-1",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedRemoved := []string{caCertificatesDir}
+	if !reflect.DeepEqual(expectedRemoved, sys.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", expectedRemoved, sys.RemovedPaths)
+	}
+
+	if cfg.State.CACertificates != nil {
+		t.Fatalf("expected recorded CA certificates to be cleared, got: %v", cfg.State.CACertificates)
+	}
+}
+
+func TestCACertificatesValidator(t *testing.T) {
+	sys := system.NewMockSystem()
+	sys.MockFile("bad.pem", []byte("not a certificate"))
+
+	cfg := &config.Config{}
+	cfg.Host.CACertificates = []string{"bad.pem"}
+	if err := validateCACertificates(NewPlan(cfg, sys)); err == nil {
+		t.Fatalf("expected invalid CA certificate to be rejected")
+	}
+
+	cfg.Host.CACertificates = []string{system.MockCACertificate}
+	if err := validateCACertificates(NewPlan(cfg, sys)); err != nil {
+		t.Fatalf("expected valid CA certificate to be accepted, got: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package concierge
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// hostConflict is a known piece of host software that conflicts with one or more
+// of concierge's providers.
+type hostConflict struct {
+	// name is the key used to configure the conflict's policy in `host.conflicts`.
+	name string
+	// defaultPolicy is applied when the config does not specify a policy.
+	defaultPolicy config.ConflictPolicy
+	// policies lists the policies that can be applied to the conflict.
+	policies []config.ConflictPolicy
+	// appliesTo reports whether the conflict is relevant to the plan.
+	appliesTo func(p *Plan) bool
+	// detect inspects the live host, returning the conflicts found.
+	detect func(p *Plan) ([]detectedConflict, error)
+}
+
+// detectedConflict is a conflict found on the host, along with the details
+// needed to stop or remove it, and later put it back.
+type detectedConflict struct {
+	detail string
+	record config.ResolvedConflict
+}
+
+// allPolicies is the set of policies that can be applied to installed software.
+var allPolicies = []config.ConflictPolicy{
+	config.ConflictFail, config.ConflictStop, config.ConflictRemove, config.ConflictIgnore,
+}
+
+// hostConflicts is the catalogue of conflicts that concierge checks for before provisioning.
+var hostConflicts = []hostConflict{
+	{
+		// Docker's containerd and iptables rules break the k8s snap's bootstrap.
+		name:          "docker",
+		defaultPolicy: config.ConflictStop,
+		policies:      allPolicies,
+		appliesTo:     func(p *Plan) bool { return p.hasProvider("k8s") },
+		detect:        func(p *Plan) ([]detectedConflict, error) { return detectDocker(p.system) },
+	},
+	{
+		// A MicroK8s installed outside of concierge competes with K8s for the
+		// host's networking and container runtime.
+		name:          "microk8s",
+		defaultPolicy: config.ConflictFail,
+		policies:      allPolicies,
+		appliesTo:     func(p *Plan) bool { return p.hasProvider("k8s") },
+		detect:        func(p *Plan) ([]detectedConflict, error) { return detectSnap(p.system, "microk8s") },
+	},
+	{
+		// Likewise, a K8s installed outside of concierge competes with MicroK8s.
+		name:          "k8s",
+		defaultPolicy: config.ConflictFail,
+		policies:      allPolicies,
+		appliesTo:     func(p *Plan) bool { return p.hasProvider("microk8s") },
+		detect:        func(p *Plan) ([]detectedConflict, error) { return detectSnap(p.system, "k8s") },
+	},
+	{
+		// Ports needed by the Kubernetes API servers and the Juju controller. An
+		// arbitrary process cannot be safely stopped, so only fail or ignore apply.
+		name:          "ports",
+		defaultPolicy: config.ConflictFail,
+		policies:      []config.ConflictPolicy{config.ConflictFail, config.ConflictIgnore},
+		appliesTo:     func(p *Plan) bool { return len(p.requiredPorts()) > 0 },
+		detect:        detectPorts,
+	},
+}
+
+// portOwnerPattern extracts the process name from `ss -p` output, e.g.
+// `users:(("kube-apiserver",pid=1234,fd=3))`.
+var portOwnerPattern = regexp.MustCompile(`users:\(\("([^"]+)"`)
+
+// resolveConflicts inspects the host for software that conflicts with the plan,
+// and applies the configured policy to each conflict found. Changes are recorded
+// in the runtime state so that restoreConflicts can undo them.
+func (p *Plan) resolveConflicts() error {
+	failures := []string{}
+
+	for _, c := range hostConflicts {
+		if !c.appliesTo(p) {
+			continue
+		}
+
+		found, err := c.detect(p)
+		if err != nil {
+			return fmt.Errorf("failed to check for conflicting %s: %w", c.name, err)
+		}
+
+		policy := p.conflictPolicy(c)
+		for _, d := range found {
+			slog.Info("Conflicting host software found", "conflict", c.name, "detail", d.detail, "policy", policy)
+
+			switch policy {
+			case config.ConflictIgnore:
+				continue
+			case config.ConflictFail:
+				failures = append(failures, fmt.Sprintf("%s (set host.conflicts.%s to one of: %s)", d.detail, c.name, policyList(c.policies)))
+				continue
+			}
+
+			d.record.Name = c.name
+			d.record.Policy = policy
+			if err := p.applyConflictPolicy(d.record); err != nil {
+				return fmt.Errorf("failed to %s conflicting %s: %w", policy, c.name, err)
+			}
+			p.recordConflict(d.record)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("conflicting software found on host: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// restoreConflicts puts back any conflicting software that was stopped or removed
+// during `prepare`, in the reverse order to which it was resolved.
+func (p *Plan) restoreConflicts() error {
+	conflicts := p.config.State.Conflicts
+	for i := len(conflicts) - 1; i >= 0; i-- {
+		c := conflicts[i]
+
+		var cmds []*system.Command
+		switch c.Policy {
+		case config.ConflictStop:
+			if c.Snap != "" {
+				cmds = append(cmds, system.NewCommand("snap", []string{"enable", c.Snap}))
+			}
+			if len(c.Services) > 0 {
+				cmds = append(cmds, system.NewCommand("systemctl", append([]string{"start"}, c.Services...)))
+			}
+		case config.ConflictRemove:
+			if c.Snap != "" {
+				snap := system.NewSnap(c.Snap, c.Channel, nil)
+				if err := packages.NewSnapHandler(p.system, []*system.Snap{snap}).Prepare(); err != nil {
+					return fmt.Errorf("failed to reinstall conflicting %s: %w", c.Name, err)
+				}
+			}
+			if len(c.Packages) > 0 {
+				debs := []*packages.Deb{}
+				for _, name := range c.Packages {
+					debs = append(debs, packages.NewDeb(name))
+				}
+				if err := packages.NewDebHandler(p.system, debs).Prepare(); err != nil {
+					return fmt.Errorf("failed to reinstall conflicting %s: %w", c.Name, err)
+				}
+			}
+		}
+
+		for _, cmd := range cmds {
+			if _, err := system.RunExclusive(p.system, cmd); err != nil {
+				return fmt.Errorf("failed to restore conflicting %s: %w", c.Name, err)
+			}
+		}
+
+		slog.Info("Restored conflicting host software", "conflict", c.Name, "policy", c.Policy)
+	}
+
+	p.config.State.Conflicts = nil
+	return nil
+}
+
+// applyConflictPolicy stops or removes a conflicting piece of software.
+func (p *Plan) applyConflictPolicy(c config.ResolvedConflict) error {
+	var cmds []*system.Command
+
+	switch c.Policy {
+	case config.ConflictStop:
+		if c.Snap != "" {
+			cmds = append(cmds, system.NewCommand("snap", []string{"disable", c.Snap}))
+		}
+		if len(c.Services) > 0 {
+			cmds = append(cmds, system.NewCommand("systemctl", append([]string{"stop"}, c.Services...)))
+		}
+	case config.ConflictRemove:
+		if c.Snap != "" {
+			// Without --purge, snapd keeps a snapshot of the snap's data.
+			cmds = append(cmds, system.NewCommand("snap", []string{"remove", c.Snap}))
+		}
+	}
+
+	for _, cmd := range cmds {
+		if _, err := system.RunExclusive(p.system, cmd); err != nil {
+			return err
+		}
+	}
+
+	if c.Policy == config.ConflictRemove && len(c.Packages) > 0 {
+		debs := []*packages.Deb{}
+		for _, name := range c.Packages {
+			debs = append(debs, packages.NewDeb(name))
+		}
+		if err := packages.NewDebHandler(p.system, debs).Restore(); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Resolved conflicting host software", "conflict", c.Name, "policy", c.Policy)
+	return nil
+}
+
+// recordConflict adds a resolved conflict to the runtime state, unless an
+// identical change was already recorded by a previous `prepare`.
+func (p *Plan) recordConflict(c config.ResolvedConflict) {
+	if slices.ContainsFunc(p.config.State.Conflicts, func(existing config.ResolvedConflict) bool {
+		return existing.Name == c.Name && existing.Snap == c.Snap && existing.Policy == c.Policy
+	}) {
+		return
+	}
+	p.config.State.Conflicts = append(p.config.State.Conflicts, c)
+}
+
+// conflictPolicy returns the configured policy for a conflict, or its default.
+func (p *Plan) conflictPolicy(c hostConflict) config.ConflictPolicy {
+	if policy, ok := p.config.Host.Conflicts[c.name]; ok && policy != "" {
+		return policy
+	}
+	return c.defaultPolicy
+}
+
+// hasProvider reports whether the named provider is enabled in the plan.
+func (p *Plan) hasProvider(name string) bool {
+	return slices.ContainsFunc(p.Providers, func(provider providers.Provider) bool { return provider.Name() == name })
+}
+
+// requiredPorts maps each host port needed by the plan to the names of the
+// processes that are expected to hold it (for example, on a re-run of `prepare`).
+func (p *Plan) requiredPorts() map[int][]string {
+	ports := map[int][]string{}
+
+	if p.hasProvider("k8s") {
+		ports[6443] = []string{"kube-apiserver", "k8s-apiserver-proxy"}
+	}
+	if p.hasProvider("microk8s") {
+		ports[16443] = []string{"kubelite", "kube-apiserver"}
+	}
+	if !p.config.Juju.Disable && slices.ContainsFunc(p.Providers, providers.Provider.Bootstrap) {
+		ports[17070] = []string{"jujud"}
+	}
+
+	return ports
+}
+
+// detectPorts reports any required port that is held by an unexpected process.
+func detectPorts(p *Plan) ([]detectedConflict, error) {
+	ports := p.requiredPorts()
+
+	numbers := make([]int, 0, len(ports))
+	for port := range ports {
+		numbers = append(numbers, port)
+	}
+	slices.Sort(numbers)
+
+	found := []detectedConflict{}
+	for _, port := range numbers {
+		cmd := system.NewCommand("ss", []string{"-Hltnp", fmt.Sprintf("sport = :%d", port)})
+		cmd.ReadOnly = true
+		output, err := p.system.Run(cmd)
+		if err != nil || strings.TrimSpace(string(output)) == "" {
+			continue
+		}
+
+		owner := "an unknown process"
+		if m := portOwnerPattern.FindSubmatch(output); m != nil {
+			owner = string(m[1])
+			if slices.Contains(ports[port], owner) {
+				continue
+			}
+		}
+
+		found = append(found, detectedConflict{detail: fmt.Sprintf("port %d is in use by %s", port, owner)})
+	}
+
+	return found, nil
+}
+
+// detectDocker reports whether Docker is installed and running, either from the
+// snap or from the archive.
+func detectDocker(w system.Worker) ([]detectedConflict, error) {
+	snapInfo, err := w.LocalSnapInfo("docker")
+	if err != nil {
+		return nil, err
+	}
+
+	if snapInfo.Installed && snapInfo.Active {
+		return []detectedConflict{{
+			detail: "the docker snap is installed",
+			record: config.ResolvedConflict{Snap: "docker", Channel: snapInfo.TrackingChannel, Classic: snapInfo.Classic},
+		}}, nil
+	}
+
+	cmd := system.NewCommand("systemctl", []string{"is-active", "docker.service"})
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `inactive|unknown|failed`
+	output, err := w.Run(cmd)
+	if err != nil || strings.TrimSpace(string(output)) != "active" {
+		return nil, nil
+	}
+
+	return []detectedConflict{{
+		detail: "the docker service is running",
+		record: config.ResolvedConflict{
+			Services: []string{"docker.socket", "docker.service"},
+			Packages: packages.InstalledDebs(w, "docker.io", "docker-ce", "docker-ce-cli", "containerd.io"),
+		},
+	}}, nil
+}
+
+// detectSnap reports whether the named snap is installed.
+func detectSnap(w system.Worker, name string) ([]detectedConflict, error) {
+	snapInfo, err := w.LocalSnapInfo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !snapInfo.Installed {
+		return nil, nil
+	}
+
+	return []detectedConflict{{
+		detail: fmt.Sprintf("the %s snap is installed", name),
+		record: config.ResolvedConflict{Snap: name, Channel: snapInfo.TrackingChannel, Classic: snapInfo.Classic},
+	}}, nil
+}
+
+// policyList renders a list of policies for use in error messages.
+func policyList(policies []config.ConflictPolicy) string {
+	names := make([]string, 0, len(policies))
+	for _, p := range policies {
+		names = append(names, string(p))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,152 @@
+package concierge
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestResolveConflictsStopsDocker(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("systemctl is-active docker.service", []byte("active\n"), nil)
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.resolveConflicts(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"systemctl is-active docker.service",
+		"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' docker.io docker-ce docker-ce-cli containerd.io",
+		"systemctl stop docker.socket docker.service",
+		"ss -Hltnp 'sport = :6443'",
+	}
+	if !reflect.DeepEqual(expected, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, sys.ExecutedCommands)
+	}
+
+	if len(cfg.State.Conflicts) != 1 || cfg.State.Conflicts[0].Name != "docker" {
+		t.Fatalf("expected docker conflict to be recorded, got: %+v", cfg.State.Conflicts)
+	}
+
+	sys.ExecutedCommands = nil
+	if err := plan.restoreConflicts(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{"systemctl start docker.socket docker.service"}
+	if !reflect.DeepEqual(expected, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, sys.ExecutedCommands)
+	}
+	if cfg.State.Conflicts != nil {
+		t.Fatalf("expected recorded conflicts to be cleared, got: %+v", cfg.State.Conflicts)
+	}
+}
+
+func TestResolveConflictsFailsOnForeignMicroK8s(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+
+	sys := system.NewMockSystem()
+	sys.MockSnapStoreLookup("microk8s", "1.31/stable", true, true)
+
+	err := NewPlan(cfg, sys).resolveConflicts()
+	if err == nil || !strings.Contains(err.Error(), "host.conflicts.microk8s") {
+		t.Fatalf("expected actionable conflict error, got: %v", err)
+	}
+}
+
+func TestResolveConflictsRemovesMicroK8s(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+	cfg.Host.Conflicts = map[string]config.ConflictPolicy{"microk8s": config.ConflictRemove}
+
+	sys := system.NewMockSystem()
+	sys.MockSnapStoreLookup("microk8s", "1.31/stable", true, true)
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.resolveConflicts(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Contains(sys.ExecutedCommands, "snap remove microk8s") {
+		t.Fatalf("expected microk8s to be removed, got: %v", sys.ExecutedCommands)
+	}
+
+	// Once removed, the snap is no longer installed; restore reinstalls it
+	// from the channel it was tracking.
+	sys.MockSnapStoreLookup("microk8s", "", true, false)
+	sys.ExecutedCommands = nil
+	if err := plan.restoreConflicts(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"snap install microk8s --channel 1.31/stable --classic"}
+	if !reflect.DeepEqual(expected, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, sys.ExecutedCommands)
+	}
+}
+
+func TestResolveConflictsPorts(t *testing.T) {
+	type test struct {
+		owner     string
+		expectErr bool
+	}
+
+	tests := []test{
+		{owner: "kube-apiserver", expectErr: false},
+		{owner: "nginx", expectErr: true},
+	}
+
+	for _, tc := range tests {
+		cfg := &config.Config{}
+		cfg.Providers.K8s.Enable = true
+
+		sys := system.NewMockSystem()
+		sys.MockCommandReturn(
+			"ss -Hltnp 'sport = :6443'",
+			fmt.Appendf(nil, `LISTEN 0 4096 *:6443 *:* users:(("%s",pid=1234,fd=3))`, tc.owner),
+			nil,
+		)
+
+		err := NewPlan(cfg, sys).resolveConflicts()
+		if tc.expectErr && (err == nil || !strings.Contains(err.Error(), "port 6443 is in use by nginx")) {
+			t.Fatalf("expected port conflict error, got: %v", err)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("expected no error for port held by %s, got: %v", tc.owner, err)
+		}
+	}
+}
+
+func TestConflictPolicyValidator(t *testing.T) {
+	type test struct {
+		conflicts map[string]config.ConflictPolicy
+		expectErr bool
+	}
+
+	tests := []test{
+		{conflicts: map[string]config.ConflictPolicy{"docker": "remove", "ports": "ignore"}, expectErr: false},
+		{conflicts: map[string]config.ConflictPolicy{"podman": "stop"}, expectErr: true},
+		{conflicts: map[string]config.ConflictPolicy{"docker": "explode"}, expectErr: true},
+		{conflicts: map[string]config.ConflictPolicy{"ports": "stop"}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		cfg := &config.Config{}
+		cfg.Host.Conflicts = tc.conflicts
+
+		err := validateConflictPolicies(NewPlan(cfg, system.NewMockSystem()))
+		if tc.expectErr != (err != nil) {
+			t.Fatalf("conflicts %v: expected error: %v, got: %v", tc.conflicts, tc.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package concierge
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/ci"
+	"github.com/canonical/concierge/internal/juju"
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvFormats are the formats in which an Environment can be rendered.
+var EnvFormats = []string{"sh", "json", "github", "dotenv"}
+
+// lxdSocket is the endpoint of LXD when it is not listening on the network.
+const lxdSocket = "unix:///var/snap/lxd/common/lxd/unix.socket"
+
+// EnvVar is an environment variable describing the prepared machine.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// Environment is a list of environment variables, in the order they are rendered.
+type Environment []EnvVar
+
+// kubeconfig holds the parts of a kubeconfig file that describe its current context.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server string `yaml:"server"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+}
+
+// Environment describes what concierge prepared on the machine as environment
+// variables: the Juju controllers and model, the kubeconfig and its context, the
+// endpoints of the providers and the versions of the installed tools. Variables
+// whose value cannot be found are left out.
+func (p *Plan) Environment() Environment {
+	env := p.clientEnvironment()
+
+	names := []string{}
+	for _, provider := range p.Providers {
+		names = append(names, provider.Name())
+	}
+	env = append(env, EnvVar{Name: "CONCIERGE_PROVIDERS", Value: strings.Join(names, ",")})
+
+	controllers := p.controllers()
+	for _, c := range controllers {
+		env = append(env, EnvVar{Name: envName(c.Provider, "CONTROLLER"), Value: c.Name})
+	}
+	if len(controllers) > 0 {
+		env = append(env, EnvVar{Name: "CONCIERGE_JUJU_MODEL", Value: juju.Model})
+	}
+
+	env = append(env, p.kubeEnvironment()...)
+
+	if slices.ContainsFunc(p.Providers, func(provider providers.Provider) bool { return provider.Name() == "lxd" }) {
+		env = append(env, EnvVar{Name: "CONCIERGE_LXD_ENDPOINT", Value: p.lxdEndpoint()})
+	}
+
+	for _, tool := range p.installedTools() {
+		env = append(env, EnvVar{Name: envName(tool.Name, "VERSION"), Value: tool.Version})
+	}
+
+	return env
+}
+
+// controllers returns the Juju controllers that concierge bootstraps.
+func (p *Plan) controllers() []ci.Controller {
+	if p.config.Juju.Disable {
+		return nil
+	}
+
+	controllers := []ci.Controller{}
+	for _, provider := range p.Providers {
+		if provider.Bootstrap() {
+			controllers = append(controllers, ci.Controller{Provider: provider.Name(), Name: juju.ControllerName(provider), Model: juju.Model})
+		}
+	}
+	return controllers
+}
+
+// kubeEnvironment returns the context of the user's kubeconfig, and the endpoint of
+// the cluster it points at, if there is a kubeconfig.
+func (p *Plan) kubeEnvironment() Environment {
+	contents, err := p.system.ReadFile(path.Join(p.system.User().HomeDir, ".kube", "config"))
+	if err != nil {
+		return nil
+	}
+
+	var kc kubeconfig
+	if err := yaml.Unmarshal(contents, &kc); err != nil || kc.CurrentContext == "" {
+		return nil
+	}
+
+	env := Environment{{Name: "CONCIERGE_KUBE_CONTEXT", Value: kc.CurrentContext}}
+
+	for _, context := range kc.Contexts {
+		if context.Name != kc.CurrentContext {
+			continue
+		}
+		for _, cluster := range kc.Clusters {
+			if cluster.Name == context.Context.Cluster && cluster.Cluster.Server != "" {
+				env = append(env, EnvVar{Name: "CONCIERGE_KUBE_ENDPOINT", Value: cluster.Cluster.Server})
+			}
+		}
+	}
+
+	return env
+}
+
+// lxdEndpoint returns the address on which LXD listens on the network, or its unix
+// socket if it does not.
+func (p *Plan) lxdEndpoint() string {
+	cmd := system.NewCommand("lxc", []string{"config", "get", "core.https_address"})
+	cmd.ReadOnly = true
+	output, err := p.system.Run(cmd)
+	if address := strings.TrimSpace(string(output)); err == nil && address != "" {
+		return "https://" + address
+	}
+	return lxdSocket
+}
+
+// installedTools returns the installed snaps that the plan manages, including those
+// of the providers and Juju, with their versions.
+func (p *Plan) installedTools() []ci.Tool {
+	names := []string{}
+	for _, snap := range p.Snaps {
+		names = append(names, snap.Name)
+	}
+	for _, provider := range p.Providers {
+		snaps, _ := provider.Packages()
+		for _, snap := range snaps {
+			names = append(names, snap.Name)
+		}
+	}
+	if !p.config.Juju.Disable {
+		names = append(names, "juju")
+	}
+
+	cmd := system.NewCommand("snap", []string{"list"})
+	cmd.ReadOnly = true
+	output, err := p.system.Run(cmd)
+	if err != nil {
+		return nil
+	}
+
+	// `snap list` prints a header, then "<name> <version> <rev> ..." for each snap.
+	versions := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			versions[fields[0]] = fields[1]
+		}
+	}
+
+	tools := []ci.Tool{}
+	for _, name := range names {
+		version, ok := versions[name]
+		if ok && !slices.ContainsFunc(tools, func(t ci.Tool) bool { return t.Name == name }) {
+			tools = append(tools, ci.Tool{Name: name, Version: version})
+		}
+	}
+	return tools
+}
+
+// envName returns the name of a variable describing something concierge prepared,
+// e.g. CONCIERGE_K8S_CONTROLLER.
+func envName(subject, suffix string) string {
+	subject = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(subject))
+	return "CONCIERGE_" + subject + "_" + suffix
+}
+
+// Render renders the environment in one of the EnvFormats:
+//   - sh: `export` statements, to be evaluated by a POSIX shell
+//   - json: a JSON object
+//   - github: lines to append to $GITHUB_ENV in a GitHub Actions workflow
+//   - dotenv: a `.env` file
+func (e Environment) Render(format string) (string, error) {
+	b := strings.Builder{}
+
+	switch format {
+	case "sh":
+		for _, v := range e {
+			fmt.Fprintf(&b, "export %s='%s'\n", v.Name, strings.ReplaceAll(v.Value, "'", `'\''`))
+		}
+	case "json":
+		values := map[string]string{}
+		for _, v := range e {
+			values[v.Name] = v.Value
+		}
+		out, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal environment as json: %w", err)
+		}
+		b.Write(out)
+		b.WriteString("\n")
+	case "github":
+		for _, v := range e {
+			if strings.Contains(v.Value, "\n") {
+				fmt.Fprintf(&b, "%s<<CONCIERGE_EOF\n%s\nCONCIERGE_EOF\n", v.Name, v.Value)
+			} else {
+				fmt.Fprintf(&b, "%s=%s\n", v.Name, v.Value)
+			}
+		}
+	case "dotenv":
+		escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`)
+		for _, v := range e {
+			fmt.Fprintf(&b, "%s=\"%s\"\n", v.Name, escape.Replace(v.Value))
+		}
+	default:
+		return "", fmt.Errorf("unknown format '%s', must be one of: %s", format, strings.Join(EnvFormats, ", "))
+	}
+
+	return b.String(), nil
+}
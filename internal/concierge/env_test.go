@@ -0,0 +1,124 @@
+package concierge
+
+import (
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestEnvironment(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.LXD.Enable = true
+	cfg.Providers.LXD.Bootstrap = true
+	cfg.Providers.K8s.Enable = true
+	cfg.Providers.K8s.Bootstrap = true
+
+	sys := system.NewMockSystem()
+	home := sys.User().HomeDir
+	sys.MockFile(path.Join(home, ".kube", "config"), []byte(`
+current-context: k8s
+contexts:
+  - name: k8s
+    context:
+      cluster: k8s
+clusters:
+  - name: k8s
+    cluster:
+      server: https://10.0.0.5:6443
+`))
+	sys.MockCommandReturn("lxc config get core.https_address", []byte("[::]:8443\n"), nil)
+	sys.MockCommandReturn("snap list", []byte(`Name     Version  Rev    Tracking     Publisher   Notes
+juju     3.6.8    31228  3.6/stable   canonical✓  -
+k8s      v1.32.3  3567   1.32/stable  canonical✓  classic
+lxd      5.21.3   33110  5.21/stable  canonical✓  -
+snapd    2.68.5   24505  latest/stable canonical✓ snapd
+`), nil)
+
+	env := NewPlan(cfg, sys).Environment()
+
+	expected := Environment{
+		{Name: "JUJU_DATA", Value: path.Join(home, ".local/share/juju")},
+		{Name: "KUBECONFIG", Value: path.Join(home, ".kube/config")},
+		{Name: "CONCIERGE_PROVIDERS", Value: "k8s,lxd"},
+		{Name: "CONCIERGE_K8S_CONTROLLER", Value: "concierge-k8s"},
+		{Name: "CONCIERGE_LXD_CONTROLLER", Value: "concierge-lxd"},
+		{Name: "CONCIERGE_JUJU_MODEL", Value: "testing"},
+		{Name: "CONCIERGE_KUBE_CONTEXT", Value: "k8s"},
+		{Name: "CONCIERGE_KUBE_ENDPOINT", Value: "https://10.0.0.5:6443"},
+		{Name: "CONCIERGE_LXD_ENDPOINT", Value: "https://[::]:8443"},
+		{Name: "CONCIERGE_K8S_VERSION", Value: "v1.32.3"},
+		{Name: "CONCIERGE_LXD_VERSION", Value: "5.21.3"},
+		{Name: "CONCIERGE_JUJU_VERSION", Value: "3.6.8"},
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected: %v, got: %v", expected, env)
+	}
+}
+
+func TestEnvironmentJujuDisabled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juju.Disable = true
+	cfg.Providers.LXD.Enable = true
+	cfg.Providers.LXD.Bootstrap = true
+
+	sys := system.NewMockSystem()
+	env := NewPlan(cfg, sys).Environment()
+
+	expected := Environment{
+		{Name: "JUJU_DATA", Value: path.Join(sys.User().HomeDir, ".local/share/juju")},
+		{Name: "CONCIERGE_PROVIDERS", Value: "lxd"},
+		{Name: "CONCIERGE_LXD_ENDPOINT", Value: lxdSocket},
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected: %v, got: %v", expected, env)
+	}
+}
+
+func TestEnvironmentRender(t *testing.T) {
+	env := Environment{
+		{Name: "CONCIERGE_JUJU_MODEL", Value: "testing"},
+		{Name: "CONCIERGE_QUOTED", Value: `it's "$x"`},
+	}
+
+	type test struct {
+		format   string
+		expected string
+	}
+
+	tests := []test{
+		{
+			format:   "sh",
+			expected: "export CONCIERGE_JUJU_MODEL='testing'\nexport CONCIERGE_QUOTED='it'\\''s \"$x\"'\n",
+		},
+		{
+			format:   "json",
+			expected: "{\n  \"CONCIERGE_JUJU_MODEL\": \"testing\",\n  \"CONCIERGE_QUOTED\": \"it's \\\"$x\\\"\"\n}\n",
+		},
+		{
+			format:   "github",
+			expected: "CONCIERGE_JUJU_MODEL=testing\nCONCIERGE_QUOTED=it's \"$x\"\n",
+		},
+		{
+			format:   "dotenv",
+			expected: "CONCIERGE_JUJU_MODEL=\"testing\"\nCONCIERGE_QUOTED=\"it's \\\"\\$x\\\"\"\n",
+		},
+	}
+
+	for _, tc := range tests {
+		out, err := env.Render(tc.format)
+		if err != nil {
+			t.Fatalf("expected: nil, got: %v", err)
+		}
+		if out != tc.expected {
+			t.Fatalf("expected: %v, got: %v", tc.expected, out)
+		}
+	}
+
+	_, err := env.Render("xml")
+	if err == nil {
+		t.Fatalf("expected an error for an unknown format, got: nil")
+	}
+}
@@ -0,0 +1,62 @@
+package concierge
+
+import (
+	"path"
+	"slices"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+// execCommand constructs a command that runs as the user, with the groups of the
+// enabled providers, and with the environment that concierge set up for them. As
+// when Juju is bootstrapped, the command is run with `sudo -g`, and sudo sets the
+// user's supplementary groups from the group database, so the command has the
+// groups that the user was just added to even though their session does not.
+func (p *Plan) execCommand(args []string) *system.Command {
+	group := ""
+	if groups := p.providerGroups(); len(groups) > 0 {
+		group = groups[0]
+	}
+
+	cmd := system.NewCommandAs(p.system.User().Username, group, args[0], args[1:])
+	cmd.Env = p.userEnvironment()
+	return cmd
+}
+
+// providerGroups returns the POSIX groups that allow the user to interact with the
+// enabled providers.
+func (p *Plan) providerGroups() []string {
+	groups := []string{}
+	for _, provider := range p.Providers {
+		if group := provider.GroupName(); group != "" && !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// userEnvironment returns the environment variables that point tools at what
+// concierge set up for the user: the Juju client data, the kubeconfig (if there is
+// one) and the proxy.
+func (p *Plan) userEnvironment() []string {
+	env := []string{}
+	for _, v := range p.clientEnvironment() {
+		env = append(env, v.Name+"="+v.Value)
+	}
+	return append(env, p.config.Proxy.Environment()...)
+}
+
+// clientEnvironment returns the variables that point the Juju client and kubectl at
+// the user's client data and kubeconfig, leaving out the kubeconfig if there is none.
+func (p *Plan) clientEnvironment() Environment {
+	home := p.system.User().HomeDir
+
+	env := Environment{{Name: "JUJU_DATA", Value: path.Join(home, ".local", "share", "juju")}}
+
+	kubeconfig := path.Join(home, ".kube", "config")
+	if _, err := p.system.ReadFile(kubeconfig); err == nil {
+		env = append(env, EnvVar{Name: "KUBECONFIG", Value: kubeconfig})
+	}
+
+	return env
+}
@@ -0,0 +1,44 @@
+package concierge
+
+import (
+	"path"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestExecCommand(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.LXD.Enable = true
+	cfg.Providers.K8s.Enable = true
+	cfg.Proxy.HTTPS = "http://proxy.internal:3128"
+
+	sys := system.NewMockSystem()
+	home := sys.User().HomeDir
+	sys.MockFile(path.Join(home, ".kube", "config"), []byte("kubeconfig"))
+
+	cmd := NewPlan(cfg, sys).execCommand([]string{"juju", "status", "--format", "json"})
+
+	expected := "sudo -u test-user -g lxd" +
+		" JUJU_DATA=" + path.Join(home, ".local/share/juju") +
+		" KUBECONFIG=" + path.Join(home, ".kube/config") +
+		" HTTPS_PROXY=http://proxy.internal:3128 https_proxy=http://proxy.internal:3128" +
+		" juju status --format json"
+	if cmd.CommandString() != expected {
+		t.Fatalf("expected: %v, got: %v", expected, cmd.CommandString())
+	}
+}
+
+func TestExecCommandWithoutProviderGroups(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+
+	sys := system.NewMockSystem()
+	cmd := NewPlan(cfg, sys).execCommand([]string{"tox", "-e", "integration"})
+
+	expected := "sudo -u test-user JUJU_DATA=" + path.Join(sys.User().HomeDir, ".local/share/juju") + " tox -e integration"
+	if cmd.CommandString() != expected {
+		t.Fatalf("expected: %v, got: %v", expected, cmd.CommandString())
+	}
+}
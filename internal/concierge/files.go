@@ -0,0 +1,322 @@
+package concierge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// fileBackupsDir is the directory, relative to the user's home directory, in which
+// the previous contents of files that concierge overwrites are saved.
+var fileBackupsDir = path.Join(".cache", "concierge", "backups")
+
+// fileTemplateData is passed to the templates of host files.
+type fileTemplateData struct {
+	Config *config.Config
+	Host   hostFacts
+}
+
+// hostFacts describes the host, for use in the templates of host files.
+type hostFacts struct {
+	User     string
+	Home     string
+	Hostname string
+	Arch     string
+	Release  string
+	Codename string
+}
+
+// configureFiles writes the configured host files, first saving the previous contents
+// of any that already exist so that restore can put them back. This happens before
+// any packages are installed.
+func (p *Plan) configureFiles() error {
+	if len(p.config.Host.Files) == 0 {
+		return nil
+	}
+
+	facts := p.hostFacts()
+
+	for _, file := range p.config.Host.Files {
+		contents, err := renderFile(file, &fileTemplateData{Config: p.config, Host: facts})
+		if err != nil {
+			return err
+		}
+
+		filePath, home := p.resolveFilePath(file.Path)
+
+		record, err := p.backupFile(filePath, home)
+		if err != nil {
+			return fmt.Errorf("failed to back up '%s': %w", filePath, err)
+		}
+
+		err = p.putFile(filePath, home, contents)
+		if err != nil {
+			return err
+		}
+
+		// The file is only recorded once it is written, so that restore never
+		// removes a file that concierge did not write.
+		if record != nil {
+			p.config.State.Files = append(p.config.State.Files, *record)
+		}
+
+		if file.Mode != "" {
+			_, err = p.system.Run(system.NewCommand("chmod", []string{file.Mode, filePath}))
+			if err != nil {
+				return fmt.Errorf("failed to set mode of '%s': %w", filePath, err)
+			}
+		}
+
+		if file.Owner != "" {
+			_, err = p.system.Run(system.NewCommand("chown", []string{file.Owner, filePath}))
+			if err != nil {
+				return fmt.Errorf("failed to set owner of '%s': %w", filePath, err)
+			}
+		}
+
+		slog.Info("Wrote file", "path", filePath)
+	}
+
+	return nil
+}
+
+// restoreFiles undoes the writes recorded in the runtime state, according to the
+// restore policy of each file.
+func (p *Plan) restoreFiles() error {
+	for _, record := range slices.Clone(p.config.State.Files) {
+		policy := config.FileRevert
+		for _, file := range p.config.Host.Files {
+			if filePath, _ := p.resolveFilePath(file.Path); filePath == record.Path && file.Restore != "" {
+				policy = file.Restore
+			}
+		}
+
+		err := p.restoreFile(record, policy)
+		if err != nil {
+			return fmt.Errorf("failed to restore '%s': %w", record.Path, err)
+		}
+
+		p.config.State.Files = slices.DeleteFunc(p.config.State.Files, func(r config.FileRecord) bool { return r.Path == record.Path })
+	}
+
+	return nil
+}
+
+// restoreFile applies a restore policy to a file, then removes its backup.
+func (p *Plan) restoreFile(record config.FileRecord, policy config.FileRestorePolicy) error {
+	switch {
+	case policy == config.FileKeep:
+		slog.Info("Keeping file", "path", record.Path)
+	case policy == config.FileRevert && record.Backup != "":
+		contents, err := system.ReadHomeDirFile(p.system, record.Backup)
+		if err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+
+		_, home := p.resolveFilePath(record.Path)
+		err = p.putFile(record.Path, home, contents)
+		if err != nil {
+			return err
+		}
+
+		err = p.revertAttributes(record)
+		if err != nil {
+			return err
+		}
+		slog.Info("Reverted file", "path", record.Path)
+	default:
+		err := p.system.RemovePath(record.Path)
+		if err != nil {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		slog.Info("Removed file", "path", record.Path)
+	}
+
+	if record.Backup != "" {
+		err := p.system.RemovePath(path.Join(p.system.User().HomeDir, record.Backup))
+		if err != nil {
+			return fmt.Errorf("failed to remove backup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// backupFile saves the current contents of a file, unless a previous run already
+// recorded it, and returns the record of where they were saved. A file that does
+// not exist yet is recorded without a backup. Directories are refused, since
+// restore would otherwise remove them.
+func (p *Plan) backupFile(filePath string, home bool) (*config.FileRecord, error) {
+	if slices.ContainsFunc(p.config.State.Files, func(r config.FileRecord) bool { return r.Path == filePath }) {
+		return nil, nil
+	}
+
+	record := &config.FileRecord{Path: filePath}
+
+	var contents []byte
+	var err error
+	if home {
+		contents, err = system.ReadHomeDirFile(p.system, p.homeRelative(filePath))
+	} else {
+		contents, err = p.system.ReadFile(filePath)
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return record, nil
+	case errors.Is(err, syscall.EISDIR):
+		return nil, fmt.Errorf("'%s' is a directory", filePath)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	record.Mode, record.Owner, err = p.fileAttributes(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The backup may hold sensitive contents, so only the user can read it.
+	record.Backup = path.Join(fileBackupsDir, url.QueryEscape(filePath))
+	err = system.MkHomeSubdirectory(p.system, fileBackupsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	backupPath := path.Join(p.system.User().HomeDir, record.Backup)
+	err = p.system.WriteFile(backupPath, contents, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write file '%s': %w", backupPath, err)
+	}
+
+	err = p.system.ChownAll(backupPath, p.system.User())
+	if err != nil {
+		return nil, fmt.Errorf("failed to change ownership of file '%s': %w", backupPath, err)
+	}
+
+	return record, nil
+}
+
+// fileAttributes returns the permissions and owner of an existing file.
+func (p *Plan) fileAttributes(filePath string) (string, string, error) {
+	cmd := system.NewCommand("stat", []string{"-c", "%a %U:%G", filePath})
+	cmd.ReadOnly = true
+	output, err := p.system.Run(cmd)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read attributes of file: %w", err)
+	}
+
+	mode, owner, _ := strings.Cut(strings.TrimSpace(string(output)), " ")
+	return mode, owner, nil
+}
+
+// revertAttributes puts back the permissions and owner that a file had before
+// concierge first wrote it.
+func (p *Plan) revertAttributes(record config.FileRecord) error {
+	if record.Mode != "" {
+		_, err := p.system.Run(system.NewCommand("chmod", []string{record.Mode, record.Path}))
+		if err != nil {
+			return fmt.Errorf("failed to set mode of '%s': %w", record.Path, err)
+		}
+	}
+
+	if record.Owner != "" {
+		_, err := p.system.Run(system.NewCommand("chown", []string{record.Owner, record.Path}))
+		if err != nil {
+			return fmt.Errorf("failed to set owner of '%s': %w", record.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// putFile writes a file, as the user if it is in their home directory, otherwise
+// creating its parent directories as needed.
+func (p *Plan) putFile(filePath string, home bool, contents []byte) error {
+	if home {
+		return system.WriteHomeDirFile(p.system, p.homeRelative(filePath), contents)
+	}
+
+	dir := path.Dir(filePath)
+	err := p.system.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", dir, err)
+	}
+
+	err = p.system.WriteFile(filePath, contents, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write file '%s': %w", filePath, err)
+	}
+	return nil
+}
+
+// resolveFilePath returns the absolute path of a configured file, and whether it is
+// in the user's home directory.
+func (p *Plan) resolveFilePath(filePath string) (string, bool) {
+	home := p.system.User().HomeDir
+	if rel, ok := strings.CutPrefix(filePath, "~/"); ok {
+		return path.Join(home, rel), true
+	}
+
+	filePath = path.Clean(filePath)
+	return filePath, strings.HasPrefix(filePath, home+"/")
+}
+
+// homeRelative returns the path of a file in the user's home directory, relative to it.
+func (p *Plan) homeRelative(filePath string) string {
+	return strings.TrimPrefix(filePath, p.system.User().HomeDir+"/")
+}
+
+// hostFacts gathers the facts about the host that templates may refer to.
+func (p *Plan) hostFacts() hostFacts {
+	facts := hostFacts{
+		User: p.system.User().Username,
+		Home: p.system.User().HomeDir,
+		Arch: runtime.GOARCH,
+	}
+
+	cmd := system.NewCommand("hostname", []string{})
+	cmd.ReadOnly = true
+	if output, err := p.system.Run(cmd); err == nil {
+		facts.Hostname = strings.TrimSpace(string(output))
+	}
+
+	if contents, err := p.system.ReadFile("/etc/os-release"); err == nil {
+		fields := system.ParseOSRelease(string(contents))
+		facts.Release = fields["VERSION_ID"]
+		facts.Codename = fields["VERSION_CODENAME"]
+	}
+
+	return facts
+}
+
+// renderFile returns the contents of a configured file, rendering its template if
+// it has one.
+func renderFile(file config.FileConfig, data *fileTemplateData) ([]byte, error) {
+	if file.Template == "" {
+		return []byte(file.Content), nil
+	}
+
+	tmpl, err := template.New(file.Path).Option("missingkey=error").Parse(file.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template for '%s': %w", file.Path, err)
+	}
+
+	b := bytes.Buffer{}
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template for '%s': %w", file.Path, err)
+	}
+
+	return b.Bytes(), nil
+}
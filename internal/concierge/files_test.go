@@ -0,0 +1,134 @@
+package concierge
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestConfigureFiles(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juju.Channel = "3.6/stable"
+	cfg.Host.Files = []config.FileConfig{
+		{Path: "~/.config/jhack/config.toml", Template: "user = \"{{ .Host.User }}@{{ .Host.Hostname }}\"\njuju = \"{{ .Config.Juju.Channel }}\"\n"},
+		{Path: "/etc/sysctl.d/99-concierge.conf", Content: "fs.inotify.max_user_watches = 1048576\n", Mode: "0600", Owner: "root:adm"},
+	}
+
+	sys := system.NewMockSystem()
+	home := sys.User().HomeDir
+	sys.MockFile("/etc/sysctl.d/99-concierge.conf", []byte("vm.swappiness = 10\n"))
+	sys.MockCommandReturn("hostname", []byte("ci-host\n"), nil)
+	sys.MockCommandReturn("stat -c '%a %U:%G' /etc/sysctl.d/99-concierge.conf", []byte("644 root:root\n"), nil)
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.configureFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := path.Join(fileBackupsDir, "%2Fetc%2Fsysctl.d%2F99-concierge.conf")
+	expectedFiles := map[string]string{
+		path.Join(home, ".config/jhack/config.toml"): "user = \"test-user@ci-host\"\njuju = \"3.6/stable\"\n",
+		path.Join(home, backup):                      "vm.swappiness = 10\n",
+		"/etc/sysctl.d/99-concierge.conf":            "fs.inotify.max_user_watches = 1048576\n",
+	}
+	if !reflect.DeepEqual(expectedFiles, sys.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, sys.CreatedFiles)
+	}
+
+	expectedCommands := []string{
+		"hostname",
+		"stat -c '%a %U:%G' /etc/sysctl.d/99-concierge.conf",
+		"chmod 0600 /etc/sysctl.d/99-concierge.conf",
+		"chown root:adm /etc/sysctl.d/99-concierge.conf",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedState := []config.FileRecord{
+		{Path: path.Join(home, ".config/jhack/config.toml")},
+		{Path: "/etc/sysctl.d/99-concierge.conf", Backup: backup, Mode: "644", Owner: "root:root"},
+	}
+	if !reflect.DeepEqual(expectedState, cfg.State.Files) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.Files)
+	}
+
+	// Running prepare again must keep the original backups.
+	sys.MockFile("/etc/sysctl.d/99-concierge.conf", []byte("fs.inotify.max_user_watches = 1048576\n"))
+	if err := plan.configureFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedState, cfg.State.Files) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.Files)
+	}
+}
+
+func TestConfigureFilesRefusesDirectory(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Host.Files = []config.FileConfig{{Path: "/etc/sysctl.d", Content: "vm.swappiness = 10\n"}}
+
+	sys := system.NewMockSystem()
+	_ = sys.MkdirAll("/etc/sysctl.d", 0755)
+
+	err := NewPlan(cfg, sys).configureFiles()
+	if err == nil || !strings.Contains(err.Error(), "'/etc/sysctl.d' is a directory") {
+		t.Fatalf("expected: directory error, got: %v", err)
+	}
+
+	if len(sys.CreatedFiles) != 0 || len(cfg.State.Files) != 0 {
+		t.Fatalf("expected: nothing written or recorded, got: %v, %v", sys.CreatedFiles, cfg.State.Files)
+	}
+}
+
+func TestRestoreFiles(t *testing.T) {
+	sys := system.NewMockSystem()
+	home := sys.User().HomeDir
+
+	cfg := &config.Config{}
+	cfg.Host.Files = []config.FileConfig{
+		{Path: "~/.bashrc.d/concierge.sh", Restore: config.FileKeep},
+		{Path: "/etc/sysctl.d/99-concierge.conf"},
+		{Path: "/etc/profile.d/concierge.sh", Restore: config.FileRemove},
+	}
+	cfg.State.Files = []config.FileRecord{
+		{Path: path.Join(home, ".bashrc.d/concierge.sh")},
+		{Path: "/etc/sysctl.d/99-concierge.conf", Backup: ".cache/concierge/backups/sysctl", Mode: "644", Owner: "root:root"},
+		{Path: "/etc/profile.d/concierge.sh", Backup: ".cache/concierge/backups/profile"},
+	}
+
+	sys.MockFile(path.Join(home, ".cache/concierge/backups/sysctl"), []byte("vm.swappiness = 10\n"))
+
+	if err := NewPlan(cfg, sys).restoreFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := map[string]string{"/etc/sysctl.d/99-concierge.conf": "vm.swappiness = 10\n"}
+	if !reflect.DeepEqual(expectedFiles, sys.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, sys.CreatedFiles)
+	}
+
+	expectedCommands := []string{
+		"chmod 644 /etc/sysctl.d/99-concierge.conf",
+		"chown root:root /etc/sysctl.d/99-concierge.conf",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedRemoved := []string{
+		path.Join(home, ".cache/concierge/backups/sysctl"),
+		"/etc/profile.d/concierge.sh",
+		path.Join(home, ".cache/concierge/backups/profile"),
+	}
+	if !reflect.DeepEqual(expectedRemoved, sys.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", expectedRemoved, sys.RemovedPaths)
+	}
+
+	if len(cfg.State.Files) != 0 {
+		t.Fatalf("expected recorded files to be cleared, got: %v", cfg.State.Files)
+	}
+}
@@ -0,0 +1,225 @@
+package concierge
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+// sysctlConfPath is the sysctl drop-in written with the kernel parameters to set. It
+// sorts late, so that its values take precedence over those of other drop-ins.
+const sysctlConfPath = "/etc/sysctl.d/99-concierge.conf"
+
+// modulesLoadConfPath lists the kernel modules to load at boot.
+const modulesLoadConfPath = "/etc/modules-load.d/concierge.conf"
+
+// configureKernel loads the kernel modules and sets the kernel parameters needed by
+// the host config and the enabled providers, both now and at boot, first recording
+// the parameters' values so that restore can put them back. A provider's parameter
+// is only raised, never lowered, whereas the host config's are set as given. This
+// happens before any packages are installed.
+func (p *Plan) configureKernel() error {
+	params, err := p.sysctlParams()
+	if err != nil {
+		return err
+	}
+	modules := p.kernelModules()
+
+	if len(params) == 0 && len(modules) == 0 {
+		return nil
+	}
+
+	if len(modules) > 0 {
+		err = p.loadKernelModules(modules)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(params) > 0 {
+		err = p.setSysctlParams(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreKernel removes the sysctl and modules-load drop-ins, puts back the values
+// of the kernel parameters recorded in the runtime state, and unloads the kernel
+// modules that concierge loaded, if they are no longer in use.
+func (p *Plan) restoreKernel() error {
+	if len(p.config.State.Sysctl) == 0 && len(p.config.State.KernelModules) == 0 && len(p.config.Host.Sysctl) == 0 && len(p.kernelModules()) == 0 {
+		return nil
+	}
+
+	for _, confPath := range []string{sysctlConfPath, modulesLoadConfPath} {
+		err := p.system.RemovePath(confPath)
+		if err != nil {
+			return fmt.Errorf("failed to remove '%s': %w", confPath, err)
+		}
+	}
+
+	previous := p.config.State.Sysctl
+	for _, key := range slices.Sorted(maps.Keys(previous)) {
+		if previous[key] == "" {
+			continue
+		}
+
+		_, err := p.system.Run(system.NewCommand("sysctl", []string{"-w", key + "=" + previous[key]}))
+		if err != nil {
+			return fmt.Errorf("failed to reset kernel parameter '%s': %w", key, err)
+		}
+	}
+	if len(previous) > 0 {
+		slog.Info("Reset kernel parameters", "count", len(previous))
+	}
+	p.config.State.Sysctl = nil
+
+	for _, module := range slices.Backward(p.config.State.KernelModules) {
+		cmd := system.NewCommand("modprobe", []string{"-r", module})
+		cmd.ExpectedError = `in use`
+		_, err := p.system.Run(cmd)
+		if err != nil {
+			slog.Warn("Failed to unload kernel module, leaving it loaded", "module", module, "error", err)
+			continue
+		}
+		slog.Info("Unloaded kernel module", "module", module)
+	}
+	p.config.State.KernelModules = nil
+
+	return nil
+}
+
+// sysctlParams returns the kernel parameters to set, mapped to their values. The
+// providers' parameters are left out if they are already at least as high as needed.
+func (p *Plan) sysctlParams() (map[string]string, error) {
+	params := map[string]string{}
+
+	minimums := p.requirements().Sysctl
+	for _, key := range slices.Sorted(maps.Keys(minimums)) {
+		if _, ok := p.config.Host.Sysctl[key]; ok {
+			continue
+		}
+
+		// A parameter that a previous run raised stays in the drop-in.
+		if _, ok := p.config.State.Sysctl[key]; !ok {
+			current, err := p.sysctlValue(key)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := strconv.Atoi(current); err == nil && n >= minimums[key] {
+				continue
+			}
+		}
+		params[key] = strconv.Itoa(minimums[key])
+	}
+
+	maps.Copy(params, p.config.Host.Sysctl)
+	return params, nil
+}
+
+// kernelModules returns the kernel modules to load, from the host config and the
+// enabled providers.
+func (p *Plan) kernelModules() []string {
+	modules := slices.Clone(p.config.Host.KernelModules)
+	for _, module := range p.requirements().KernelModules {
+		if !slices.Contains(modules, module) {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// setSysctlParams writes the kernel parameters to the sysctl drop-in and applies
+// them, recording the values they had on the first run.
+func (p *Plan) setSysctlParams(params map[string]string) error {
+	keys := slices.Sorted(maps.Keys(params))
+
+	if p.config.State.Sysctl == nil {
+		p.config.State.Sysctl = map[string]string{}
+	}
+	for _, key := range keys {
+		if _, ok := p.config.State.Sysctl[key]; ok {
+			continue
+		}
+		current, err := p.sysctlValue(key)
+		if err != nil {
+			return err
+		}
+		p.config.State.Sysctl[key] = current
+	}
+
+	b := strings.Builder{}
+	b.WriteString("# Written by concierge, and removed by `concierge restore`.\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", key, params[key])
+	}
+
+	err := p.system.WriteFile(sysctlConfPath, []byte(b.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write '%s': %w", sysctlConfPath, err)
+	}
+
+	_, err = p.system.Run(system.NewCommand("sysctl", []string{"-p", sysctlConfPath}))
+	if err != nil {
+		return fmt.Errorf("failed to apply kernel parameters: %w", err)
+	}
+
+	slog.Info("Set kernel parameters", "parameters", keys)
+	return nil
+}
+
+// loadKernelModules writes the kernel modules to the modules-load drop-in, so that
+// they are loaded at boot, and loads those that are not already loaded, recording them.
+func (p *Plan) loadKernelModules(modules []string) error {
+	contents := "# Written by concierge, and removed by `concierge restore`.\n" + strings.Join(modules, "\n") + "\n"
+	err := p.system.WriteFile(modulesLoadConfPath, []byte(contents), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write '%s': %w", modulesLoadConfPath, err)
+	}
+
+	loaded, _ := p.system.ReadFile("/proc/modules")
+	lines := strings.Split(string(loaded), "\n")
+
+	for _, module := range modules {
+		if slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, module+" ") }) {
+			continue
+		}
+
+		_, err := p.system.Run(system.NewCommand("modprobe", []string{module}))
+		if err != nil {
+			return fmt.Errorf("failed to load kernel module '%s': %w", module, err)
+		}
+
+		if !slices.Contains(p.config.State.KernelModules, module) {
+			p.config.State.KernelModules = append(p.config.State.KernelModules, module)
+		}
+		slog.Info("Loaded kernel module", "module", module)
+	}
+
+	return nil
+}
+
+// sysctlValue returns the current value of a kernel parameter, or "" if the kernel
+// does not have it.
+func (p *Plan) sysctlValue(key string) (string, error) {
+	cmd := system.NewCommand("sysctl", []string{"-n", key})
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `cannot stat`
+
+	output, err := p.system.Run(cmd)
+	if err != nil && strings.Contains(string(output), "cannot stat") {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read kernel parameter '%s': %w", key, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
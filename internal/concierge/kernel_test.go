@@ -0,0 +1,92 @@
+package concierge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestConfigureKernel(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+	cfg.Host.Sysctl = map[string]string{"vm.max_map_count": "262144"}
+	cfg.Host.KernelModules = []string{"nf_conntrack"}
+
+	sys := system.NewMockSystem()
+	sys.MockFile("/proc/modules", []byte("overlay 212992 0 - Live 0x0000000000000000\n"))
+	sys.MockCommandReturn("sysctl -n fs.inotify.max_user_instances", []byte("128\n"), nil)
+	sys.MockCommandReturn("sysctl -n fs.inotify.max_user_watches", []byte("4194304\n"), nil)
+	sys.MockCommandReturn("sysctl -n vm.max_map_count", []byte("65530\n"), nil)
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.configureKernel(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The inotify watches are already higher than k8s needs, so are left alone.
+	expectedFiles := map[string]string{
+		sysctlConfPath: "# Written by concierge, and removed by `concierge restore`.\n" +
+			"fs.inotify.max_user_instances = 1024\n" +
+			"vm.max_map_count = 262144\n",
+		modulesLoadConfPath: "# Written by concierge, and removed by `concierge restore`.\n" +
+			"nf_conntrack\noverlay\nbr_netfilter\n",
+	}
+	if !reflect.DeepEqual(expectedFiles, sys.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, sys.CreatedFiles)
+	}
+
+	expectedCommands := []string{
+		"sysctl -n fs.inotify.max_user_instances",
+		"sysctl -n fs.inotify.max_user_watches",
+		"modprobe nf_conntrack",
+		"modprobe br_netfilter",
+		"sysctl -n fs.inotify.max_user_instances",
+		"sysctl -n vm.max_map_count",
+		"sysctl -p " + sysctlConfPath,
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedSysctl := map[string]string{"fs.inotify.max_user_instances": "128", "vm.max_map_count": "65530"}
+	if !reflect.DeepEqual(expectedSysctl, cfg.State.Sysctl) {
+		t.Fatalf("expected: %v, got: %v", expectedSysctl, cfg.State.Sysctl)
+	}
+
+	expectedModules := []string{"nf_conntrack", "br_netfilter"}
+	if !reflect.DeepEqual(expectedModules, cfg.State.KernelModules) {
+		t.Fatalf("expected: %v, got: %v", expectedModules, cfg.State.KernelModules)
+	}
+}
+
+func TestRestoreKernel(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.State.Sysctl = map[string]string{"fs.inotify.max_user_instances": "128", "vm.max_map_count": "65530", "kernel.unknown": ""}
+	cfg.State.KernelModules = []string{"nf_conntrack", "br_netfilter"}
+
+	sys := system.NewMockSystem()
+	if err := NewPlan(cfg, sys).restoreKernel(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRemoved := []string{sysctlConfPath, modulesLoadConfPath}
+	if !reflect.DeepEqual(expectedRemoved, sys.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", expectedRemoved, sys.RemovedPaths)
+	}
+
+	expectedCommands := []string{
+		"sysctl -w fs.inotify.max_user_instances=128",
+		"sysctl -w vm.max_map_count=65530",
+		"modprobe -r br_netfilter",
+		"modprobe -r nf_conntrack",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	if cfg.State.Sysctl != nil || cfg.State.KernelModules != nil {
+		t.Fatalf("expected recorded kernel state to be cleared, got: %v, %v", cfg.State.Sysctl, cfg.State.KernelModules)
+	}
+}
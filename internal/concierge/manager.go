@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log/slog"
 	"path"
+	"time"
 
+	"github.com/canonical/concierge/internal/ci"
 	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/securitylog"
 	"github.com/canonical/concierge/internal/system"
@@ -17,15 +19,20 @@ func NewManager(config *config.Config) (*Manager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialise system: %w", err)
 	}
+	sys.UseStoreCache(config.StoreCacheTTL, config.RefreshStoreCache)
 
 	var worker system.Worker = sys
+	var reporter ci.Reporter
 	if config.DryRun {
 		worker = system.NewDryRunWorker(sys)
+	} else {
+		reporter = ci.Detect()
 	}
 
 	return &Manager{
-		config: config,
-		system: worker,
+		config:   config,
+		system:   worker,
+		reporter: reporter,
 	}, nil
 }
 
@@ -34,6 +41,9 @@ type Manager struct {
 	Plan   *Plan
 	system system.Worker
 	config *config.Config
+	// reporter, if set, reports prepare and restore runs to the CI system that
+	// concierge is running in.
+	reporter ci.Reporter
 }
 
 // Prepare runs the steps required for provisioning the machine according to
@@ -73,13 +83,35 @@ func (m *Manager) Restore() error {
 			"action", RestoreAction, "user", m.system.User().Username)
 	}
 
-	return m.execute(RestoreAction)
+	err := m.execute(RestoreAction)
+
+	// Nothing was restored if the runtime config could not be loaded.
+	if m.Plan == nil {
+		return err
+	}
+
+	// Record the state that is left to restore, which the handlers clear as they
+	// undo each change, so that a later `prepare` does not carry forward records of
+	// changes that were already undone.
+	var recordErr error
+	if err != nil {
+		recordErr = m.recordRuntimeConfig(config.Failed)
+	} else {
+		recordErr = m.recordRuntimeConfig(config.Restored)
+	}
+
+	if recordErr != nil {
+		slog.Error("failed to record concierge status", "error", recordErr.Error())
+	}
+
+	return err
 }
 
 // execute runs the overlord with a specified action.
 func (m *Manager) execute(action string) error {
 	switch action {
 	case PrepareAction:
+		m.carryForwardState()
 		err := m.recordRuntimeConfig(config.Provisioning)
 		if err != nil {
 			return fmt.Errorf("failed to record config file: %w", err)
@@ -93,9 +125,25 @@ func (m *Manager) execute(action string) error {
 		return fmt.Errorf("unknown handler action: %s", action)
 	}
 
+	// Ensure secrets from the config never appear in trace output, logs or
+	// security events.
+	system.RegisterSecret(m.config.Secrets()...)
+
 	// Create the installation/preparation plan
 	m.Plan = NewPlan(m.config, m.system)
-	return m.Plan.Execute(action)
+	m.Plan.reporter = m.reporter
+
+	start := time.Now()
+	err := m.Plan.Execute(action)
+
+	if m.reporter != nil {
+		reportErr := m.reporter.Finish(m.Plan.runReport(action, time.Since(start), err))
+		if reportErr != nil {
+			slog.Warn("Failed to report run to CI", "error", reportErr)
+		}
+	}
+
+	return err
 }
 
 // recordRuntimeConfig dumps the current manager config into a file in the user's home
@@ -122,6 +170,33 @@ func (m *Manager) recordRuntimeConfig(status config.Status) error {
 	return nil
 }
 
+// carryForwardState copies the runtime state recorded by a previous `prepare`
+// into the current config, so that changes made to the host by earlier runs are
+// still undone by `restore`. If there is no previous run, or it was restored, this
+// is a no-op.
+func (m *Manager) carryForwardState() {
+	recordPath := path.Join(".cache", "concierge", "concierge.yaml")
+
+	contents, err := system.ReadHomeDirFile(m.system, recordPath)
+	if err != nil {
+		return
+	}
+
+	var previous config.Config
+	err = yaml.Unmarshal(contents, &previous)
+	if err != nil {
+		slog.Warn("Failed to parse previous runtime configuration", "path", recordPath, "error", err)
+		return
+	}
+
+	// Everything recorded by a run that was fully restored has been undone.
+	if previous.Status == config.Restored {
+		return
+	}
+
+	m.config.State = previous.State
+}
+
 // loadRuntimeConfig loads a previously cached concierge runtime configuration.
 // CLI flags (DryRun, Trace, Verbose) are preserved from the current config.
 func (m *Manager) loadRuntimeConfig() error {
@@ -150,6 +225,48 @@ func (m *Manager) loadRuntimeConfig() error {
 	return nil
 }
 
+// Exec runs a command as the user, with the groups of the providers enabled by the
+// last `prepare`, and with concierge's environment, returning once it exits.
+func (m *Manager) Exec(args []string) error {
+	err := m.loadRuntimeConfig()
+	if err != nil {
+		return fmt.Errorf("concierge has not prepared this machine: %w", err)
+	}
+
+	system.RegisterSecret(m.config.Secrets()...)
+
+	m.Plan = NewPlan(m.config, m.system)
+	return m.system.RunInteractive(m.Plan.execCommand(args))
+}
+
+// Environment describes what the last `prepare` set up on the machine as
+// environment variables.
+func (m *Manager) Environment() (Environment, error) {
+	err := m.loadRuntimeConfig()
+	if err != nil {
+		return nil, fmt.Errorf("concierge has not prepared this machine: %w", err)
+	}
+
+	system.RegisterSecret(m.config.Secrets()...)
+
+	m.Plan = NewPlan(m.config, m.system)
+	return m.Plan.Environment(), nil
+}
+
+// Preflight checks whether the machine meets the requirements of the configured
+// plan, without making any changes to it.
+func (m *Manager) Preflight() PreflightReport {
+	m.Plan = NewPlan(m.config, m.system)
+	return m.Plan.Preflight()
+}
+
+// Bundle downloads everything the configured plan installs into a tarball, from
+// which machines without network access can be provisioned.
+func (m *Manager) Bundle(opts BundleOptions) error {
+	m.Plan = NewPlan(m.config, m.system)
+	return m.Plan.Bundle(opts)
+}
+
 // Status reads the concierge status on the machine.
 func (m *Manager) Status() (config.Status, error) {
 	recordPath := path.Join(".cache", "concierge", "concierge.yaml")
@@ -3,7 +3,11 @@ package concierge
 import (
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"time"
 
+	"github.com/canonical/concierge/internal/ci"
 	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/juju"
 	"github.com/canonical/concierge/internal/packages"
@@ -17,17 +21,43 @@ type Plan struct {
 	Providers []providers.Provider
 	Snaps     []*system.Snap
 	Debs      []*packages.Deb
+	Sources   []*packages.AptSource
+	// PythonTools are installed for the user with uv.
+	PythonTools []*packages.PythonTool
 
 	config *config.Config
 	system system.Worker
+
+	// reporter, if set, reports each step of the plan to the CI system it runs in.
+	reporter ci.Reporter
+	// steps records the steps of the plan that have run.
+	steps []ci.Step
 }
 
 // NewPlan constructs a new plan consisting of snaps/debs/providers & juju.
 func NewPlan(cfg *config.Config, worker system.Worker) *Plan {
 	plan := &Plan{config: cfg, system: worker}
 
-	for name, snapConfig := range cfg.Host.Snaps {
+	// The artifacts directory is set before the providers are constructed, since
+	// they install their snaps from it too.
+	if cfg.Overrides.Artifacts != "" {
+		cfg.Host.Artifacts = cfg.Overrides.Artifacts
+	}
+
+	// Host snaps are added in name order, so that the install order does not
+	// depend on map iteration; orderSnaps then honours any `after` dependencies.
+	for _, name := range slices.Sorted(maps.Keys(cfg.Host.Snaps)) {
+		snapConfig := cfg.Host.Snaps[name]
 		snap := system.NewSnap(name, snapConfig.Channel, snapConfig.Connections)
+		snap.After = snapConfig.After
+		snap.Revision = snapConfig.Revision
+		snap.Hold = snapConfig.Hold
+		snap.Config = snapConfig.Config
+		snap.Aliases = snapConfig.Aliases
+		snap.Devmode = snapConfig.Devmode
+		snap.Path = snapConfig.Path
+		snap.Dangerous = snapConfig.Dangerous
+		snap.RefreshPolicy = snapConfig.RefreshPolicy
 		// Check if the channel has been overridden by a CLI argument/env var
 		channelOverride := getSnapChannelOverride(cfg, snap.Name)
 		if channelOverride != "" {
@@ -47,8 +77,47 @@ func NewPlan(cfg *config.Config, worker system.Worker) *Plan {
 		plan.Snaps = append(plan.Snaps, snap)
 	}
 
-	for _, p := range append(cfg.Host.Packages, cfg.Overrides.ExtraDebs...) {
-		plan.Debs = append(plan.Debs, packages.NewDeb(p))
+	// Snaps without a refresh policy of their own follow the host's.
+	for _, snap := range plan.Snaps {
+		if snap.RefreshPolicy == "" {
+			snap.RefreshPolicy = cfg.Host.RefreshPolicy
+		}
+	}
+
+	plan.Snaps = orderSnaps(plan.Snaps)
+
+	for _, debConfig := range cfg.Host.Packages {
+		deb := packages.NewDebFromString(debConfig.Name)
+		if debConfig.Version != "" {
+			deb.Version = debConfig.Version
+		}
+		deb.Hold = deb.Hold || debConfig.Hold
+		if debConfig.InstallRecommends != nil {
+			deb.NoRecommends = !*debConfig.InstallRecommends
+		}
+		deb.Debconf = debConfig.Debconf
+		plan.Debs = append(plan.Debs, deb)
+	}
+
+	for _, sourceConfig := range cfg.Host.AptSources {
+		plan.Sources = append(plan.Sources, &packages.AptSource{
+			Name:          sourceConfig.Name,
+			PPA:           sourceConfig.PPA,
+			URIs:          sourceConfig.URIs,
+			Suites:        sourceConfig.Suites,
+			Components:    sourceConfig.Components,
+			Architectures: sourceConfig.Architectures,
+			SignedBy:      sourceConfig.SignedBy,
+		})
+	}
+
+	// Add the ExtraDebs specified in the overrides
+	for _, d := range cfg.Overrides.ExtraDebs {
+		plan.Debs = append(plan.Debs, packages.NewDebFromString(d))
+	}
+
+	for _, spec := range cfg.Host.PythonTools {
+		plan.PythonTools = append(plan.PythonTools, packages.NewPythonTool(spec))
 	}
 
 	for _, providerName := range providers.SupportedProviders {
@@ -77,41 +146,177 @@ func (p *Plan) Execute(action string) error {
 		return fmt.Errorf("failed to validate plan: %w", err)
 	}
 
-	var eg errgroup.Group
+	// Check the host can support the plan before making any changes to it.
+	if action == PrepareAction && !p.config.SkipPreflight {
+		err = p.preflight()
+		if err != nil {
+			return fmt.Errorf("preflight checks failed: %w", err)
+		}
+	}
+
+	if action == PrepareAction {
+		err = p.resolveConflicts()
+		if err != nil {
+			return fmt.Errorf("failed to resolve host conflicts: %w", err)
+		}
+
+		err = p.configureProxy()
+		if err != nil {
+			return fmt.Errorf("failed to configure proxy: %w", err)
+		}
+
+		err = p.configureCACertificates()
+		if err != nil {
+			return fmt.Errorf("failed to install CA certificates: %w", err)
+		}
+
+		err = p.configureFiles()
+		if err != nil {
+			return fmt.Errorf("failed to write files: %w", err)
+		}
+
+		err = p.configureKernel()
+		if err != nil {
+			return fmt.Errorf("failed to configure kernel: %w", err)
+		}
+	}
+
+	err = p.waitSnapd(action)
+	if err != nil {
+		return fmt.Errorf("failed waiting for snapd: %w", err)
+	}
 
 	snapHandler := packages.NewSnapHandler(p.system, p.Snaps)
+	snapHandler.State = &p.config.State
+	// Connections may involve snaps installed by the providers or Juju, so they
+	// are made once everything else is in place.
+	snapHandler.DeferConnections = true
+	snapHandler.Artifacts = p.config.Host.Artifacts
 	debHandler := packages.NewDebHandler(p.system, p.Debs)
+	debHandler.Sources = p.Sources
+	debHandler.Artifacts = p.config.Host.Artifacts
+	debHandler.State = &p.config.State
+
+	pythonToolHandler := packages.NewPythonToolHandler(p.system, p.PythonTools)
+	pythonToolHandler.State = &p.config.State
+
+	// Python tools are installed with uv, which may itself be a host package, so they
+	// are prepared alongside the providers once the packages are in place, and
+	// restored before the packages are removed. The additional users are set up last,
+	// and so are restored first, while the provider groups still exist.
+	if action == RestoreAction {
+		err = p.restoreUsers()
+		if err != nil {
+			return fmt.Errorf("failed to restore users: %w", err)
+		}
+
+		err = p.doActions("Python tools", action, planStep{"Python tools", pythonToolHandler})
+		if err != nil {
+			return err
+		}
+	}
 
 	// Prepare/restore package handlers concurrently
-	eg.Go(func() error { return DoAction(snapHandler, action) })
-	eg.Go(func() error { return DoAction(debHandler, action) })
-	if err := eg.Wait(); err != nil {
+	err = p.doActions("Packages", action,
+		planStep{"Snap packages", snapHandler},
+		planStep{"Apt packages", debHandler},
+	)
+	if err != nil {
 		return err
 	}
 
 	// Prepare/restore providers concurrently
+	group := "Providers"
+	steps := []planStep{}
 	for _, provider := range p.Providers {
-		eg.Go(func() error { return DoAction(provider, action) })
+		steps = append(steps, planStep{"Provider " + provider.Name(), provider})
 	}
-	if err := eg.Wait(); err != nil {
+	if action == PrepareAction {
+		group = "Providers and Python tools"
+		steps = append(steps, planStep{"Python tools", pythonToolHandler})
+	}
+	err = p.doActions(group, action, steps...)
+	if err != nil {
 		return err
 	}
 
 	// Skip Juju handler if Juju is disabled in the config
-	if p.config.Juju.Disable {
-		return nil
+	if !p.config.Juju.Disable {
+		// Prepare/Restore juju controllers
+		jujuHandler := juju.NewJujuHandler(p.config, p.system, p.Providers)
+		err = p.doActions("Juju", action, planStep{"Juju", jujuHandler})
+		if err != nil {
+			return fmt.Errorf("failed to prepare Juju: %w", err)
+		}
 	}
 
-	// Prepare/Restore juju controllers
-	jujuHandler := juju.NewJujuHandler(p.config, p.system, p.Providers)
-	err = DoAction(jujuHandler, action)
-	if err != nil {
-		return fmt.Errorf("failed to prepare Juju: %w", err)
+	if action == PrepareAction {
+		err = snapHandler.Connect()
+		if err != nil {
+			return fmt.Errorf("failed to create snap connections: %w", err)
+		}
+
+		err = p.configureUsers()
+		if err != nil {
+			return fmt.Errorf("failed to set up users: %w", err)
+		}
+	}
+
+	// Put back any conflicting software that was stopped or removed during
+	// prepare, now that the providers it conflicted with are gone.
+	if action == RestoreAction {
+		err = p.restoreConflicts()
+		if err != nil {
+			return fmt.Errorf("failed to restore host conflicts: %w", err)
+		}
+
+		err = p.restoreCACertificates()
+		if err != nil {
+			return fmt.Errorf("failed to remove CA certificates: %w", err)
+		}
+
+		err = p.restoreProxy()
+		if err != nil {
+			return fmt.Errorf("failed to restore proxy: %w", err)
+		}
+
+		err = p.restoreFiles()
+		if err != nil {
+			return fmt.Errorf("failed to restore files: %w", err)
+		}
+
+		err = p.restoreKernel()
+		if err != nil {
+			return fmt.Errorf("failed to restore kernel configuration: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// waitSnapd waits, once, for snapd to be ready to change the snaps that the plan
+// installs or removes, so that the first snap change does not race snapd seeding
+// or an auto-refresh on a freshly booted machine. When preparing, auto-refreshes
+// may then be held for the configured duration.
+func (p *Plan) waitSnapd(action string) error {
+	snaps, _ := p.packages()
+	if len(snaps) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(snaps))
+	for _, snap := range snaps {
+		names = append(names, snap.Name)
+	}
+
+	var hold time.Duration
+	if action == PrepareAction {
+		hold = p.config.HoldRefreshes
+	}
+
+	return p.system.WaitSnapd(names, hold)
+}
+
 // validate returns an error if the generated plan contains errors that would prevent a successful
 // configuration of the machine.
 func (p *Plan) validate() error {
@@ -128,6 +333,41 @@ func (p *Plan) validate() error {
 	return nil
 }
 
+// orderSnaps returns the snaps ordered so that each comes after the snaps listed
+// in its After field, otherwise preserving the given order. Dependencies on snaps
+// outside the list are ignored, and snaps in a dependency cycle are left at the
+// end in their original order; validateSnapOrdering reports both as errors.
+func orderSnaps(snaps []*system.Snap) []*system.Snap {
+	ordered := make([]*system.Snap, 0, len(snaps))
+	placed := map[string]bool{}
+	names := map[string]bool{}
+	for _, snap := range snaps {
+		names[snap.Name] = true
+	}
+
+	ready := func(snap *system.Snap) bool {
+		for _, dep := range snap.After {
+			if names[dep] && !placed[dep] {
+				return false
+			}
+		}
+		return true
+	}
+
+	remaining := slices.Clone(snaps)
+	for len(remaining) > 0 {
+		i := slices.IndexFunc(remaining, ready)
+		if i < 0 {
+			break
+		}
+		ordered = append(ordered, remaining[i])
+		placed[remaining[i].Name] = true
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+
+	return append(ordered, remaining...)
+}
+
 // getSnapChannelOverride takes the name of a snap. If the snap's version
 // is overridden, the overridden channel is returned.
 func getSnapChannelOverride(config *config.Config, snap string) string {
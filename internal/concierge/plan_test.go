@@ -1,10 +1,14 @@
 package concierge
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
 )
 
 func TestGetSnapChannelOverride(t *testing.T) {
@@ -32,3 +36,47 @@ func TestGetSnapChannelOverride(t *testing.T) {
 		}
 	}
 }
+
+func TestNewPlanOrdersSnaps(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Host.Snaps = map[string]config.SnapConfig{
+		"yq":     {},
+		"jhack":  {After: []string{"yq", "charm"}},
+		"jq":     {},
+		"charm":  {},
+		"zellij": {},
+	}
+	cfg.Overrides.ExtraSnaps = []string{"node/22/stable"}
+
+	for range 10 {
+		plan := NewPlan(cfg, system.NewMockSystem())
+
+		names := []string{}
+		for _, snap := range plan.Snaps {
+			names = append(names, snap.Name)
+		}
+
+		expected := []string{"charm", "jq", "yq", "jhack", "zellij", "node"}
+		if !reflect.DeepEqual(expected, names) {
+			t.Fatalf("expected: %v, got: %v", expected, names)
+		}
+	}
+}
+
+func TestPlanExecuteWaitsForSnapd(t *testing.T) {
+	cfg := &config.Config{SkipPreflight: true}
+	cfg.Juju.Disable = true
+	cfg.Host.Snaps = map[string]config.SnapConfig{"jq": {}}
+
+	r := system.NewMockSystem()
+	r.MockCommandReturn("snap wait system seed.loaded", nil, fmt.Errorf("snapd was not ready after 10m0s"))
+
+	err := NewPlan(cfg, r).Execute(PrepareAction)
+	if err == nil || !strings.Contains(err.Error(), "snapd was not ready") {
+		t.Fatalf("expected snapd readiness error, got: %v", err)
+	}
+
+	if slices.Contains(r.ExecutedCommands, "snap install jq") {
+		t.Fatalf("expected no snaps to be installed before snapd was ready, got: %v", r.ExecutedCommands)
+	}
+}
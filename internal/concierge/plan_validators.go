@@ -2,12 +2,33 @@ package concierge
 
 import (
 	"fmt"
+	"net/url"
+	"path"
 	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/system"
 )
 
 // planValidators is a list of planValidators used to verify a plan
 var planValidators = []func(p *Plan) error{
 	validateSingleLocalKubernetesInstance,
+	validateConflictPolicies,
+	validateProxy,
+	validateCACertificates,
+	validateSnapConnections,
+	validateSnapOrdering,
+	validateSnapOptions,
+	validateRefreshPolicies,
+	validateAptSources,
+	validatePythonTools,
+	validateFiles,
+	validateKernel,
+	validateUsers,
 }
 
 // validateSingleLocalKubernetesInstance ensures the plan won't try and install multiple
@@ -25,3 +46,256 @@ func validateSingleLocalKubernetesInstance(plan *Plan) error {
 
 	return nil
 }
+
+// validateConflictPolicies ensures that each configured conflict policy names a
+// known conflict, and a policy that can be applied to it.
+func validateConflictPolicies(plan *Plan) error {
+	for name, policy := range plan.config.Host.Conflicts {
+		i := slices.IndexFunc(hostConflicts, func(c hostConflict) bool { return c.name == name })
+		if i < 0 {
+			return fmt.Errorf("unknown host conflict '%s'", name)
+		}
+
+		if !slices.Contains(hostConflicts[i].policies, policy) {
+			return fmt.Errorf("invalid policy '%s' for host conflict '%s', must be one of: %s", policy, name, policyList(hostConflicts[i].policies))
+		}
+	}
+
+	return nil
+}
+
+// validateProxy ensures that any configured proxy is a valid http(s) URL.
+func validateProxy(plan *Plan) error {
+	for key, value := range map[string]string{"http": plan.config.Proxy.HTTP, "https": plan.config.Proxy.HTTPS} {
+		if value == "" {
+			continue
+		}
+
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid proxy.%s '%s', must be an http:// or https:// URL", key, value)
+		}
+	}
+
+	return nil
+}
+
+// validateCACertificates ensures that each configured CA certificate can be read,
+// and is a valid PEM encoded certificate.
+func validateCACertificates(plan *Plan) error {
+	_, err := system.LoadCACertificates(plan.system, plan.config.Host.CACertificates)
+	return err
+}
+
+// validateSnapConnections ensures that each snap connection in the plan names a
+// valid plug, and optionally a valid slot.
+func validateSnapConnections(plan *Plan) error {
+	for _, snap := range plan.Snaps {
+		for _, connection := range snap.Connections {
+			if _, err := system.ParseSnapConnection(connection); err != nil {
+				return fmt.Errorf("invalid connection for snap '%s': %w", snap.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateSnapOrdering ensures that each snap's `after` dependencies name other
+// snaps in the plan, and do not form a cycle.
+func validateSnapOrdering(plan *Plan) error {
+	names := []string{}
+	for _, snap := range plan.Snaps {
+		names = append(names, snap.Name)
+	}
+
+	for _, snap := range plan.Snaps {
+		for _, dep := range snap.After {
+			if dep == snap.Name {
+				return fmt.Errorf("snap '%s' cannot be installed after itself", snap.Name)
+			}
+			if !slices.Contains(names, dep) {
+				return fmt.Errorf("snap '%s' is to be installed after '%s', which is not in the plan", snap.Name, dep)
+			}
+		}
+	}
+
+	// orderSnaps leaves snaps in a cycle at the end, so the ordering is only
+	// valid if every snap comes after its dependencies.
+	position := map[string]int{}
+	for i, snap := range orderSnaps(plan.Snaps) {
+		position[snap.Name] = i
+	}
+
+	for _, snap := range plan.Snaps {
+		for _, dep := range snap.After {
+			if position[dep] > position[snap.Name] {
+				return fmt.Errorf("snap '%s' has a cyclic dependency on '%s'", snap.Name, dep)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateSnapOptions ensures that each snap's install options can be used together.
+func validateSnapOptions(plan *Plan) error {
+	for _, snap := range plan.Snaps {
+		if snap.Dangerous && snap.Path == "" {
+			return fmt.Errorf("snap '%s' is marked dangerous, but is not installed from a local file", snap.Name)
+		}
+
+		if snap.Path != "" && (snap.Channel != "" || snap.Revision != "") {
+			return fmt.Errorf("snap '%s' is installed from a local file, so cannot specify a channel or revision", snap.Name)
+		}
+
+		for alias, app := range snap.Aliases {
+			if alias == "" || app == "" {
+				return fmt.Errorf("invalid alias '%s: %s' for snap '%s'", alias, app, snap.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRefreshPolicies ensures that the host's and each snap's refresh policy is valid.
+func validateRefreshPolicies(plan *Plan) error {
+	host := plan.config.Host.RefreshPolicy
+	if host != "" && !slices.Contains(config.RefreshPolicies, host) {
+		return fmt.Errorf("invalid host refresh policy '%s', must be one of: %s", host, refreshPolicyList())
+	}
+
+	for _, snap := range plan.Snaps {
+		policy := snap.RefreshPolicy
+		if policy != "" && !slices.Contains(config.RefreshPolicies, policy) {
+			return fmt.Errorf("invalid refresh policy '%s' for snap '%s', must be one of: %s", policy, snap.Name, refreshPolicyList())
+		}
+	}
+
+	return nil
+}
+
+// validateAptSources ensures that each apt source is named, has a valid PPA or the
+// repository details that a PPA would otherwise provide, and does not share its name.
+func validateAptSources(plan *Plan) error {
+	names := []string{}
+	for i, source := range plan.Sources {
+		if source.PPA != "" {
+			if _, _, err := packages.ParsePPA(source.PPA); err != nil {
+				return fmt.Errorf("invalid apt source %d: %w", i, err)
+			}
+		} else if len(source.URIs) == 0 || len(source.Suites) == 0 {
+			return fmt.Errorf("invalid apt source %d, must specify a ppa, or uris and suites", i)
+		}
+
+		named, err := source.WithName()
+		if err != nil || named.Name == "" {
+			return fmt.Errorf("invalid apt source %d, must specify a name or a ppa", i)
+		}
+		if strings.ContainsAny(named.Name, "/ ") {
+			return fmt.Errorf("invalid apt source name '%s', must not contain slashes or spaces", named.Name)
+		}
+		if slices.Contains(names, named.Name) {
+			return fmt.Errorf("duplicate apt source '%s'", named.Name)
+		}
+		names = append(names, named.Name)
+	}
+
+	return nil
+}
+
+// validatePythonTools ensures that each python tool names a package, and that no
+// package is listed twice.
+func validatePythonTools(plan *Plan) error {
+	names := []string{}
+	for _, tool := range plan.PythonTools {
+		if tool.Name == "" {
+			return fmt.Errorf("invalid python tool '%s', must start with a package name", tool.Spec)
+		}
+		if slices.Contains(names, tool.Name) {
+			return fmt.Errorf("duplicate python tool '%s'", tool.Name)
+		}
+		names = append(names, tool.Name)
+	}
+
+	return nil
+}
+
+// validateFiles ensures that each host file has a usable path, mode and restore
+// policy, and either content or a template that parses.
+func validateFiles(plan *Plan) error {
+	paths := []string{}
+	for _, file := range plan.config.Host.Files {
+		if !path.IsAbs(file.Path) && !strings.HasPrefix(file.Path, "~/") {
+			return fmt.Errorf("invalid file path '%s', must be absolute or start with ~/", file.Path)
+		}
+		if slices.Contains(paths, file.Path) {
+			return fmt.Errorf("duplicate file '%s'", file.Path)
+		}
+		paths = append(paths, file.Path)
+
+		if file.Content != "" && file.Template != "" {
+			return fmt.Errorf("file '%s' cannot specify both content and a template", file.Path)
+		}
+		if _, err := template.New(file.Path).Parse(file.Template); err != nil {
+			return fmt.Errorf("invalid template for file '%s': %w", file.Path, err)
+		}
+
+		if mode, err := strconv.ParseUint(file.Mode, 8, 32); file.Mode != "" && (err != nil || mode > 0o7777) {
+			return fmt.Errorf("invalid mode '%s' for file '%s', must be in octal, e.g. 0644", file.Mode, file.Path)
+		}
+
+		if file.Restore != "" && !slices.Contains(config.FileRestorePolicies, file.Restore) {
+			return fmt.Errorf("invalid restore policy '%s' for file '%s', must be one of: remove, keep, revert", file.Restore, file.Path)
+		}
+	}
+
+	return nil
+}
+
+// validateKernel ensures that each kernel parameter and module is named, and that
+// neither would break the lines of the drop-in files they are written to.
+func validateKernel(plan *Plan) error {
+	for key, value := range plan.config.Host.Sysctl {
+		if key == "" || strings.ContainsAny(key, "= \t\n") {
+			return fmt.Errorf("invalid kernel parameter '%s'", key)
+		}
+		if value == "" || strings.Contains(value, "\n") {
+			return fmt.Errorf("invalid value '%s' for kernel parameter '%s'", value, key)
+		}
+	}
+
+	for _, module := range plan.config.Host.KernelModules {
+		if module == "" || strings.ContainsAny(module, " \t\n/") {
+			return fmt.Errorf("invalid kernel module '%s'", module)
+		}
+	}
+
+	return nil
+}
+
+// validateUsers ensures that each additional user is named, and named only once.
+func validateUsers(plan *Plan) error {
+	names := []string{}
+	for _, name := range plan.config.Host.Users {
+		if name == "" || strings.ContainsAny(name, " :/") {
+			return fmt.Errorf("invalid user '%s'", name)
+		}
+		if slices.Contains(names, name) {
+			return fmt.Errorf("duplicate user '%s'", name)
+		}
+		names = append(names, name)
+	}
+
+	return nil
+}
+
+// refreshPolicyList returns the valid refresh policies as a comma-separated list.
+func refreshPolicyList() string {
+	names := make([]string, 0, len(config.RefreshPolicies))
+	for _, p := range config.RefreshPolicies {
+		names = append(names, string(p))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,460 @@
+package concierge
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// baseRequirements are the host requirements of concierge itself, regardless of
+// which providers are enabled: room for the host snaps and debs it installs.
+var baseRequirements = providers.Requirements{
+	MemoryMB: 1024,
+	CPUs:     1,
+	DiskMB:   map[string]int{"/var/snap": 2048, "/var/lib": 1024},
+}
+
+// minimumUbuntuRelease is the oldest Ubuntu release concierge is tested against.
+const minimumUbuntuRelease = "22.04"
+
+// PreflightResult is the outcome of a single preflight check.
+type PreflightResult struct {
+	// Check is a short name for the check, e.g. "memory" or "disk /var/snap".
+	Check string
+	// Passed reports whether the host satisfied the check.
+	Passed bool
+	// Warning indicates that a failure should be reported, but should not stop
+	// the machine from being provisioned.
+	Warning bool
+	// Detail describes what was found on the host.
+	Detail string
+	// Remedy is an actionable suggestion for fixing a failed check.
+	Remedy string
+}
+
+// PreflightReport is the set of results from running all preflight checks.
+type PreflightReport []PreflightResult
+
+// Failures returns the failed checks that should prevent provisioning.
+func (r PreflightReport) Failures() []PreflightResult {
+	failures := []PreflightResult{}
+	for _, res := range r {
+		if !res.Passed && !res.Warning {
+			failures = append(failures, res)
+		}
+	}
+	return failures
+}
+
+// String renders the report as a human-readable table, with remedies listed
+// beneath any check that did not pass.
+func (r PreflightReport) String() string {
+	var sb strings.Builder
+
+	for _, res := range r {
+		status := "PASS"
+		if !res.Passed && res.Warning {
+			status = "WARN"
+		} else if !res.Passed {
+			status = "FAIL"
+		}
+
+		fmt.Fprintf(&sb, "%-4s  %-28s  %s\n", status, res.Check, res.Detail)
+		if !res.Passed && res.Remedy != "" {
+			fmt.Fprintf(&sb, "      %-28s  -> %s\n", "", res.Remedy)
+		}
+	}
+
+	return sb.String()
+}
+
+// preflightChecks is the list of checks run against the host before provisioning.
+var preflightChecks = []func(w system.Worker, req providers.Requirements) []PreflightResult{
+	checkArchitecture,
+	checkUbuntuRelease,
+	checkSystemd,
+	checkSnapdSeeded,
+	checkCgroupV2,
+	checkMemory,
+	checkCPUs,
+	checkDisk,
+	checkKernelModules,
+}
+
+// Preflight checks that the host has the resources and platform features
+// needed by the enabled providers, returning a report of every check.
+func (p *Plan) Preflight() PreflightReport {
+	req := p.requirements()
+
+	report := PreflightReport{}
+	for _, check := range preflightChecks {
+		for _, res := range check(p.system, req) {
+			slog.Debug("Preflight check", "check", res.Check, "passed", res.Passed, "detail", res.Detail)
+			report = append(report, res)
+		}
+	}
+
+	return report
+}
+
+// preflight runs the preflight checks, logging any warnings, and returns an
+// error listing every failed check.
+func (p *Plan) preflight() error {
+	report := p.Preflight()
+
+	for _, res := range report {
+		if !res.Passed && res.Warning {
+			slog.Warn("Preflight check failed", "check", res.Check, "detail", res.Detail, "remedy", res.Remedy)
+		}
+	}
+
+	failures := report.Failures()
+	if len(failures) == 0 {
+		return nil
+	}
+
+	msgs := []string{}
+	for _, f := range failures {
+		msgs = append(msgs, fmt.Sprintf("%s: %s (%s)", f.Check, f.Detail, f.Remedy))
+	}
+
+	return fmt.Errorf("host does not meet requirements (use --skip-preflight to override): %s", strings.Join(msgs, "; "))
+}
+
+// requirements combines concierge's own requirements with those of each enabled
+// provider. Memory and disk are summed since the providers run side by side;
+// the CPU requirement, and each kernel parameter, is the largest of any provider.
+func (p *Plan) requirements() providers.Requirements {
+	combined := providers.Requirements{DiskMB: map[string]int{}, Sysctl: map[string]int{}}
+
+	reqs := []providers.Requirements{baseRequirements}
+	for _, provider := range p.Providers {
+		reqs = append(reqs, provider.Requirements())
+	}
+
+	for _, r := range reqs {
+		combined.MemoryMB += r.MemoryMB
+		combined.CPUs = max(combined.CPUs, r.CPUs)
+		combined.CgroupV2 = combined.CgroupV2 || r.CgroupV2
+
+		for dir, mb := range r.DiskMB {
+			combined.DiskMB[dir] += mb
+		}
+
+		for _, mod := range r.KernelModules {
+			if !slices.Contains(combined.KernelModules, mod) {
+				combined.KernelModules = append(combined.KernelModules, mod)
+			}
+		}
+
+		for key, value := range r.Sysctl {
+			combined.Sysctl[key] = max(combined.Sysctl[key], value)
+		}
+
+		if len(r.Architectures) == 0 {
+			continue
+		}
+		if combined.Architectures == nil {
+			combined.Architectures = slices.Clone(r.Architectures)
+			continue
+		}
+		combined.Architectures = slices.DeleteFunc(combined.Architectures, func(a string) bool {
+			return !slices.Contains(r.Architectures, a)
+		})
+	}
+
+	return combined
+}
+
+// checkArchitecture verifies that every enabled provider supports the host architecture.
+func checkArchitecture(_ system.Worker, req providers.Requirements) []PreflightResult {
+	res := PreflightResult{Check: "architecture", Passed: true, Detail: runtime.GOARCH}
+
+	if req.Architectures != nil && !slices.Contains(req.Architectures, runtime.GOARCH) {
+		res.Passed = false
+		res.Detail = fmt.Sprintf("%s is not supported by the enabled providers", runtime.GOARCH)
+		res.Remedy = fmt.Sprintf("use a machine with one of: %s", strings.Join(req.Architectures, ", "))
+	}
+
+	return []PreflightResult{res}
+}
+
+// checkUbuntuRelease warns if the host is not a supported Ubuntu release. Other
+// distributions may work, so this never blocks provisioning.
+func checkUbuntuRelease(w system.Worker, _ providers.Requirements) []PreflightResult {
+	res := PreflightResult{Check: "ubuntu release", Warning: true}
+
+	contents, err := w.ReadFile("/etc/os-release")
+	if err != nil {
+		res.Detail = "unable to read /etc/os-release"
+		res.Remedy = "concierge is only tested on Ubuntu"
+		return []PreflightResult{res}
+	}
+
+	fields := system.ParseOSRelease(string(contents))
+	id, version := fields["ID"], fields["VERSION_ID"]
+
+	res.Detail = strings.TrimSpace(id + " " + version)
+	switch {
+	case id != "ubuntu":
+		res.Remedy = "concierge is only tested on Ubuntu"
+	case compareVersions(version, minimumUbuntuRelease) < 0:
+		res.Remedy = fmt.Sprintf("upgrade to Ubuntu %s or later", minimumUbuntuRelease)
+	default:
+		res.Passed = true
+	}
+
+	return []PreflightResult{res}
+}
+
+// checkSystemd verifies that systemd is the init system, which snapd requires.
+func checkSystemd(w system.Worker, _ providers.Requirements) []PreflightResult {
+	res := PreflightResult{Check: "systemd", Passed: true, Detail: "running"}
+
+	contents, err := w.ReadFile("/proc/1/comm")
+	if err != nil || strings.TrimSpace(string(contents)) != "systemd" {
+		res.Passed = false
+		res.Detail = "systemd is not the init system"
+		res.Remedy = "use a machine or container image that boots with systemd"
+	}
+
+	return []PreflightResult{res}
+}
+
+// checkSnapdSeeded verifies that snapd is installed and has finished seeding,
+// without which every snap operation fails.
+func checkSnapdSeeded(w system.Worker, _ providers.Requirements) []PreflightResult {
+	res := PreflightResult{Check: "snapd", Passed: true, Detail: "seeded"}
+
+	cmd := system.NewCommand("timeout", []string{"60", "snap", "wait", "system", "seed.loaded"})
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `.*`
+	if _, err := w.Run(cmd); err != nil {
+		res.Passed = false
+		res.Detail = "snapd is not installed, or has not finished seeding"
+		res.Remedy = "install snapd and wait for `snap wait system seed.loaded` to complete"
+	}
+
+	return []PreflightResult{res}
+}
+
+// checkCgroupV2 verifies that the unified cgroup v2 hierarchy is mounted, where
+// an enabled provider requires it.
+func checkCgroupV2(w system.Worker, req providers.Requirements) []PreflightResult {
+	if !req.CgroupV2 {
+		return nil
+	}
+
+	res := PreflightResult{Check: "cgroup v2", Passed: true, Detail: "unified hierarchy mounted"}
+
+	if _, err := w.ReadFile("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		res.Passed = false
+		res.Detail = "cgroup v2 unified hierarchy is not mounted"
+		res.Remedy = "boot with systemd.unified_cgroup_hierarchy=1"
+	}
+
+	return []PreflightResult{res}
+}
+
+// memoryMarginPercent is how far short of the required memory a host may fall, as a
+// percentage of the requirement, and only be warned about rather than fail.
+const memoryMarginPercent = 15
+
+// checkMemory verifies that the host has enough RAM for the enabled providers.
+func checkMemory(w system.Worker, req providers.Requirements) []PreflightResult {
+	res := PreflightResult{Check: "memory"}
+
+	contents, err := w.ReadFile("/proc/meminfo")
+	if err != nil {
+		res.Warning = true
+		res.Detail = "unable to read /proc/meminfo"
+		return []PreflightResult{res}
+	}
+
+	totalMB := -1
+	for line := range strings.SplitSeq(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			if kb, err := strconv.Atoi(fields[1]); err == nil {
+				totalMB = kb / 1024
+			}
+		}
+	}
+
+	if totalMB < 0 {
+		res.Warning = true
+		res.Detail = "unable to determine total memory"
+		return []PreflightResult{res}
+	}
+
+	res.Detail = fmt.Sprintf("%d MB total, %d MB required", totalMB, req.MemoryMB)
+	res.Passed = totalMB >= req.MemoryMB
+	if !res.Passed {
+		res.Remedy = "use a machine with more memory, or enable fewer providers"
+		// The kernel reserves some memory, so a machine sold with exactly the required
+		// amount reports less. Small shortfalls are only warned about.
+		res.Warning = totalMB >= req.MemoryMB*(100-memoryMarginPercent)/100
+	}
+
+	return []PreflightResult{res}
+}
+
+// checkCPUs verifies that the host has enough CPUs for the enabled providers.
+func checkCPUs(w system.Worker, req providers.Requirements) []PreflightResult {
+	res := PreflightResult{Check: "cpus"}
+
+	cmd := system.NewCommand("nproc", []string{})
+	cmd.ReadOnly = true
+	output, err := w.Run(cmd)
+	cpus, convErr := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil || convErr != nil {
+		res.Warning = true
+		res.Detail = "unable to determine CPU count"
+		return []PreflightResult{res}
+	}
+
+	res.Detail = fmt.Sprintf("%d available, %d required", cpus, req.CPUs)
+	res.Passed = cpus >= req.CPUs
+	if !res.Passed {
+		res.Remedy = "use a machine with more CPUs, or enable fewer providers"
+	}
+
+	return []PreflightResult{res}
+}
+
+// diskUsage is the space required of a filesystem by the paths that it holds.
+type diskUsage struct {
+	dirs       []string
+	freeMB     int
+	requiredMB int
+}
+
+// checkDisk verifies that each filesystem holding the paths used by concierge and
+// the enabled providers has enough free space for all of them, since paths such as
+// /var/snap and /var/lib are usually on the same filesystem.
+func checkDisk(w system.Worker, req providers.Requirements) []PreflightResult {
+	results := []PreflightResult{}
+
+	usage := map[string]*diskUsage{}
+	for _, dir := range slices.Sorted(maps.Keys(req.DiskMB)) {
+		mount, free, err := diskFree(w, dir)
+		if err != nil {
+			results = append(results, PreflightResult{
+				Check:   "disk " + dir,
+				Warning: true,
+				Detail:  fmt.Sprintf("unable to determine free space: %s", err),
+			})
+			continue
+		}
+
+		if usage[mount] == nil {
+			usage[mount] = &diskUsage{freeMB: free}
+		}
+		usage[mount].dirs = append(usage[mount].dirs, dir)
+		usage[mount].requiredMB += req.DiskMB[dir]
+	}
+
+	for _, mount := range slices.Sorted(maps.Keys(usage)) {
+		u := usage[mount]
+		res := PreflightResult{
+			Check:  "disk " + mount,
+			Detail: fmt.Sprintf("%d MB free, %d MB required for %s", u.freeMB, u.requiredMB, strings.Join(u.dirs, ", ")),
+			Passed: u.freeMB >= u.requiredMB,
+		}
+		if !res.Passed {
+			res.Remedy = fmt.Sprintf("free up or add disk space on the filesystem mounted at %s", mount)
+		}
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// checkKernelModules verifies that each kernel module required by the enabled
+// providers is either loaded, or available to be loaded.
+func checkKernelModules(w system.Worker, req providers.Requirements) []PreflightResult {
+	loaded, _ := w.ReadFile("/proc/modules")
+
+	results := []PreflightResult{}
+	for _, mod := range req.KernelModules {
+		res := PreflightResult{Check: "kernel module " + mod, Passed: true, Detail: "loaded"}
+
+		if !slices.ContainsFunc(strings.Split(string(loaded), "\n"), func(l string) bool {
+			return strings.HasPrefix(l, mod+" ")
+		}) {
+			cmd := system.NewCommand("modinfo", []string{mod})
+			cmd.ReadOnly = true
+			cmd.ExpectedError = `.*`
+			if _, err := w.Run(cmd); err != nil {
+				res.Passed = false
+				res.Detail = "not loaded, and not available to load"
+				res.Remedy = fmt.Sprintf("install the linux-modules-extra package for the running kernel, or build %s into it", mod)
+			} else {
+				res.Detail = "available"
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// diskFree reports the mount point of the filesystem holding dir, and its free
+// space in megabytes. If dir does not exist yet, its nearest existing parent is
+// used instead.
+func diskFree(w system.Worker, dir string) (string, int, error) {
+	for {
+		cmd := system.NewCommand("df", []string{"--output=target,avail", "-BM", dir})
+		cmd.ReadOnly = true
+		cmd.ExpectedError = `No such file or directory`
+		output, err := w.Run(cmd)
+		if err == nil {
+			lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+			fields := strings.Fields(lines[len(lines)-1])
+			if len(lines) < 2 || len(fields) < 2 {
+				return "", 0, fmt.Errorf("unexpected df output")
+			}
+
+			// The mount point may contain spaces, but the free space never does.
+			mount := strings.Join(fields[:len(fields)-1], " ")
+			free, err := strconv.Atoi(strings.TrimSuffix(fields[len(fields)-1], "M"))
+			return mount, free, err
+		}
+
+		if dir == "/" {
+			return "", 0, err
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// compareVersions compares two dotted numeric versions such as "22.04",
+// returning -1, 0 or 1. Non-numeric components compare as zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
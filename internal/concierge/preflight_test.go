@@ -0,0 +1,198 @@
+package concierge
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// mockHealthyHost configures a mock system that satisfies every preflight check
+// for the given amount of memory, CPUs and free disk space.
+func mockHealthyHost(memoryMB, cpus, diskMB int) *system.MockSystem {
+	sys := system.NewMockSystem()
+	sys.MockFile("/etc/os-release", []byte("NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"24.04\"\n"))
+	sys.MockFile("/proc/1/comm", []byte("systemd\n"))
+	sys.MockFile("/sys/fs/cgroup/cgroup.controllers", []byte("cpuset cpu io memory pids\n"))
+	sys.MockFile("/proc/meminfo", fmt.Appendf(nil, "MemTotal:       %d kB\nMemFree:        1024 kB\n", memoryMB*1024))
+	sys.MockFile("/proc/modules", []byte("overlay 151552 0 - Live 0x0000000000000000\n"))
+	sys.MockCommandReturn("nproc", fmt.Appendf(nil, "%d\n", cpus), nil)
+	for _, dir := range []string{"/var/snap", "/var/lib"} {
+		sys.MockCommandReturn("df --output=target,avail -BM "+dir, fmt.Appendf(nil, "Mounted on Avail\n/ %dM\n", diskMB), nil)
+	}
+	return sys
+}
+
+func TestPreflightPasses(t *testing.T) {
+	if !slices.Contains([]string{"amd64", "arm64"}, runtime.GOARCH) {
+		t.Skip("k8s is not supported on this architecture")
+	}
+
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+	cfg.Providers.LXD.Enable = true
+
+	plan := NewPlan(cfg, mockHealthyHost(16384, 4, 50000))
+	report := plan.Preflight()
+
+	if failures := report.Failures(); len(failures) > 0 {
+		t.Fatalf("expected no failures, got: %v\n%s", failures, report)
+	}
+	if err := plan.preflight(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestPreflightFailsOnSmallHost(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+	cfg.Providers.LXD.Enable = true
+
+	plan := NewPlan(cfg, mockHealthyHost(2048, 1, 4096))
+	report := plan.Preflight()
+
+	failed := []string{}
+	for _, f := range report.Failures() {
+		failed = append(failed, f.Check)
+	}
+
+	for _, check := range []string{"memory", "cpus", "disk /"} {
+		if !slices.Contains(failed, check) {
+			t.Fatalf("expected check %q to fail, got failures: %v", check, failed)
+		}
+	}
+
+	err := plan.preflight()
+	if err == nil || !strings.Contains(err.Error(), "--skip-preflight") {
+		t.Fatalf("expected actionable error, got: %v", err)
+	}
+}
+
+func TestCheckDiskSumsRequirementsPerFilesystem(t *testing.T) {
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("df --output=target,avail -BM /var/lib", []byte("Mounted on Avail\n/ 5000M\n"), nil)
+	sys.MockCommandReturn("df --output=target,avail -BM /var/snap", []byte("Mounted on Avail\n/ 5000M\n"), nil)
+	sys.MockCommandReturn("df --output=target,avail -BM /srv/data", []byte("Mounted on Avail\n/srv/my data 3000M\n"), nil)
+
+	// Each path fits on its own, but /var/lib and /var/snap do not fit together.
+	req := providers.Requirements{DiskMB: map[string]int{"/var/lib": 2048, "/var/snap": 4096, "/srv/data": 1024}}
+	results := checkDisk(sys, req)
+
+	expected := []PreflightResult{
+		{Check: "disk /", Detail: "5000 MB free, 6144 MB required for /var/lib, /var/snap", Remedy: "free up or add disk space on the filesystem mounted at /"},
+		{Check: "disk /srv/my data", Detail: "3000 MB free, 1024 MB required for /srv/data", Passed: true},
+	}
+	if !reflect.DeepEqual(expected, results) {
+		t.Fatalf("expected: %v, got: %v", expected, results)
+	}
+}
+
+func TestPreflightKernelModules(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.MicroK8s.Enable = true
+	cfg.Providers.MicroK8s.Channel = "1.32-strict/stable"
+
+	sys := mockHealthyHost(16384, 4, 50000)
+	sys.MockCommandReturn("modinfo br_netfilter", nil, fmt.Errorf("module not found"))
+
+	report := NewPlan(cfg, sys).Preflight()
+
+	for _, res := range report {
+		switch res.Check {
+		case "kernel module overlay":
+			if !res.Passed || res.Detail != "loaded" {
+				t.Fatalf("expected overlay to be loaded, got: %+v", res)
+			}
+		case "kernel module br_netfilter":
+			if res.Passed {
+				t.Fatalf("expected br_netfilter check to fail, got: %+v", res)
+			}
+		}
+	}
+}
+
+func TestPreflightWarnsOnNonUbuntu(t *testing.T) {
+	sys := mockHealthyHost(16384, 4, 50000)
+	sys.MockFile("/etc/os-release", []byte("ID=debian\nVERSION_ID=\"12\"\n"))
+
+	report := NewPlan(&config.Config{}, sys).Preflight()
+
+	if len(report.Failures()) > 0 {
+		t.Fatalf("expected non-Ubuntu host to only warn, got: %v", report.Failures())
+	}
+	if !strings.Contains(report.String(), "WARN  ubuntu release") {
+		t.Fatalf("expected warning in report, got:\n%s", report)
+	}
+}
+
+func TestPlanRequirements(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+	cfg.Providers.LXD.Enable = true
+
+	req := NewPlan(cfg, system.NewMockSystem()).requirements()
+
+	if req.MemoryMB != 1024+1024+2048 {
+		t.Fatalf("expected memory to be summed, got: %d", req.MemoryMB)
+	}
+	if req.CPUs != 2 {
+		t.Fatalf("expected largest CPU requirement, got: %d", req.CPUs)
+	}
+	if req.DiskMB["/var/snap"] != 2048+2048+4096 {
+		t.Fatalf("expected /var/snap requirements to be summed, got: %d", req.DiskMB["/var/snap"])
+	}
+	if !req.CgroupV2 {
+		t.Fatalf("expected cgroup v2 to be required by k8s")
+	}
+	if !slices.Equal(req.Architectures, []string{"amd64", "arm64"}) {
+		t.Fatalf("expected k8s architectures, got: %v", req.Architectures)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"22.04", "22.04", 0},
+		{"20.04", "22.04", -1},
+		{"24.10", "22.04", 1},
+		{"22.04.1", "22.04", 1},
+	}
+
+	for _, tc := range tests {
+		if got := compareVersions(tc.a, tc.b); got != tc.expected {
+			t.Fatalf("compareVersions(%q, %q): expected %d, got %d", tc.a, tc.b, tc.expected, got)
+		}
+	}
+}
+
+func TestPreflightMemoryMargin(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Enable = true
+	cfg.Providers.LXD.Enable = true
+
+	// A "4 GB" machine reports less than 4096 MB, which is only warned about.
+	plan := NewPlan(cfg, mockHealthyHost(3900, 4, 100000))
+	for _, res := range plan.Preflight() {
+		if res.Check == "memory" && (res.Passed || !res.Warning) {
+			t.Fatalf("expected: %v, got: %v", "memory warning", res)
+		}
+	}
+	if err := plan.preflight(); err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+
+	plan = NewPlan(cfg, mockHealthyHost(3000, 4, 100000))
+	for _, res := range plan.Preflight() {
+		if res.Check == "memory" && (res.Passed || res.Warning) {
+			t.Fatalf("expected: %v, got: %v", "memory failure", res)
+		}
+	}
+}
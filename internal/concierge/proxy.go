@@ -0,0 +1,166 @@
+package concierge
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+// aptProxyConfPath is the apt configuration drop-in written when a proxy is configured.
+const aptProxyConfPath = "/etc/apt/apt.conf.d/95concierge-proxy"
+
+// snapdProxyOptions returns the snapd system options that carry the configured
+// proxy, mapped to the value each should be set to.
+func (p *Plan) snapdProxyOptions() map[string]string {
+	return map[string]string{
+		"proxy.http":     p.config.Proxy.HTTP,
+		"proxy.https":    p.config.Proxy.HTTPS,
+		"proxy.no-proxy": p.config.Proxy.NoProxy,
+	}
+}
+
+// configureProxy points snapd and apt at the configured proxy. This happens before
+// any packages are installed, so that the installs themselves go via the proxy.
+// The providers and Juju configure their own proxy settings as they are prepared.
+func (p *Plan) configureProxy() error {
+	if !p.config.Proxy.Enabled() {
+		return nil
+	}
+
+	// The proxy URLs may hold credentials, which are registered as secrets.
+	slog.Info("Configuring proxy", "http", system.Redact(p.config.Proxy.HTTP), "https", system.Redact(p.config.Proxy.HTTPS), "no-proxy", p.config.Proxy.NoProxy)
+
+	err := p.configureSnapdProxy()
+	if err != nil {
+		return fmt.Errorf("failed to configure snapd proxy: %w", err)
+	}
+
+	err = p.system.WriteFile(aptProxyConfPath, []byte(p.aptProxyConf()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write apt proxy configuration: %w", err)
+	}
+
+	return nil
+}
+
+// configureSnapdProxy sets snapd's system proxy options, first recording the
+// values they had so that restoreProxy can put them back.
+func (p *Plan) configureSnapdProxy() error {
+	options := p.snapdProxyOptions()
+	keys := slices.Sorted(maps.Keys(options))
+
+	// Only record the original values on the first run; on later runs the
+	// options already hold concierge's values.
+	if p.config.State.SnapdProxy == nil {
+		previous := map[string]string{}
+		for _, key := range keys {
+			value, err := p.snapdOption(key)
+			if err != nil {
+				return err
+			}
+			previous[key] = value
+		}
+		p.config.State.SnapdProxy = previous
+	}
+
+	args := []string{"set", "system"}
+	for _, key := range keys {
+		if options[key] != "" {
+			args = append(args, fmt.Sprintf("%s=%s", key, options[key]))
+		}
+	}
+
+	_, err := system.RunExclusive(p.system, system.NewCommand("snap", args))
+	return err
+}
+
+// snapdOption returns the current value of a snapd system option, or "" if the
+// option is unset.
+func (p *Plan) snapdOption(key string) (string, error) {
+	cmd := system.NewCommand("snap", []string{"get", "system", key})
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `has no .* configuration option`
+
+	output, err := p.system.Run(cmd)
+	if err != nil {
+		if cmd.IsExpectedError(output) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read snapd option '%s': %w", key, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// aptProxyConf renders the apt configuration for the proxy. Hosts in the no-proxy
+// list are reached directly, though apt only understands exact host names, so
+// domain suffixes and CIDRs are skipped.
+func (p *Plan) aptProxyConf() string {
+	var sb strings.Builder
+
+	sb.WriteString("// Written by concierge, and removed by `concierge restore`.\n")
+
+	if p.config.Proxy.HTTP != "" {
+		fmt.Fprintf(&sb, "Acquire::http::Proxy %q;\n", p.config.Proxy.HTTP)
+	}
+	if p.config.Proxy.HTTPS != "" {
+		fmt.Fprintf(&sb, "Acquire::https::Proxy %q;\n", p.config.Proxy.HTTPS)
+	}
+
+	for host := range strings.SplitSeq(p.config.Proxy.NoProxy, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" || strings.ContainsAny(host, "/*") || strings.HasPrefix(host, ".") {
+			continue
+		}
+		for _, scheme := range []string{"http", "https"} {
+			fmt.Fprintf(&sb, "Acquire::%s::Proxy::%s \"DIRECT\";\n", scheme, host)
+		}
+	}
+
+	return sb.String()
+}
+
+// restoreProxy puts snapd's proxy options back to the values recorded before
+// concierge changed them, and removes the apt configuration drop-in.
+func (p *Plan) restoreProxy() error {
+	previous := p.config.State.SnapdProxy
+	if previous == nil && !p.config.Proxy.Enabled() {
+		return nil
+	}
+
+	if previous != nil {
+		setArgs := []string{"set", "system"}
+		unsetArgs := []string{"unset", "system"}
+		for _, key := range slices.Sorted(maps.Keys(previous)) {
+			if previous[key] == "" {
+				unsetArgs = append(unsetArgs, key)
+			} else {
+				setArgs = append(setArgs, fmt.Sprintf("%s=%s", key, previous[key]))
+			}
+		}
+
+		for _, args := range [][]string{setArgs, unsetArgs} {
+			if len(args) == 2 {
+				continue
+			}
+			_, err := system.RunExclusive(p.system, system.NewCommand("snap", args))
+			if err != nil {
+				return fmt.Errorf("failed to restore snapd proxy: %w", err)
+			}
+		}
+	}
+
+	err := p.system.RemovePath(aptProxyConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove apt proxy configuration: %w", err)
+	}
+
+	p.config.State.SnapdProxy = nil
+
+	slog.Info("Restored proxy configuration")
+	return nil
+}
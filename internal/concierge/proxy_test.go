@@ -0,0 +1,113 @@
+package concierge
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestConfigureProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy = config.ProxyConfig{
+		HTTP:    "http://proxy:3128",
+		HTTPS:   "http://proxy:3128",
+		NoProxy: "localhost,.internal,10.0.0.0/8",
+	}
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("snap get system proxy.http", []byte("http://old:8080\n"), nil)
+	for _, key := range []string{"proxy.https", "proxy.no-proxy"} {
+		sys.MockCommandReturn(
+			"snap get system "+key,
+			fmt.Appendf(nil, `error: snap "core" has no %q configuration option`, key),
+			fmt.Errorf("exit status 1"),
+		)
+	}
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.configureProxy(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap get system proxy.http",
+		"snap get system proxy.https",
+		"snap get system proxy.no-proxy",
+		"snap set system proxy.http=http://proxy:3128 proxy.https=http://proxy:3128 proxy.no-proxy=localhost,.internal,10.0.0.0/8",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedAptConf := `// Written by concierge, and removed by ` + "`concierge restore`" + `.
+Acquire::http::Proxy "http://proxy:3128";
+Acquire::https::Proxy "http://proxy:3128";
+Acquire::http::Proxy::localhost "DIRECT";
+Acquire::https::Proxy::localhost "DIRECT";
+`
+	if got := sys.CreatedFiles[aptProxyConfPath]; got != expectedAptConf {
+		t.Fatalf("expected: %v, got: %v", expectedAptConf, got)
+	}
+
+	expectedState := map[string]string{"proxy.http": "http://old:8080", "proxy.https": "", "proxy.no-proxy": ""}
+	if !reflect.DeepEqual(expectedState, cfg.State.SnapdProxy) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.SnapdProxy)
+	}
+
+	// A second prepare must not overwrite the original values with concierge's own.
+	sys.MockCommandReturn("snap get system proxy.http", []byte("http://proxy:3128\n"), nil)
+	if err := plan.configureProxy(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedState, cfg.State.SnapdProxy) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.SnapdProxy)
+	}
+}
+
+func TestRestoreProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.HTTP = "http://proxy:3128"
+	cfg.State.SnapdProxy = map[string]string{"proxy.http": "http://old:8080", "proxy.https": "", "proxy.no-proxy": ""}
+
+	sys := system.NewMockSystem()
+	if err := NewPlan(cfg, sys).restoreProxy(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap set system proxy.http=http://old:8080",
+		"snap unset system proxy.https proxy.no-proxy",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedRemoved := []string{aptProxyConfPath}
+	if !reflect.DeepEqual(expectedRemoved, sys.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", expectedRemoved, sys.RemovedPaths)
+	}
+
+	if cfg.State.SnapdProxy != nil {
+		t.Fatalf("expected recorded snapd proxy to be cleared, got: %v", cfg.State.SnapdProxy)
+	}
+}
+
+func TestProxyNotConfigured(t *testing.T) {
+	sys := system.NewMockSystem()
+	plan := NewPlan(&config.Config{}, sys)
+
+	if err := plan.configureProxy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.restoreProxy(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sys.ExecutedCommands) > 0 || len(sys.CreatedFiles) > 0 || len(sys.RemovedPaths) > 0 {
+		t.Fatalf("expected no changes without a proxy, got commands: %v, files: %v, removed: %v",
+			sys.ExecutedCommands, sys.CreatedFiles, sys.RemovedPaths)
+	}
+}
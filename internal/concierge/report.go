@@ -0,0 +1,76 @@
+package concierge
+
+import (
+	"strings"
+	"time"
+
+	"github.com/canonical/concierge/internal/ci"
+	"golang.org/x/sync/errgroup"
+)
+
+// planStep is a named step of the plan, which prepares or restores an Executable.
+type planStep struct {
+	name       string
+	executable Executable
+}
+
+// doActions runs DoAction for each of the steps concurrently, as a named group,
+// recording how long each step took and whether it failed. The group is reported
+// to the CI system as a whole, since the logs of its steps are interleaved. It
+// returns the first error that a step failed with.
+func (p *Plan) doActions(group string, action string, steps ...planStep) error {
+	if p.reporter != nil {
+		p.reporter.StartGroup(group)
+	}
+
+	var eg errgroup.Group
+	results := make([]ci.Step, len(steps))
+	for i, s := range steps {
+		eg.Go(func() error {
+			start := time.Now()
+			err := DoAction(s.executable, action)
+			results[i] = ci.Step{Name: s.name, Duration: time.Since(start), Err: err}
+			return err
+		})
+	}
+	err := eg.Wait()
+
+	p.steps = append(p.steps, results...)
+
+	if p.reporter != nil {
+		p.reporter.EndGroup(group, results)
+	}
+
+	return err
+}
+
+// runReport describes a run of the plan that took the specified duration and ended
+// with err. Once the machine is successfully prepared, it also describes what was
+// set up: the installed tools, the Juju controllers and the environment.
+func (p *Plan) runReport(action string, duration time.Duration, err error) *ci.Run {
+	run := &ci.Run{
+		Action:   action,
+		Duration: duration,
+		Err:      err,
+		Steps:    p.steps,
+	}
+
+	if action != PrepareAction || err != nil {
+		return run
+	}
+
+	run.Tools = p.installedTools()
+	run.Controllers = p.controllers()
+	for _, v := range p.Environment() {
+		run.Outputs = append(run.Outputs, ci.Output{Name: outputName(v.Name), Value: v.Value})
+	}
+
+	return run
+}
+
+// outputName returns the name of the CI output for an environment variable, e.g.
+// "k8s-controller" for CONCIERGE_K8S_CONTROLLER.
+func outputName(envName string) string {
+	name := strings.TrimPrefix(envName, "CONCIERGE_")
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
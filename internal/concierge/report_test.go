@@ -0,0 +1,138 @@
+package concierge
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/ci"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+type testReporter struct {
+	events []string
+}
+
+func (r *testReporter) StartGroup(name string) { r.events = append(r.events, "start "+name) }
+
+func (r *testReporter) EndGroup(name string, steps []ci.Step) {
+	r.events = append(r.events, "end "+name)
+	for _, step := range steps {
+		r.events = append(r.events, fmt.Sprintf("step %s %v", step.Name, step.Err))
+	}
+}
+
+func (r *testReporter) Finish(run *ci.Run) error { return nil }
+
+type testExecutable struct {
+	err error
+	// wait, if set, blocks Prepare until it is closed. release, if set, is closed
+	// by Prepare.
+	wait    chan struct{}
+	release chan struct{}
+}
+
+func (e *testExecutable) Prepare() error {
+	if e.release != nil {
+		close(e.release)
+	}
+	if e.wait != nil {
+		<-e.wait
+	}
+	return e.err
+}
+
+func (e *testExecutable) Restore() error { return e.err }
+
+func TestDoActionsReportsSteps(t *testing.T) {
+	reporter := &testReporter{}
+	plan := NewPlan(&config.Config{}, system.NewMockSystem())
+	plan.reporter = reporter
+
+	failure := fmt.Errorf("exit status 1")
+
+	err := plan.doActions("Packages", PrepareAction,
+		planStep{"Snap packages", &testExecutable{}},
+		planStep{"Apt packages", &testExecutable{}},
+	)
+	if err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+
+	err = plan.doActions("Juju", PrepareAction, planStep{"Juju", &testExecutable{err: failure}})
+	if err != failure {
+		t.Fatalf("expected: %v, got: %v", failure, err)
+	}
+
+	expectedEvents := []string{
+		"start Packages", "end Packages", "step Snap packages <nil>", "step Apt packages <nil>",
+		"start Juju", "end Juju", "step Juju exit status 1",
+	}
+	if !reflect.DeepEqual(reporter.events, expectedEvents) {
+		t.Fatalf("expected: %v, got: %v", expectedEvents, reporter.events)
+	}
+
+	if len(plan.steps) != 3 || plan.steps[0].Name != "Snap packages" || plan.steps[2].Err != failure {
+		t.Fatalf("expected: %v, got: %v", "three steps, the last failed", plan.steps)
+	}
+}
+
+func TestDoActionsRunsConcurrently(t *testing.T) {
+	plan := NewPlan(&config.Config{}, system.NewMockSystem())
+	plan.reporter = &testReporter{}
+
+	// The first step only finishes once the second has started, so the group can
+	// only finish if its steps run concurrently.
+	ch := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- plan.doActions("Providers", PrepareAction,
+			planStep{"Provider lxd", &testExecutable{wait: ch}},
+			planStep{"Provider k8s", &testExecutable{release: ch}},
+		)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected: nil, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected: %v, got: %v", "steps to run concurrently", "timeout")
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juju.Disable = true
+	cfg.Providers.LXD.Enable = true
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("snap list", []byte("Name  Version  Rev  Tracking  Publisher  Notes\nlxd   5.21.3   33110  5.21/stable  canonical✓  -\n"), nil)
+	plan := NewPlan(cfg, sys)
+
+	run := plan.runReport(PrepareAction, 0, nil)
+
+	expectedTools := []ci.Tool{{Name: "lxd", Version: "5.21.3"}}
+	if !reflect.DeepEqual(run.Tools, expectedTools) {
+		t.Fatalf("expected: %v, got: %v", expectedTools, run.Tools)
+	}
+
+	expectedOutputs := []ci.Output{
+		{Name: "juju-data", Value: sys.User().HomeDir + "/.local/share/juju"},
+		{Name: "providers", Value: "lxd"},
+		{Name: "lxd-endpoint", Value: lxdSocket},
+		{Name: "lxd-version", Value: "5.21.3"},
+	}
+	if !reflect.DeepEqual(run.Outputs, expectedOutputs) {
+		t.Fatalf("expected: %v, got: %v", expectedOutputs, run.Outputs)
+	}
+
+	// A failed run, or a restore, does not describe the machine.
+	run = plan.runReport(PrepareAction, 0, fmt.Errorf("failed"))
+	if run.Tools != nil || run.Outputs != nil {
+		t.Fatalf("expected: %v, got: %v", "no tools or outputs", run)
+	}
+}
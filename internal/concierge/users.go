@@ -0,0 +1,217 @@
+package concierge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// userClientFiles are the files, relative to the home directory, that give a user
+// access to the Kubernetes clusters of the providers, and to the Juju controllers.
+// Each set of files is copied as a unit: the Juju client data files refer to one
+// another, so copying some of them alongside a user's own would mix two sets of
+// controllers and credentials.
+var userClientFiles = [][]string{
+	{".kube/config"},
+	{
+		".local/share/juju/controllers.yaml",
+		".local/share/juju/accounts.yaml",
+		".local/share/juju/models.yaml",
+		".local/share/juju/clouds.yaml",
+		".local/share/juju/credentials.yaml",
+	},
+}
+
+// configureUsers sets up each additional user alongside the user running concierge:
+// it adds them to the groups that allow access to the providers, and copies over
+// the kubeconfig and Juju client data, so that they share the same controllers.
+// This happens once the providers and Juju are prepared.
+func (p *Plan) configureUsers() error {
+	for _, name := range p.config.Host.Users {
+		if name == p.system.User().Username {
+			continue
+		}
+
+		u, err := system.LookupUserWith(p.system, name)
+		if err != nil {
+			return fmt.Errorf("failed to look up user '%s': %w", name, err)
+		}
+
+		record := p.userRecord(name)
+
+		err = p.addUserToGroups(name, record)
+		if err != nil {
+			return err
+		}
+
+		err = p.copyClientFiles(system.NewUserWorker(p.system, u), record)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Set up user", "user", name)
+	}
+
+	return nil
+}
+
+// restoreUsers removes the group memberships and files recorded in the runtime state
+// for each additional user.
+func (p *Plan) restoreUsers() error {
+	for _, record := range slices.Clone(p.config.State.Users) {
+		for _, filePath := range record.Files {
+			err := p.system.RemovePath(filePath)
+			if err != nil {
+				return fmt.Errorf("failed to remove '%s': %w", filePath, err)
+			}
+		}
+
+		for _, group := range record.Groups {
+			// The user may have left the group since, or the group may have been
+			// removed along with the provider's snap.
+			cmd := system.NewCommand("gpasswd", []string{"-d", record.Name, group})
+			cmd.ExpectedError = `is not a member of|does not exist`
+			output, err := p.system.Run(cmd)
+			if err != nil && cmd.IsExpectedError(output) {
+				slog.Debug("User is no longer in group", "user", record.Name, "group", group)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to remove user '%s' from group '%s': %w", record.Name, group, err)
+			}
+		}
+
+		p.config.State.Users = slices.DeleteFunc(p.config.State.Users, func(r config.UserRecord) bool { return r.Name == record.Name })
+		slog.Info("Removed user setup", "user", record.Name)
+	}
+
+	return nil
+}
+
+// addUserToGroups adds the user to the groups of the enabled providers that they are
+// not already in, recording those added.
+func (p *Plan) addUserToGroups(name string, record *config.UserRecord) error {
+	cmd := system.NewCommand("id", []string{"-nG", name})
+	cmd.ReadOnly = true
+	output, err := p.system.Run(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to list groups of user '%s': %w", name, err)
+	}
+	groups := strings.Fields(string(output))
+
+	for _, group := range p.providerGroups() {
+		if slices.Contains(groups, group) {
+			continue
+		}
+
+		_, err := p.system.Run(system.NewCommand("usermod", []string{"-a", "-G", group, name}))
+		if err != nil {
+			return fmt.Errorf("failed to add user '%s' to group '%s': %w", name, group, err)
+		}
+
+		groups = append(groups, group)
+		if !slices.Contains(record.Groups, group) {
+			record.Groups = append(record.Groups, group)
+		}
+	}
+
+	return nil
+}
+
+// copyClientFiles copies the kubeconfig and Juju client data of the user running
+// concierge to another user, recording the files copied. The files hold credentials,
+// so only their owner can read them. If the user already had any of a set of files,
+// the whole set is left alone, since they may hold the user's own credentials, which
+// restore would then remove.
+func (p *Plan) copyClientFiles(uw *system.UserWorker, record *config.UserRecord) error {
+	for _, files := range userClientFiles {
+		existing, err := p.existingClientFile(uw, record, files)
+		if err != nil {
+			return err
+		}
+		if existing != "" {
+			slog.Warn("Keeping existing client files of user", "user", uw.User().Username, "path", existing)
+			continue
+		}
+
+		for _, file := range files {
+			err := p.copyClientFile(uw, record, file)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// existingClientFile returns the path of the first of the files that the user
+// already had before concierge copied it, if any.
+func (p *Plan) existingClientFile(uw *system.UserWorker, record *config.UserRecord, files []string) (string, error) {
+	for _, file := range files {
+		filePath := path.Join(uw.User().HomeDir, file)
+		if slices.Contains(record.Files, filePath) {
+			continue
+		}
+
+		_, err := uw.ReadFile(filePath)
+		if err == nil {
+			return filePath, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read file '%s': %w", filePath, err)
+		}
+	}
+
+	return "", nil
+}
+
+// copyClientFile copies one of the client files of the user running concierge to
+// another user, if it exists, recording the file copied.
+func (p *Plan) copyClientFile(uw *system.UserWorker, record *config.UserRecord, file string) error {
+	contents, err := system.ReadHomeDirFile(p.system, file)
+	if err != nil {
+		return nil
+	}
+
+	filePath := path.Join(uw.User().HomeDir, file)
+
+	err = system.MkHomeSubdirectory(uw, path.Dir(file))
+	if err != nil {
+		return err
+	}
+
+	err = uw.WriteFile(filePath, contents, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write file '%s': %w", filePath, err)
+	}
+
+	err = uw.ChownAll(filePath, uw.User())
+	if err != nil {
+		return fmt.Errorf("failed to change ownership of file '%s': %w", filePath, err)
+	}
+
+	if !slices.Contains(record.Files, filePath) {
+		record.Files = append(record.Files, filePath)
+	}
+
+	return nil
+}
+
+// userRecord returns the state recorded for the named user, recording it first if
+// there is none.
+func (p *Plan) userRecord(name string) *config.UserRecord {
+	i := slices.IndexFunc(p.config.State.Users, func(r config.UserRecord) bool { return r.Name == name })
+	if i < 0 {
+		p.config.State.Users = append(p.config.State.Users, config.UserRecord{Name: name})
+		i = len(p.config.State.Users) - 1
+	}
+	return &p.config.State.Users[i]
+}
@@ -0,0 +1,127 @@
+package concierge
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestConfigureUsers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.LXD.Enable = true
+	cfg.Providers.MicroK8s.Enable = true
+	cfg.Host.Users = []string{"test-user", "ci-runner"}
+
+	sys := system.NewMockSystem()
+	home := sys.User().HomeDir
+	sys.MockFile(path.Join(home, ".kube/config"), []byte("kubeconfig"))
+	sys.MockFile(path.Join(home, ".local/share/juju/controllers.yaml"), []byte("controllers"))
+	sys.MockFile(path.Join(home, ".local/share/juju/credentials.yaml"), []byte("credentials"))
+	sys.MockCommandReturn("getent passwd ci-runner", []byte("ci-runner:x:1001:1001::/home/ci-runner:/bin/bash\n"), nil)
+	sys.MockCommandReturn("id -nG ci-runner", []byte("ci-runner lxd\n"), nil)
+	// The user's own Juju client data is kept as a whole, and so not recorded.
+	sys.MockFile("/home/ci-runner/.local/share/juju/credentials.yaml", []byte("own credentials"))
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.configureUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := map[string]string{
+		"/home/ci-runner/.kube/config": "kubeconfig",
+	}
+	if !reflect.DeepEqual(expectedFiles, sys.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, sys.CreatedFiles)
+	}
+
+	expectedCommands := []string{
+		"getent passwd ci-runner",
+		"id -nG ci-runner",
+		"usermod -a -G " + plan.Providers[1].GroupName() + " ci-runner",
+	}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	expectedState := []config.UserRecord{{
+		Name:   "ci-runner",
+		Groups: []string{plan.Providers[1].GroupName()},
+		Files:  []string{"/home/ci-runner/.kube/config"},
+	}}
+	if !reflect.DeepEqual(expectedState, cfg.State.Users) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.Users)
+	}
+}
+
+func TestConfigureUsersCopiesJujuDataAsAUnit(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.LXD.Enable = true
+	cfg.Host.Users = []string{"ci-runner"}
+
+	sys := system.NewMockSystem()
+	home := sys.User().HomeDir
+	sys.MockFile(path.Join(home, ".local/share/juju/controllers.yaml"), []byte("controllers"))
+	sys.MockFile(path.Join(home, ".local/share/juju/credentials.yaml"), []byte("credentials"))
+	sys.MockCommandReturn("getent passwd ci-runner", []byte("ci-runner:x:1001:1001::/home/ci-runner:/bin/bash\n"), nil)
+	sys.MockCommandReturn("id -nG ci-runner", []byte("ci-runner lxd\n"), nil)
+
+	plan := NewPlan(cfg, sys)
+	if err := plan.configureUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := map[string]string{
+		"/home/ci-runner/.local/share/juju/controllers.yaml": "controllers",
+		"/home/ci-runner/.local/share/juju/credentials.yaml": "credentials",
+	}
+	if !reflect.DeepEqual(expectedFiles, sys.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, sys.CreatedFiles)
+	}
+
+	// A second run copies the files again, since concierge copied them in the first place.
+	if err := plan.configureUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedState := []config.UserRecord{{
+		Name:  "ci-runner",
+		Files: []string{"/home/ci-runner/.local/share/juju/controllers.yaml", "/home/ci-runner/.local/share/juju/credentials.yaml"},
+	}}
+	if !reflect.DeepEqual(expectedState, cfg.State.Users) {
+		t.Fatalf("expected: %v, got: %v", expectedState, cfg.State.Users)
+	}
+}
+
+func TestRestoreUsers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.State.Users = []config.UserRecord{{
+		Name:   "ci-runner",
+		Groups: []string{"microk8s", "lxd"},
+		Files:  []string{"/home/ci-runner/.kube/config"},
+	}}
+
+	sys := system.NewMockSystem()
+	// The user already left the microk8s group, which does not stop them leaving the others.
+	sys.MockCommandReturn("gpasswd -d ci-runner microk8s", []byte("gpasswd: user 'ci-runner' is not a member of 'microk8s'\n"), fmt.Errorf("exit status 3"))
+	if err := NewPlan(cfg, sys).restoreUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRemoved := []string{"/home/ci-runner/.kube/config"}
+	if !reflect.DeepEqual(expectedRemoved, sys.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", expectedRemoved, sys.RemovedPaths)
+	}
+
+	expectedCommands := []string{"gpasswd -d ci-runner microk8s", "gpasswd -d ci-runner lxd"}
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	if len(cfg.State.Users) != 0 {
+		t.Fatalf("expected recorded users to be cleared, got: %v", cfg.State.Users)
+	}
+}
@@ -51,11 +51,19 @@ func NewConfig(cmd *cobra.Command, flags *pflag.FlagSet) (*Config, error) {
 	}
 
 	dryRun, _ := flags.GetBool("dry-run")
+	skipPreflight, _ := flags.GetBool("skip-preflight")
+	storeCacheTTL, _ := flags.GetDuration("store-cache-ttl")
+	refreshStoreCache, _ := flags.GetBool("refresh-store-cache")
+	holdRefreshes, _ := flags.GetDuration("hold-refreshes")
 
 	conf.Overrides = getOverrides(flags)
 	conf.Verbose = verbose
 	conf.Trace = trace
 	conf.DryRun = dryRun
+	conf.SkipPreflight = skipPreflight
+	conf.StoreCacheTTL = storeCacheTTL
+	conf.RefreshStoreCache = refreshStoreCache
+	conf.HoldRefreshes = holdRefreshes
 
 	return conf, nil
 }
@@ -124,6 +132,8 @@ func getOverrides(flags *pflag.FlagSet) ConfigOverrides {
 
 		ExtraSnaps: envOrFlagSlice(flags, "extra-snaps"),
 		ExtraDebs:  envOrFlagSlice(flags, "extra-debs"),
+
+		Artifacts: envOrFlagString(flags, "artifacts"),
 	}
 }
 
@@ -223,4 +233,27 @@ func expandConfigEnvVars(conf *Config) {
 	conf.Providers.K8s.ImageRegistry.URL = expandEnvVars(conf.Providers.K8s.ImageRegistry.URL)
 	conf.Providers.K8s.ImageRegistry.Username = expandEnvVars(conf.Providers.K8s.ImageRegistry.Username)
 	conf.Providers.K8s.ImageRegistry.Password = expandEnvVars(conf.Providers.K8s.ImageRegistry.Password)
+
+	// Expand in proxy config, so that e.g. `http: $HTTP_PROXY` picks up the
+	// proxy already configured in the environment.
+	conf.Proxy.HTTP = expandEnvVars(conf.Proxy.HTTP)
+	conf.Proxy.HTTPS = expandEnvVars(conf.Proxy.HTTPS)
+	conf.Proxy.NoProxy = expandEnvVars(conf.Proxy.NoProxy)
+
+	// Expand in CA certificates, so that a certificate can be passed inline
+	// from the environment, e.g. a CI secret.
+	for i, cert := range conf.Host.CACertificates {
+		conf.Host.CACertificates[i] = expandEnvVars(cert)
+	}
+
+	conf.Host.Artifacts = expandEnvVars(conf.Host.Artifacts)
+
+	// Expand in snap config values and local snap paths.
+	for name, snap := range conf.Host.Snaps {
+		snap.Path = expandEnvVars(snap.Path)
+		for key, value := range snap.Config {
+			snap.Config[key] = expandEnvVars(value)
+		}
+		conf.Host.Snaps[name] = snap
+	}
 }
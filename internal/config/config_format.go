@@ -1,17 +1,45 @@
 package config
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // Config represents concierge's configuration format.
 type Config struct {
 	Juju      jujuConfig     `yaml:"juju"`
 	Providers providerConfig `yaml:"providers"`
 	Host      hostConfig     `yaml:"host"`
+	Proxy     ProxyConfig    `yaml:"proxy"`
 
 	// The following are added at runtime according to CLI flags
-	Overrides ConfigOverrides `yaml:"overrides"`
-	Status    Status          `yaml:"status"`
-	Verbose   bool            `yaml:"-"`
-	Trace     bool            `yaml:"-"`
-	DryRun    bool            `yaml:"-"`
+	Overrides     ConfigOverrides `yaml:"overrides"`
+	Status        Status          `yaml:"status"`
+	State         RuntimeState    `yaml:"state"`
+	Verbose       bool            `yaml:"-"`
+	Trace         bool            `yaml:"-"`
+	DryRun        bool            `yaml:"-"`
+	SkipPreflight bool            `yaml:"-"`
+	// StoreCacheTTL is how long snap store metadata is cached on disk, if at all.
+	// RefreshStoreCache replaces the cached metadata with fresh lookups.
+	StoreCacheTTL     time.Duration `yaml:"-"`
+	RefreshStoreCache bool          `yaml:"-"`
+	// HoldRefreshes is how long snap auto-refreshes are held for once snapd is ready.
+	HoldRefreshes time.Duration `yaml:"-"`
+}
+
+// Secrets returns the secret values held in the config, such as image registry
+// passwords, so that they can be redacted from concierge's output.
+func (c *Config) Secrets() []string {
+	secrets := []string{
+		c.Providers.K8s.ImageRegistry.Password,
+		c.Providers.MicroK8s.ImageRegistry.Password,
+	}
+	secrets = append(secrets, proxyPasswords(c.Proxy.HTTP)...)
+	return append(secrets, proxyPasswords(c.Proxy.HTTPS)...)
 }
 
 // Status represents the status of concierge on a given machine.
@@ -21,11 +49,13 @@ const (
 	Provisioning Status = iota
 	Succeeded
 	Failed
+	// Restored means that `restore` undid everything that `prepare` did.
+	Restored
 )
 
 // String returns a string representation of a given concierge status.
 func (s Status) String() string {
-	return [...]string{"provisioning", "succeeded", "failed"}[s]
+	return [...]string{"provisioning", "succeeded", "failed", "restored"}[s]
 }
 
 // jujuConfig represents the configuration for juju, including the desired version,
@@ -104,19 +134,248 @@ type k8sConfig struct {
 	BootstrapConstraints map[string]string            `yaml:"bootstrap-constraints"`
 }
 
+// ProxyConfig represents the HTTP(S) proxy through which the host, and the providers
+// configured on it, reach the internet.
+type ProxyConfig struct {
+	// HTTP is the proxy URL used for plain HTTP traffic.
+	HTTP string `yaml:"http"`
+	// HTTPS is the proxy URL used for HTTPS traffic.
+	HTTPS string `yaml:"https"`
+	// NoProxy is a comma-separated list of hosts, domains and CIDRs that are
+	// reached directly, rather than through the proxy.
+	NoProxy string `yaml:"no-proxy"`
+}
+
+// Enabled reports whether a proxy is configured.
+func (p ProxyConfig) Enabled() bool {
+	return p.HTTP != "" || p.HTTPS != ""
+}
+
+// Environment returns the proxy configuration as "KEY=value" environment variables,
+// in both the upper and lower case forms, since tools differ in which they honour.
+func (p ProxyConfig) Environment() []string {
+	env := []string{}
+	for _, v := range []struct{ key, value string }{
+		{"HTTP_PROXY", p.HTTP},
+		{"HTTPS_PROXY", p.HTTPS},
+		{"NO_PROXY", p.NoProxy},
+	} {
+		if v.value == "" {
+			continue
+		}
+		env = append(env, v.key+"="+v.value, strings.ToLower(v.key)+"="+v.value)
+	}
+	return env
+}
+
+// DebConfig represents the configuration for a specific apt package to be installed.
+type DebConfig struct {
+	// Name is the name of the package, which may be pinned to a version as "name=version".
+	Name string `yaml:"-"`
+	// Version pins the package to a specific version.
+	Version string `yaml:"version,omitempty"`
+	// Hold marks the package as held with `apt-mark hold` until concierge restores.
+	Hold bool `yaml:"hold,omitempty"`
+	// InstallRecommends, if false, installs the package without its recommended packages.
+	InstallRecommends *bool `yaml:"install-recommends,omitempty"`
+	// Debconf lists debconf selections to preseed before the package is installed, in
+	// the form accepted by `debconf-set-selections`.
+	Debconf []string `yaml:"debconf,omitempty"`
+}
+
+// DebPackages is a list of apt packages to be installed. In YAML, it is either a list
+// of package names, or a map of package names to their configuration.
+type DebPackages []DebConfig
+
+// UnmarshalYAML parses either form of a list of apt packages, keeping the order in
+// which the packages are given.
+func (d *DebPackages) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		*d = DebPackages{}
+		for _, name := range names {
+			*d = append(*d, DebConfig{Name: name})
+		}
+	case yaml.MappingNode:
+		*d = DebPackages{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			deb := DebConfig{}
+			if err := node.Content[i+1].Decode(&deb); err != nil {
+				return err
+			}
+			deb.Name = node.Content[i].Value
+			*d = append(*d, deb)
+		}
+	default:
+		return fmt.Errorf("packages must be a list or a map, at line %d", node.Line)
+	}
+	return nil
+}
+
+// MarshalYAML renders the packages as a list of names, unless some have options,
+// in which case they are rendered as a map.
+func (d DebPackages) MarshalYAML() (any, error) {
+	names := []string{}
+	for _, deb := range d {
+		if deb.Version != "" || deb.Hold || deb.InstallRecommends != nil || len(deb.Debconf) > 0 {
+			return d.mapNode()
+		}
+		names = append(names, deb.Name)
+	}
+	return names, nil
+}
+
+// mapNode renders the packages as a map of names to their configuration.
+func (d DebPackages) mapNode() (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, deb := range d {
+		value := &yaml.Node{}
+		if err := value.Encode(deb); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: deb.Name}, value)
+	}
+	return node, nil
+}
+
+// AptSourceConfig represents the configuration for an additional apt source, using the
+// fields of a deb822 `.sources` file.
+type AptSourceConfig struct {
+	// Name identifies the source. It defaults to the name of the PPA, if one is given.
+	Name string `yaml:"name"`
+	// PPA is a Launchpad PPA of the form "ppa:<owner>/<name>", from which the URIs,
+	// suites, components and signing key are derived unless they are given.
+	PPA string `yaml:"ppa"`
+	// URIs are the base URIs of the repository.
+	URIs []string `yaml:"uris"`
+	// Suites are the suites of the repository, e.g. "noble".
+	Suites []string `yaml:"suites"`
+	// Components are the components of the repository, e.g. "main".
+	Components []string `yaml:"components"`
+	// Architectures, if set, restricts the architectures fetched from the repository.
+	Architectures []string `yaml:"architectures"`
+	// SignedBy is the key that signs the repository, either inline or as a path.
+	SignedBy string `yaml:"signed-by"`
+}
+
 // SnapConfig represents the configuration for a specific snap to be installed.
 type SnapConfig struct {
 	// Channel is the channel from which to install the snap. If omitted, the default behaviour is decided by snapd.
 	Channel string `yaml:"channel"`
 	// Connections is a list of snap connections to form.
 	Connections []string `yaml:"connections"`
+	// After lists other host snaps that must be installed before this one.
+	After []string `yaml:"after"`
+	// Revision is the revision of the snap to install.
+	Revision string `yaml:"revision"`
+	// Hold prevents snapd from refreshing the snap automatically.
+	Hold bool `yaml:"hold"`
+	// Config maps snap configuration options to values, applied with `snap set`.
+	Config map[string]string `yaml:"config"`
+	// Aliases maps aliases to the apps of the snap that they point to.
+	Aliases map[string]string `yaml:"aliases"`
+	// Devmode installs the snap in development mode.
+	Devmode bool `yaml:"devmode"`
+	// Path is a local snap file to install instead of the store snap.
+	Path string `yaml:"path"`
+	// Dangerous allows a local snap file to be installed without a signed assertion.
+	Dangerous bool `yaml:"dangerous"`
+	// RefreshPolicy decides whether the snap is refreshed if it is already installed,
+	// overriding the host's refresh policy.
+	RefreshPolicy RefreshPolicy `yaml:"refresh-policy"`
 }
 
+// RefreshPolicy determines whether concierge refreshes a snap that is already installed.
+type RefreshPolicy string
+
+const (
+	// RefreshAlways refreshes installed snaps to the requested channel and revision.
+	RefreshAlways RefreshPolicy = "always"
+	// RefreshNever leaves installed snaps as they are.
+	RefreshNever RefreshPolicy = "never"
+	// RefreshIfChannelDiffers refreshes installed snaps only if they track a
+	// different channel, or are at a different revision, to that requested.
+	RefreshIfChannelDiffers RefreshPolicy = "if-channel-differs"
+	// RefreshIfOlder refreshes installed snaps only if their revision is older
+	// than the revision available in the store.
+	RefreshIfOlder RefreshPolicy = "if-older"
+)
+
+// RefreshPolicies lists the valid refresh policies.
+var RefreshPolicies = []RefreshPolicy{RefreshAlways, RefreshNever, RefreshIfChannelDiffers, RefreshIfOlder}
+
+// FileConfig represents a file that concierge writes on the host.
+type FileConfig struct {
+	// Path is the path of the file, either absolute, or relative to the user's home
+	// directory if it starts with "~/".
+	Path string `yaml:"path"`
+	// Content is the literal content of the file.
+	Content string `yaml:"content"`
+	// Template is a Go template rendered with the config and facts about the host, as
+	// an alternative to Content.
+	Template string `yaml:"template"`
+	// Mode is the file's permissions in octal, e.g. "0600". Defaults to "0644".
+	Mode string `yaml:"mode"`
+	// Owner is the owner of the file, as "<user>[:<group>]". Defaults to the user for
+	// files in their home directory, and to root otherwise.
+	Owner string `yaml:"owner"`
+	// Restore decides what happens to the file on restore. Defaults to "revert".
+	Restore FileRestorePolicy `yaml:"restore"`
+}
+
+// FileRestorePolicy determines what concierge does with a file it wrote on restore.
+type FileRestorePolicy string
+
+const (
+	// FileRemove removes the file.
+	FileRemove FileRestorePolicy = "remove"
+	// FileKeep leaves the file as concierge wrote it.
+	FileKeep FileRestorePolicy = "keep"
+	// FileRevert puts back the file's previous contents, or removes it if it did
+	// not exist before concierge first wrote it.
+	FileRevert FileRestorePolicy = "revert"
+)
+
+// FileRestorePolicies lists the valid file restore policies.
+var FileRestorePolicies = []FileRestorePolicy{FileRemove, FileKeep, FileRevert}
+
 // hostConfig is a top-level field containing addition configuration for the host being
 // configured.
 type hostConfig struct {
-	// Packages is a of apt packages to be installed from the archive
-	Packages []string `yaml:"packages"`
+	// Packages is a list of apt packages to be installed from the archive
+	Packages DebPackages `yaml:"packages"`
+	// PythonTools is a list of Python tools to install for the user with `uv tool install`,
+	// each given as a requirement specifier such as "tox>=4".
+	PythonTools []string `yaml:"python-tools"`
+	// Users is a list of additional users to set up alongside the user running
+	// concierge, each with access to the providers and the Juju controllers.
+	Users []string `yaml:"users"`
+	// Sysctl maps kernel parameters to the values to set them to, on top of those
+	// that the enabled providers need.
+	Sysctl map[string]string `yaml:"sysctl"`
+	// KernelModules is a list of kernel modules to load, on top of those that the
+	// enabled providers need.
+	KernelModules []string `yaml:"kernel-modules"`
+	// Files is a list of files to write on the host.
+	Files []FileConfig `yaml:"files"`
+	// AptSources is a list of additional apt sources, from which packages can be installed.
+	AptSources []AptSourceConfig `yaml:"apt-sources"`
 	// Snaps is a map of snaps to be installed.
 	Snaps map[string]SnapConfig `yaml:"snaps"`
+	// CACertificates is a list of additional CA certificates to trust, each given
+	// either inline as PEM or as the path to a PEM file.
+	CACertificates []string `yaml:"ca-certificates"`
+	// Conflicts maps the name of a known conflict (e.g. "docker") to the policy
+	// used to resolve it when found on the host.
+	Conflicts map[string]ConflictPolicy `yaml:"conflicts"`
+	// RefreshPolicy decides whether snaps that are already installed, including those
+	// of the providers and Juju, are refreshed. It defaults to "always".
+	RefreshPolicy RefreshPolicy `yaml:"refresh-policy"`
+	// Artifacts is a directory of snap and deb files, described by a manifest, to
+	// install from instead of the store and the archive.
+	Artifacts string `yaml:"artifacts"`
 }
@@ -15,4 +15,6 @@ type ConfigOverrides struct {
 
 	ExtraSnaps []string
 	ExtraDebs  []string
+
+	Artifacts string
 }
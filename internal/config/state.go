@@ -0,0 +1,140 @@
+package config
+
+// RuntimeState records changes that concierge made to the host during `prepare`
+// which cannot be derived from the config alone, so that `restore` can undo them.
+// It is persisted alongside the config in the runtime cache.
+type RuntimeState struct {
+	// Conflicts lists conflicting host software that was stopped or removed.
+	Conflicts []ResolvedConflict `yaml:"conflicts,omitempty"`
+	// SnapdProxy maps each snapd system proxy option that concierge set to the
+	// value it had beforehand, where "" means that the option was unset.
+	SnapdProxy map[string]string `yaml:"snapd-proxy,omitempty"`
+	// CACertificates lists the names of the CA certificates that were added to
+	// the host's and snapd's trust stores.
+	CACertificates []string `yaml:"ca-certificates,omitempty"`
+	// Snaps records the host snaps that concierge prepared, so that restore can
+	// tell snaps it installed apart from those that were already present.
+	Snaps []SnapRecord `yaml:"snaps,omitempty"`
+	// Debs records the host debs that concierge prepared, so that restore can
+	// tell debs it installed apart from those that were already present.
+	Debs []DebRecord `yaml:"debs,omitempty"`
+	// PythonTools records the Python tools that concierge prepared, so that restore
+	// can tell tools it installed apart from those that were already present.
+	PythonTools []PythonToolRecord `yaml:"python-tools,omitempty"`
+	// Sysctl maps each kernel parameter that concierge set to the value it had
+	// beforehand, where "" means that the parameter could not be read.
+	Sysctl map[string]string `yaml:"sysctl,omitempty"`
+	// KernelModules lists the kernel modules that concierge loaded.
+	KernelModules []string `yaml:"kernel-modules,omitempty"`
+	// Users records the additional users that concierge set up.
+	Users []UserRecord `yaml:"users,omitempty"`
+	// Files records the host files that concierge wrote, and where their previous
+	// contents were backed up.
+	Files []FileRecord `yaml:"files,omitempty"`
+}
+
+// FileRecord records a host file written by concierge.
+type FileRecord struct {
+	// Path is the absolute path of the file.
+	Path string `yaml:"path"`
+	// Backup is the path, relative to the user's home directory, at which the file's
+	// previous contents were saved. It is empty if the file did not exist before
+	// concierge first wrote it.
+	Backup string `yaml:"backup,omitempty"`
+	// Mode and Owner are the previous permissions (e.g. "644") and owner (e.g.
+	// "root:root") of the file, which are put back along with its contents.
+	Mode  string `yaml:"mode,omitempty"`
+	Owner string `yaml:"owner,omitempty"`
+}
+
+// UserRecord records an additional user set up by concierge.
+type UserRecord struct {
+	// Name is the name of the user.
+	Name string `yaml:"name"`
+	// Groups lists the groups that concierge added the user to.
+	Groups []string `yaml:"groups,omitempty"`
+	// Files lists the absolute paths of the files that concierge copied to the
+	// user's home directory.
+	Files []string `yaml:"files,omitempty"`
+}
+
+// PythonToolRecord records a Python tool prepared by concierge.
+type PythonToolRecord struct {
+	// Name is the normalised name of the package that provides the tool.
+	Name string `yaml:"name"`
+	// Preexisting reports whether the tool was installed before concierge first
+	// prepared it. Pre-existing tools are left installed by restore.
+	Preexisting bool `yaml:"preexisting,omitempty"`
+}
+
+// DebRecord records a host deb prepared by concierge.
+type DebRecord struct {
+	// Name is the name of the package.
+	Name string `yaml:"name"`
+	// Preexisting reports whether the package was installed before concierge
+	// first prepared it. Pre-existing packages are left installed by restore.
+	Preexisting bool `yaml:"preexisting,omitempty"`
+	// Held reports whether concierge held the package with `apt-mark hold`.
+	Held bool `yaml:"held,omitempty"`
+}
+
+// SnapRecord records a host snap prepared by concierge.
+type SnapRecord struct {
+	// Name is the name of the snap.
+	Name string `yaml:"name"`
+	// Preexisting reports whether the snap was installed before concierge first
+	// prepared it. Pre-existing snaps are left installed by restore.
+	Preexisting bool `yaml:"preexisting,omitempty"`
+	// Connections lists the interface connections that concierge made for the
+	// snap, in the form accepted by `snap connect`.
+	Connections []string `yaml:"connections,omitempty"`
+	// Held reports whether concierge held the snap's refreshes.
+	Held bool `yaml:"held,omitempty"`
+	// Config maps each configuration option that concierge set to the value it
+	// had beforehand, where "" means that the option was unset.
+	Config map[string]string `yaml:"config,omitempty"`
+	// Aliases lists the aliases that concierge created.
+	Aliases []string `yaml:"aliases,omitempty"`
+	// Snapshot is the ID of the snapshot set saved before concierge first refreshed
+	// or reconfigured the pre-existing snap.
+	Snapshot string `yaml:"snapshot,omitempty"`
+	// Revision and Channel are the revision and tracking channel the pre-existing
+	// snap had when the snapshot was saved.
+	Revision string `yaml:"revision,omitempty"`
+	Channel  string `yaml:"channel,omitempty"`
+}
+
+// ConflictPolicy determines how concierge handles host software that conflicts
+// with an enabled provider.
+type ConflictPolicy string
+
+const (
+	// ConflictFail stops provisioning with an error describing the conflict.
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictStop stops (or disables) the conflicting software for the duration
+	// of the run; it is started again on restore.
+	ConflictStop ConflictPolicy = "stop"
+	// ConflictRemove uninstalls the conflicting software; it is reinstalled on restore.
+	ConflictRemove ConflictPolicy = "remove"
+	// ConflictIgnore leaves the conflicting software in place and carries on.
+	ConflictIgnore ConflictPolicy = "ignore"
+)
+
+// ResolvedConflict records a conflicting piece of host software that concierge
+// stopped or removed, along with what is needed to put it back.
+type ResolvedConflict struct {
+	// Name is the name of the conflict, as used in `host.conflicts`.
+	Name string `yaml:"name"`
+	// Policy is the policy that was applied.
+	Policy ConflictPolicy `yaml:"policy"`
+	// Snap is the name of a conflicting snap, if any.
+	Snap string `yaml:"snap,omitempty"`
+	// Channel is the channel the conflicting snap was tracking.
+	Channel string `yaml:"channel,omitempty"`
+	// Classic reports whether the conflicting snap uses classic confinement.
+	Classic bool `yaml:"classic,omitempty"`
+	// Services lists the systemd units that were stopped.
+	Services []string `yaml:"services,omitempty"`
+	// Packages lists the apt packages that were removed.
+	Packages []string `yaml:"packages,omitempty"`
+}
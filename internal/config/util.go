@@ -1,5 +1,10 @@
 package config
 
+import (
+	"net/url"
+	"strings"
+)
+
 // MergeMaps takes two maps and returns a combined map, where KV pairs in the second map arg
 // take precedence over the first.
 func MergeMaps[K string, V any](m1 map[K]V, m2 map[K]V) map[K]V {
@@ -12,3 +17,28 @@ func MergeMaps[K string, V any](m1 map[K]V, m2 map[K]V) map[K]V {
 	}
 	return combinedMap
 }
+
+// proxyPasswords returns the password embedded in a proxy URL, if any, both decoded
+// and percent-encoded as written in the URL, since the URL is passed on as written.
+func proxyPasswords(proxyURL string) []string {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.User == nil {
+		return nil
+	}
+	password, _ := u.User.Password()
+
+	// The userinfo is what precedes the last "@" before the host's path, if any.
+	authority := proxyURL
+	if _, rest, ok := strings.Cut(authority, "://"); ok {
+		authority = rest
+	}
+	if i := strings.IndexAny(authority, "/?#"); i >= 0 {
+		authority = authority[:i]
+	}
+	encoded := ""
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		_, encoded, _ = strings.Cut(authority[:i], ":")
+	}
+
+	return []string{password, encoded}
+}
@@ -22,6 +22,15 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Model is the name of the model that concierge adds to each controller it bootstraps.
+const Model = "testing"
+
+// ControllerName returns the name of the controller that concierge bootstraps on the
+// specified provider, e.g. "concierge-lxd".
+func ControllerName(provider providers.Provider) string {
+	return "concierge-" + provider.Name()
+}
+
 // NewJujuHandler constructs a new JujuHandler instance.
 func NewJujuHandler(config *config.Config, r system.Worker, providers []providers.Provider) *JujuHandler {
 	var channel string
@@ -44,10 +53,14 @@ func NewJujuHandler(config *config.Config, r system.Worker, providers []provider
 		agentVersion:         config.Juju.AgentVersion,
 		bootstrapConstraints: config.Juju.BootstrapConstraints,
 		modelDefaults:        config.Juju.ModelDefaults,
+		proxy:                config.Proxy,
+		caCertificates:       config.Host.CACertificates,
 		extraBootstrapArgs:   config.Juju.ExtraBootstrapArgs,
+		artifacts:            config.Host.Artifacts,
+		state:                &config.State,
 		providers:            providers,
 		system:               r,
-		snaps:                []*system.Snap{{Name: "juju", Channel: channel, Revision: revision}},
+		snaps:                []*system.Snap{{Name: "juju", Channel: channel, Revision: revision, RefreshPolicy: config.Host.RefreshPolicy}},
 	}
 }
 
@@ -58,10 +71,17 @@ type JujuHandler struct {
 	agentVersion         string
 	bootstrapConstraints map[string]string
 	modelDefaults        map[string]string
+	proxy                config.ProxyConfig
+	caCertificates       []string
+	caDefaultsFile       string
 	extraBootstrapArgs   string
+	artifacts            string
+	agentsDir            string
 	providers            []providers.Provider
 	system               system.Worker
 	snaps                []*system.Snap
+	// state records what was installed before concierge, so that restore keeps it.
+	state *config.RuntimeState
 }
 
 // Prepare bootstraps Juju on the configured providers.
@@ -83,6 +103,20 @@ func (j *JujuHandler) Prepare() error {
 		return fmt.Errorf("failed to write juju credentials file: %w", err)
 	}
 
+	err = j.writeCACertificateDefaults()
+	if err != nil {
+		return fmt.Errorf("failed to write juju CA certificate model-defaults: %w", err)
+	}
+
+	// Agent binaries bundled with the artifacts let controllers bootstrap offline.
+	if j.artifacts != "" {
+		manifest, err := packages.LoadManifest(j.system, j.artifacts)
+		if err != nil {
+			return err
+		}
+		j.agentsDir = manifest.AgentsDir()
+	}
+
 	err = j.bootstrap()
 	if err != nil {
 		return fmt.Errorf("failed to bootstrap Juju controller: %w", err)
@@ -91,6 +125,9 @@ func (j *JujuHandler) Prepare() error {
 	return nil
 }
 
+// Snaps reports the snaps installed for Juju.
+func (j *JujuHandler) Snaps() []*system.Snap { return j.snaps }
+
 // Restore uninstalls Juju from the system.
 func (j *JujuHandler) Restore() error {
 	// Kill controllers for credentialed providers.
@@ -111,6 +148,7 @@ func (j *JujuHandler) Restore() error {
 	}
 
 	snapHandler := packages.NewSnapHandler(j.system, j.snaps)
+	snapHandler.State = j.state
 
 	err = snapHandler.Restore()
 	if err != nil {
@@ -125,6 +163,8 @@ func (j *JujuHandler) Restore() error {
 // install ensures that Juju is installed.
 func (j *JujuHandler) install() error {
 	snapHandler := packages.NewSnapHandler(j.system, j.snaps)
+	snapHandler.State = j.state
+	snapHandler.Artifacts = j.artifacts
 
 	err := snapHandler.Prepare()
 	if err != nil {
@@ -181,6 +221,45 @@ func (j *JujuHandler) writeCredentials() error {
 	return nil
 }
 
+// writeCACertificateDefaults writes a model-defaults file that has machines created by
+// Juju, including controllers, trust the configured CA certificates via cloud-init.
+// Juju model config values are parsed as YAML, so the multi-line cloudinit-userdata
+// is passed as a file rather than on the command line.
+func (j *JujuHandler) writeCACertificateDefaults() error {
+	certs, err := system.LoadCACertificates(j.system, j.caCertificates)
+	if err != nil {
+		return err
+	}
+
+	if len(certs) == 0 {
+		return nil
+	}
+
+	trusted := []string{}
+	for _, cert := range certs {
+		trusted = append(trusted, string(cert.PEM))
+	}
+
+	userdata, err := yaml.Marshal(map[string]any{"ca-certs": map[string]any{"trusted": trusted}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cloudinit-userdata to yaml: %w", err)
+	}
+
+	content, err := yaml.Marshal(map[string]string{"cloudinit-userdata": string(userdata)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal model-defaults to yaml: %w", err)
+	}
+
+	defaultsPath := path.Join(".local", "share", "juju", "concierge-ca-certificates.yaml")
+	err = system.WriteHomeDirFile(j.system, defaultsPath, content)
+	if err != nil {
+		return fmt.Errorf("failed to write '%s': %w", defaultsPath, err)
+	}
+
+	j.caDefaultsFile = path.Join(j.system.User().HomeDir, defaultsPath)
+	return nil
+}
+
 // bootstrap iterates over the set of configured providers, and bootstraps each of
 // them in parallel with a unique controller name.
 func (j *JujuHandler) bootstrap() error {
@@ -203,7 +282,7 @@ func (j *JujuHandler) bootstrapProvider(provider providers.Provider) error {
 		return nil
 	}
 
-	controllerName := fmt.Sprintf("concierge-%s", provider.Name())
+	controllerName := ControllerName(provider)
 
 	bootstrapped, err := j.checkBootstrapped(controllerName)
 	if err != nil {
@@ -229,10 +308,21 @@ func (j *JujuHandler) bootstrapProvider(provider providers.Provider) error {
 		bootstrapArgs = append(bootstrapArgs, "--agent-version", j.agentVersion)
 	}
 
-	// Combine the global and provider-local model-defaults and bootstrap-constraints.
-	modelDefaults := config.MergeMaps(j.modelDefaults, provider.ModelDefaults())
+	if j.agentsDir != "" {
+		bootstrapArgs = append(bootstrapArgs, "--metadata-source", j.agentsDir)
+	}
+
+	// Combine the proxy, global and provider-local model-defaults, and the global
+	// and provider-local bootstrap-constraints.
+	modelDefaults := config.MergeMaps(proxyModelDefaults(j.proxy), config.MergeMaps(j.modelDefaults, provider.ModelDefaults()))
 	bootstrapConstraints := config.MergeMaps(j.bootstrapConstraints, provider.BootstrapConstraints())
 
+	// Pass the CA certificate model-defaults first, so that they can be overridden
+	// by an explicitly configured cloudinit-userdata.
+	if j.caDefaultsFile != "" {
+		bootstrapArgs = append(bootstrapArgs, "--model-default", j.caDefaultsFile)
+	}
+
 	// Iterate over the model-defaults and append them to the bootstrapArgs
 	for _, k := range sortedKeys(modelDefaults) {
 		bootstrapArgs = append(bootstrapArgs, "--model-default", fmt.Sprintf("%s=%s", k, modelDefaults[k]))
@@ -263,14 +353,14 @@ func (j *JujuHandler) bootstrapProvider(provider providers.Provider) error {
 		return err
 	}
 
-	cmd = system.NewCommandAs(user, "", "juju", []string{"add-model", "-c", controllerName, "testing"})
+	cmd = system.NewCommandAs(user, "", "juju", []string{"add-model", "-c", controllerName, Model})
 	_, err = j.system.Run(cmd)
 	if err != nil {
 		return err
 	}
 
 	// Set the architecture constraint for the testing model to match the runtime architecture.
-	modelName := fmt.Sprintf("%s:testing", controllerName)
+	modelName := fmt.Sprintf("%s:%s", controllerName, Model)
 	cmd = system.NewCommandAs(user, "", "juju", []string{"set-model-constraints", "-m", modelName, fmt.Sprintf("arch=%s", goArchToJujuArch(runtime.GOARCH))})
 	_, err = j.system.Run(cmd)
 	if err != nil {
@@ -283,7 +373,7 @@ func (j *JujuHandler) bootstrapProvider(provider providers.Provider) error {
 
 // killProvider destroys the controller for a specific provider.
 func (j *JujuHandler) killProvider(provider providers.Provider) error {
-	controllerName := fmt.Sprintf("concierge-%s", provider.Name())
+	controllerName := ControllerName(provider)
 
 	bootstrapped, err := j.checkBootstrapped(controllerName)
 	if err != nil {
@@ -347,6 +437,32 @@ func (j *JujuHandler) checkBootstrapped(controllerName string) (bool, error) {
 	})
 }
 
+// proxyModelDefaults returns the Juju model-defaults that route the controller's and
+// models' traffic via the configured proxy. Model-defaults set explicitly in the
+// config take precedence over these.
+func proxyModelDefaults(proxy config.ProxyConfig) map[string]string {
+	defaults := map[string]string{}
+
+	if proxy.HTTP != "" {
+		defaults["juju-http-proxy"] = proxy.HTTP
+		defaults["apt-http-proxy"] = proxy.HTTP
+		defaults["snap-http-proxy"] = proxy.HTTP
+	}
+
+	if proxy.HTTPS != "" {
+		defaults["juju-https-proxy"] = proxy.HTTPS
+		defaults["apt-https-proxy"] = proxy.HTTPS
+		defaults["snap-https-proxy"] = proxy.HTTPS
+	}
+
+	if proxy.Enabled() && proxy.NoProxy != "" {
+		defaults["juju-no-proxy"] = proxy.NoProxy
+		defaults["apt-no-proxy"] = proxy.NoProxy
+	}
+
+	return defaults
+}
+
 // sortedKeys gets an alphabetically sorted list of keys from a map.
 func sortedKeys(m map[string]string) []string {
 	keys := make([]string, 0, len(m))
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
 	"github.com/canonical/concierge/internal/providers"
 	"github.com/canonical/concierge/internal/system"
 	"gopkg.in/yaml.v3"
@@ -160,15 +161,17 @@ type mockProvider struct {
 	credentials map[string]any
 }
 
-func (m *mockProvider) Prepare() error                          { return nil }
-func (m *mockProvider) Restore() error                          { return nil }
-func (m *mockProvider) Name() string                            { return m.name }
-func (m *mockProvider) Bootstrap() bool                         { return false }
-func (m *mockProvider) CloudName() string                       { return m.cloudName }
-func (m *mockProvider) GroupName() string                       { return "" }
-func (m *mockProvider) Credentials() map[string]any             { return m.credentials }
-func (m *mockProvider) ModelDefaults() map[string]string        { return nil }
-func (m *mockProvider) BootstrapConstraints() map[string]string { return nil }
+func (m *mockProvider) Prepare() error                              { return nil }
+func (m *mockProvider) Restore() error                              { return nil }
+func (m *mockProvider) Name() string                                { return m.name }
+func (m *mockProvider) Bootstrap() bool                             { return false }
+func (m *mockProvider) CloudName() string                           { return m.cloudName }
+func (m *mockProvider) GroupName() string                           { return "" }
+func (m *mockProvider) Credentials() map[string]any                 { return m.credentials }
+func (m *mockProvider) ModelDefaults() map[string]string            { return nil }
+func (m *mockProvider) BootstrapConstraints() map[string]string     { return nil }
+func (m *mockProvider) Requirements() providers.Requirements        { return providers.Requirements{} }
+func (m *mockProvider) Packages() ([]*system.Snap, []*packages.Deb) { return nil, nil }
 
 func TestJujuHandlerWithCredentialedProvider(t *testing.T) {
 	expectedCredsFileContent := []byte(`credentials:
@@ -446,6 +449,29 @@ func TestJujuHandlerWithRevision(t *testing.T) {
 	}
 }
 
+func TestJujuHandlerFollowsHostRefreshPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juju.Channel = "3.6/stable"
+	cfg.Host.RefreshPolicy = "never"
+	cfg.Providers.LXD.Enable = true
+
+	system := system.NewMockSystem()
+	system.MockSnapStoreLookup("juju", "3.5/stable", false, true)
+
+	provider := providers.NewLXD(system, cfg)
+	handler := NewJujuHandler(cfg, system, []providers.Provider{provider})
+
+	if err := handler.Prepare(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, cmd := range system.ExecutedCommands {
+		if strings.HasPrefix(cmd, "snap refresh juju") {
+			t.Fatalf("expected juju not to be refreshed, got: %v", system.ExecutedCommands)
+		}
+	}
+}
+
 func TestJujuHandlerRevisionOverridesEnv(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Juju.Channel = "3.6/stable"
@@ -495,3 +521,65 @@ func TestGoArchToJujuArch(t *testing.T) {
 		}
 	}
 }
+
+func TestJujuHandlerProxyModelDefaults(t *testing.T) {
+	system, handler, err := setupHandlerWithPreset("machine")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	handler.proxy = config.ProxyConfig{HTTP: "http://proxy:3128", NoProxy: "10.0.0.0/8"}
+	// Explicitly configured model-defaults take precedence over the proxy.
+	handler.modelDefaults["apt-http-proxy"] = "http://apt-cache:3142"
+
+	err = handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := "sudo -u test-user -g lxd juju bootstrap localhost concierge-lxd --verbose" +
+		" --model-default apt-http-proxy=http://apt-cache:3142" +
+		" --model-default apt-no-proxy=10.0.0.0/8" +
+		" --model-default automatically-retry-hooks=false" +
+		" --model-default juju-http-proxy=http://proxy:3128" +
+		" --model-default juju-no-proxy=10.0.0.0/8" +
+		" --model-default snap-http-proxy=http://proxy:3128" +
+		" --model-default test-mode=true"
+
+	if !slices.Contains(system.ExecutedCommands, expected) {
+		t.Fatalf("expected: %v, got: %v", expected, system.ExecutedCommands)
+	}
+}
+
+func TestJujuHandlerCACertificates(t *testing.T) {
+	sys, handler, err := setupHandlerWithPreset("machine")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	handler.caCertificates = []string{"corp-ca.pem"}
+	sys.MockFile("corp-ca.pem", []byte(system.MockCACertificate))
+
+	err = handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defaultsPath := path.Join(os.TempDir(), ".local", "share", "juju", "concierge-ca-certificates.yaml")
+
+	var defaults map[string]string
+	if err := yaml.Unmarshal([]byte(sys.CreatedFiles[defaultsPath]), &defaults); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(defaults["cloudinit-userdata"], "ca-certs:") ||
+		!strings.Contains(defaults["cloudinit-userdata"], "BEGIN CERTIFICATE") {
+		t.Fatalf("expected cloudinit-userdata to trust the CA certificate, got: %v", defaults)
+	}
+
+	expected := "sudo -u test-user -g lxd juju bootstrap localhost concierge-lxd --verbose" +
+		" --model-default " + defaultsPath +
+		" --model-default automatically-retry-hooks=false --model-default test-mode=true"
+	if !slices.Contains(sys.ExecutedCommands, expected) {
+		t.Fatalf("expected: %v, got: %v", expected, sys.ExecutedCommands)
+	}
+}
@@ -0,0 +1,279 @@
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+// aptSourcesDir is where apt reads additional package sources from.
+const aptSourcesDir = "/etc/apt/sources.list.d"
+
+// aptKeyringsDir is where the keys that sign additional package sources are kept.
+const aptKeyringsDir = "/etc/apt/keyrings"
+
+// ppaArchiveURL is the archive of a Launchpad PPA, given its owner and name.
+const ppaArchiveURL = "https://ppa.launchpadcontent.net/%s/%s/ubuntu"
+
+// ppaSigningKeyURL returns the armored signing key of a Launchpad PPA, as a JSON
+// string, given its owner and name.
+const ppaSigningKeyURL = "https://api.launchpad.net/1.0/~%s/+archive/ubuntu/%s?ws.op=getSigningKeyData"
+
+// AptSource is an additional apt package source, written as a deb822 `.sources` file.
+type AptSource struct {
+	// Name identifies the source, and names the files written for it.
+	Name string
+	// PPA is a Launchpad PPA of the form "ppa:<owner>/<name>". If set, the URIs,
+	// suites, components and signing key default to those of the PPA.
+	PPA           string
+	URIs          []string
+	Suites        []string
+	Components    []string
+	Architectures []string
+	// SignedBy is the key that signs the source, given either inline or as the path
+	// to a key file, in armored or binary form.
+	SignedBy string
+}
+
+// ParsePPA splits a PPA of the form "ppa:<owner>/<name>", or "<owner>/<name>", into
+// its owner and name.
+func ParsePPA(ppa string) (string, string, error) {
+	owner, name, ok := strings.Cut(strings.TrimPrefix(ppa, "ppa:"), "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid ppa '%s', must be of the form ppa:<owner>/<name>", ppa)
+	}
+	return owner, name, nil
+}
+
+// sourcePath returns the path of the `.sources` file written for the source.
+func (s *AptSource) sourcePath() string {
+	return path.Join(aptSourcesDir, "concierge-"+s.Name+".sources")
+}
+
+// keyPaths returns the paths to which an armored and a binary key are written for
+// the source.
+func (s *AptSource) keyPaths() (string, string) {
+	base := path.Join(aptKeyringsDir, "concierge-"+s.Name)
+	return base + ".asc", base + ".gpg"
+}
+
+// addSources writes the handler's apt sources and their keys, reporting whether any
+// of the files changed, in which case the apt cache must be updated.
+func (h *DebHandler) addSources() (bool, error) {
+	if len(h.Sources) == 0 {
+		return false, nil
+	}
+
+	err := h.system.MkdirAll(aptKeyringsDir, 0755)
+	if err != nil {
+		return false, fmt.Errorf("failed to create directory '%s': %w", aptKeyringsDir, err)
+	}
+
+	changed := false
+	for _, source := range h.Sources {
+		resolved, err := h.resolveSource(source)
+		if err != nil {
+			return false, err
+		}
+
+		written, err := h.writeSource(resolved)
+		if err != nil {
+			return false, fmt.Errorf("failed to add apt source '%s': %w", resolved.Name, err)
+		}
+		if written {
+			slog.Info("Added apt source", "source", resolved.Name)
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// removeSources removes the files written for the handler's apt sources.
+func (h *DebHandler) removeSources() error {
+	for _, source := range h.Sources {
+		resolved, err := source.WithName()
+		if err != nil {
+			return err
+		}
+
+		armored, binary := resolved.keyPaths()
+		for _, p := range []string{resolved.sourcePath(), armored, binary} {
+			err := h.system.RemovePath(p)
+			if err != nil {
+				return fmt.Errorf("failed to remove '%s': %w", p, err)
+			}
+		}
+
+		slog.Info("Removed apt source", "source", resolved.Name)
+	}
+	return nil
+}
+
+// WithName returns a copy of the source, named after its PPA if it has no name.
+func (s *AptSource) WithName() (*AptSource, error) {
+	resolved := *s
+	if resolved.Name != "" {
+		return &resolved, nil
+	}
+
+	owner, name, err := ParsePPA(s.PPA)
+	if err != nil {
+		return nil, err
+	}
+	resolved.Name = owner + "-ubuntu-" + name
+	return &resolved, nil
+}
+
+// resolveSource returns a copy of the source, filling in the details of its PPA
+// where they are not given.
+func (h *DebHandler) resolveSource(source *AptSource) (*AptSource, error) {
+	resolved, err := source.WithName()
+	if err != nil || resolved.PPA == "" {
+		return resolved, err
+	}
+
+	owner, name, err := ParsePPA(resolved.PPA)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resolved.URIs) == 0 {
+		resolved.URIs = []string{fmt.Sprintf(ppaArchiveURL, owner, name)}
+	}
+	if len(resolved.Suites) == 0 {
+		codename, err := h.codename()
+		if err != nil {
+			return nil, err
+		}
+		resolved.Suites = []string{codename}
+	}
+	if len(resolved.Components) == 0 {
+		resolved.Components = []string{"main"}
+	}
+	if resolved.SignedBy == "" {
+		key, err := h.ppaSigningKey(owner, name)
+		if err != nil {
+			return nil, err
+		}
+		resolved.SignedBy = key
+	}
+
+	return resolved, nil
+}
+
+// codename returns the codename of the host's Ubuntu release, e.g. "noble".
+func (h *DebHandler) codename() (string, error) {
+	contents, err := h.system.ReadFile("/etc/os-release")
+	if err != nil {
+		return "", fmt.Errorf("failed to read /etc/os-release: %w", err)
+	}
+
+	fields := system.ParseOSRelease(string(contents))
+	for _, key := range []string{"VERSION_CODENAME", "UBUNTU_CODENAME"} {
+		if fields[key] != "" {
+			return fields[key], nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find the release codename in /etc/os-release")
+}
+
+// ppaSigningKey fetches the armored signing key of a PPA from Launchpad.
+func (h *DebHandler) ppaSigningKey(owner, name string) (string, error) {
+	cmd := system.NewCommand("curl", []string{"-fsSL", fmt.Sprintf(ppaSigningKeyURL, owner, name)})
+	cmd.ReadOnly = true
+
+	output, err := system.RunWithRetries(h.system, cmd, downloadRetryDuration)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch signing key of ppa:%s/%s: %w", owner, name, err)
+	}
+
+	var key string
+	if err := json.Unmarshal(output, &key); err != nil || key == "" {
+		return "", fmt.Errorf("failed to parse signing key of ppa:%s/%s", owner, name)
+	}
+	return key, nil
+}
+
+// writeSource writes the key and the `.sources` file of a resolved source, reporting
+// whether either of them changed.
+func (h *DebHandler) writeSource(source *AptSource) (bool, error) {
+	keyPath := ""
+	keyChanged := false
+	if source.SignedBy != "" {
+		key := []byte(source.SignedBy)
+		if !strings.Contains(source.SignedBy, "-----BEGIN") {
+			b, err := h.system.ReadFile(source.SignedBy)
+			if err != nil {
+				return false, fmt.Errorf("failed to read signing key '%s': %w", source.SignedBy, err)
+			}
+			key = b
+		}
+
+		armored, binary := source.keyPaths()
+		keyPath = binary
+		if bytes.Contains(key, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+			keyPath = armored
+			key = append(bytes.TrimSpace(key), '\n')
+		}
+
+		var err error
+		keyChanged, err = h.writeIfChanged(keyPath, key)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	sourceChanged, err := h.writeIfChanged(source.sourcePath(), []byte(source.deb822(keyPath)))
+	if err != nil {
+		return false, err
+	}
+
+	return keyChanged || sourceChanged, nil
+}
+
+// writeIfChanged writes a file, unless it already has the specified contents.
+func (h *DebHandler) writeIfChanged(filePath string, contents []byte) (bool, error) {
+	if existing, err := h.system.ReadFile(filePath); err == nil && bytes.Equal(existing, contents) {
+		return false, nil
+	}
+
+	err := h.system.WriteFile(filePath, contents, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to write '%s': %w", filePath, err)
+	}
+	return true, nil
+}
+
+// deb822 renders the source in the deb822 format of a `.sources` file.
+func (s *AptSource) deb822(keyPath string) string {
+	type field struct {
+		key    string
+		values []string
+	}
+
+	fields := []field{
+		{"Types", []string{"deb"}},
+		{"URIs", s.URIs},
+		{"Suites", s.Suites},
+		{"Components", s.Components},
+		{"Architectures", s.Architectures},
+	}
+	if keyPath != "" {
+		fields = append(fields, field{"Signed-By", []string{keyPath}})
+	}
+
+	b := strings.Builder{}
+	for _, field := range fields {
+		if len(field.values) > 0 {
+			fmt.Fprintf(&b, "%s: %s\n", field.key, strings.Join(field.values, " "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package packages
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+const testKey = "-----BEGIN PGP PUBLIC KEY BLOCK-----\nmQINBF\n-----END PGP PUBLIC KEY BLOCK-----"
+
+func TestDebHandlerAddsPPA(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockFile("/etc/os-release", []byte("NAME=\"Ubuntu\"\nVERSION_CODENAME=noble\n"))
+	r.MockCommandReturn("curl -fsSL 'https://api.launchpad.net/1.0/~deadsnakes/+archive/ubuntu/ppa?ws.op=getSigningKeyData'", []byte(`"-----BEGIN PGP PUBLIC KEY BLOCK-----\nmQINBF\n-----END PGP PUBLIC KEY BLOCK-----\n"`), nil)
+
+	handler := NewDebHandler(r, nil)
+	handler.Sources = []*AptSource{{PPA: "ppa:deadsnakes/ppa"}}
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expectedSource := "Types: deb\n" +
+		"URIs: https://ppa.launchpadcontent.net/deadsnakes/ppa/ubuntu\n" +
+		"Suites: noble\n" +
+		"Components: main\n" +
+		"Signed-By: /etc/apt/keyrings/concierge-deadsnakes-ubuntu-ppa.asc\n"
+
+	expectedFiles := map[string]string{
+		"/etc/apt/sources.list.d/concierge-deadsnakes-ubuntu-ppa.sources": expectedSource,
+		"/etc/apt/keyrings/concierge-deadsnakes-ubuntu-ppa.asc":           testKey + "\n",
+	}
+	if !reflect.DeepEqual(expectedFiles, r.CreatedFiles) {
+		t.Fatalf("expected: %v, got: %v", expectedFiles, r.CreatedFiles)
+	}
+
+	// A new source forces an update, however recently the cache was updated.
+	update := "DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update"
+	if !slices.Contains(r.ExecutedCommands, update) {
+		t.Fatalf("expected the apt cache to be updated, got: %v", r.ExecutedCommands)
+	}
+}
+
+func TestDebHandlerSkipsUnchangedSources(t *testing.T) {
+	source := &AptSource{
+		Name:     "docker",
+		URIs:     []string{"https://download.docker.com/linux/ubuntu"},
+		Suites:   []string{"noble"},
+		SignedBy: testKey,
+	}
+	sourceFile := "Types: deb\n" +
+		"URIs: https://download.docker.com/linux/ubuntu\n" +
+		"Suites: noble\n" +
+		"Signed-By: /etc/apt/keyrings/concierge-docker.asc\n"
+
+	r := system.NewMockSystem()
+	r.MockFile("/etc/apt/sources.list.d/concierge-docker.sources", []byte(sourceFile))
+	r.MockFile("/etc/apt/keyrings/concierge-docker.asc", []byte(testKey+"\n"))
+	r.MockCommandReturn("stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists", []byte(fmt.Sprintf("%d\n", time.Now().Unix())), nil)
+	r.MockCommandReturn("dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' docker-ce", []byte("ii  docker-ce 5:27.3.1-1\n"), nil)
+
+	handler := NewDebHandler(r, []*Deb{NewDeb("docker-ce")})
+	handler.Sources = []*AptSource{source}
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if len(r.CreatedFiles) != 0 {
+		t.Fatalf("expected no files to be written, got: %v", r.CreatedFiles)
+	}
+
+	expected := []string{"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' docker-ce"}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestDebHandlerRemovesSources(t *testing.T) {
+	r := system.NewMockSystem()
+
+	handler := NewDebHandler(r, nil)
+	handler.Sources = []*AptSource{{PPA: "ppa:deadsnakes/ppa"}, {Name: "docker"}}
+
+	err := handler.Restore()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := []string{
+		"/etc/apt/sources.list.d/concierge-deadsnakes-ubuntu-ppa.sources",
+		"/etc/apt/keyrings/concierge-deadsnakes-ubuntu-ppa.asc",
+		"/etc/apt/keyrings/concierge-deadsnakes-ubuntu-ppa.gpg",
+		"/etc/apt/sources.list.d/concierge-docker.sources",
+		"/etc/apt/keyrings/concierge-docker.asc",
+		"/etc/apt/keyrings/concierge-docker.gpg",
+	}
+	if !reflect.DeepEqual(expected, r.RemovedPaths) {
+		t.Fatalf("expected: %v, got: %v", expected, r.RemovedPaths)
+	}
+}
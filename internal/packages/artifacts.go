@@ -0,0 +1,184 @@
+package packages
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the name of the manifest describing the contents of an artifacts
+// directory, from which snaps and debs can be installed without network access.
+const ManifestFile = "manifest.yaml"
+
+// Manifest maps the snaps and debs that concierge may install to the files in an
+// artifacts directory that provide them.
+type Manifest struct {
+	Snaps  []SnapArtifact  `yaml:"snaps"`
+	Debs   []DebArtifact   `yaml:"debs"`
+	Images []ImageArtifact `yaml:"images,omitempty"`
+	// Agents is a directory of Juju agent binaries and their metadata, relative to
+	// the artifacts directory, from which Juju controllers are bootstrapped.
+	Agents string `yaml:"agents,omitempty"`
+
+	dir string
+}
+
+// SnapArtifact is a snap file downloaded from a given channel of the store, along
+// with the assertions needed to install it without `--dangerous`.
+type SnapArtifact struct {
+	Name     string `yaml:"name"`
+	Channel  string `yaml:"channel,omitempty"`
+	Revision string `yaml:"revision,omitempty"`
+	Classic  bool   `yaml:"classic,omitempty"`
+	// File and Assertion are paths relative to the artifacts directory.
+	File      string `yaml:"file"`
+	Assertion string `yaml:"assertion,omitempty"`
+	// Prerequisites names the snaps that must be installed before this one, such as
+	// snapd, its base and its default content providers. Each has an artifact of its
+	// own in the manifest.
+	Prerequisites []string `yaml:"prerequisites,omitempty"`
+}
+
+// DebArtifact is a deb file, along with the deb files of the dependencies it needs
+// that might not be installed on the machine.
+type DebArtifact struct {
+	Name string `yaml:"name"`
+	// File and Dependencies are paths relative to the artifacts directory.
+	File         string   `yaml:"file"`
+	Dependencies []string `yaml:"dependencies,omitempty"`
+}
+
+// ImageArtifact is an OCI image saved as an archive, which the k8s provider side-loads
+// into containerd.
+type ImageArtifact struct {
+	Name string `yaml:"name"`
+	// File is a path relative to the artifacts directory.
+	File string `yaml:"file"`
+}
+
+// LoadManifest reads the manifest of the specified artifacts directory.
+func LoadManifest(w system.Worker, dir string) (*Manifest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve artifacts directory '%s': %w", dir, err)
+	}
+
+	contents, err := w.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifacts manifest: %w", err)
+	}
+
+	manifest := &Manifest{}
+	err = yaml.Unmarshal(contents, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse artifacts manifest: %w", err)
+	}
+
+	for _, s := range manifest.Snaps {
+		if s.Name == "" || s.File == "" {
+			return nil, fmt.Errorf("snap artifacts must specify a name and a file")
+		}
+	}
+	for _, d := range manifest.Debs {
+		if d.Name == "" || d.File == "" {
+			return nil, fmt.Errorf("deb artifacts must specify a name and a file")
+		}
+	}
+
+	manifest.dir = dir
+	return manifest, nil
+}
+
+// Snap returns the artifact that provides the specified snap, with its paths resolved,
+// or nil if there is none. An artifact matches if it has the snap's channel and
+// revision, where those are specified. Snaps installed from a given path never match.
+func (m *Manifest) Snap(s *system.Snap) *SnapArtifact {
+	if m == nil || s.Path != "" {
+		return nil
+	}
+
+	for _, a := range m.Snaps {
+		if a.Name != s.Name {
+			continue
+		}
+		if s.Channel != "" && system.NormalizeChannel(s.Channel) != system.NormalizeChannel(a.Channel) {
+			continue
+		}
+		if s.Revision != "" && s.Revision != a.Revision {
+			continue
+		}
+
+		a.File = m.path(a.File)
+		if a.Assertion != "" {
+			a.Assertion = m.path(a.Assertion)
+		}
+		return &a
+	}
+
+	return nil
+}
+
+// Deb returns the artifact that provides the named deb, with its paths resolved, or
+// nil if there is none.
+func (m *Manifest) Deb(name string) *DebArtifact {
+	if m == nil {
+		return nil
+	}
+
+	for _, a := range m.Debs {
+		if a.Name != name {
+			continue
+		}
+
+		a.File = m.path(a.File)
+		dependencies := make([]string, 0, len(a.Dependencies))
+		for _, d := range a.Dependencies {
+			dependencies = append(dependencies, m.path(d))
+		}
+		a.Dependencies = dependencies
+		return &a
+	}
+
+	return nil
+}
+
+// ImageFiles returns the paths of the image archives.
+func (m *Manifest) ImageFiles() []string {
+	if m == nil {
+		return nil
+	}
+
+	files := []string{}
+	for _, image := range m.Images {
+		files = append(files, m.path(image.File))
+	}
+	return files
+}
+
+// AgentsDir returns the path of the directory of Juju agent binaries, or an empty
+// string if there is none.
+func (m *Manifest) AgentsDir() string {
+	if m == nil || m.Agents == "" {
+		return ""
+	}
+	return m.path(m.Agents)
+}
+
+// path resolves a path in the manifest against the artifacts directory.
+func (m *Manifest) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.dir, p)
+}
+
+// loadManifest loads the manifest of an artifacts directory, returning nil if no
+// directory is specified.
+func loadManifest(w system.Worker, dir string) (*Manifest, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	return LoadManifest(w, dir)
+}
@@ -0,0 +1,76 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestManifestSnap(t *testing.T) {
+	manifest := &Manifest{
+		dir: "/srv/artifacts",
+		Snaps: []SnapArtifact{
+			{Name: "lxd", Channel: "5.21/stable", Revision: "33110", File: "lxd_33110.snap", Assertion: "lxd_33110.assert"},
+			{Name: "lxd", Channel: "latest/stable", Revision: "34000", File: "/mnt/lxd_34000.snap"},
+		},
+	}
+
+	tests := []struct {
+		snap     *system.Snap
+		expected *SnapArtifact
+	}{
+		{
+			snap:     &system.Snap{Name: "lxd", Channel: "5.21"},
+			expected: &SnapArtifact{Name: "lxd", Channel: "5.21/stable", Revision: "33110", File: "/srv/artifacts/lxd_33110.snap", Assertion: "/srv/artifacts/lxd_33110.assert"},
+		},
+		{
+			snap:     &system.Snap{Name: "lxd", Channel: "stable"},
+			expected: &SnapArtifact{Name: "lxd", Channel: "latest/stable", Revision: "34000", File: "/mnt/lxd_34000.snap"},
+		},
+		{
+			snap:     &system.Snap{Name: "lxd"},
+			expected: &SnapArtifact{Name: "lxd", Channel: "5.21/stable", Revision: "33110", File: "/srv/artifacts/lxd_33110.snap", Assertion: "/srv/artifacts/lxd_33110.assert"},
+		},
+		{
+			snap:     &system.Snap{Name: "lxd", Revision: "34000"},
+			expected: &SnapArtifact{Name: "lxd", Channel: "latest/stable", Revision: "34000", File: "/mnt/lxd_34000.snap"},
+		},
+		{snap: &system.Snap{Name: "lxd", Channel: "6/stable"}, expected: nil},
+		{snap: &system.Snap{Name: "lxd", Path: "/tmp/lxd.snap"}, expected: nil},
+		{snap: &system.Snap{Name: "juju"}, expected: nil},
+	}
+
+	for _, tc := range tests {
+		got := manifest.Snap(tc.snap)
+		if !reflect.DeepEqual(tc.expected, got) {
+			t.Fatalf("expected: %+v, got: %+v", tc.expected, got)
+		}
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockFile("/srv/artifacts/manifest.yaml", []byte("debs:\n  - name: make\n    file: make.deb\n"))
+	r.MockFile("/srv/invalid/manifest.yaml", []byte("snaps:\n  - name: lxd\n"))
+
+	manifest, err := LoadManifest(r, "/srv/artifacts")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := &DebArtifact{Name: "make", File: "/srv/artifacts/make.deb", Dependencies: []string{}}
+	if got := manifest.Deb("make"); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected: %+v, got: %+v", expected, got)
+	}
+
+	_, err = LoadManifest(r, "/srv/invalid")
+	if err == nil {
+		t.Fatalf("expected an error for a snap artifact without a file")
+	}
+
+	_, err = LoadManifest(r, "/srv/missing")
+	if err == nil {
+		t.Fatalf("expected an error for a missing manifest")
+	}
+}
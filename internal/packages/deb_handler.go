@@ -3,18 +3,70 @@ package packages
 import (
 	"fmt"
 	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/system"
 )
 
+// aptLockTimeout bounds how long apt waits for the dpkg frontend lock, e.g. while
+// unattended-upgrades holds it on a freshly booted machine.
+const aptLockTimeout = 5 * time.Minute
+
+// aptCacheMaxAge is how long after its last update the apt cache is considered
+// fresh enough to install from without updating it again.
+const aptCacheMaxAge = time.Hour
+
+// aptCacheStamps are touched when the apt cache is updated. The stamp file is only
+// present on machines with update-notifier-common, hence the lists directory.
+var aptCacheStamps = []string{"/var/lib/apt/periodic/update-success-stamp", "/var/lib/apt/lists"}
+
 // NewDeb constructs a new Deb instance.
 func NewDeb(name string) *Deb {
 	return &Deb{Name: name}
 }
 
+// NewDebFromString constructs a new Deb from a string of the form
+// "<name>[=<version>][+<flag>...]", where the flags are "hold" and "no-recommends",
+// e.g. "make=4.3-4.1build1+hold". Since versions may themselves contain "+", only
+// known flags are taken from the end of the string.
+func NewDebFromString(deb string) *Deb {
+	parts := strings.Split(deb, "+")
+
+	d := &Deb{}
+	for len(parts) > 1 {
+		switch parts[len(parts)-1] {
+		case "hold":
+			d.Hold = true
+		case "no-recommends":
+			d.NoRecommends = true
+		default:
+			d.Name, d.Version, _ = strings.Cut(strings.Join(parts, "+"), "=")
+			return d
+		}
+		parts = parts[:len(parts)-1]
+	}
+
+	d.Name, d.Version, _ = strings.Cut(parts[0], "=")
+	return d
+}
+
 // Deb is a simple representation of a package installed from the Ubuntu archive.
 type Deb struct {
 	Name string
+	// Version, if set, pins the package to a specific version.
+	Version string
+	// Hold marks the package as held with `apt-mark hold` until it is restored.
+	Hold bool
+	// NoRecommends installs the package without its recommended packages.
+	NoRecommends bool
+	// Debconf lists debconf selections to preseed before the package is installed,
+	// in the form accepted by `debconf-set-selections`.
+	Debconf []string
 }
 
 // NewDebHandler constructs a new instance of a DebHandler.
@@ -27,7 +79,17 @@ func NewDebHandler(system system.Worker, debs []*Deb) *DebHandler {
 
 // DebHandler can install or remove a set of debs.
 type DebHandler struct {
-	Debs   []*Deb
+	Debs []*Deb
+	// Sources are additional apt sources, added before the debs are installed so that
+	// they can be installed from them.
+	Sources []*AptSource
+	// Artifacts, if set, is a directory of deb files described by a manifest. Debs
+	// found in it are installed from their files, and the rest from the archive.
+	Artifacts string
+	// State, if set, records which debs were already installed, so that restore
+	// only removes the debs that concierge installed.
+	State *config.RuntimeState
+
 	system system.Worker
 }
 
@@ -40,89 +102,449 @@ var aptEnv = []string{
 }
 
 // aptCommand constructs an apt-get command that runs non-interactively so
-// package operations never hang waiting for user input.
+// package operations never hang waiting for user input, and that waits for
+// the dpkg lock rather than failing if another process holds it.
 func aptCommand(args ...string) *system.Command {
-	cmd := system.NewCommand("apt-get", append([]string{"-y"}, args...))
+	lockTimeout := fmt.Sprintf("DPkg::Lock::Timeout=%d", int(aptLockTimeout.Seconds()))
+	cmd := system.NewCommand("apt-get", append([]string{"-y", "-o", lockTimeout}, args...))
 	cmd.Env = aptEnv
 	return cmd
 }
 
-// Prepare updates the apt cache and installs a set of debs from the archive.
+// InstalledDebs returns the subset of the named packages that are installed.
+func InstalledDebs(w system.Worker, names ...string) []string {
+	versions := installedVersions(w, names...)
+
+	installed := []string{}
+	for _, name := range names {
+		if _, ok := versions[name]; ok && !slices.Contains(installed, name) {
+			installed = append(installed, name)
+		}
+	}
+	return installed
+}
+
+// installedVersions maps each of the named packages that is installed to its version.
+func installedVersions(w system.Worker, names ...string) map[string]string {
+	cmd := system.NewCommand("dpkg-query", append([]string{"-W", "-f=${db:Status-Abbrev} ${Package} ${Version}\\n"}, names...))
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `no packages found`
+	// dpkg-query exits non-zero if any of the names is unknown, but still
+	// reports the ones it knows about, so the error is deliberately ignored.
+	output, _ := w.Run(cmd)
+
+	versions := map[string]string{}
+	for line := range strings.SplitSeq(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "ii" {
+			versions[fields[1]] = fields[2]
+		}
+	}
+	return versions
+}
+
+// Prepare adds the apt sources, then installs the debs that are not already installed,
+// or not at their pinned version, in a single apt transaction, then holds those that
+// should be held. Debs found in the artifacts directory are installed from their files,
+// and the apt cache is only updated if the sources changed, or if some debs must come
+// from the archive and it has not been updated recently.
 func (h *DebHandler) Prepare() error {
+	if len(h.Debs) == 0 && len(h.Sources) == 0 {
+		return nil
+	}
+
+	changed, err := h.addSources()
+	if err != nil {
+		return err
+	}
+	if changed {
+		err = h.updateAptCache(true)
+		if err != nil {
+			return fmt.Errorf("failed to update apt cache: %w", err)
+		}
+	}
 	if len(h.Debs) == 0 {
 		return nil
 	}
 
-	err := h.updateAptCache()
+	manifest, err := loadManifest(h.system, h.Artifacts)
 	if err != nil {
-		return fmt.Errorf("failed to update apt cache: %w", err)
+		return err
 	}
 
+	versions := installedVersions(h.system, h.names()...)
+
+	missing := []*Deb{}
 	for _, deb := range h.Debs {
-		err := h.installDeb(deb)
+		version, installed := versions[deb.Name]
+		h.recordDeb(deb.Name, installed)
+
+		if installed && (deb.Version == "" || deb.Version == version) {
+			continue
+		}
+		if !slices.ContainsFunc(missing, func(d *Deb) bool { return d.Name == deb.Name }) {
+			missing = append(missing, deb)
+		}
+	}
+
+	if len(missing) > 0 {
+		err = h.installMissing(missing, manifest, changed)
 		if err != nil {
-			return fmt.Errorf("failed to install deb: %w", err)
+			return err
 		}
+	} else {
+		slog.Debug("All apt packages are already installed", "packages", h.names())
+	}
+
+	err = h.holdDebs()
+	if err != nil {
+		return fmt.Errorf("failed to hold debs: %w", err)
 	}
 	return nil
 }
 
-// Restore removes a set of debs from the machine.
+// installMissing preseeds the debconf selections of the missing debs, then installs
+// them, updating the apt cache first if needed and it was not just updated.
+func (h *DebHandler) installMissing(missing []*Deb, manifest *Manifest, updated bool) error {
+	if !updated && slices.ContainsFunc(missing, func(d *Deb) bool { return manifest.Deb(d.Name) == nil }) {
+		err := h.updateAptCache(false)
+		if err != nil {
+			return fmt.Errorf("failed to update apt cache: %w", err)
+		}
+	}
+
+	err := h.preseedDebs(missing)
+	if err != nil {
+		return fmt.Errorf("failed to preseed debconf selections: %w", err)
+	}
+
+	// Recommended packages are chosen for the whole transaction, so debs installed
+	// without them need a transaction of their own.
+	withRecommends := slices.DeleteFunc(slices.Clone(missing), func(d *Deb) bool { return d.NoRecommends })
+	withoutRecommends := slices.DeleteFunc(slices.Clone(missing), func(d *Deb) bool { return !d.NoRecommends })
+
+	for _, debs := range [][]*Deb{withRecommends, withoutRecommends} {
+		if len(debs) == 0 {
+			continue
+		}
+		err := h.installDebs(debs, manifest)
+		if err != nil {
+			return fmt.Errorf("failed to install debs: %w", err)
+		}
+	}
+	return nil
+}
+
+// Restore releases the holds that concierge placed, then removes the debs that
+// concierge installed, and finally the apt sources. Debs that were preseeded are
+// purged, which clears their debconf selections. Debs that were already installed
+// before concierge first prepared them are kept.
 func (h *DebHandler) Restore() error {
+	if len(h.Debs) == 0 {
+		return h.removeSources()
+	}
+
+	err := h.unholdDebs()
+	if err != nil {
+		return fmt.Errorf("failed to release deb holds: %w", err)
+	}
+
+	installed := InstalledDebs(h.system, h.names()...)
+
+	remove := []string{}
+	purge := []string{}
 	for _, deb := range h.Debs {
-		err := h.removeDeb(deb)
+		if record := h.record(deb.Name); record != nil && record.Preexisting {
+			slog.Info("Keeping pre-existing apt package", "package", deb.Name)
+			continue
+		}
+		if !slices.Contains(installed, deb.Name) || slices.Contains(remove, deb.Name) || slices.Contains(purge, deb.Name) {
+			continue
+		}
+		if len(deb.Debconf) > 0 {
+			purge = append(purge, deb.Name)
+		} else {
+			remove = append(remove, deb.Name)
+		}
+	}
+
+	for _, action := range []struct {
+		verb  string
+		names []string
+	}{{"purge", purge}, {"remove", remove}} {
+		if len(action.names) == 0 {
+			continue
+		}
+		err := h.runApt(aptCommand(append([]string{action.verb}, action.names...)...))
 		if err != nil {
-			return fmt.Errorf("failed to remove deb: %w", err)
+			return fmt.Errorf("failed to %s apt packages: %w", action.verb, err)
 		}
+		slog.Info("Removed apt packages", "packages", action.names)
 	}
 
-	cmd := aptCommand("autoremove")
+	if len(remove) > 0 || len(purge) > 0 {
+		err = h.runApt(aptCommand("autoremove"))
+		if err != nil {
+			return fmt.Errorf("failed to remove unused apt packages: %w", err)
+		}
+	}
 
-	_, err := system.RunExclusive(h.system, cmd)
+	for _, deb := range h.Debs {
+		h.forgetDeb(deb.Name)
+	}
+	return h.removeSources()
+}
+
+// installDebs uses `apt` to install the packages on the system in a single transaction,
+// each from the archive, or from the files of its artifact if it has one.
+func (h *DebHandler) installDebs(debs []*Deb, manifest *Manifest) error {
+	names := []string{}
+	packages := []string{}
+	pinned := false
+	for _, d := range debs {
+		names = append(names, d.Name)
+
+		artifact := manifest.Deb(d.Name)
+		if artifact == nil {
+			if d.Version != "" {
+				packages = append(packages, d.Name+"="+d.Version)
+				pinned = true
+			} else {
+				packages = append(packages, d.Name)
+			}
+			continue
+		}
+
+		// apt-get treats arguments containing a slash as paths to deb files.
+		for _, file := range append([]string{artifact.File}, h.missingDependencies(artifact)...) {
+			if !slices.Contains(packages, file) {
+				packages = append(packages, file)
+			}
+		}
+	}
+
+	args := []string{"install",
+		"-o", "Dpkg::Options::=--force-confdef",
+		"-o", "Dpkg::Options::=--force-confold"}
+	if debs[0].NoRecommends {
+		args = append(args, "--no-install-recommends")
+	}
+	// A pinned version may be older than the version already installed.
+	if pinned {
+		args = append(args, "--allow-downgrades")
+	}
+
+	err := h.runApt(aptCommand(append(args, packages...)...))
 	if err != nil {
-		return fmt.Errorf("failed to install apt package: %w", err)
+		return fmt.Errorf("failed to install apt packages %s: %w", strings.Join(names, ", "), err)
 	}
 
+	slog.Info("Installed apt packages", "packages", names)
 	return nil
 }
 
-// installDeb uses `apt` to install the package on the system from the archives.
-func (h *DebHandler) installDeb(d *Deb) error {
-	cmd := aptCommand("install",
-		"-o", "Dpkg::Options::=--force-confdef",
-		"-o", "Dpkg::Options::=--force-confold",
-		d.Name)
+// missingDependencies returns the files of the artifact's dependencies that are not
+// already installed. The bundled dependencies include base packages such as libc6,
+// which apt would refuse to downgrade if the machine has newer versions of them.
+func (h *DebHandler) missingDependencies(artifact *DebArtifact) []string {
+	if len(artifact.Dependencies) == 0 {
+		return nil
+	}
+
+	// Deb files are named "<package>_<version>_<arch>.deb".
+	names := []string{}
+	for _, file := range artifact.Dependencies {
+		name, _, _ := strings.Cut(path.Base(file), "_")
+		names = append(names, name)
+	}
+
+	installed := InstalledDebs(h.system, names...)
+
+	missing := []string{}
+	for i, file := range artifact.Dependencies {
+		if !slices.Contains(installed, names[i]) {
+			missing = append(missing, file)
+		}
+	}
+	return missing
+}
+
+// preseedDebs sets the debconf selections of the debs with `debconf-set-selections`,
+// so that packages which would otherwise prompt can be installed unattended.
+func (h *DebHandler) preseedDebs(debs []*Deb) error {
+	selections := []string{}
+	for _, d := range debs {
+		selections = append(selections, d.Debconf...)
+	}
+	if len(selections) == 0 {
+		return nil
+	}
+
+	// The selections may hold secrets, such as database passwords, so they are piped
+	// to `debconf-set-selections` rather than written to a file.
+	cmd := system.NewCommand("debconf-set-selections", []string{})
+	cmd.Stdin = []byte(strings.Join(selections, "\n") + "\n")
 
 	_, err := system.RunExclusive(h.system, cmd)
 	if err != nil {
-		return fmt.Errorf("failed to install apt package '%s': %w", d.Name, err)
+		return err
 	}
 
-	slog.Info("Installed apt package", "package", d.Name)
+	slog.Debug("Preseeded debconf selections", "count", len(selections))
 	return nil
 }
 
-// Remove uninstalls the deb from the system with `apt`.
-func (h *DebHandler) removeDeb(d *Deb) error {
-	cmd := aptCommand("remove", d.Name)
+// holdDebs marks the debs that should be held with `apt-mark hold`, so that they are
+// not upgraded, recording those that were not already held.
+func (h *DebHandler) holdDebs() error {
+	hold := []string{}
+	for _, d := range h.Debs {
+		if d.Hold && !slices.Contains(hold, d.Name) {
+			hold = append(hold, d.Name)
+		}
+	}
+	if len(hold) == 0 {
+		return nil
+	}
+
+	cmd := system.NewCommand("apt-mark", append([]string{"showhold"}, hold...))
+	cmd.ReadOnly = true
+	output, err := h.system.Run(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to list held apt packages: %w", err)
+	}
 
-	_, err := system.RunExclusive(h.system, cmd)
+	held := strings.Fields(string(output))
+	hold = slices.DeleteFunc(hold, func(name string) bool { return slices.Contains(held, name) })
+	if len(hold) == 0 {
+		return nil
+	}
+
+	_, err = system.RunExclusive(h.system, system.NewCommand("apt-mark", append([]string{"hold"}, hold...)))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range hold {
+		if record := h.record(name); record != nil {
+			record.Held = true
+		}
+	}
+
+	slog.Info("Held apt packages", "packages", hold)
+	return nil
+}
+
+// unholdDebs releases the holds that concierge placed on debs. Without a record of
+// which holds it placed, every deb that should be held is released.
+func (h *DebHandler) unholdDebs() error {
+	unhold := []string{}
+	for _, d := range h.Debs {
+		record := h.record(d.Name)
+		if (record != nil && record.Held) || (record == nil && d.Hold) {
+			if !slices.Contains(unhold, d.Name) {
+				unhold = append(unhold, d.Name)
+			}
+		}
+	}
+	if len(unhold) == 0 {
+		return nil
+	}
+
+	_, err := system.RunExclusive(h.system, system.NewCommand("apt-mark", append([]string{"unhold"}, unhold...)))
 	if err != nil {
-		return fmt.Errorf("failed to remove apt package '%s': %w", d.Name, err)
+		return err
 	}
 
-	slog.Info("Removed apt package", "package", d.Name)
+	slog.Info("Released apt package holds", "packages", unhold)
 	return nil
 }
 
-// updateAptCache is a helper method to update the host's package cache.
-func (h *DebHandler) updateAptCache() error {
-	cmd := aptCommand("update")
+// updateAptCache is a helper method to update the host's package cache, unless it
+// was updated recently and the update is not forced.
+func (h *DebHandler) updateAptCache(force bool) error {
+	if !force {
+		if age, ok := h.aptCacheAge(); ok && age < aptCacheMaxAge {
+			slog.Debug("Skipping apt cache update", "updated", age.Round(time.Second).String()+" ago")
+			return nil
+		}
+	}
 
-	_, err := system.RunExclusive(h.system, cmd)
+	err := h.runApt(aptCommand("update"))
 	if err != nil {
 		return fmt.Errorf("failed to update apt package lists: %w", err)
 	}
 
 	return nil
 }
+
+// aptCacheAge returns how long ago the apt cache was last updated, if it can tell.
+func (h *DebHandler) aptCacheAge() (time.Duration, bool) {
+	cmd := system.NewCommand("stat", append([]string{"-c", "%Y"}, aptCacheStamps...))
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `No such file or directory`
+	// stat exits non-zero if any stamp is missing, but still reports the others.
+	output, _ := h.system.Run(cmd)
+
+	var updated int64
+	for line := range strings.SplitSeq(string(output), "\n") {
+		if t, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64); err == nil {
+			updated = max(updated, t)
+		}
+	}
+	if updated == 0 {
+		return 0, false
+	}
+
+	return time.Since(time.Unix(updated, 0)), true
+}
+
+// runApt runs an apt-get command, one at a time, reporting clearly if apt gave up
+// waiting for another process to release the dpkg lock.
+func (h *DebHandler) runApt(cmd *system.Command) error {
+	output, err := system.RunExclusive(h.system, cmd)
+	if err != nil && strings.Contains(string(output), "Could not get lock") {
+		return fmt.Errorf("timed out after %s waiting for the dpkg lock, held by another process such as unattended-upgrades: %w", aptLockTimeout, err)
+	}
+	return err
+}
+
+// names returns the names of the handler's debs.
+func (h *DebHandler) names() []string {
+	names := make([]string, 0, len(h.Debs))
+	for _, deb := range h.Debs {
+		names = append(names, deb.Name)
+	}
+	return names
+}
+
+// record returns the state recorded for the named deb, or nil if there is none.
+func (h *DebHandler) record(name string) *config.DebRecord {
+	if h.State == nil {
+		return nil
+	}
+
+	i := slices.IndexFunc(h.State.Debs, func(r config.DebRecord) bool { return r.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &h.State.Debs[i]
+}
+
+// recordDeb records whether the named deb was installed before concierge prepared
+// it. Once recorded, the record is left as it is: on a later `prepare`, dpkg lists
+// the debs that concierge itself installed, which restore would then keep.
+func (h *DebHandler) recordDeb(name string, installed bool) {
+	if h.State == nil || h.record(name) != nil {
+		return
+	}
+	h.State.Debs = append(h.State.Debs, config.DebRecord{Name: name, Preexisting: installed})
+}
+
+// forgetDeb removes the state recorded for the named deb once it is restored.
+func (h *DebHandler) forgetDeb(name string) {
+	if h.State == nil {
+		return
+	}
+	h.State.Debs = slices.DeleteFunc(h.State.Debs, func(r config.DebRecord) bool { return r.Name == name })
+}
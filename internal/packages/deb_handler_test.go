@@ -1,9 +1,13 @@
 package packages
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/system"
 )
 
@@ -17,17 +21,16 @@ func TestDebHandlerCommands(t *testing.T) {
 		{
 			func(d *DebHandler) { _ = d.Prepare() },
 			[]string{
-				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y update",
-				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold cowsay",
-				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold python3-venv",
+				"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' cowsay python3-venv",
+				"stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists",
+				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update",
+				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold cowsay python3-venv",
 			},
 		},
 		{
 			func(d *DebHandler) { _ = d.Restore() },
 			[]string{
-				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y remove cowsay",
-				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y remove python3-venv",
-				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y autoremove",
+				"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' cowsay python3-venv",
 			},
 		},
 	}
@@ -46,3 +49,213 @@ func TestDebHandlerCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestDebHandlerInstalledState(t *testing.T) {
+	query := "dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' cowsay make python3-venv"
+	debs := []*Deb{NewDeb("cowsay"), NewDeb("make"), NewDeb("python3-venv")}
+	state := &config.RuntimeState{}
+
+	r := system.NewMockSystem()
+	r.MockCommandReturn(query, []byte("ii  make 4.3-4.1build1\nrc  python3-venv 3.12.3-0ubuntu1\n"), nil)
+	r.MockCommandReturn("stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists", []byte(fmt.Sprintf("%d\n", time.Now().Unix())), nil)
+
+	handler := NewDebHandler(r, debs)
+	handler.State = state
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The apt cache is fresh, and make is already installed.
+	expected := []string{
+		query,
+		"stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold cowsay python3-venv",
+	}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+
+	expectedState := []config.DebRecord{{Name: "cowsay"}, {Name: "make", Preexisting: true}, {Name: "python3-venv"}}
+	if !reflect.DeepEqual(expectedState, state.Debs) {
+		t.Fatalf("expected: %v, got: %v", expectedState, state.Debs)
+	}
+
+	r = system.NewMockSystem()
+	r.MockCommandReturn(query, []byte("ii  cowsay 3.03+dfsg2-8\nii  make 4.3-4.1build1\nii  python3-venv 3.12.3-0ubuntu1\n"), nil)
+
+	handler = NewDebHandler(r, debs)
+	handler.State = state
+
+	err = handler.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the debs that concierge installed are removed.
+	expected = []string{
+		query,
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 remove cowsay python3-venv",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 autoremove",
+	}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+	if len(state.Debs) != 0 {
+		t.Fatalf("expected recorded debs to be forgotten, got: %v", state.Debs)
+	}
+}
+
+func TestDebHandlerSpecs(t *testing.T) {
+	query := "dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' make wireshark-common cowsay"
+	make := NewDebFromString("make=4.3-4.1build1+hold")
+	wireshark := NewDeb("wireshark-common")
+	wireshark.NoRecommends = true
+	wireshark.Debconf = []string{"wireshark-common wireshark-common/install-setuid boolean true"}
+	debs := []*Deb{make, wireshark, NewDeb("cowsay")}
+	state := &config.RuntimeState{}
+
+	r := system.NewMockSystem()
+	r.MockCommandReturn(query, []byte("ii  make 4.3-4build1\nii  cowsay 3.03+dfsg2-8\n"), nil)
+
+	handler := NewDebHandler(r, debs)
+	handler.State = state
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make is installed at a different version to its pin, so it is installed again.
+	expected := []string{
+		query,
+		"stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update",
+		"debconf-set-selections",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold --allow-downgrades make=4.3-4.1build1",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold --no-install-recommends wireshark-common",
+		"apt-mark showhold make",
+		"apt-mark hold make",
+	}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+
+	if r.CommandInputs["debconf-set-selections"] != wireshark.Debconf[0]+"\n" {
+		t.Fatalf("expected: %q, got: %q", wireshark.Debconf[0]+"\n", r.CommandInputs["debconf-set-selections"])
+	}
+	if len(r.CreatedFiles) != 0 {
+		t.Fatalf("expected debconf selections not to be written to a file, got: %v", r.CreatedFiles)
+	}
+
+	r = system.NewMockSystem()
+	r.MockCommandReturn(query, []byte("ii  make 4.3-4.1build1\nii  wireshark-common 4.2.2-1.1build3\nii  cowsay 3.03+dfsg2-8\n"), nil)
+
+	handler = NewDebHandler(r, debs)
+	handler.State = state
+
+	err = handler.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hold is released, and the preseeded package is purged. The pre-existing
+	// packages are kept.
+	expected = []string{
+		"apt-mark unhold make",
+		query,
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 purge wireshark-common",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 autoremove",
+	}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestNewDebFromString(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected Deb
+	}{
+		{spec: "cowsay", expected: Deb{Name: "cowsay"}},
+		{spec: "make=4.3-4.1build1", expected: Deb{Name: "make", Version: "4.3-4.1build1"}},
+		{spec: "make+hold+no-recommends", expected: Deb{Name: "make", Hold: true, NoRecommends: true}},
+		{spec: "libfoo=1.0+dfsg-1", expected: Deb{Name: "libfoo", Version: "1.0+dfsg-1"}},
+		{spec: "libfoo=1.0+dfsg-1+hold", expected: Deb{Name: "libfoo", Version: "1.0+dfsg-1", Hold: true}},
+	}
+
+	for _, tc := range tests {
+		got := NewDebFromString(tc.spec)
+		if !reflect.DeepEqual(tc.expected, *got) {
+			t.Fatalf("expected: %+v, got: %+v", tc.expected, *got)
+		}
+	}
+}
+
+func TestDebHandlerLockTimeout(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockCommandReturn(
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update",
+		[]byte("E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (unattended-upgr)"),
+		fmt.Errorf("exit status 100"),
+	)
+
+	err := NewDebHandler(r, []*Deb{NewDeb("cowsay")}).Prepare()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 5m0s waiting for the dpkg lock") {
+		t.Fatalf("expected dpkg lock timeout error, got: %v", err)
+	}
+}
+
+func TestDebHandlerArtifacts(t *testing.T) {
+	manifest := `
+debs:
+  - name: cowsay
+    file: debs/cowsay_3.03_all.deb
+    dependencies: [debs/libtext-charwidth-perl_0.04_amd64.deb, debs/libc6_2.39-0ubuntu8_amd64.deb]
+`
+	deps := "dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' libtext-charwidth-perl libc6"
+
+	tests := []struct {
+		debs     []*Deb
+		expected []string
+	}{
+		{
+			debs: []*Deb{NewDeb("cowsay")},
+			expected: []string{
+				"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' cowsay",
+				deps,
+				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold /srv/artifacts/debs/cowsay_3.03_all.deb /srv/artifacts/debs/libtext-charwidth-perl_0.04_amd64.deb",
+			},
+		},
+		{
+			debs: []*Deb{NewDeb("cowsay"), NewDeb("make")},
+			expected: []string{
+				"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' cowsay make",
+				"stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists",
+				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update",
+				deps,
+				"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold /srv/artifacts/debs/cowsay_3.03_all.deb /srv/artifacts/debs/libtext-charwidth-perl_0.04_amd64.deb make",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		system := system.NewMockSystem()
+		system.MockFile("/srv/artifacts/manifest.yaml", []byte(manifest))
+		// libc6 is already installed, at a newer version than the bundled one.
+		system.MockCommandReturn(deps, []byte("ii libc6 2.39-0ubuntu8.4\n"), nil)
+
+		handler := NewDebHandler(system, tc.debs)
+		handler.Artifacts = "/srv/artifacts"
+
+		err := handler.Prepare()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(tc.expected, system.ExecutedCommands) {
+			t.Fatalf("expected: %v, got: %v", tc.expected, system.ExecutedCommands)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package packages
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// downloadRetryDuration bounds how long a failed download is retried, e.g. while
+// the store or the archive is briefly unreachable.
+const downloadRetryDuration = 5 * time.Minute
+
+// Download fetches each snap, along with its assertions, into the "snaps" subdirectory
+// of dir using `snap download`, returning the artifacts that describe them. The snaps
+// they need, such as snapd and their bases, are fetched too, recursively. Snaps that
+// are installed from a local path are skipped.
+func (h *SnapHandler) Download(dir string) ([]SnapArtifact, error) {
+	err := h.system.MkdirAll(path.Join(dir, "snaps"), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snaps directory: %w", err)
+	}
+
+	artifacts := []SnapArtifact{}
+	for _, snap := range h.Snaps {
+		if snap.Path != "" {
+			slog.Warn("Skipping snap installed from a local file", "snap", snap.Name, "path", snap.Path)
+			continue
+		}
+
+		duplicate := slices.ContainsFunc(artifacts, func(a SnapArtifact) bool {
+			return a.Name == snap.Name &&
+				system.NormalizeChannel(a.Channel) == system.NormalizeChannel(snap.Channel) &&
+				(snap.Revision == "" || a.Revision == snap.Revision)
+		})
+		if duplicate {
+			continue
+		}
+
+		artifact, err := h.downloadSnap(snap, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download snap '%s': %w", snap.Name, err)
+		}
+		artifacts = append(artifacts, *artifact)
+	}
+
+	// The prerequisites are appended as they are found, so that theirs are found too.
+	for i := 0; i < len(artifacts); i++ {
+		prerequisites, err := h.prerequisites(dir, &artifacts[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve prerequisites of snap '%s': %w", artifacts[i].Name, err)
+		}
+		artifacts[i].Prerequisites = prerequisites
+
+		for _, name := range prerequisites {
+			if slices.ContainsFunc(artifacts, func(a SnapArtifact) bool { return a.Name == name }) {
+				continue
+			}
+
+			artifact, err := h.downloadSnap(system.NewSnap(name, "", []string{}), dir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to download snap '%s': %w", name, err)
+			}
+			artifacts = append(artifacts, *artifact)
+		}
+	}
+
+	return artifacts, nil
+}
+
+// snapMetadata holds the parts of a snap's meta/snap.yaml that concierge needs: its
+// confinement, and which other snaps it needs.
+type snapMetadata struct {
+	Type        string         `yaml:"type"`
+	Base        string         `yaml:"base"`
+	Confinement string         `yaml:"confinement"`
+	Plugs       map[string]any `yaml:"plugs"`
+}
+
+// readSnapMetadata reads the meta/snap.yaml of a snap file with `unsquashfs`.
+func (h *SnapHandler) readSnapMetadata(file string) (*snapMetadata, error) {
+	cmd := system.NewCommand("unsquashfs", []string{"-cat", file, "meta/snap.yaml"})
+	cmd.ReadOnly = true
+	output, err := h.system.Run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snap metadata: %w", err)
+	}
+
+	meta := &snapMetadata{}
+	err = yaml.Unmarshal(output, meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse snap metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// prerequisites reads the metadata of a downloaded snap, returning the snaps that
+// must be installed before it: snapd, its base and the default providers of its
+// content plugs. Only applications need them.
+func (h *SnapHandler) prerequisites(dir string, artifact *SnapArtifact) ([]string, error) {
+	meta, err := h.readSnapMetadata(path.Join(dir, artifact.File))
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Type != "" && meta.Type != "app" {
+		return nil, nil
+	}
+
+	// Applications without a base run on "core".
+	prerequisites := []string{"snapd"}
+	switch meta.Base {
+	case "":
+		prerequisites = append(prerequisites, "core")
+	case "none":
+	default:
+		prerequisites = append(prerequisites, meta.Base)
+	}
+
+	// A content plug names its default provider as "<snap>" or "<snap>:<slot>".
+	for _, name := range slices.Sorted(maps.Keys(meta.Plugs)) {
+		plug, ok := meta.Plugs[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		provider, ok := plug["default-provider"].(string)
+		if !ok || provider == "" {
+			continue
+		}
+		provider, _, _ = strings.Cut(provider, ":")
+		if !slices.Contains(prerequisites, provider) {
+			prerequisites = append(prerequisites, provider)
+		}
+	}
+
+	return slices.DeleteFunc(prerequisites, func(name string) bool { return name == artifact.Name }), nil
+}
+
+// downloadSnap downloads a snap and its assertions, reading the names of the files it
+// was saved as from the output of `snap download`, e.g. "snap install /dir/jq_6.snap".
+func (h *SnapHandler) downloadSnap(s *system.Snap, dir string) (*SnapArtifact, error) {
+	snapInfo, err := h.system.SnapInfo(s.Name, s.Channel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup snap details: %w", err)
+	}
+
+	args := []string{"download", s.Name, "--target-directory", path.Join(dir, "snaps")}
+	if s.Channel != "" {
+		args = append(args, "--channel", s.Channel)
+	}
+	if s.Revision != "" {
+		args = append(args, "--revision", s.Revision)
+	}
+
+	output, err := system.RunWithRetries(h.system, system.NewCommand("snap", args), downloadRetryDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := &SnapArtifact{Name: s.Name, Channel: s.Channel, Classic: snapInfo.Classic}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "snap" {
+			continue
+		}
+
+		file := path.Base(fields[2])
+		switch fields[1] {
+		case "ack":
+			artifact.Assertion = path.Join("snaps", file)
+		case "install":
+			artifact.File = path.Join("snaps", file)
+			artifact.Revision = strings.TrimSuffix(strings.TrimPrefix(file, s.Name+"_"), ".snap")
+		}
+	}
+
+	if artifact.File == "" {
+		return nil, fmt.Errorf("could not find the downloaded snap file in the output of 'snap download'")
+	}
+
+	slog.Info("Downloaded snap", "snap", s.Name, "revision", artifact.Revision)
+	return artifact, nil
+}
+
+// Download fetches each deb, along with the debs it depends on, into the "debs"
+// subdirectory of dir using `apt-get download`, returning the artifacts that describe
+// them.
+func (h *DebHandler) Download(dir string) ([]DebArtifact, error) {
+	if len(h.Debs) == 0 {
+		return []DebArtifact{}, nil
+	}
+
+	debsDir := path.Join(dir, "debs")
+	err := h.system.MkdirAll(debsDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create debs directory: %w", err)
+	}
+
+	err = h.updateAptCache(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update apt cache: %w", err)
+	}
+
+	// Each deb's dependencies, including the deb itself, in the order apt lists them.
+	dependencies := map[string][]string{}
+	all := []string{}
+	for _, deb := range h.Debs {
+		names, err := h.dependencies(deb.Name)
+		if err != nil {
+			return nil, err
+		}
+		dependencies[deb.Name] = names
+
+		for _, name := range names {
+			if !slices.Contains(all, name) {
+				all = append(all, name)
+			}
+		}
+	}
+
+	// Pinned debs are downloaded at their pinned version.
+	for _, deb := range h.Debs {
+		if i := slices.Index(all, deb.Name); i >= 0 && deb.Version != "" {
+			all[i] = deb.Name + "=" + deb.Version
+		}
+	}
+
+	files, err := h.downloadFiles(all)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := system.NewCommand("apt-get", append([]string{"download"}, all...))
+	cmd.Dir = debsDir
+	_, err = system.RunWithRetries(h.system, cmd, downloadRetryDuration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download debs: %w", err)
+	}
+
+	artifacts := []DebArtifact{}
+	for _, deb := range h.Debs {
+		artifact := DebArtifact{Name: deb.Name, File: path.Join("debs", files[deb.Name])}
+		for _, name := range dependencies[deb.Name] {
+			if name != deb.Name {
+				artifact.Dependencies = append(artifact.Dependencies, path.Join("debs", files[name]))
+			}
+		}
+		artifacts = append(artifacts, artifact)
+		slog.Info("Downloaded apt package", "package", deb.Name, "dependencies", len(artifact.Dependencies))
+	}
+
+	return artifacts, nil
+}
+
+// dependencies lists the named deb and every package it depends on, recursively,
+// leaving out virtual packages and recommended packages.
+func (h *DebHandler) dependencies(name string) ([]string, error) {
+	cmd := system.NewCommand("apt-cache", []string{
+		"depends", "--recurse", "--no-recommends", "--no-suggests", "--no-conflicts",
+		"--no-breaks", "--no-replaces", "--no-enhances", name,
+	})
+	cmd.ReadOnly = true
+
+	output, err := h.system.Run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dependencies of '%s': %w", name, err)
+	}
+
+	// Packages are listed unindented, each followed by its indented dependencies.
+	// Virtual packages are shown as "<name>", and foreign architectures as "name:arch".
+	names := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "<") || strings.Contains(line, ":") {
+			continue
+		}
+		if !slices.Contains(names, line) {
+			names = append(names, line)
+		}
+	}
+
+	if !slices.Contains(names, name) {
+		return nil, fmt.Errorf("apt package '%s' not found", name)
+	}
+
+	return names, nil
+}
+
+// downloadFiles maps each of the named debs to the name of the file `apt-get download`
+// saves it as, e.g. "make_4.3-4.1build1_amd64.deb".
+func (h *DebHandler) downloadFiles(names []string) (map[string]string, error) {
+	cmd := system.NewCommand("apt-get", append([]string{"download", "--print-uris"}, names...))
+	cmd.ReadOnly = true
+
+	output, err := h.system.Run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve deb files: %w", err)
+	}
+
+	// Each line is of the form "'<uri>' <file> <size> <hash>".
+	files := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name, _, _ := strings.Cut(fields[1], "_")
+		files[name] = fields[1]
+	}
+
+	for _, name := range names {
+		name, _, _ = strings.Cut(name, "=")
+		if files[name] == "" {
+			return nil, fmt.Errorf("failed to resolve deb file for '%s'", name)
+		}
+	}
+
+	return files, nil
+}
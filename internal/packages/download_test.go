@@ -0,0 +1,127 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestSnapHandlerDownload(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("charmcraft", "latest/stable", true, false)
+	r.MockCommandReturn("snap download charmcraft --target-directory /tmp/bundle/snaps --channel latest/stable", []byte(`Fetching snap "charmcraft"
+Fetching assertions for "charmcraft"
+Install the snap with:
+   snap ack /tmp/bundle/snaps/charmcraft_6197.assert
+   snap install /tmp/bundle/snaps/charmcraft_6197.snap
+`), nil)
+	r.MockCommandReturn("snap download jq --target-directory /tmp/bundle/snaps", []byte(`Install the snap with:
+   snap ack /tmp/bundle/snaps/jq_6.assert
+   snap install /tmp/bundle/snaps/jq_6.snap
+`), nil)
+
+	r.MockCommandReturn("unsquashfs -cat /tmp/bundle/snaps/charmcraft_6197.snap meta/snap.yaml", []byte(`name: charmcraft
+base: core22
+confinement: classic
+plugs:
+  home: null
+  gtk-3-themes:
+    interface: content
+    default-provider: gtk-common-themes:gtk-3-themes
+`), nil)
+	// jq has no base, so it runs on core.
+	r.MockCommandReturn("unsquashfs -cat /tmp/bundle/snaps/jq_6.snap meta/snap.yaml", []byte("name: jq\n"), nil)
+	for name, revision := range map[string]string{"snapd": "24505", "core22": "1981", "gtk-common-themes": "1535", "core": "17212"} {
+		file := "/tmp/bundle/snaps/" + name + "_" + revision
+		r.MockCommandReturn("snap download "+name+" --target-directory /tmp/bundle/snaps", []byte("   snap ack "+file+".assert\n   snap install "+file+".snap\n"), nil)
+		r.MockCommandReturn("unsquashfs -cat "+file+".snap meta/snap.yaml", []byte("name: "+name+"\ntype: base\n"), nil)
+	}
+	r.MockCommandReturn("unsquashfs -cat /tmp/bundle/snaps/snapd_24505.snap meta/snap.yaml", []byte("name: snapd\ntype: snapd\n"), nil)
+	// Content providers are applications too, with prerequisites of their own.
+	r.MockCommandReturn("unsquashfs -cat /tmp/bundle/snaps/gtk-common-themes_1535.snap meta/snap.yaml", []byte("name: gtk-common-themes\nbase: bare\n"), nil)
+	r.MockCommandReturn("snap download bare --target-directory /tmp/bundle/snaps", []byte("   snap ack /tmp/bundle/snaps/bare_5.assert\n   snap install /tmp/bundle/snaps/bare_5.snap\n"), nil)
+	r.MockCommandReturn("unsquashfs -cat /tmp/bundle/snaps/bare_5.snap meta/snap.yaml", []byte("name: bare\ntype: base\n"), nil)
+
+	snaps := []*system.Snap{
+		system.NewSnap("charmcraft", "latest/stable", []string{}),
+		system.NewSnap("jq", "", []string{}),
+		// The same channel in short form is only downloaded once.
+		system.NewSnap("charmcraft", "stable", []string{}),
+		{Name: "foo", Path: "/tmp/foo.snap"},
+	}
+
+	artifacts, err := NewSnapHandler(r, snaps).Download("/tmp/bundle")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []SnapArtifact{
+		{
+			Name: "charmcraft", Channel: "latest/stable", Revision: "6197", Classic: true,
+			File: "snaps/charmcraft_6197.snap", Assertion: "snaps/charmcraft_6197.assert",
+			Prerequisites: []string{"snapd", "core22", "gtk-common-themes"},
+		},
+		{Name: "jq", Revision: "6", File: "snaps/jq_6.snap", Assertion: "snaps/jq_6.assert", Prerequisites: []string{"snapd", "core"}},
+		{Name: "snapd", Revision: "24505", File: "snaps/snapd_24505.snap", Assertion: "snaps/snapd_24505.assert"},
+		{Name: "core22", Revision: "1981", File: "snaps/core22_1981.snap", Assertion: "snaps/core22_1981.assert"},
+		{
+			Name: "gtk-common-themes", Revision: "1535",
+			File: "snaps/gtk-common-themes_1535.snap", Assertion: "snaps/gtk-common-themes_1535.assert",
+			Prerequisites: []string{"snapd", "bare"},
+		},
+		{Name: "core", Revision: "17212", File: "snaps/core_17212.snap", Assertion: "snaps/core_17212.assert"},
+		{Name: "bare", Revision: "5", File: "snaps/bare_5.snap", Assertion: "snaps/bare_5.assert"},
+	}
+
+	if !reflect.DeepEqual(expected, artifacts) {
+		t.Fatalf("expected: %+v, got: %+v", expected, artifacts)
+	}
+}
+
+func TestDebHandlerDownload(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockCommandReturn("apt-cache depends --recurse --no-recommends --no-suggests --no-conflicts --no-breaks --no-replaces --no-enhances cowsay", []byte(`cowsay
+  Depends: perl
+  Depends: libtext-charwidth-perl
+perl
+  Depends: <perlapi-5.38.2>
+libtext-charwidth-perl
+<perlapi-5.38.2>
+libc6:i386
+`), nil)
+	r.MockCommandReturn("apt-get download --print-uris cowsay perl libtext-charwidth-perl", []byte(`'http://archive.ubuntu.com/ubuntu/pool/universe/c/cowsay/cowsay_3.03%2bdfsg2-8_all.deb' cowsay_3.03+dfsg2-8_all.deb 18592 SHA512:abc
+'http://archive.ubuntu.com/ubuntu/pool/main/p/perl/perl_5.38.2-3.2build2_amd64.deb' perl_5.38.2-3.2build2_amd64.deb 231072 SHA512:def
+'http://archive.ubuntu.com/ubuntu/pool/main/libt/libtext-charwidth-perl/libtext-charwidth-perl_0.04-11build3_amd64.deb' libtext-charwidth-perl_0.04-11build3_amd64.deb 9420 SHA512:ghi
+`), nil)
+
+	artifacts, err := NewDebHandler(r, []*Deb{NewDeb("cowsay")}).Download("/tmp/bundle")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []DebArtifact{{
+		Name: "cowsay",
+		File: "debs/cowsay_3.03+dfsg2-8_all.deb",
+		Dependencies: []string{
+			"debs/perl_5.38.2-3.2build2_amd64.deb",
+			"debs/libtext-charwidth-perl_0.04-11build3_amd64.deb",
+		},
+	}}
+
+	if !reflect.DeepEqual(expected, artifacts) {
+		t.Fatalf("expected: %+v, got: %+v", expected, artifacts)
+	}
+
+	expectedCommands := []string{
+		"stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update",
+		"apt-cache depends --recurse --no-recommends --no-suggests --no-conflicts --no-breaks --no-replaces --no-enhances cowsay",
+		"apt-get download --print-uris cowsay perl libtext-charwidth-perl",
+		"apt-get download cowsay perl libtext-charwidth-perl",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, r.ExecutedCommands)
+	}
+}
@@ -0,0 +1,188 @@
+package packages
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// pythonSpecifierChars are the characters that end the name in a requirement
+// specifier, and start its extras, version constraints, markers or URL.
+const pythonSpecifierChars = "<>=!~[;@ "
+
+// NewPythonTool constructs a new PythonTool from a requirement specifier, such as
+// "tox" or "tox>=4.20".
+func NewPythonTool(spec string) *PythonTool {
+	spec = strings.TrimSpace(spec)
+	name := spec
+	if i := strings.IndexAny(spec, pythonSpecifierChars); i >= 0 {
+		name = spec[:i]
+	}
+	return &PythonTool{Name: normalizePythonName(name), Spec: spec}
+}
+
+// PythonTool is a Python command-line tool installed for the user with `uv tool`.
+type PythonTool struct {
+	// Name is the normalised name of the package that provides the tool.
+	Name string
+	// Spec is the requirement specifier given to `uv tool install`, which may
+	// constrain the version, e.g. "pytest-operator==0.38.0".
+	Spec string
+}
+
+// NewPythonToolHandler constructs a new instance of a PythonToolHandler.
+func NewPythonToolHandler(system system.Worker, tools []*PythonTool) *PythonToolHandler {
+	return &PythonToolHandler{
+		Tools:  tools,
+		system: system,
+	}
+}
+
+// PythonToolHandler can install or remove a set of Python tools for the user. The
+// tools are installed with `uv`, which must already be installed.
+type PythonToolHandler struct {
+	Tools []*PythonTool
+	// State, if set, records which tools were already installed, so that restore
+	// only removes the tools that concierge installed.
+	State *config.RuntimeState
+
+	system system.Worker
+}
+
+// Prepare installs the tools as the user. Tools that are already installed are only
+// installed again if a version is specified, in which case uv leaves them alone if
+// the installed version satisfies it.
+func (h *PythonToolHandler) Prepare() error {
+	if len(h.Tools) == 0 {
+		return nil
+	}
+
+	installed := h.installedTools()
+
+	for _, tool := range h.Tools {
+		preexisting := slices.Contains(installed, tool.Name)
+		h.recordTool(tool.Name, preexisting)
+
+		if preexisting && !strings.ContainsAny(tool.Spec, pythonSpecifierChars) {
+			slog.Debug("Python tool already installed", "tool", tool.Name)
+			continue
+		}
+
+		_, err := h.system.Run(h.uvCommand("tool", "install", tool.Spec))
+		if err != nil {
+			return fmt.Errorf("failed to install python tool '%s': %w", tool.Spec, err)
+		}
+
+		slog.Info("Installed python tool", "tool", tool.Spec)
+	}
+
+	return nil
+}
+
+// Restore uninstalls the tools that concierge installed, leaving those that were
+// already installed before concierge first prepared them.
+func (h *PythonToolHandler) Restore() error {
+	if len(h.Tools) == 0 {
+		return nil
+	}
+
+	installed := h.installedTools()
+
+	uninstall := []string{}
+	for _, tool := range h.Tools {
+		if record := h.record(tool.Name); record != nil && record.Preexisting {
+			slog.Info("Keeping pre-existing python tool", "tool", tool.Name)
+			continue
+		}
+		if slices.Contains(installed, tool.Name) && !slices.Contains(uninstall, tool.Name) {
+			uninstall = append(uninstall, tool.Name)
+		}
+	}
+
+	if len(uninstall) > 0 {
+		_, err := h.system.Run(h.uvCommand(append([]string{"tool", "uninstall"}, uninstall...)...))
+		if err != nil {
+			return fmt.Errorf("failed to uninstall python tools: %w", err)
+		}
+		slog.Info("Removed python tools", "tools", uninstall)
+	}
+
+	for _, tool := range h.Tools {
+		h.forgetTool(tool.Name)
+	}
+	return nil
+}
+
+// installedTools lists the names of the tools that the user has installed with uv.
+// If uv cannot be run, for example because it is not installed, none are listed.
+func (h *PythonToolHandler) installedTools() []string {
+	cmd := h.uvCommand("tool", "list")
+	cmd.ReadOnly = true
+	output, err := h.system.Run(cmd)
+	if err != nil {
+		return []string{}
+	}
+
+	// Each tool is listed as "<name> v<version>", followed by its executables as
+	// "- <executable>".
+	installed := []string{}
+	for line := range strings.SplitSeq(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.HasPrefix(fields[1], "v") {
+			installed = append(installed, normalizePythonName(fields[0]))
+		}
+	}
+	return installed
+}
+
+// uvCommand constructs a uv command that runs as the user, so that the tools are
+// installed into the user's environment.
+func (h *PythonToolHandler) uvCommand(args ...string) *system.Command {
+	return system.NewCommandAs(h.system.User().Username, "", "uv", args)
+}
+
+// record returns the state recorded for the named tool, or nil if there is none.
+func (h *PythonToolHandler) record(name string) *config.PythonToolRecord {
+	if h.State == nil {
+		return nil
+	}
+
+	i := slices.IndexFunc(h.State.PythonTools, func(r config.PythonToolRecord) bool { return r.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &h.State.PythonTools[i]
+}
+
+// recordTool records whether the named tool was already installed with uv. The first
+// record wins, since `uv tool list` also shows the tools that an earlier, possibly
+// failed, `prepare` installed.
+func (h *PythonToolHandler) recordTool(name string, installed bool) {
+	if h.State == nil || h.record(name) != nil {
+		return
+	}
+	h.State.PythonTools = append(h.State.PythonTools, config.PythonToolRecord{Name: name, Preexisting: installed})
+}
+
+// forgetTool removes the state recorded for the named tool once it is restored.
+func (h *PythonToolHandler) forgetTool(name string) {
+	if h.State == nil {
+		return
+	}
+	h.State.PythonTools = slices.DeleteFunc(h.State.PythonTools, func(r config.PythonToolRecord) bool { return r.Name == name })
+}
+
+// normalizePythonName normalises a Python package name as uv lists it, since names
+// are compared case-insensitively, treating runs of "-", "_" and "." as equal.
+func normalizePythonName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer("_", "-", ".", "-").Replace(name)
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	return name
+}
@@ -0,0 +1,83 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestNewPythonTool(t *testing.T) {
+	tests := map[string]PythonTool{
+		"tox":                      {Name: "tox", Spec: "tox"},
+		"tox>=4.20":                {Name: "tox", Spec: "tox>=4.20"},
+		"pytest_operator==0.38.0":  {Name: "pytest-operator", Spec: "pytest_operator==0.38.0"},
+		"Jubilant[dev] ~= 1.0":     {Name: "jubilant", Spec: "Jubilant[dev] ~= 1.0"},
+		" charmcraft.lint-plugins": {Name: "charmcraft-lint-plugins", Spec: "charmcraft.lint-plugins"},
+	}
+
+	for spec, expected := range tests {
+		tool := NewPythonTool(spec)
+		if !reflect.DeepEqual(expected, *tool) {
+			t.Fatalf("expected: %+v, got: %+v", expected, *tool)
+		}
+	}
+}
+
+func TestPythonToolHandlerPrepare(t *testing.T) {
+	state := &config.RuntimeState{}
+
+	r := system.NewMockSystem()
+	r.MockCommandReturn("sudo -u test-user uv tool list", []byte("tox v4.23.2\n- tox\njubilant v1.0.1\n- jubilant\n"), nil)
+
+	handler := NewPythonToolHandler(r, []*PythonTool{NewPythonTool("tox"), NewPythonTool("jubilant>=1.1"), NewPythonTool("pytest-operator")})
+	handler.State = state
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := []string{
+		"sudo -u test-user uv tool list",
+		"sudo -u test-user uv tool install 'jubilant>=1.1'",
+		"sudo -u test-user uv tool install pytest-operator",
+	}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+
+	expectedState := []config.PythonToolRecord{
+		{Name: "tox", Preexisting: true},
+		{Name: "jubilant", Preexisting: true},
+		{Name: "pytest-operator"},
+	}
+	if !reflect.DeepEqual(expectedState, state.PythonTools) {
+		t.Fatalf("expected: %v, got: %v", expectedState, state.PythonTools)
+	}
+
+	// Restore only removes the tool that concierge installed.
+	r = system.NewMockSystem()
+	r.MockCommandReturn("sudo -u test-user uv tool list", []byte("tox v4.23.2\n- tox\npytest-operator v0.38.0\n"), nil)
+
+	handler = NewPythonToolHandler(r, handler.Tools)
+	handler.State = state
+
+	err = handler.Restore()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected = []string{
+		"sudo -u test-user uv tool list",
+		"sudo -u test-user uv tool uninstall pytest-operator",
+	}
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+
+	if len(state.PythonTools) != 0 {
+		t.Fatalf("expected the python tool records to be removed, got: %v", state.PythonTools)
+	}
+}
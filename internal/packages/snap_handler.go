@@ -3,11 +3,25 @@ package packages
 import (
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/system"
 )
 
+// stateMutex guards the snap records shared by the snap handlers of the plan, the
+// providers and Juju, since the providers are prepared concurrently. It is only held
+// while the records are read or written, never while snapd is at work.
+var stateMutex sync.Mutex
+
+// prerequisitesMutex keeps snap handlers that run concurrently from installing the
+// same prerequisite, such as a shared base, from its artifact at the same time.
+var prerequisitesMutex sync.Mutex
+
 // NewSnapHandler constructs a new instance of a SnapHandler.
 func NewSnapHandler(system system.Worker, snaps []*system.Snap) *SnapHandler {
 	return &SnapHandler{
@@ -18,19 +32,86 @@ func NewSnapHandler(system system.Worker, snaps []*system.Snap) *SnapHandler {
 
 // SnapHandler can install or remove a set of snaps.
 type SnapHandler struct {
-	Snaps  []*system.Snap
+	Snaps []*system.Snap
+	// State, if set, records which snaps were already installed and the connections
+	// made for them, so that Restore leaves pre-existing snaps installed and only
+	// disconnects what the handler connected.
+	State *config.RuntimeState
+	// DeferConnections skips making connections in Prepare, leaving the caller
+	// to call Connect once any other snaps they depend on are installed.
+	DeferConnections bool
+	// Artifacts, if set, is a directory of snap files described by a manifest. Snaps
+	// found in it are installed from their files, and the rest from the store.
+	Artifacts string
+
 	system system.Worker
+	// saved tracks the snaps snapshotted during this run, since a dry run
+	// yields no snapshot set ID to record.
+	saved map[string]bool
 }
 
-// Prepare installs a set of snaps on the machine.
+// Prepare installs a set of snaps on the machine, in order, then makes their connections
+// unless DeferConnections is set. Snaps that can be installed without any options are
+// installed together in a single snapd change.
 func (h *SnapHandler) Prepare() error {
+	manifest, err := loadManifest(h.system, h.Artifacts)
+	if err != nil {
+		return err
+	}
+
+	infos := make(map[string]*system.SnapInfo, len(h.Snaps))
+	artifacts := map[string]*SnapArtifact{}
+	batch := []string{}
+
 	for _, snap := range h.Snaps {
-		err := h.installSnap(snap)
+		snapInfo, artifact, err := h.snapInfo(snap, manifest)
 		if err != nil {
-			return fmt.Errorf("failed to install snap: %w", err)
+			return fmt.Errorf("failed to lookup snap details: %w", err)
+		}
+		infos[snap.Name] = snapInfo
+		if artifact != nil {
+			artifacts[snap.Name] = artifact
 		}
+		h.recordSnap(snap.Name, snapInfo.Installed)
 
-		err = h.connectSnap(snap)
+		// Snaps that must follow others are installed individually, in order.
+		if !snapInfo.Installed && canBatchInstall(snap) && !snapInfo.Classic && artifact == nil {
+			batch = append(batch, snap.Name)
+		}
+	}
+
+	if len(batch) > 1 {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapInstall, Snaps: batch})
+		if err != nil {
+			return fmt.Errorf("failed to install snaps: %w", err)
+		}
+		slog.Info("Installed snaps", "snaps", batch)
+	}
+
+	for _, snap := range h.Snaps {
+		if len(batch) <= 1 || !slices.Contains(batch, snap.Name) {
+			err := h.installSnap(snap, infos[snap.Name], artifacts[snap.Name], manifest)
+			if err != nil {
+				return fmt.Errorf("failed to install snap: %w", err)
+			}
+		}
+
+		err := h.configureSnap(snap, infos[snap.Name])
+		if err != nil {
+			return fmt.Errorf("failed to configure snap '%s': %w", snap.Name, err)
+		}
+	}
+
+	if h.DeferConnections {
+		return nil
+	}
+	return h.Connect()
+}
+
+// Connect makes the configured connections for each snap, in order.
+func (h *SnapHandler) Connect() error {
+	for _, snap := range h.Snaps {
+		err := h.connectSnap(snap)
 		if err != nil {
 			return fmt.Errorf("failed to create snap connections: %w", err)
 		}
@@ -41,91 +122,608 @@ func (h *SnapHandler) Prepare() error {
 // Restore removes a set of snaps from the machine.
 func (h *SnapHandler) Restore() error {
 	for _, snap := range h.Snaps {
-		err := h.removeSnap(snap)
-		if err != nil {
-			return fmt.Errorf("failed to remove snap: %w", err)
+		if record := h.record(snap.Name); record != nil && record.Preexisting {
+			err := h.unconfigureSnap(record)
+			if err != nil {
+				return fmt.Errorf("failed to restore snap '%s': %w", snap.Name, err)
+			}
+
+			err = h.revertSnap(record)
+			if err != nil {
+				return fmt.Errorf("failed to restore snap '%s': %w", snap.Name, err)
+			}
+
+			err = h.disconnectSnap(record)
+			if err != nil {
+				return fmt.Errorf("failed to remove snap connections: %w", err)
+			}
+		} else {
+			err := h.removeSnap(snap)
+			if err != nil {
+				return fmt.Errorf("failed to remove snap: %w", err)
+			}
 		}
+		h.forgetSnap(snap.Name)
 	}
 	return nil
 }
 
-// installSnap ensures that the specified snap is installed at the specified channel.
-// If already installed, but on the wrong channel, the snap is refreshed.
-func (h *SnapHandler) installSnap(s *system.Snap) error {
-	slog.Debug("Installing snap", "snap", s.Name)
-	var action, logAction string
+// snapInfo returns the details of a snap and the artifact to install it from, if
+// the manifest has one. Snaps with an artifact or a local file are not looked up in
+// the store, which need not have them: the artifact stands in for the store's
+// details, and the confinement of a local file is read from the file itself.
+func (h *SnapHandler) snapInfo(s *system.Snap, manifest *Manifest) (*system.SnapInfo, *SnapArtifact, error) {
+	artifact := manifest.Snap(s)
+	if artifact == nil && s.Path == "" {
+		snapInfo, err := h.system.SnapInfo(s.Name, s.Channel)
+		return snapInfo, nil, err
+	}
 
-	snapInfo, err := h.system.SnapInfo(s.Name, s.Channel)
+	snapInfo, err := h.system.LocalSnapInfo(s.Name)
 	if err != nil {
-		return fmt.Errorf("failed to lookup snap details: %w", err)
+		return nil, nil, err
 	}
 
-	if snapInfo.Installed {
+	if artifact == nil {
+		meta, err := h.readSnapMetadata(s.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		snapInfo.Classic = meta.Confinement == "classic"
+		return snapInfo, nil, nil
+	}
+	snapInfo.Classic = artifact.Classic
+	snapInfo.StoreRevision = artifact.Revision
+
+	slog.Debug("Found snap in artifacts", "snap", s.Name, "file", artifact.File)
+	return snapInfo, artifact, nil
+}
+
+// installSnap ensures that the specified snap is installed at the specified channel.
+// If already installed, but on the wrong channel, the snap is refreshed. A snap with
+// an artifact is installed from its file, once its assertions are acknowledged.
+func (h *SnapHandler) installSnap(s *system.Snap, snapInfo *system.SnapInfo, artifact *SnapArtifact, manifest *Manifest) error {
+	slog.Debug("Installing snap", "snap", s.Name)
+	var action, logAction string
+
+	if snapInfo.Installed && (s.Path == "" || artifact != nil) {
 		// A disabled snap must be enabled before it can be refreshed.
 		if !snapInfo.Active {
-			enableCmd := system.NewCommand("snap", []string{"enable", s.Name})
-			if _, err := system.RunExclusive(h.system, enableCmd); err != nil {
+			err := h.system.SnapChange(&system.SnapOp{Action: system.SnapEnable, Snaps: []string{s.Name}})
+			if err != nil {
 				return fmt.Errorf("failed to enable snap %q: %w", s.Name, err)
 			}
 			slog.Info("Enabled disabled snap", "snap", s.Name)
 		}
-		action = "refresh"
+
+		refresh, reason := shouldRefresh(s, snapInfo)
+		if !refresh {
+			slog.Info(fmt.Sprintf("Skipping refresh of %s: %s", s.Name, reason))
+			return nil
+		}
+		slog.Debug(fmt.Sprintf("Refreshing %s: %s", s.Name, reason))
+
+		action = system.SnapRefresh
 		logAction = "Refreshed"
 	} else {
-		action = "install"
+		action = system.SnapInstall
 		logAction = "Installed"
 	}
 
-	args := []string{action, s.Name}
-
-	if s.Channel != "" {
-		args = append(args, "--channel", s.Channel)
+	// Save the data of a pre-existing snap before it is replaced.
+	if snapInfo.Installed {
+		err := h.snapshotSnap(s.Name, snapInfo)
+		if err != nil {
+			return err
+		}
 	}
 
-	if s.Revision != "" {
-		args = append(args, "--revision", s.Revision)
+	op := &system.SnapOp{
+		Action:    action,
+		Snaps:     []string{s.Name},
+		Channel:   s.Channel,
+		Revision:  s.Revision,
+		Classic:   snapInfo.Classic,
+		Devmode:   s.Devmode,
+		Path:      s.Path,
+		Dangerous: s.Dangerous,
 	}
 
-	if snapInfo.Classic {
-		args = append(args, "--classic")
+	if artifact != nil {
+		err := h.installPrerequisites(artifact, manifest)
+		if err != nil {
+			return err
+		}
+
+		err = h.ackArtifact(artifact)
+		if err != nil {
+			return err
+		}
+
+		// Installing a file replaces any installed revision, so is never a refresh.
+		op.Action = system.SnapInstall
+		op.Channel = ""
+		op.Revision = ""
+		op.Path = artifact.File
+		op.Dangerous = artifact.Assertion == ""
 	}
 
-	cmd := system.NewCommand("snap", args)
-	_, err = system.RunExclusive(h.system, cmd)
+	err := h.system.SnapChange(op)
 	if err != nil {
-		return fmt.Errorf("command failed: %w", err)
+		return fmt.Errorf("failed to %s snap '%s': %w", action, s.Name, err)
 	}
 
 	slog.Info(fmt.Sprintf("%s snap", logAction), "snap", s.Name)
 	return nil
 }
 
-// connectSnap ensures that the specified snap interfaces are connected.
+// ackArtifact adds the assertions of a snap artifact to the system, so that its snap
+// file can be installed without `--dangerous`.
+func (h *SnapHandler) ackArtifact(artifact *SnapArtifact) error {
+	if artifact.Assertion == "" {
+		slog.Warn("Installing snap artifact without assertions", "snap", artifact.Name, "file", artifact.File)
+		return nil
+	}
+
+	err := h.system.SnapChange(&system.SnapOp{Action: system.SnapAck, Path: artifact.Assertion})
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge assertions of snap '%s': %w", artifact.Name, err)
+	}
+	return nil
+}
+
+// installPrerequisites installs the snaps that a snap artifact needs, such as snapd
+// and its base, from their own artifacts, unless they are already installed. snapd
+// would otherwise try to fetch them from the store. Prerequisites are left installed
+// on restore, as snapd leaves bases installed when the snaps using them are removed.
+func (h *SnapHandler) installPrerequisites(artifact *SnapArtifact, manifest *Manifest) error {
+	if len(artifact.Prerequisites) == 0 {
+		return nil
+	}
+
+	prerequisitesMutex.Lock()
+	defer prerequisitesMutex.Unlock()
+
+	return h.installPrerequisitesLocked(artifact, manifest)
+}
+
+// installPrerequisitesLocked installs the prerequisites of a snap artifact, and theirs
+// before them, with prerequisitesMutex held.
+func (h *SnapHandler) installPrerequisitesLocked(artifact *SnapArtifact, manifest *Manifest) error {
+	for _, name := range artifact.Prerequisites {
+		snapInfo, err := h.system.LocalSnapInfo(name)
+		if err != nil {
+			return fmt.Errorf("failed to lookup snap details: %w", err)
+		}
+		if snapInfo.Installed {
+			continue
+		}
+
+		prerequisite := manifest.Snap(&system.Snap{Name: name})
+		if prerequisite == nil {
+			return fmt.Errorf("snap '%s' needs snap '%s', which is not in the artifacts", artifact.Name, name)
+		}
+
+		err = h.installPrerequisitesLocked(prerequisite, manifest)
+		if err != nil {
+			return err
+		}
+
+		err = h.ackArtifact(prerequisite)
+		if err != nil {
+			return err
+		}
+
+		err = h.system.SnapChange(&system.SnapOp{
+			Action:    system.SnapInstall,
+			Snaps:     []string{name},
+			Path:      prerequisite.File,
+			Dangerous: prerequisite.Assertion == "",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to install snap '%s' needed by '%s': %w", name, artifact.Name, err)
+		}
+		slog.Info("Installed snap prerequisite", "snap", name, "for", artifact.Name)
+	}
+
+	return nil
+}
+
+// shouldRefresh decides, according to the snap's refresh policy, whether an installed
+// snap should be refreshed, returning the reason for the decision.
+func shouldRefresh(s *system.Snap, snapInfo *system.SnapInfo) (bool, string) {
+	tracking := snapInfo.TrackingChannel
+	requested := s.Channel
+	if requested == "" {
+		requested = tracking
+	}
+
+	switch s.RefreshPolicy {
+	case config.RefreshNever:
+		return false, "refresh policy is never"
+
+	case config.RefreshIfChannelDiffers:
+		if system.NormalizeChannel(requested) != system.NormalizeChannel(tracking) {
+			return true, fmt.Sprintf("tracking %s, requested %s", tracking, requested)
+		}
+		if s.Revision != "" && s.Revision != snapInfo.Revision {
+			return true, fmt.Sprintf("at revision %s, requested %s", snapInfo.Revision, s.Revision)
+		}
+		return false, fmt.Sprintf("tracking %s, requested %s", tracking, requested)
+
+	case config.RefreshIfOlder:
+		target := s.Revision
+		if target == "" {
+			target = snapInfo.StoreRevision
+		}
+
+		installed, errInstalled := strconv.Atoi(snapInfo.Revision)
+		available, errAvailable := strconv.Atoi(target)
+		if errInstalled != nil || errAvailable != nil {
+			return false, fmt.Sprintf("cannot compare installed revision %q with %q", snapInfo.Revision, target)
+		}
+		if installed < available {
+			return true, fmt.Sprintf("revision %d is older than %d", installed, available)
+		}
+		return false, fmt.Sprintf("revision %d is not older than %d", installed, available)
+
+	default:
+		return true, "refresh policy is always"
+	}
+}
+
+// canBatchInstall reports whether a snap can be installed alongside others in a
+// single change, which snapd only supports for snaps without install options.
+// Snaps that must follow others are installed individually, in order.
+func canBatchInstall(s *system.Snap) bool {
+	return s.Channel == "" && s.Revision == "" && !s.Devmode && s.Path == "" && len(s.After) == 0
+}
+
+// configureSnap applies the snap's configuration options, aliases and refresh hold,
+// recording what is needed to undo them for a pre-existing snap.
+func (h *SnapHandler) configureSnap(s *system.Snap, snapInfo *system.SnapInfo) error {
+	record := h.record(s.Name)
+
+	if len(s.Config) > 0 {
+		if record != nil && record.Preexisting {
+			err := h.snapshotSnap(s.Name, snapInfo)
+			if err != nil {
+				return err
+			}
+
+			err = h.recordSnapConfig(record, s.Config)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapSet, Snaps: []string{s.Name}, Config: s.Config})
+		if err != nil {
+			return fmt.Errorf("failed to set snap configuration: %w", err)
+		}
+		slog.Info("Configured snap", "snap", s.Name)
+	}
+
+	for _, alias := range slices.Sorted(maps.Keys(s.Aliases)) {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapAlias, Snaps: []string{s.Name}, App: s.Aliases[alias], Alias: alias})
+		if err != nil {
+			return fmt.Errorf("failed to create alias '%s': %w", alias, err)
+		}
+		h.updateRecord(s.Name, func(r *config.SnapRecord) {
+			if !slices.Contains(r.Aliases, alias) {
+				r.Aliases = append(r.Aliases, alias)
+			}
+		})
+		slog.Info("Created snap alias", "snap", s.Name, "app", s.Aliases[alias], "alias", alias)
+	}
+
+	if s.Hold {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapHold, Snaps: []string{s.Name}})
+		if err != nil {
+			return fmt.Errorf("failed to hold snap refreshes: %w", err)
+		}
+		// A hold that was already in place, whether the user's or one from a previous
+		// run, is left for whoever placed it to release.
+		if !snapInfo.Held {
+			h.updateRecord(s.Name, func(r *config.SnapRecord) { r.Held = true })
+		}
+		slog.Info("Held snap refreshes", "snap", s.Name)
+	}
+
+	return nil
+}
+
+// snapshotSnap saves a snapshot of a pre-existing snap's data with `snap save`, before
+// concierge first refreshes or reconfigures it, recording the snapshot set along with
+// the revision and channel to return the snap to on restore.
+func (h *SnapHandler) snapshotSnap(name string, snapInfo *system.SnapInfo) error {
+	record := h.record(name)
+	if record == nil || !record.Preexisting || record.Snapshot != "" || h.saved[name] {
+		return nil
+	}
+
+	op := &system.SnapOp{Action: system.SnapSave, Snaps: []string{name}}
+	err := h.system.SnapChange(op)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot of snap '%s': %w", name, err)
+	}
+
+	if h.saved == nil {
+		h.saved = map[string]bool{}
+	}
+	h.saved[name] = true
+
+	h.updateRecord(name, func(r *config.SnapRecord) {
+		r.Snapshot = op.SetID
+		r.Revision = snapInfo.Revision
+		r.Channel = snapInfo.TrackingChannel
+	})
+
+	slog.Info("Saved snapshot of snap", "snap", name, "set", op.SetID)
+	return nil
+}
+
+// revertSnap returns a pre-existing snap to the revision it had when its snapshot
+// was saved, restores the snapshot and then forgets it.
+func (h *SnapHandler) revertSnap(record *config.SnapRecord) error {
+	if record.Snapshot == "" {
+		return nil
+	}
+
+	if _, err := strconv.Atoi(record.Revision); err == nil {
+		err := h.system.SnapChange(&system.SnapOp{
+			Action:   system.SnapRefresh,
+			Snaps:    []string{record.Name},
+			Channel:  record.Channel,
+			Revision: record.Revision,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reinstall revision %s: %w", record.Revision, err)
+		}
+	} else {
+		// Local revisions (e.g. "x1") cannot be fetched from the store again.
+		slog.Warn("Cannot reinstall original revision of snap", "snap", record.Name, "revision", record.Revision)
+	}
+
+	err := h.system.SnapChange(&system.SnapOp{Action: system.SnapRestore, Snaps: []string{record.Name}, SetID: record.Snapshot})
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot %s: %w", record.Snapshot, err)
+	}
+
+	err = h.system.SnapChange(&system.SnapOp{Action: system.SnapForget, SetID: record.Snapshot})
+	if err != nil {
+		return fmt.Errorf("failed to forget snapshot %s: %w", record.Snapshot, err)
+	}
+
+	slog.Info("Restored snap from snapshot", "snap", record.Name, "revision", record.Revision, "set", record.Snapshot)
+	return nil
+}
+
+// recordSnapConfig records the current value of each configuration option that is
+// about to be set, unless it was recorded by a previous run.
+func (h *SnapHandler) recordSnapConfig(record *config.SnapRecord, conf map[string]string) error {
+	previous := map[string]string{}
+	for _, key := range slices.Sorted(maps.Keys(conf)) {
+		if _, ok := record.Config[key]; ok {
+			continue
+		}
+
+		cmd := system.NewCommand("snap", []string{"get", record.Name, key})
+		cmd.ReadOnly = true
+		cmd.ExpectedError = `has no .* configuration option`
+
+		output, err := h.system.Run(cmd)
+		if err != nil && !cmd.IsExpectedError(output) {
+			return fmt.Errorf("failed to read snap configuration option '%s': %w", key, err)
+		}
+
+		value := ""
+		if err == nil {
+			value = strings.TrimSpace(string(output))
+		}
+		previous[key] = value
+	}
+
+	h.updateRecord(record.Name, func(r *config.SnapRecord) {
+		if r.Config == nil {
+			r.Config = map[string]string{}
+		}
+		for key, value := range previous {
+			if _, ok := r.Config[key]; !ok {
+				r.Config[key] = value
+			}
+		}
+	})
+	return nil
+}
+
+// unconfigureSnap undoes the refresh hold, aliases and configuration options that
+// were applied to a pre-existing snap.
+func (h *SnapHandler) unconfigureSnap(record *config.SnapRecord) error {
+	if record.Held {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapUnhold, Snaps: []string{record.Name}})
+		if err != nil {
+			return fmt.Errorf("failed to unhold snap refreshes: %w", err)
+		}
+	}
+
+	for _, alias := range record.Aliases {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapUnalias, Alias: alias})
+		if err != nil {
+			return fmt.Errorf("failed to remove alias '%s': %w", alias, err)
+		}
+	}
+
+	previous := map[string]string{}
+	unset := []string{}
+	for _, key := range slices.Sorted(maps.Keys(record.Config)) {
+		if record.Config[key] == "" {
+			unset = append(unset, key)
+		} else {
+			previous[key] = record.Config[key]
+		}
+	}
+
+	if len(previous) > 0 {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapSet, Snaps: []string{record.Name}, Config: previous})
+		if err != nil {
+			return fmt.Errorf("failed to restore snap configuration: %w", err)
+		}
+	}
+
+	if len(unset) > 0 {
+		err := h.system.SnapChange(&system.SnapOp{Action: system.SnapUnset, Snaps: []string{record.Name}, Keys: unset})
+		if err != nil {
+			return fmt.Errorf("failed to restore snap configuration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// connectSnap ensures that the specified snap interfaces are connected. Connections
+// that snapd reports as already established are skipped.
 func (h *SnapHandler) connectSnap(s *system.Snap) error {
 	for _, connection := range s.Connections {
-		parts := strings.Split(connection, " ")
-		if len(parts) > 2 {
-			return fmt.Errorf("too many arguments in snap connection string '%s'", connection)
+		conn, err := system.ParseSnapConnection(connection)
+		if err != nil {
+			return err
 		}
 
-		args := append([]string{"connect"}, parts...)
+		connected, err := h.isConnected(conn)
+		if err != nil {
+			return fmt.Errorf("cannot connect '%s': %w", connection, err)
+		}
+		if connected {
+			slog.Debug("Snap connection already established", "snap", s.Name, "connection", conn.String())
+			continue
+		}
 
-		cmd := system.NewCommand("snap", args)
-		_, err := system.RunExclusive(h.system, cmd)
+		err = h.system.SnapChange(conn.Op(system.SnapConnect))
 		if err != nil {
-			return fmt.Errorf("command failed: %w", err)
+			return fmt.Errorf("failed to connect '%s': %w", connection, err)
 		}
+
+		h.recordConnection(s.Name, conn.String())
+		slog.Info("Connected snap interface", "snap", s.Name, "connection", conn.String())
 	}
 	return nil
 }
 
-// removeSnap uninstalls the specified snap from the system, optionally purging its data.
+// isConnected reports whether snapd already has a connection that satisfies conn.
+// An error is returned if the snaps on either side of it are not installed.
+func (h *SnapHandler) isConnected(conn *system.SnapConnection) (bool, error) {
+	established, err := h.system.SnapConnections(conn.PlugSnap)
+	if err != nil {
+		return false, err
+	}
+
+	if conn.SlotSnap != "" && conn.SlotSnap != conn.PlugSnap {
+		if _, err := h.system.SnapConnections(conn.SlotSnap); err != nil {
+			return false, err
+		}
+	}
+
+	return slices.ContainsFunc(established, conn.Satisfies), nil
+}
+
+// disconnectSnap removes the connections recorded as made for a pre-existing snap.
+func (h *SnapHandler) disconnectSnap(record *config.SnapRecord) error {
+	for _, connection := range record.Connections {
+		conn, err := system.ParseSnapConnection(connection)
+		if err != nil {
+			return err
+		}
+
+		err = h.system.SnapChange(conn.Op(system.SnapDisconnect))
+		if err != nil {
+			return fmt.Errorf("failed to disconnect '%s': %w", connection, err)
+		}
+
+		slog.Info("Disconnected snap interface", "snap", record.Name, "connection", connection)
+	}
+	return nil
+}
+
+// record returns a copy of the state recorded for the named snap, or nil if there is
+// none. Changes to the record are made with updateRecord.
+func (h *SnapHandler) record(name string) *config.SnapRecord {
+	if h.State == nil {
+		return nil
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	i := slices.IndexFunc(h.State.Snaps, func(r config.SnapRecord) bool { return r.Name == name })
+	if i < 0 {
+		return nil
+	}
+
+	record := h.State.Snaps[i]
+	record.Connections = slices.Clone(record.Connections)
+	record.Aliases = slices.Clone(record.Aliases)
+	record.Config = maps.Clone(record.Config)
+	return &record
+}
+
+// updateRecord applies update to the state recorded for the named snap, if there is
+// any.
+func (h *SnapHandler) updateRecord(name string, update func(r *config.SnapRecord)) {
+	if h.State == nil {
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	i := slices.IndexFunc(h.State.Snaps, func(r config.SnapRecord) bool { return r.Name == name })
+	if i >= 0 {
+		update(&h.State.Snaps[i])
+	}
+}
+
+// recordSnap records that the named snap is being prepared. A snap recorded by a
+// previous run keeps its original record, since by now concierge may have installed it.
+func (h *SnapHandler) recordSnap(name string, installed bool) {
+	if h.State == nil {
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if !slices.ContainsFunc(h.State.Snaps, func(r config.SnapRecord) bool { return r.Name == name }) {
+		h.State.Snaps = append(h.State.Snaps, config.SnapRecord{Name: name, Preexisting: installed})
+	}
+}
+
+// recordConnection records a connection made for the named snap.
+func (h *SnapHandler) recordConnection(name, connection string) {
+	h.updateRecord(name, func(r *config.SnapRecord) {
+		if !slices.Contains(r.Connections, connection) {
+			r.Connections = append(r.Connections, connection)
+		}
+	})
+}
+
+// forgetSnap removes the state recorded for the named snap once it is restored.
+func (h *SnapHandler) forgetSnap(name string) {
+	if h.State == nil {
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	h.State.Snaps = slices.DeleteFunc(h.State.Snaps, func(r config.SnapRecord) bool { return r.Name == name })
+}
+
+// removeSnap uninstalls the specified snap from the system, purging its data.
 func (h *SnapHandler) removeSnap(s *system.Snap) error {
 	slog.Debug("Removing snap", "snap", s.Name)
-	args := []string{"remove", s.Name, "--purge"}
 
-	cmd := system.NewCommand("snap", args)
-	_, err := system.RunExclusive(h.system, cmd)
+	err := h.system.SnapChange(&system.SnapOp{Action: system.SnapRemove, Snaps: []string{s.Name}, Purge: true})
 	if err != nil {
 		return fmt.Errorf("failed to remove snap '%s': %w", s.Name, err)
 	}
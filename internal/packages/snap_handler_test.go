@@ -1,10 +1,15 @@
 package packages
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/system"
+	"golang.org/x/sync/errgroup"
 )
 
 func TestSnapHandlerCommands(t *testing.T) {
@@ -84,3 +89,501 @@ func TestSnapHandlerWithRevision(t *testing.T) {
 		}
 	}
 }
+
+func TestSnapHandlerBatchesPlainInstalls(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("jq", "", false, false)
+	r.MockSnapStoreLookup("yq", "", false, false)
+	r.MockSnapStoreLookup("charmcraft", "latest/stable", true, false)
+
+	snaps := []*system.Snap{
+		system.NewSnap("jq", "", []string{}),
+		system.NewSnap("charmcraft", "latest/stable", []string{}),
+		system.NewSnap("yq", "", []string{"yq:home"}),
+	}
+
+	err := NewSnapHandler(r, snaps).Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"snap install jq yq",
+		"snap install charmcraft --channel latest/stable --classic",
+		"snap connect yq:home",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerSkipsEstablishedConnections(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("jhack", "latest/edge", false, true)
+	r.MockSnapConnections("jhack", []string{"jhack:dot-local-share-juju snapd:dot-local-share-juju"}, nil)
+
+	snaps := []*system.Snap{
+		system.NewSnap("jhack", "latest/edge", []string{"jhack:dot-local-share-juju", "jhack:ssh-read :ssh-keys"}),
+	}
+
+	err := NewSnapHandler(r, snaps).Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"snap refresh jhack --channel latest/edge",
+		"snap connect jhack:ssh-read :ssh-keys",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerConnectionToMissingSnap(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapConnections("charm", nil, nil)
+	r.MockSnapConnections("lxd", nil, system.ErrSnapNotInstalled)
+
+	snaps := []*system.Snap{system.NewSnap("charm", "", []string{"charm:lxd lxd"})}
+
+	err := NewSnapHandler(r, snaps).Prepare()
+	if err == nil || !strings.Contains(err.Error(), "snap not installed") {
+		t.Fatalf("expected snap not installed error, got: %v", err)
+	}
+}
+
+func TestSnapHandlerRecordsState(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("jhack", "latest/edge", false, true)
+	r.MockSnapRevisions("jhack", "512", "520")
+	r.MockCommandReturn("snap save jhack", []byte("3"), nil)
+
+	state := &config.RuntimeState{}
+	handler := NewSnapHandler(r, []*system.Snap{
+		system.NewSnap("jhack", "latest/edge", []string{"jhack:dot-local-share-juju"}),
+		system.NewSnap("jq", "", []string{}),
+	})
+	handler.State = state
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []config.SnapRecord{
+		{Name: "jhack", Preexisting: true, Connections: []string{"jhack:dot-local-share-juju"}, Snapshot: "3", Revision: "512", Channel: "latest/edge"},
+		{Name: "jq"},
+	}
+
+	if !reflect.DeepEqual(expected, state.Snaps) {
+		t.Fatalf("expected: %v, got: %v", expected, state.Snaps)
+	}
+}
+
+func TestSnapHandlerRestorePreexisting(t *testing.T) {
+	r := system.NewMockSystem()
+
+	state := &config.RuntimeState{Snaps: []config.SnapRecord{
+		{Name: "jhack", Preexisting: true, Connections: []string{"jhack:dot-local-share-juju"}},
+		{Name: "jq"},
+	}}
+	handler := NewSnapHandler(r, []*system.Snap{
+		system.NewSnap("jhack", "latest/edge", []string{"jhack:dot-local-share-juju"}),
+		system.NewSnap("jq", "", []string{}),
+	})
+	handler.State = state
+
+	err := handler.Restore()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"snap disconnect jhack:dot-local-share-juju",
+		"snap remove jq --purge",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+	if len(state.Snaps) != 0 {
+		t.Fatalf("expected snap records to be cleared, got: %v", state.Snaps)
+	}
+}
+
+func TestSnapHandlerDeferConnections(t *testing.T) {
+	r := system.NewMockSystem()
+
+	handler := NewSnapHandler(r, []*system.Snap{
+		system.NewSnap("jhack", "latest/edge", []string{"jhack:dot-local-share-juju"}),
+		{Name: "jq", After: []string{"jhack"}},
+		system.NewSnap("yq", "", []string{}),
+	})
+	handler.DeferConnections = true
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"snap install jhack --channel latest/edge",
+		"snap install jq",
+		"snap install yq",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+
+	err = handler.Connect()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected = append(expected, "snap connect jhack:dot-local-share-juju")
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerConfiguresSnaps(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("lxd", "5.21/stable", false, true)
+	r.MockSnapRevisions("lxd", "33000", "33110")
+	r.MockCommandReturn("snap save lxd", []byte("7"), nil)
+	r.MockCommandReturn("snap get lxd ui.enable", []byte("false\n"), nil)
+	r.MockCommandReturn("snap get lxd daemon.debug", []byte(`error: snap "lxd" has no "daemon.debug" configuration option`), fmt.Errorf("exit status 1"))
+
+	lxd := system.NewSnap("lxd", "5.21/stable", []string{})
+	lxd.Revision = "33110"
+	lxd.Hold = true
+	lxd.Config = map[string]string{"ui.enable": "true", "daemon.debug": "true"}
+	lxd.Aliases = map[string]string{"lc": "lxc"}
+
+	state := &config.RuntimeState{}
+	handler := NewSnapHandler(r, []*system.Snap{lxd})
+	handler.State = state
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"snap save lxd",
+		"snap refresh lxd --channel 5.21/stable --revision 33110",
+		"snap get lxd daemon.debug",
+		"snap get lxd ui.enable",
+		"snap set lxd daemon.debug=true ui.enable=true",
+		"snap alias lxd.lxc lc",
+		"snap refresh --hold lxd",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+
+	expectedRecord := config.SnapRecord{
+		Name:        "lxd",
+		Preexisting: true,
+		Held:        true,
+		Config:      map[string]string{"ui.enable": "false", "daemon.debug": ""},
+		Aliases:     []string{"lc"},
+		Snapshot:    "7",
+		Revision:    "33000",
+		Channel:     "5.21/stable",
+	}
+
+	if !reflect.DeepEqual([]config.SnapRecord{expectedRecord}, state.Snaps) {
+		t.Fatalf("expected: %v, got: %v", expectedRecord, state.Snaps)
+	}
+
+	r.ExecutedCommands = nil
+
+	err = handler.Restore()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected = []string{
+		"snap refresh --unhold lxd",
+		"snap unalias lc",
+		"snap set lxd ui.enable=false",
+		"snap unset lxd daemon.debug",
+		"snap refresh lxd --channel 5.21/stable --revision 33000",
+		"snap restore 7 lxd",
+		"snap forget 7",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerInstallsLocalSnap(t *testing.T) {
+	r := system.NewMockSystem()
+
+	// The file is not looked up in the store, so its confinement is read from it.
+	r.MockCommandReturn("unsquashfs -cat /tmp/foo.snap meta/snap.yaml", []byte("name: foo\nconfinement: classic\n"), nil)
+
+	snap := &system.Snap{Name: "foo", Path: "/tmp/foo.snap", Dangerous: true, Devmode: true}
+
+	err := NewSnapHandler(r, []*system.Snap{snap, system.NewSnap("jq", "", []string{})}).Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"unsquashfs -cat /tmp/foo.snap meta/snap.yaml",
+		"snap install /tmp/foo.snap --classic --devmode --dangerous",
+		"snap install jq",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestShouldRefresh(t *testing.T) {
+	type test struct {
+		snap     *system.Snap
+		info     *system.SnapInfo
+		expected bool
+	}
+
+	installed := func(tracking, revision, store string) *system.SnapInfo {
+		return &system.SnapInfo{Installed: true, Active: true, TrackingChannel: tracking, Revision: revision, StoreRevision: store}
+	}
+
+	tests := []test{
+		{snap: &system.Snap{Name: "lxd", Channel: "5.21/stable"}, info: installed("5.21/stable", "1", "1"), expected: true},
+		{snap: &system.Snap{Name: "lxd", Channel: "5.21/stable", RefreshPolicy: "always"}, info: installed("5.21/stable", "1", "1"), expected: true},
+		{snap: &system.Snap{Name: "lxd", Channel: "6/stable", RefreshPolicy: "never"}, info: installed("5.21/stable", "1", "2"), expected: false},
+		{snap: &system.Snap{Name: "lxd", Channel: "5.21/stable", RefreshPolicy: "if-channel-differs"}, info: installed("5.21/stable", "1", "2"), expected: false},
+		{snap: &system.Snap{Name: "lxd", Channel: "stable", RefreshPolicy: "if-channel-differs"}, info: installed("latest/stable", "1", "2"), expected: false},
+		{snap: &system.Snap{Name: "lxd", RefreshPolicy: "if-channel-differs"}, info: installed("5.21/stable", "1", "2"), expected: false},
+		{snap: &system.Snap{Name: "lxd", Channel: "6/stable", RefreshPolicy: "if-channel-differs"}, info: installed("5.21/stable", "1", "2"), expected: true},
+		{snap: &system.Snap{Name: "lxd", Channel: "5.21/stable", Revision: "3", RefreshPolicy: "if-channel-differs"}, info: installed("5.21/stable", "1", "2"), expected: true},
+		{snap: &system.Snap{Name: "lxd", RefreshPolicy: "if-older"}, info: installed("5.21/stable", "9", "10"), expected: true},
+		{snap: &system.Snap{Name: "lxd", RefreshPolicy: "if-older"}, info: installed("5.21/stable", "10", "9"), expected: false},
+		{snap: &system.Snap{Name: "lxd", Revision: "12", RefreshPolicy: "if-older"}, info: installed("5.21/stable", "10", "9"), expected: true},
+		{snap: &system.Snap{Name: "charmcraft", RefreshPolicy: "if-older"}, info: installed("latest/edge", "x1", "9"), expected: false},
+	}
+
+	for _, tc := range tests {
+		refresh, reason := shouldRefresh(tc.snap, tc.info)
+		if refresh != tc.expected {
+			t.Fatalf("snap %+v with %+v: expected: %v, got: %v (%s)", tc.snap, tc.info, tc.expected, refresh, reason)
+		}
+	}
+}
+
+func TestSnapHandlerSkipsRefresh(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("lxd", "5.21/stable", false, true)
+	r.MockSnapRevisions("lxd", "33110", "33110")
+
+	lxd := system.NewSnap("lxd", "5.21/stable", []string{})
+	lxd.RefreshPolicy = "if-channel-differs"
+
+	err := NewSnapHandler(r, []*system.Snap{lxd}).Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(r.ExecutedCommands) != 0 {
+		t.Fatalf("expected no commands, got: %v", r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerInstallsArtifacts(t *testing.T) {
+	manifest := `
+snaps:
+  - name: juju
+    channel: 3.6/stable
+    revision: "29241"
+    file: snaps/juju_29241.snap
+    assertion: snaps/juju_29241.assert
+  - name: charmcraft
+    channel: latest/stable
+    revision: "6197"
+    classic: true
+    file: snaps/charmcraft_6197.snap
+`
+
+	r := system.NewMockSystem()
+	r.MockFile("/srv/artifacts/manifest.yaml", []byte(manifest))
+
+	snaps := []*system.Snap{
+		system.NewSnap("juju", "3.6", []string{}),
+		system.NewSnap("charmcraft", "latest/stable", []string{}),
+		// Not in the manifest at this channel, so installed from the store.
+		system.NewSnap("jq", "latest/edge", []string{}),
+	}
+
+	handler := NewSnapHandler(r, snaps)
+	handler.Artifacts = "/srv/artifacts"
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"snap ack /srv/artifacts/snaps/juju_29241.assert",
+		"snap install /srv/artifacts/snaps/juju_29241.snap",
+		"snap install /srv/artifacts/snaps/charmcraft_6197.snap --classic --dangerous",
+		"snap install jq --channel latest/edge",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerArtifactRefreshPolicy(t *testing.T) {
+	manifest := `
+snaps:
+  - name: lxd
+    channel: 5.21/stable
+    revision: "33110"
+    file: lxd_33110.snap
+    assertion: lxd_33110.assert
+`
+
+	r := system.NewMockSystem()
+	r.MockFile("/srv/artifacts/manifest.yaml", []byte(manifest))
+	r.MockSnapStoreLookup("lxd", "5.21/stable", false, true)
+	r.MockSnapRevisions("lxd", "33110", "")
+
+	lxd := system.NewSnap("lxd", "5.21/stable", []string{})
+	lxd.RefreshPolicy = "if-older"
+
+	handler := NewSnapHandler(r, []*system.Snap{lxd})
+	handler.Artifacts = "/srv/artifacts"
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(r.ExecutedCommands) != 0 {
+		t.Fatalf("expected no commands, got: %v", r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerKeepsExistingHold(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("lxd", "5.21/stable", false, true)
+	r.MockSnapHeld("lxd")
+
+	lxd := system.NewSnap("lxd", "5.21/stable", []string{})
+	lxd.Hold = true
+
+	state := &config.RuntimeState{}
+	handler := NewSnapHandler(r, []*system.Snap{lxd})
+	handler.State = state
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(state.Snaps) != 1 || state.Snaps[0].Held {
+		t.Fatalf("expected: %v, got: %v", "hold not recorded", state.Snaps)
+	}
+
+	r.ExecutedCommands = nil
+
+	err = handler.Restore()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if slices.Contains(r.ExecutedCommands, "snap refresh --unhold lxd") {
+		t.Fatalf("expected the user's hold to be kept, got: %v", r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlersShareState(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapStoreLookup("lxd", "5.21/stable", false, true)
+	r.MockSnapHeld("lxd")
+
+	state := &config.RuntimeState{}
+	handlers := []*SnapHandler{
+		NewSnapHandler(r, []*system.Snap{{Name: "lxd", Channel: "5.21/stable", Hold: true}}),
+		NewSnapHandler(r, []*system.Snap{system.NewSnap("k8s", "1.32/stable", []string{})}),
+		NewSnapHandler(r, []*system.Snap{system.NewSnap("juju", "3.6/stable", []string{})}),
+	}
+
+	// The handlers of the providers prepare their snaps concurrently.
+	var eg errgroup.Group
+	for _, h := range handlers {
+		h.State = state
+		eg.Go(h.Prepare)
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	names := []string{}
+	for _, record := range state.Snaps {
+		names = append(names, record.Name)
+	}
+	slices.Sort(names)
+
+	expected := []string{"juju", "k8s", "lxd"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Fatalf("expected: %v, got: %v", expected, names)
+	}
+}
+
+func TestSnapHandlerInstallsPrerequisites(t *testing.T) {
+	manifest := `
+snaps:
+  - name: k8s
+    channel: 1.32-classic/stable
+    revision: "3612"
+    classic: true
+    file: snaps/k8s_3612.snap
+    assertion: snaps/k8s_3612.assert
+    prerequisites: [snapd, core22]
+  - name: snapd
+    revision: "24505"
+    file: snaps/snapd_24505.snap
+    assertion: snaps/snapd_24505.assert
+  - name: core22
+    revision: "1981"
+    file: snaps/core22_1981.snap
+    assertion: snaps/core22_1981.assert
+`
+
+	r := system.NewMockSystem()
+	r.MockFile("/srv/artifacts/manifest.yaml", []byte(manifest))
+	r.MockSnapStoreLookup("snapd", "latest/stable", false, true)
+
+	handler := NewSnapHandler(r, []*system.Snap{system.NewSnap("k8s", "1.32-classic/stable", []string{})})
+	handler.Artifacts = "/srv/artifacts"
+
+	err := handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// snapd is already installed, so only the base is installed before k8s.
+	expected := []string{
+		"snap ack /srv/artifacts/snaps/core22_1981.assert",
+		"snap install /srv/artifacts/snaps/core22_1981.snap",
+		"snap ack /srv/artifacts/snaps/k8s_3612.assert",
+		"snap install /srv/artifacts/snaps/k8s_3612.snap --classic",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
 	"github.com/canonical/concierge/internal/system"
 	"gopkg.in/yaml.v3"
 )
@@ -79,6 +80,13 @@ func (l *Google) ModelDefaults() map[string]string { return l.modelDefaults }
 // BootstrapConstraints reports the Juju bootstrap-constraints specific to the provider.
 func (l *Google) BootstrapConstraints() map[string]string { return l.bootstrapConstraints }
 
+// Requirements reports the host requirements of the Google provider, which has
+// none beyond those of concierge itself since the cloud is remote.
+func (l *Google) Requirements() Requirements { return Requirements{} }
+
+// Packages reports the snaps and debs installed for the provider, of which there are none.
+func (l *Google) Packages() ([]*system.Snap, []*packages.Deb) { return nil, nil }
+
 // Remove Google provider.
 func (l *Google) Restore() error {
 	slog.Info("Restored provider", "provider", l.Name())
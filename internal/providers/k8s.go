@@ -18,6 +18,14 @@ import (
 // Default channel from which K8s is installed.
 const defaultK8sChannel = "1.32-classic/stable"
 
+// k8sImagesDir is the directory from which the containerd of the k8s snap imports
+// image archives when it starts.
+const k8sImagesDir = "/var/snap/k8s/common/images"
+
+// k8sContainerdProxyConf is the systemd drop-in that sets the proxy environment
+// for the containerd service shipped in the k8s snap.
+const k8sContainerdProxyConf = "/etc/systemd/system/snap.k8s.containerd.service.d/http-proxy.conf"
+
 // NewK8s constructs a new K8s provider instance.
 func NewK8s(r system.Worker, config *config.Config) *K8s {
 	var channel string
@@ -34,16 +42,20 @@ func NewK8s(r system.Worker, config *config.Config) *K8s {
 		Channel:              channel,
 		Features:             config.Providers.K8s.Features,
 		ImageRegistry:        config.Providers.K8s.ImageRegistry,
+		caCertificates:       config.Host.CACertificates,
 		bootstrap:            config.Providers.K8s.Bootstrap,
 		modelDefaults:        config.Providers.K8s.ModelDefaults,
 		bootstrapConstraints: config.Providers.K8s.BootstrapConstraints,
+		proxy:                config.Proxy,
+		artifacts:            config.Host.Artifacts,
+		state:                &config.State,
 		system:               r,
 		debs: []*packages.Deb{
 			{Name: "iptables"},
 		},
 		snaps: []*system.Snap{
-			{Name: "k8s", Channel: channel},
-			{Name: "kubectl", Channel: "stable"},
+			{Name: "k8s", Channel: channel, RefreshPolicy: config.Host.RefreshPolicy},
+			{Name: "kubectl", Channel: "stable", RefreshPolicy: config.Host.RefreshPolicy},
 		},
 	}
 }
@@ -57,6 +69,11 @@ type K8s struct {
 	bootstrap            bool
 	modelDefaults        map[string]string
 	bootstrapConstraints map[string]string
+	proxy                config.ProxyConfig
+	caCertificates       []string
+	artifacts            string
+	// state records what was installed before concierge, so that restore keeps it.
+	state *config.RuntimeState
 
 	system system.Worker
 	debs   []*packages.Deb
@@ -72,12 +89,22 @@ func (k *K8s) Prepare() error {
 		return fmt.Errorf("failed to install K8s: %w", err)
 	}
 
+	err = k.configureProxy()
+	if err != nil {
+		return fmt.Errorf("failed to configure K8s proxy: %w", err)
+	}
+
 	// Configure image registry before bootstrapping K8s
 	err = k.configureImageRegistry()
 	if err != nil {
 		return fmt.Errorf("failed to configure image registry: %w", err)
 	}
 
+	err = k.sideloadImages()
+	if err != nil {
+		return fmt.Errorf("failed to side-load images: %w", err)
+	}
+
 	err = k.init()
 	if err != nil {
 		return fmt.Errorf("failed to install K8s: %w", err)
@@ -119,9 +146,27 @@ func (m *K8s) ModelDefaults() map[string]string { return m.modelDefaults }
 // BootstrapConstraints reports the Juju bootstrap-constraints specific to the provider.
 func (m *K8s) BootstrapConstraints() map[string]string { return m.bootstrapConstraints }
 
+// Requirements reports the minimum host resources and platform features needed
+// to run K8s.
+func (k *K8s) Requirements() Requirements {
+	return Requirements{
+		MemoryMB:      2048,
+		CPUs:          2,
+		DiskMB:        map[string]int{"/var/snap": 4096, "/var/lib": 2048},
+		Architectures: []string{"amd64", "arm64"},
+		KernelModules: []string{"overlay", "br_netfilter"},
+		CgroupV2:      true,
+		Sysctl:        inotifyLimits,
+	}
+}
+
+// Packages reports the snaps and debs installed for the provider.
+func (k *K8s) Packages() ([]*system.Snap, []*packages.Deb) { return k.snaps, k.debs }
+
 // Remove uninstalls K8s and kubectl.
 func (k *K8s) Restore() error {
 	snapHandler := packages.NewSnapHandler(k.system, k.snaps)
+	snapHandler.State = k.state
 
 	err := snapHandler.Restore()
 	if err != nil {
@@ -135,6 +180,8 @@ func (k *K8s) Restore() error {
 
 	k.restoreImageRegistry()
 
+	k.restoreProxy()
+
 	k.restoreContainerd()
 
 	slog.Info("Removed provider", "provider", k.Name())
@@ -149,7 +196,7 @@ func (k *K8s) Restore() error {
 // `concierge restore`. Failures are logged as warnings — restore has already
 // done the heavy lifting and we should not fail it over a stray file.
 func (k *K8s) restoreImageRegistry() {
-	if k.ImageRegistry.URL == "" {
+	if k.ImageRegistry.URL == "" && len(k.caCertificates) == 0 {
 		return
 	}
 
@@ -160,13 +207,72 @@ func (k *K8s) restoreImageRegistry() {
 	}
 }
 
+// configureProxy sets the proxy environment for K8s's containerd, so that it can
+// pull images through the proxy. This is done before bootstrap so that the
+// drop-in is in place when containerd first starts; if it is already running,
+// it is restarted to pick up the change.
+func (k *K8s) configureProxy() error {
+	if !k.proxy.Enabled() {
+		return nil
+	}
+
+	slog.Info("Configuring containerd proxy", "provider", k.Name())
+
+	err := k.system.MkdirAll(path.Dir(k8sContainerdProxyConf), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create systemd drop-in directory: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("[Service]\n")
+	for _, env := range k.proxy.Environment() {
+		// systemd expands %-specifiers in unit files, so escape any literal '%'
+		// (e.g. URL-encoded characters in proxy credentials).
+		fmt.Fprintf(&sb, "Environment=\"%s\"\n", strings.ReplaceAll(env, "%", "%%"))
+	}
+
+	err = k.system.WriteFile(k8sContainerdProxyConf, []byte(sb.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write containerd proxy configuration: %w", err)
+	}
+
+	return system.RunMany(k.system,
+		system.NewCommand("systemctl", []string{"daemon-reload"}),
+		system.NewCommand("systemctl", []string{"try-restart", "snap.k8s.containerd.service"}),
+	)
+}
+
+// restoreProxy removes the containerd proxy drop-in written by configureProxy,
+// which lives outside of the snap and so survives its removal. As with
+// restoreImageRegistry, failures are logged rather than failing the restore.
+func (k *K8s) restoreProxy() {
+	if !k.proxy.Enabled() {
+		return
+	}
+
+	slog.Debug("Removing containerd proxy configuration", "path", k8sContainerdProxyConf)
+	if err := k.system.RemovePath(k8sContainerdProxyConf); err != nil {
+		slog.Warn("Failed to remove containerd proxy configuration", "path", k8sContainerdProxyConf, "error", err)
+		return
+	}
+
+	cmd := system.NewCommand("systemctl", []string{"daemon-reload"})
+	if _, err := k.system.Run(cmd); err != nil {
+		slog.Warn("Failed to reload systemd units", "error", err)
+	}
+}
+
 // install ensures that K8s is installed.
 func (k *K8s) install() error {
 	var eg errgroup.Group
 
 	// Prepare/restore package handlers concurrently
 	debHandler := packages.NewDebHandler(k.system, k.debs)
+	debHandler.State = k.state
+	debHandler.Artifacts = k.artifacts
 	snapHandler := packages.NewSnapHandler(k.system, k.snaps)
+	snapHandler.State = k.state
+	snapHandler.Artifacts = k.artifacts
 
 	eg.Go(func() error {
 		// In some cases, iptables is not present on the system. In those cases,
@@ -199,6 +305,38 @@ func (k *K8s) install() error {
 	return nil
 }
 
+// sideloadImages copies the image archives of the artifacts into the images directory
+// of the k8s snap before bootstrap, so that containerd imports them when it starts
+// and K8s does not need to pull them. The directory is removed along with the snap.
+func (k *K8s) sideloadImages() error {
+	if k.artifacts == "" {
+		return nil
+	}
+
+	manifest, err := packages.LoadManifest(k.system, k.artifacts)
+	if err != nil {
+		return err
+	}
+
+	files := manifest.ImageFiles()
+	if len(files) == 0 {
+		return nil
+	}
+
+	err = k.system.MkdirAll(k8sImagesDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create images directory: %w", err)
+	}
+
+	_, err = k.system.Run(system.NewCommand("cp", append(files, k8sImagesDir)))
+	if err != nil {
+		return fmt.Errorf("failed to copy image archives: %w", err)
+	}
+
+	slog.Info("Side-loaded images", "provider", k.Name(), "images", len(files))
+	return nil
+}
+
 // init ensures that K8s is installed, minimally configured, and ready.
 func (k *K8s) init() error {
 	if k.needsBootstrap() {
@@ -327,23 +465,35 @@ func (k *K8s) restoreContainerd() {
 
 // configureImageRegistry configures an image registry mirror for K8s.
 // This allows using alternative registries like internal mirrors for docker.io.
+// Any configured CA certificates are trusted for the registry, which is Docker
+// Hub itself if no mirror is configured.
 func (k *K8s) configureImageRegistry() error {
-	if k.ImageRegistry.URL == "" {
+	certs, err := system.LoadCACertificates(k.system, k.caCertificates)
+	if err != nil {
+		return err
+	}
+
+	if k.ImageRegistry.URL == "" && len(certs) == 0 {
 		return nil
 	}
 
-	slog.Info("Configuring image registry", "url", k.ImageRegistry.URL)
+	slog.Info("Configuring image registry", "url", registryOrDefault(k.ImageRegistry).URL)
 
 	// Create the hosts.d directory for docker.io registry configuration
 	// The k8s snap uses containerd with hosts.d configuration at /etc/containerd/hosts.d/
 	hostsDir := "/etc/containerd/hosts.d/docker.io"
-	err := k.system.MkdirAll(hostsDir, 0755)
+	err = k.system.MkdirAll(hostsDir, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create hosts directory: %w", err)
 	}
 
+	caPaths, err := writeRegistryCACertificates(k.system, hostsDir, certs)
+	if err != nil {
+		return err
+	}
+
 	// Build the hosts.toml content and write it to the file
-	hostsConfig := k.buildHostsToml()
+	hostsConfig := k.buildHostsToml(caPaths)
 	hostsPath := path.Join(hostsDir, "hosts.toml")
 
 	err = k.system.WriteFile(hostsPath, []byte(hostsConfig), 0600)
@@ -356,6 +506,6 @@ func (k *K8s) configureImageRegistry() error {
 
 // buildHostsToml generates the hosts.toml configuration for containerd using
 // the K8s provider's image registry configuration.
-func (k *K8s) buildHostsToml() string {
-	return buildHostsTomlFromConfig(k.ImageRegistry)
+func (k *K8s) buildHostsToml(caPaths []string) string {
+	return buildHostsTomlFromConfig(registryOrDefault(k.ImageRegistry), caPaths)
 }
@@ -64,6 +64,10 @@ func TestNewK8s(t *testing.T) {
 
 		// Remove fields that can't be compared with DeepEqual
 		ck8s.snaps = nil
+		if ck8s.state != &tc.config.State {
+			t.Fatalf("expected: %v, got: %v", &tc.config.State, ck8s.state)
+		}
+		ck8s.state = nil
 		ck8s.debs = nil
 		if !reflect.DeepEqual(tc.expected, ck8s) {
 			t.Fatalf("expected: %v, got: %v", tc.expected, ck8s)
@@ -78,8 +82,10 @@ func TestK8sPrepareCommands(t *testing.T) {
 
 	expectedCommands := []string{
 		"which iptables",
-		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y update",
-		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold iptables",
+		"dpkg-query -W '-f=${db:Status-Abbrev} ${Package} ${Version}\\n' iptables",
+		"stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 update",
+		"DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a apt-get -y -o DPkg::Lock::Timeout=300 install -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold iptables",
 		fmt.Sprintf("snap install k8s --channel %s", defaultK8sChannel),
 		"snap install kubectl --channel stable",
 		"systemctl is-active containerd.service",
@@ -314,6 +320,55 @@ func TestK8sPrepareWithImageRegistry(t *testing.T) {
 	}
 }
 
+func TestK8sSideloadImages(t *testing.T) {
+	manifest := `images:
+  - name: ghcr.io/canonical/coredns:1.11.3
+    file: images/ghcr.io_canonical_coredns_1.11.3.tar
+  - name: ghcr.io/canonical/metrics-server:0.7.0
+    file: images/ghcr.io_canonical_metrics-server_0.7.0.tar
+`
+
+	config := &config.Config{}
+	config.Host.Artifacts = "/srv/artifacts"
+
+	system := system.NewMockSystem()
+	system.MockFile("/srv/artifacts/manifest.yaml", []byte(manifest))
+
+	ck8s := NewK8s(system, config)
+	if err := ck8s.sideloadImages(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"cp /srv/artifacts/images/ghcr.io_canonical_coredns_1.11.3.tar /srv/artifacts/images/ghcr.io_canonical_metrics-server_0.7.0.tar /var/snap/k8s/common/images",
+	}
+	if !slices.Equal(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+
+	expectedDirectories := []string{k8sImagesDir}
+	if !slices.Equal(expectedDirectories, system.CreatedDirectories) {
+		t.Fatalf("expected: %v, got: %v", expectedDirectories, system.CreatedDirectories)
+	}
+}
+
+func TestK8sSideloadImagesWithoutImages(t *testing.T) {
+	config := &config.Config{}
+	config.Host.Artifacts = "/srv/artifacts"
+
+	system := system.NewMockSystem()
+	system.MockFile("/srv/artifacts/manifest.yaml", []byte("snaps: []\n"))
+
+	ck8s := NewK8s(system, config)
+	if err := ck8s.sideloadImages(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(system.ExecutedCommands) != 0 || len(system.CreatedDirectories) != 0 {
+		t.Fatalf("expected: no commands or directories, got: %v, %v", system.ExecutedCommands, system.CreatedDirectories)
+	}
+}
+
 func TestK8sRestoreWithImageRegistry(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Providers.K8s.Channel = ""
@@ -371,7 +426,7 @@ func TestK8sBuildHostsToml(t *testing.T) {
 	sys := system.NewMockSystem()
 	ck8s := NewK8s(sys, cfg)
 
-	hostsToml := ck8s.buildHostsToml()
+	hostsToml := ck8s.buildHostsToml(nil)
 
 	expectedContent := `server = "https://mirror.example.com"
 
@@ -394,7 +449,7 @@ func TestK8sBuildHostsTomlWithAuth(t *testing.T) {
 	sys := system.NewMockSystem()
 	ck8s := NewK8s(sys, cfg)
 
-	hostsToml := ck8s.buildHostsToml()
+	hostsToml := ck8s.buildHostsToml(nil)
 
 	// Check that the auth header is present (base64 of "testuser:testpass")
 	expectedAuth := "dGVzdHVzZXI6dGVzdHBhc3M=" // base64("testuser:testpass")
@@ -406,3 +461,83 @@ func TestK8sBuildHostsTomlWithAuth(t *testing.T) {
 		t.Fatalf("expected hosts.toml to contain authorization header, got: %v", hostsToml)
 	}
 }
+
+func TestK8sPrepareWithProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Channel = defaultK8sChannel
+	cfg.Proxy = config.ProxyConfig{HTTP: "http://proxy:3128", HTTPS: "http://proxy:3128", NoProxy: "10.0.0.0/8"}
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("which iptables", []byte("/usr/sbin/iptables"), nil)
+	sys.MockCommandReturn("k8s status", []byte("Error: The node is not part of a Kubernetes cluster."), fmt.Errorf("command error"))
+	ck8s := NewK8s(sys, cfg)
+	if err := ck8s.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDropIn := `[Service]
+Environment="HTTP_PROXY=http://proxy:3128"
+Environment="http_proxy=http://proxy:3128"
+Environment="HTTPS_PROXY=http://proxy:3128"
+Environment="https_proxy=http://proxy:3128"
+Environment="NO_PROXY=10.0.0.0/8"
+Environment="no_proxy=10.0.0.0/8"
+`
+	if got := sys.CreatedFiles[k8sContainerdProxyConf]; got != expectedDropIn {
+		t.Fatalf("expected: %v, got: %v", expectedDropIn, got)
+	}
+
+	// The drop-in must be loaded before K8s is bootstrapped.
+	reload := slices.Index(sys.ExecutedCommands, "systemctl daemon-reload")
+	bootstrap := slices.Index(sys.ExecutedCommands, "k8s bootstrap")
+	if reload < 0 || bootstrap < 0 || reload > bootstrap {
+		t.Fatalf("expected systemd to be reloaded before bootstrap, got: %v", sys.ExecutedCommands)
+	}
+}
+
+func TestK8sRestoreWithProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.HTTP = "http://proxy:3128"
+
+	sys := system.NewMockSystem()
+	ck8s := NewK8s(sys, cfg)
+	if err := ck8s.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Contains(sys.RemovedPaths, k8sContainerdProxyConf) {
+		t.Fatalf("expected %s to be removed, got: %v", k8sContainerdProxyConf, sys.RemovedPaths)
+	}
+
+	if !slices.Contains(sys.ExecutedCommands, "systemctl daemon-reload") {
+		t.Fatalf("expected systemd to be reloaded, got: %v", sys.ExecutedCommands)
+	}
+}
+
+func TestK8sPrepareWithCACertificates(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.K8s.Channel = defaultK8sChannel
+	cfg.Host.CACertificates = []string{system.MockCACertificate}
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("which iptables", []byte("/usr/sbin/iptables"), nil)
+	ck8s := NewK8s(sys, cfg)
+	if err := ck8s.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a mirror, the certificates are trusted for Docker Hub itself.
+	expectedHostsToml := `server = "https://registry-1.docker.io"
+
+[host."https://registry-1.docker.io"]
+capabilities = ["pull", "resolve"]
+ca = ["/etc/containerd/hosts.d/docker.io/concierge-0.crt"]
+`
+	if got := sys.CreatedFiles["/etc/containerd/hosts.d/docker.io/hosts.toml"]; got != expectedHostsToml {
+		t.Fatalf("expected: %v, got: %v", expectedHostsToml, got)
+	}
+
+	if got := sys.CreatedFiles["/etc/containerd/hosts.d/docker.io/concierge-0.crt"]; got != system.MockCACertificate {
+		t.Fatalf("expected: %v, got: %v", system.MockCACertificate, got)
+	}
+}
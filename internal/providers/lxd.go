@@ -24,7 +24,10 @@ func NewLXD(r system.Worker, config *config.Config) *LXD {
 		bootstrap:            config.Providers.LXD.Bootstrap,
 		modelDefaults:        config.Providers.LXD.ModelDefaults,
 		bootstrapConstraints: config.Providers.LXD.BootstrapConstraints,
-		snaps:                []*system.Snap{{Name: "lxd", Channel: channel}},
+		proxy:                config.Proxy,
+		artifacts:            config.Host.Artifacts,
+		state:                &config.State,
+		snaps:                []*system.Snap{{Name: "lxd", Channel: channel, RefreshPolicy: config.Host.RefreshPolicy}},
 	}
 }
 
@@ -35,6 +38,10 @@ type LXD struct {
 	bootstrap            bool
 	modelDefaults        map[string]string
 	bootstrapConstraints map[string]string
+	proxy                config.ProxyConfig
+	artifacts            string
+	// state records what was installed before concierge, so that restore keeps it.
+	state *config.RuntimeState
 
 	system system.Worker
 	snaps  []*system.Snap
@@ -54,6 +61,11 @@ func (l *LXD) Prepare() error {
 		return fmt.Errorf("failed to initialise LXD: %w", err)
 	}
 
+	err = l.configureProxy()
+	if err != nil {
+		return fmt.Errorf("failed to configure LXD proxy: %w", err)
+	}
+
 	err = l.enableNonRootUserControl()
 	if err != nil {
 		return fmt.Errorf("failed to enable non-root LXD access: %w", err)
@@ -89,9 +101,24 @@ func (l *LXD) ModelDefaults() map[string]string { return l.modelDefaults }
 // BootstrapConstraints reports the Juju bootstrap-constraints specific to the provider.
 func (l *LXD) BootstrapConstraints() map[string]string { return l.bootstrapConstraints }
 
+// Requirements reports the minimum host resources and kernel parameters needed to
+// run LXD.
+func (l *LXD) Requirements() Requirements {
+	return Requirements{
+		MemoryMB: 1024,
+		CPUs:     1,
+		DiskMB:   map[string]int{"/var/snap": 2048},
+		Sysctl:   inotifyLimits,
+	}
+}
+
+// Packages reports the snaps and debs installed for the provider.
+func (l *LXD) Packages() ([]*system.Snap, []*packages.Deb) { return l.snaps, nil }
+
 // Remove uninstalls LXD.
 func (l *LXD) Restore() error {
 	snapHandler := packages.NewSnapHandler(l.system, l.snaps)
+	snapHandler.State = l.state
 
 	err := snapHandler.Restore()
 	if err != nil {
@@ -111,6 +138,8 @@ func (l *LXD) install() error {
 	}
 
 	snapHandler := packages.NewSnapHandler(l.system, l.snaps)
+	snapHandler.State = l.state
+	snapHandler.Artifacts = l.artifacts
 
 	err = snapHandler.Prepare()
 	if err != nil {
@@ -140,6 +169,27 @@ func (l *LXD) init() error {
 	)
 }
 
+// configureProxy points LXD at the configured proxy, which it uses to download
+// images. The settings live in LXD's database, so are removed along with the snap.
+func (l *LXD) configureProxy() error {
+	if !l.proxy.Enabled() {
+		return nil
+	}
+
+	commands := []*system.Command{}
+	for _, opt := range []struct{ key, value string }{
+		{"core.proxy_http", l.proxy.HTTP},
+		{"core.proxy_https", l.proxy.HTTPS},
+		{"core.proxy_ignore_hosts", l.proxy.NoProxy},
+	} {
+		if opt.value != "" {
+			commands = append(commands, system.NewCommand("lxc", []string{"config", "set", opt.key, opt.value}))
+		}
+	}
+
+	return system.RunMany(l.system, commands...)
+}
+
 // enableNonRootUserControl ensures the current user is in the `lxd` group.
 func (l *LXD) enableNonRootUserControl() error {
 	username := l.system.User().Username
@@ -162,9 +212,25 @@ func (l *LXD) deconflictFirewall() error {
 
 // workaroundRefresh checks if LXD will be refreshed and stops it first.
 // This is a workaround for an issue in the LXD snap sometimes failing
-// on refresh because of a missing snap socket file.
+// on refresh because of a missing snap socket file. LXD is not checked when
+// it is installed from an artifact, which is not a refresh from the store, or
+// when its refresh policy is never to refresh it.
 func (l *LXD) workaroundRefresh() (bool, error) {
-	snapInfo, err := l.system.SnapInfo(l.Name(), l.Channel)
+	if l.snaps[0].RefreshPolicy == config.RefreshNever {
+		return false, nil
+	}
+
+	if l.artifacts != "" {
+		manifest, err := packages.LoadManifest(l.system, l.artifacts)
+		if err != nil {
+			return false, err
+		}
+		if manifest.Snap(l.snaps[0]) != nil {
+			return false, nil
+		}
+	}
+
+	snapInfo, err := l.system.LocalSnapInfo(l.Name())
 	if err != nil {
 		return false, fmt.Errorf("failed to lookup snap details: %w", err)
 	}
@@ -174,7 +240,7 @@ func (l *LXD) workaroundRefresh() (bool, error) {
 		// If no channel is specified, snapd will refresh on the current channel without changing it.
 		// If the tracking channel matches the target channel, the refresh won't change channels.
 		// In both cases, no stop is needed since the channel isn't changing.
-		if l.Channel == "" || snapInfo.TrackingChannel == l.Channel {
+		if l.Channel == "" || system.NormalizeChannel(snapInfo.TrackingChannel) == system.NormalizeChannel(l.Channel) {
 			slog.Debug("Skipping LXD stop - no channel change required",
 				"tracking", snapInfo.TrackingChannel, "target", l.Channel)
 			return false, nil
@@ -40,6 +40,10 @@ func TestNewLXD(t *testing.T) {
 
 		// Remove the snaps so the rest of the object can be compared
 		lxd.snaps = nil
+		if lxd.state != &tc.config.State {
+			t.Fatalf("expected: %v, got: %v", &tc.config.State, lxd.state)
+		}
+		lxd.state = nil
 		if !reflect.DeepEqual(tc.expected, lxd) {
 			t.Fatalf("expected: %v, got: %v", tc.expected, lxd)
 		}
@@ -74,8 +78,10 @@ func TestLXDPrepareCommands(t *testing.T) {
 func TestLXDPrepareCommandsLXDAlreadyInstalled(t *testing.T) {
 	config := &config.Config{}
 
-	// When LXD is already installed on the same channel, it should not be stopped.
+	// When LXD is already installed on the same channel, it should not be stopped,
+	// but its data is saved before it is refreshed.
 	expected := []string{
+		"snap save lxd",
 		"snap refresh lxd",
 		"lxd waitready --timeout 270",
 		"lxd init --minimal",
@@ -106,6 +112,7 @@ func TestLXDPrepareCommandsLXDChannelChange(t *testing.T) {
 	// When LXD is installed but on a different channel, it should be stopped before refresh.
 	expected := []string{
 		"snap stop lxd",
+		"snap save lxd",
 		"snap refresh lxd --channel latest/edge",
 		"snap start lxd",
 		"lxd waitready --timeout 270",
@@ -130,6 +137,96 @@ func TestLXDPrepareCommandsLXDChannelChange(t *testing.T) {
 	}
 }
 
+func TestLXDPrepareFollowsHostRefreshPolicy(t *testing.T) {
+	config := &config.Config{}
+	config.Providers.LXD.Channel = "latest/edge"
+	config.Host.RefreshPolicy = "never"
+
+	// LXD is installed on a different channel, but the host's policy is to never refresh.
+	expected := []string{
+		"lxd waitready --timeout 270",
+		"lxd init --minimal",
+		"lxc network set lxdbr0 ipv6.address none",
+		"chmod a+wr /var/snap/lxd/common/lxd/unix.socket",
+		"usermod -a -G lxd test-user",
+		"iptables -F FORWARD",
+		"iptables -P FORWARD ACCEPT",
+	}
+
+	system := system.NewMockSystem()
+	system.MockSnapStoreLookup("lxd", "latest/stable", false, true)
+
+	lxd := NewLXD(system, config)
+	if err := lxd.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, system.ExecutedCommands)
+	}
+}
+
+func TestLXDPrepareCommandsLXDEquivalentChannel(t *testing.T) {
+	config := &config.Config{}
+	config.Providers.LXD.Channel = "5.21"
+
+	// "5.21" is the same channel as "5.21/stable", so LXD is not stopped.
+	system := system.NewMockSystem()
+	system.MockSnapStoreLookup("lxd", "5.21/stable", false, true)
+
+	lxd := NewLXD(system, config)
+	restart, err := lxd.workaroundRefresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restart || len(system.ExecutedCommands) != 0 {
+		t.Fatalf("expected: no stop, got: %v, %v", restart, system.ExecutedCommands)
+	}
+}
+
+func TestLXDPrepareFromArtifact(t *testing.T) {
+	config := &config.Config{}
+	config.Providers.LXD.Channel = "5.21/stable"
+	config.Host.Artifacts = "/srv/artifacts"
+
+	manifest := `
+snaps:
+  - name: lxd
+    channel: 5.21/stable
+    revision: "33110"
+    file: lxd_33110.snap
+    assertion: lxd_33110.assert
+`
+
+	// LXD is not stopped for a refresh when it is installed from an artifact.
+	expected := []string{
+		"snap save lxd",
+		"snap ack /srv/artifacts/lxd_33110.assert",
+		"snap install /srv/artifacts/lxd_33110.snap",
+		"lxd waitready --timeout 270",
+		"lxd init --minimal",
+		"lxc network set lxdbr0 ipv6.address none",
+		"chmod a+wr /var/snap/lxd/common/lxd/unix.socket",
+		"usermod -a -G lxd test-user",
+		"iptables -F FORWARD",
+		"iptables -P FORWARD ACCEPT",
+	}
+
+	system := system.NewMockSystem()
+	system.MockFile("/srv/artifacts/manifest.yaml", []byte(manifest))
+	system.MockSnapStoreLookup("lxd", "latest/stable", false, true)
+
+	lxd := NewLXD(system, config)
+	if err := lxd.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, system.ExecutedCommands)
+	}
+}
+
 func TestLXDRestore(t *testing.T) {
 	config := &config.Config{}
 
@@ -145,3 +242,65 @@ func TestLXDRestore(t *testing.T) {
 		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
 	}
 }
+
+func TestLXDRestorePreexisting(t *testing.T) {
+	config := &config.Config{}
+
+	system := system.NewMockSystem()
+	system.MockSnapStoreLookup("lxd", "", false, true)
+	system.MockSnapRevisions("lxd", "33000", "33110")
+	system.MockCommandReturn("snap save lxd", []byte("7"), nil)
+
+	lxd := NewLXD(system, config)
+	if err := lxd.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	system.ExecutedCommands = nil
+	if err := lxd.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	// LXD was installed before concierge, so it is reverted rather than purged, and
+	// its instances are kept.
+	expectedCommands := []string{
+		"snap refresh lxd --revision 33000",
+		"snap restore 7 lxd",
+		"snap forget 7",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+	if len(config.State.Snaps) != 0 {
+		t.Fatalf("expected: %v, got: %v", "no snap records", config.State.Snaps)
+	}
+}
+
+func TestLXDPrepareWithProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy = config.ProxyConfig{HTTP: "http://proxy:3128", NoProxy: "localhost,10.0.0.0/8"}
+
+	expected := []string{
+		"snap install lxd",
+		"lxd waitready --timeout 270",
+		"lxd init --minimal",
+		"lxc network set lxdbr0 ipv6.address none",
+		"lxc config set core.proxy_http http://proxy:3128",
+		"lxc config set core.proxy_ignore_hosts localhost,10.0.0.0/8",
+		"chmod a+wr /var/snap/lxd/common/lxd/unix.socket",
+		"usermod -a -G lxd test-user",
+		"iptables -F FORWARD",
+		"iptables -P FORWARD ACCEPT",
+	}
+
+	system := system.NewMockSystem()
+	lxd := NewLXD(system, cfg)
+	if err := lxd.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, system.ExecutedCommands)
+	}
+}
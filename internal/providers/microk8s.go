@@ -16,6 +16,15 @@ import (
 // version cannot be determined.
 const defaultMicroK8sChannel = "1.32-strict/stable"
 
+// microk8sContainerdEnv is the file from which MicroK8s's containerd reads its environment.
+const microk8sContainerdEnv = "/var/snap/microk8s/current/args/containerd-env"
+
+// Markers delimiting the proxy settings that concierge adds to microk8sContainerdEnv.
+const (
+	proxyBlockStart = "# BEGIN concierge proxy"
+	proxyBlockEnd   = "# END concierge proxy"
+)
+
 // NewMicroK8s constructs a new MicroK8s provider instance.
 func NewMicroK8s(r system.Worker, config *config.Config) *MicroK8s {
 	var channel string
@@ -23,7 +32,7 @@ func NewMicroK8s(r system.Worker, config *config.Config) *MicroK8s {
 	if config.Overrides.MicroK8sChannel != "" {
 		channel = config.Overrides.MicroK8sChannel
 	} else if config.Providers.MicroK8s.Channel == "" {
-		channel = computeDefaultChannel(r)
+		channel = computeDefaultChannel(r, config.Host.Artifacts)
 	} else {
 		channel = config.Providers.MicroK8s.Channel
 	}
@@ -32,13 +41,17 @@ func NewMicroK8s(r system.Worker, config *config.Config) *MicroK8s {
 		Channel:              channel,
 		Addons:               config.Providers.MicroK8s.Addons,
 		ImageRegistry:        config.Providers.MicroK8s.ImageRegistry,
+		caCertificates:       config.Host.CACertificates,
 		bootstrap:            config.Providers.MicroK8s.Bootstrap,
 		modelDefaults:        config.Providers.MicroK8s.ModelDefaults,
 		bootstrapConstraints: config.Providers.MicroK8s.BootstrapConstraints,
+		proxy:                config.Proxy,
+		artifacts:            config.Host.Artifacts,
+		state:                &config.State,
 		system:               r,
 		snaps: []*system.Snap{
-			{Name: "microk8s", Channel: channel},
-			{Name: "kubectl", Channel: "stable"},
+			{Name: "microk8s", Channel: channel, RefreshPolicy: config.Host.RefreshPolicy},
+			{Name: "kubectl", Channel: "stable", RefreshPolicy: config.Host.RefreshPolicy},
 		},
 	}
 }
@@ -52,6 +65,11 @@ type MicroK8s struct {
 	bootstrap            bool
 	modelDefaults        map[string]string
 	bootstrapConstraints map[string]string
+	proxy                config.ProxyConfig
+	caCertificates       []string
+	artifacts            string
+	// state records what was installed before concierge, so that restore keeps it.
+	state *config.RuntimeState
 
 	system system.Worker
 	snaps  []*system.Snap
@@ -74,6 +92,11 @@ func (m *MicroK8s) Prepare() error {
 		return fmt.Errorf("failed to configure MicroK8s: %w", err)
 	}
 
+	err = m.configureProxy()
+	if err != nil {
+		return fmt.Errorf("failed to configure MicroK8s proxy: %w", err)
+	}
+
 	err = m.configureImageRegistry()
 	if err != nil {
 		return fmt.Errorf("failed to configure image registry: %w", err)
@@ -126,9 +149,26 @@ func (m *MicroK8s) ModelDefaults() map[string]string { return m.modelDefaults }
 // BootstrapConstraints reports the Juju bootstrap-constraints specific to the provider.
 func (m *MicroK8s) BootstrapConstraints() map[string]string { return m.bootstrapConstraints }
 
+// Requirements reports the minimum host resources and platform features needed
+// to run MicroK8s.
+func (m *MicroK8s) Requirements() Requirements {
+	return Requirements{
+		MemoryMB:      2048,
+		CPUs:          2,
+		DiskMB:        map[string]int{"/var/snap": 4096},
+		Architectures: []string{"amd64", "arm64", "ppc64le", "s390x"},
+		KernelModules: []string{"overlay", "br_netfilter"},
+		Sysctl:        inotifyLimits,
+	}
+}
+
+// Packages reports the snaps and debs installed for the provider.
+func (m *MicroK8s) Packages() ([]*system.Snap, []*packages.Deb) { return m.snaps, nil }
+
 // Remove uninstalls MicroK8s and kubectl.
 func (m *MicroK8s) Restore() error {
 	snapHandler := packages.NewSnapHandler(m.system, m.snaps)
+	snapHandler.State = m.state
 
 	err := snapHandler.Restore()
 	if err != nil {
@@ -148,6 +188,8 @@ func (m *MicroK8s) Restore() error {
 // install ensures that MicroK8s is installed.
 func (m *MicroK8s) install() error {
 	snapHandler := packages.NewSnapHandler(m.system, m.snaps)
+	snapHandler.State = m.state
+	snapHandler.Artifacts = m.artifacts
 
 	err := snapHandler.Prepare()
 	if err != nil {
@@ -159,22 +201,34 @@ func (m *MicroK8s) install() error {
 
 // configureImageRegistry configures an image registry mirror for MicroK8s.
 // This allows using alternative registries like internal mirrors for docker.io.
+// Any configured CA certificates are trusted for the registry, which is Docker
+// Hub itself if no mirror is configured.
 func (m *MicroK8s) configureImageRegistry() error {
-	if m.ImageRegistry.URL == "" {
+	certs, err := system.LoadCACertificates(m.system, m.caCertificates)
+	if err != nil {
+		return err
+	}
+
+	if m.ImageRegistry.URL == "" && len(certs) == 0 {
 		return nil
 	}
 
-	slog.Info("Configuring image registry", "url", m.ImageRegistry.URL)
+	slog.Info("Configuring image registry", "url", registryOrDefault(m.ImageRegistry).URL)
 
 	// Create the certs.d directory for docker.io registry configuration
 	certsDir := "/var/snap/microk8s/current/args/certs.d/docker.io"
-	err := m.system.MkdirAll(certsDir, 0755)
+	err = m.system.MkdirAll(certsDir, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create certs directory: %w", err)
 	}
 
+	caPaths, err := writeRegistryCACertificates(m.system, certsDir, certs)
+	if err != nil {
+		return err
+	}
+
 	// Build the hosts.toml content and write it to the file
-	hostsConfig := m.buildHostsToml()
+	hostsConfig := m.buildHostsToml(caPaths)
 	hostsPath := path.Join(certsDir, "hosts.toml")
 
 	err = m.system.WriteFile(hostsPath, []byte(hostsConfig), 0600)
@@ -183,8 +237,64 @@ func (m *MicroK8s) configureImageRegistry() error {
 	}
 
 	// Restart MicroK8s to apply the registry configuration
+	return m.restart()
+}
+
+// configureProxy adds the proxy environment to MicroK8s's containerd, so that it
+// can pull images through the proxy. The settings are kept in a delimited block,
+// which is replaced on subsequent runs. The file belongs to the snap, so is
+// removed along with it on restore.
+func (m *MicroK8s) configureProxy() error {
+	if !m.proxy.Enabled() {
+		return nil
+	}
+
+	slog.Info("Configuring containerd proxy", "provider", m.Name())
+
+	// The file is created by the snap on install; if it cannot be read, start
+	// from scratch rather than failing.
+	contents, err := m.system.ReadFile(microk8sContainerdEnv)
+	if err != nil {
+		slog.Debug("Failed to read containerd environment", "path", microk8sContainerdEnv, "error", err)
+	}
+
+	env := removeProxyBlock(string(contents))
+	if env != "" && !strings.HasSuffix(env, "\n") {
+		env += "\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(env)
+	sb.WriteString(proxyBlockStart + "\n")
+	for _, env := range m.proxy.Environment() {
+		sb.WriteString(env + "\n")
+	}
+	sb.WriteString(proxyBlockEnd + "\n")
+
+	err = m.system.WriteFile(microk8sContainerdEnv, []byte(sb.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write containerd environment: %w", err)
+	}
+
+	return m.restart()
+}
+
+// removeProxyBlock strips a previously written concierge proxy block from the
+// contents of the containerd environment file.
+func removeProxyBlock(contents string) string {
+	start := strings.Index(contents, proxyBlockStart)
+	end := strings.Index(contents, proxyBlockEnd)
+	if start < 0 || end < start {
+		return contents
+	}
+
+	return contents[:start] + strings.TrimPrefix(contents[end+len(proxyBlockEnd):], "\n")
+}
+
+// restart stops and starts MicroK8s so that configuration changes take effect.
+func (m *MicroK8s) restart() error {
 	stopCmd := system.NewCommand("microk8s", []string{"stop"})
-	_, err = m.system.Run(stopCmd)
+	_, err := m.system.Run(stopCmd)
 	if err != nil {
 		return fmt.Errorf("failed to stop MicroK8s: %w", err)
 	}
@@ -202,8 +312,8 @@ func (m *MicroK8s) configureImageRegistry() error {
 
 // buildHostsToml generates the hosts.toml configuration for containerd using
 // the MicroK8s provider's image registry configuration.
-func (m *MicroK8s) buildHostsToml() string {
-	return buildHostsTomlFromConfig(m.ImageRegistry)
+func (m *MicroK8s) buildHostsToml(caPaths []string) string {
+	return buildHostsTomlFromConfig(registryOrDefault(m.ImageRegistry), caPaths)
 }
 
 // init waits for MicroK8s to be ready (via `microk8s status --wait-ready`).
@@ -266,8 +376,23 @@ func (m *MicroK8s) setupKubectl() error {
 
 // Try to compute the "correct" default channel. Concierge prefers that the 'strict'
 // variants are installed, so we filter available channels and sort descending by
-// version. If the list cannot be retrieved, default to a know good version.
-func computeDefaultChannel(s system.Worker) string {
+// version. If the list cannot be retrieved, default to a know good version. When
+// installing from artifacts, the store is not consulted, and the channel of the
+// bundled snap is used instead.
+func computeDefaultChannel(s system.Worker, artifacts string) string {
+	if artifacts != "" {
+		manifest, err := packages.LoadManifest(s, artifacts)
+		if err != nil {
+			return defaultMicroK8sChannel
+		}
+		for _, artifact := range manifest.Snaps {
+			if artifact.Name == "microk8s" && artifact.Channel != "" {
+				return artifact.Channel
+			}
+		}
+		return defaultMicroK8sChannel
+	}
+
 	channels, err := s.SnapChannels("microk8s")
 	if err != nil {
 		return defaultMicroK8sChannel
@@ -61,12 +61,43 @@ func TestNewMicroK8s(t *testing.T) {
 
 		// Remove the snaps so the rest of the object can be compared
 		uk8s.snaps = nil
+		if uk8s.state != &tc.config.State {
+			t.Fatalf("expected: %v, got: %v", &tc.config.State, uk8s.state)
+		}
+		uk8s.state = nil
 		if !reflect.DeepEqual(tc.expected, uk8s) {
 			t.Fatalf("expected: %v, got: %v", tc.expected, uk8s)
 		}
 	}
 }
 
+func TestComputeDefaultChannel(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockSnapChannels("microk8s", []string{"1.32/stable", "1.32-strict/stable"})
+	r.MockFile("/srv/artifacts/manifest.yaml", []byte(`snaps:
+  - name: microk8s
+    channel: 1.31-strict/stable
+    file: snaps/microk8s_7000.snap
+`))
+	r.MockFile("/srv/empty/manifest.yaml", []byte("snaps: []\n"))
+
+	tests := []struct {
+		artifacts string
+		expected  string
+	}{
+		{artifacts: "", expected: "1.32-strict/stable"},
+		// The store is not consulted when installing from artifacts.
+		{artifacts: "/srv/artifacts", expected: "1.31-strict/stable"},
+		{artifacts: "/srv/empty", expected: defaultMicroK8sChannel},
+	}
+
+	for _, tc := range tests {
+		if got := computeDefaultChannel(r, tc.artifacts); got != tc.expected {
+			t.Fatalf("expected: %v, got: %v", tc.expected, got)
+		}
+	}
+}
+
 func TestMicroK8sGroupName(t *testing.T) {
 	type test struct {
 		channel  string
@@ -254,7 +285,7 @@ func TestMicroK8sBuildHostsToml(t *testing.T) {
 	sys := system.NewMockSystem()
 	uk8s := NewMicroK8s(sys, cfg)
 
-	hostsToml := uk8s.buildHostsToml()
+	hostsToml := uk8s.buildHostsToml(nil)
 
 	expectedContent := `server = "https://mirror.example.com"
 
@@ -266,3 +297,61 @@ capabilities = ["pull", "resolve"]
 		t.Fatalf("expected:\n%v\ngot:\n%v", expectedContent, hostsToml)
 	}
 }
+
+func TestMicroK8sPrepareWithProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.MicroK8s.Channel = "1.31-strict/stable"
+	cfg.Proxy = config.ProxyConfig{HTTPS: "http://proxy:3128"}
+
+	sys := system.NewMockSystem()
+	// Simulate a previous run having already added a (now stale) proxy block.
+	sys.MockFile(microk8sContainerdEnv, []byte("ulimit -n 65536 || true\n"+
+		"# BEGIN concierge proxy\nHTTPS_PROXY=http://old:3128\n# END concierge proxy\n"))
+
+	uk8s := NewMicroK8s(sys, cfg)
+	if err := uk8s.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedEnv := `ulimit -n 65536 || true
+# BEGIN concierge proxy
+HTTPS_PROXY=http://proxy:3128
+https_proxy=http://proxy:3128
+# END concierge proxy
+`
+	if got := sys.CreatedFiles[microk8sContainerdEnv]; got != expectedEnv {
+		t.Fatalf("expected: %v, got: %v", expectedEnv, got)
+	}
+
+	if !slices.Contains(sys.ExecutedCommands, "microk8s stop") || !slices.Contains(sys.ExecutedCommands, "microk8s start") {
+		t.Fatalf("expected MicroK8s to be restarted, got: %v", sys.ExecutedCommands)
+	}
+}
+
+func TestMicroK8sPrepareWithCACertificatesAndImageRegistry(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.MicroK8s.Channel = "1.31-strict/stable"
+	cfg.Providers.MicroK8s.ImageRegistry.URL = "https://mirror.example.com"
+	cfg.Host.CACertificates = []string{system.MockCACertificate}
+
+	sys := system.NewMockSystem()
+	uk8s := NewMicroK8s(sys, cfg)
+	if err := uk8s.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	certsDir := "/var/snap/microk8s/current/args/certs.d/docker.io"
+	expectedHostsToml := `server = "https://mirror.example.com"
+
+[host."https://mirror.example.com"]
+capabilities = ["pull", "resolve"]
+ca = ["` + certsDir + `/concierge-0.crt"]
+`
+	if got := sys.CreatedFiles[path.Join(certsDir, "hosts.toml")]; got != expectedHostsToml {
+		t.Fatalf("expected: %v, got: %v", expectedHostsToml, got)
+	}
+
+	if got := sys.CreatedFiles[path.Join(certsDir, "concierge-0.crt")]; got != system.MockCACertificate {
+		t.Fatalf("expected: %v, got: %v", system.MockCACertificate, got)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"path"
 	"strings"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
 	"github.com/canonical/concierge/internal/system"
 )
 
@@ -40,12 +42,77 @@ type Provider interface {
 	ModelDefaults() map[string]string
 	// BootstrapConstraints reports the Juju bootstrap-constraints specific to the provider.
 	BootstrapConstraints() map[string]string
+	// Requirements reports the minimum host resources and platform features that
+	// the provider needs, which are checked before the machine is provisioned.
+	Requirements() Requirements
+	// Packages reports the snaps and debs that the provider installs.
+	Packages() ([]*system.Snap, []*packages.Deb)
+}
+
+// Requirements describes the minimum host resources and platform features that a
+// provider needs in order to be prepared successfully. Zero values mean that the
+// provider has no particular requirement.
+type Requirements struct {
+	// MemoryMB is the amount of RAM, in megabytes, consumed by the provider.
+	MemoryMB int
+	// CPUs is the minimum number of CPUs the provider needs.
+	CPUs int
+	// DiskMB maps a filesystem path to the free space, in megabytes, that the
+	// provider consumes under that path.
+	DiskMB map[string]int
+	// Architectures is the list of (Go) architectures the provider supports.
+	// An empty list means the provider supports any architecture.
+	Architectures []string
+	// KernelModules is the list of kernel modules that must be loaded or loadable.
+	KernelModules []string
+	// CgroupV2 reports whether the provider requires the unified cgroup v2 hierarchy.
+	CgroupV2 bool
+	// Sysctl maps kernel parameters to the minimum values the provider works well
+	// with. Parameters that are lower are raised when the machine is provisioned.
+	Sysctl map[string]int
+}
+
+// inotifyLimits are the inotify limits for hosts running many containers, each
+// of which watches files of its own.
+var inotifyLimits = map[string]int{
+	"fs.inotify.max_user_instances": 1024,
+	"fs.inotify.max_user_watches":   1048576,
+}
+
+// defaultRegistryURL is Docker Hub's registry, which is configured as the containerd
+// host for docker.io when CA certificates are given without an image registry mirror.
+const defaultRegistryURL = "https://registry-1.docker.io"
+
+// registryOrDefault returns the image registry to configure in containerd: the
+// configured mirror, or Docker Hub itself if no mirror is configured.
+func registryOrDefault(cfg config.ImageRegistryConfig) config.ImageRegistryConfig {
+	if cfg.URL == "" {
+		return config.ImageRegistryConfig{URL: defaultRegistryURL}
+	}
+	return cfg
+}
+
+// writeRegistryCACertificates writes CA certificates alongside a containerd hosts.toml,
+// returning the paths of the written files. The certificates are kept in the hosts
+// directory so that they are readable by strictly confined containerd instances.
+func writeRegistryCACertificates(w system.Worker, hostsDir string, certs []system.CACertificate) ([]string, error) {
+	paths := []string{}
+	for _, cert := range certs {
+		certPath := path.Join(hostsDir, cert.Name+".crt")
+		err := w.WriteFile(certPath, cert.PEM, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write CA certificate '%s': %w", certPath, err)
+		}
+		paths = append(paths, certPath)
+	}
+	return paths, nil
 }
 
 // buildHostsTomlFromConfig generates the hosts.toml configuration for containerd
-// from the provided image registry configuration. This helper is shared between
-// providers that need to configure containerd registry mirrors.
-func buildHostsTomlFromConfig(cfg config.ImageRegistryConfig) string {
+// from the provided image registry configuration, trusting the CA certificates at
+// caPaths (if any) for the registry. This helper is shared between providers that
+// need to configure containerd registry mirrors.
+func buildHostsTomlFromConfig(cfg config.ImageRegistryConfig, caPaths []string) string {
 	// (*strings.Builder).Write never returns a non-nil error per the stdlib
 	// docs, so the fmt.Fprintf return values below are safely ignored.
 	var sb strings.Builder
@@ -54,6 +121,14 @@ func buildHostsTomlFromConfig(cfg config.ImageRegistryConfig) string {
 	fmt.Fprintf(&sb, "[host.%q]\n", cfg.URL)
 	sb.WriteString("capabilities = [\"pull\", \"resolve\"]\n")
 
+	if len(caPaths) > 0 {
+		quoted := make([]string, len(caPaths))
+		for i, p := range caPaths {
+			quoted[i] = fmt.Sprintf("%q", p)
+		}
+		fmt.Fprintf(&sb, "ca = [%s]\n", strings.Join(quoted, ", "))
+	}
+
 	// Warn if only one of username/password is provided
 	if (cfg.Username != "" && cfg.Password == "") || (cfg.Username == "" && cfg.Password != "") {
 		slog.Warn("Image registry has username or password set, but not both - credentials will not be used")
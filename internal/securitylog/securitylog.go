@@ -59,9 +59,10 @@ const (
 const securityType = "security"
 
 var (
-	mu     sync.Mutex
-	logger *slog.Logger
-	appID  = "concierge"
+	mu       sync.Mutex
+	logger   *slog.Logger
+	appID    = "concierge"
+	redactor func(string) string
 )
 
 // Configure sets the destination writer and application identifier used for
@@ -95,6 +96,16 @@ func ConfigureDefault(id string) {
 	Configure(w, id)
 }
 
+// SetRedactor installs a function that is applied to the description and to
+// every string attribute value of each event before it is written, so that
+// secrets which end up in, for example, a privileged command line are masked
+// in the audit trail. A nil function disables redaction.
+func SetRedactor(f func(string) string) {
+	mu.Lock()
+	defer mu.Unlock()
+	redactor = f
+}
+
 // UserID returns the userid string that should be embedded in OWASP event
 // names. The effective UID is used: concierge runs as root, and SUDO_USER is
 // not consulted because the privilege actually in play is root's. Callers
@@ -123,15 +134,15 @@ func newLogger(w io.Writer) *slog.Logger {
 	return slog.New(h)
 }
 
-// current returns the configured logger and appid, lazily initialising the
-// logger against os.Stderr the first time it is needed.
-func current() (*slog.Logger, string) {
+// current returns the configured logger, appid and redactor, lazily
+// initialising the logger against os.Stderr the first time it is needed.
+func current() (*slog.Logger, string, func(string) string) {
 	mu.Lock()
 	defer mu.Unlock()
 	if logger == nil {
 		logger = newLogger(os.Stderr)
 	}
-	return logger, appID
+	return logger, appID, redactor
 }
 
 // Emit records a security event at WARN level — the level the OWASP Logging
@@ -143,15 +154,28 @@ func current() (*slog.Logger, string) {
 // appended to the event name as "event:arg" in the JSON record. description
 // is a human-readable summary; attrs are optional slog-style key/value pairs
 // giving event-specific context. Callers must not pass secret values (such as
-// credential contents) as attrs.
+// credential contents) as attrs; any configured redactor (see SetRedactor) is
+// a safety net, not a licence to do so.
 func Emit(event, arg, description string, attrs ...any) {
-	l, id := current()
+	l, id, redact := current()
 	eventField := event
 	if arg != "" {
 		eventField = event + ":" + arg
 	}
 	args := make([]any, 0, len(attrs)+6) // 3 key/value pairs appended below
 	args = append(args, "type", securityType, "appid", id, "event", eventField)
-	args = append(args, attrs...)
+	if redact == nil {
+		args = append(args, attrs...)
+	} else {
+		description = redact(description)
+		// Attrs are key/value pairs, so only the values (odd indices) are
+		// redacted; the keys are fixed strings chosen by the caller.
+		for i, a := range attrs {
+			if v, ok := a.(string); ok && i%2 == 1 {
+				a = redact(v)
+			}
+			args = append(args, a)
+		}
+	}
 	l.Log(context.Background(), slog.LevelWarn, description, args...)
 }
@@ -111,3 +111,19 @@ func TestConfigureEmptyIDKeepsExisting(t *testing.T) {
 		t.Errorf("appid = %v, want concierge@first", got)
 	}
 }
+
+func TestEmitAppliesRedactor(t *testing.T) {
+	t.Cleanup(func() { SetRedactor(nil) })
+	SetRedactor(func(s string) string { return strings.ReplaceAll(s, "s3cret", "[REDACTED]") })
+
+	record := decodeEvent(t, func() {
+		Emit(EventAuthzAdmin, "0,exec", "privileged command executed",
+			"command", "login --password s3cret", "elapsed", 3)
+	})
+	if got := record["command"]; got != "login --password [REDACTED]" {
+		t.Errorf("command = %v, want redacted command", got)
+	}
+	if got := record["elapsed"]; got != float64(3) {
+		t.Errorf("elapsed = %v, want non-string attrs left untouched", got)
+	}
+}
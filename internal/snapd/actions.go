@@ -0,0 +1,170 @@
+package snapd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// aliasAction is the request body for POST /v2/aliases.
+type aliasAction struct {
+	Action string `json:"action"`
+	Snap   string `json:"snap,omitempty"`
+	App    string `json:"app,omitempty"`
+	Alias  string `json:"alias"`
+}
+
+// Conf fetches the values of the specified configuration options of a snap.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--snaps-name-conf
+func (c *Client) Conf(ctx context.Context, name string, keys []string) (map[string]any, error) {
+	query := url.Values{"keys": []string{strings.Join(keys, ",")}}
+
+	resp, err := c.do(ctx, "GET", "/v2/snaps/"+url.PathEscape(name)+"/conf?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := map[string]any{}
+	if err := json.Unmarshal(resp.Result, &conf); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snap configuration: %w", err)
+	}
+
+	return conf, nil
+}
+
+// SetConf sets configuration options of a snap, returning the ID of the resulting
+// change. A nil value unsets the option.
+func (c *Client) SetConf(ctx context.Context, name string, conf map[string]any) (string, error) {
+	return c.doAsync(ctx, "PUT", "/v2/snaps/"+url.PathEscape(name)+"/conf", conf)
+}
+
+// Alias creates an alias for an app of a snap, returning the ID of the resulting change.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--aliases
+func (c *Client) Alias(ctx context.Context, snap, app, alias string) (string, error) {
+	return c.doAsync(ctx, "POST", "/v2/aliases", aliasAction{Action: "alias", Snap: snap, App: app, Alias: alias})
+}
+
+// Unalias removes an alias, returning the ID of the resulting change.
+func (c *Client) Unalias(ctx context.Context, alias string) (string, error) {
+	return c.doAsync(ctx, "POST", "/v2/aliases", aliasAction{Action: "unalias", Alias: alias})
+}
+
+// Sideload installs a snap from a local file, returning the ID of the resulting change.
+// The file is streamed to snapd as a multipart upload, as `snap install <file>` does.
+func (c *Client) Sideload(ctx context.Context, path string, opts *SideloadOptions) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open snap file: %w", err)
+	}
+	defer func() { _ = f.Close() }() // Read-only file; close error is not actionable
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeSideloadForm(mw, f, filepath.Base(path), opts))
+	}()
+
+	resp, err := c.doRaw(ctx, "POST", "/v2/snaps", mw.FormDataContentType(), pr)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Change, nil
+}
+
+// SideloadOptions are the options for installing a snap from a local file.
+type SideloadOptions struct {
+	Classic   bool
+	Devmode   bool
+	Dangerous bool
+}
+
+// writeSideloadForm writes the form fields and snap file for a sideload request.
+func writeSideloadForm(mw *multipart.Writer, snap io.Reader, filename string, opts *SideloadOptions) error {
+	if opts == nil {
+		opts = &SideloadOptions{}
+	}
+
+	for _, field := range []struct {
+		key string
+		set bool
+	}{
+		{"classic", opts.Classic},
+		{"devmode", opts.Devmode},
+		{"dangerous", opts.Dangerous},
+	} {
+		if !field.set {
+			continue
+		}
+		if err := mw.WriteField(field.key, "true"); err != nil {
+			return err
+		}
+	}
+
+	part, err := mw.CreateFormFile("snap", filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(part, snap); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+// snapshotResult is the result of a snapshot request to POST /v2/snaps.
+type snapshotResult struct {
+	SetID uint64 `json:"set-id"`
+}
+
+// snapshotAction is the request body for POST /v2/snapshots.
+type snapshotAction struct {
+	Action string   `json:"action"`
+	Set    uint64   `json:"set"`
+	Snaps  []string `json:"snaps,omitempty"`
+}
+
+// Snapshot saves a snapshot of the data of the specified snaps, returning the ID of
+// the snapshot set and of the change that creates it.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--snapshots
+func (c *Client) Snapshot(ctx context.Context, names []string) (uint64, string, error) {
+	resp, err := c.do(ctx, "POST", "/v2/snaps", snapAction{Action: "snapshot", Snaps: names})
+	if err != nil {
+		return 0, "", err
+	}
+
+	var result snapshotResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return 0, "", fmt.Errorf("failed to unmarshal snapshot result: %w", err)
+	}
+
+	return result.SetID, resp.Change, nil
+}
+
+// SnapshotAction performs an action (e.g. restore or forget) on a snapshot set,
+// optionally limited to the specified snaps, returning the ID of the resulting change.
+func (c *Client) SnapshotAction(ctx context.Context, action string, set uint64, names []string) (string, error) {
+	return c.doAsync(ctx, "POST", "/v2/snapshots", snapshotAction{Action: action, Set: set, Snaps: names})
+}
+
+// Ack adds the assertions in the specified file to the system's assertion database,
+// as `snap ack` does, so that snaps they sign can be installed from local files.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--assertions
+func (c *Client) Ack(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open assertion file: %w", err)
+	}
+	defer func() { _ = f.Close() }() // Read-only file; close error is not actionable
+
+	_, err = c.doRaw(ctx, "POST", "/v2/assertions", "application/x.ubuntu.assertion", f)
+	return err
+}
@@ -0,0 +1,289 @@
+package snapd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Error kinds returned by the snapd API that callers may want to handle.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--errors
+const (
+	ErrorKindChangeConflict    = "snap-change-conflict"
+	ErrorKindAlreadyInstalled  = "snap-already-installed"
+	ErrorKindNotInstalled      = "snap-not-installed"
+	ErrorKindNoUpdateAvailable = "snap-no-update-available"
+	ErrorKindSnapNotFound      = "snap-not-found"
+)
+
+// Change statuses reported by snapd.
+const (
+	ChangeStatusDone  = "Done"
+	ChangeStatusError = "Error"
+)
+
+// defaultPollInterval is how often WaitChange polls snapd for the status of a change.
+const defaultPollInterval = 500 * time.Millisecond
+
+// Error is an error reported by the snapd API, carrying snapd's own message.
+type Error struct {
+	StatusCode int
+	Kind       string `json:"kind"`
+	Message    string `json:"message"`
+}
+
+// Error returns snapd's error message.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected HTTP status code: %d", e.StatusCode)
+	}
+	return e.Message
+}
+
+// SnapOptions are the options for an action on one or more snaps.
+type SnapOptions struct {
+	Channel  string `json:"channel,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Classic  bool   `json:"classic,omitempty"`
+	Devmode  bool   `json:"devmode,omitempty"`
+	Purge    bool   `json:"purge,omitempty"`
+	// Time and HoldLevel apply to the "hold" action, e.g. "forever" and "general".
+	Time      string `json:"time,omitempty"`
+	HoldLevel string `json:"hold-level,omitempty"`
+}
+
+// snapAction is the request body for POST /v2/snaps and POST /v2/snaps/{name}.
+type snapAction struct {
+	Action string   `json:"action"`
+	Snaps  []string `json:"snaps,omitempty"`
+	SnapOptions
+}
+
+// Change represents a snapd change: a set of tasks carried out for a single request.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--changes
+type Change struct {
+	ID      string     `json:"id"`
+	Kind    string     `json:"kind"`
+	Summary string     `json:"summary"`
+	Status  string     `json:"status"`
+	Ready   bool       `json:"ready"`
+	Err     string     `json:"err"`
+	Tasks   []Task     `json:"tasks"`
+	Data    ChangeData `json:"data"`
+}
+
+// ChangeData holds details of a change, such as the snaps it affects.
+type ChangeData struct {
+	SnapNames []string `json:"snap-names"`
+}
+
+// Task is a single step of a snapd change.
+type Task struct {
+	ID       string       `json:"id"`
+	Kind     string       `json:"kind"`
+	Summary  string       `json:"summary"`
+	Status   string       `json:"status"`
+	Progress TaskProgress `json:"progress"`
+}
+
+// TaskProgress reports how far through a task snapd is, e.g. bytes downloaded.
+type TaskProgress struct {
+	Label string `json:"label"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// PlugRef identifies a plug on a snap.
+type PlugRef struct {
+	Snap string `json:"snap"`
+	Plug string `json:"plug"`
+}
+
+// SlotRef identifies a slot on a snap. An empty Snap refers to the system snap.
+type SlotRef struct {
+	Snap string `json:"snap"`
+	Slot string `json:"slot"`
+}
+
+// interfaceAction is the request body for POST /v2/interfaces.
+type interfaceAction struct {
+	Action string    `json:"action"`
+	Plugs  []PlugRef `json:"plugs"`
+	Slots  []SlotRef `json:"slots"`
+}
+
+// Connection is an established connection between a plug and a slot.
+type Connection struct {
+	Interface string  `json:"interface"`
+	Plug      PlugRef `json:"plug"`
+	Slot      SlotRef `json:"slot"`
+	Manual    bool    `json:"manual"`
+}
+
+// Connections is the result of GET /v2/connections.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--connections
+type Connections struct {
+	Established []Connection `json:"established"`
+}
+
+// SnapAction asks snapd to perform an action (e.g. install, refresh, remove, enable)
+// on a single snap, returning the ID of the resulting change. An empty ID is returned
+// if snapd completed the request synchronously.
+func (c *Client) SnapAction(ctx context.Context, action, name string, opts *SnapOptions) (string, error) {
+	body := snapAction{Action: action}
+	if opts != nil {
+		body.SnapOptions = *opts
+	}
+
+	return c.doAsync(ctx, "POST", "/v2/snaps/"+url.PathEscape(name), body)
+}
+
+// SnapActionMany asks snapd to perform an action on several snaps as a single
+// change. snapd does not support per-snap options such as channels for these.
+func (c *Client) SnapActionMany(ctx context.Context, action string, names []string) (string, error) {
+	return c.doAsync(ctx, "POST", "/v2/snaps", snapAction{Action: action, Snaps: names})
+}
+
+// Connect asks snapd to connect a plug to a slot, returning the ID of the resulting change.
+func (c *Client) Connect(ctx context.Context, plug PlugRef, slot SlotRef) (string, error) {
+	return c.doAsync(ctx, "POST", "/v2/interfaces", interfaceAction{
+		Action: "connect", Plugs: []PlugRef{plug}, Slots: []SlotRef{slot},
+	})
+}
+
+// Disconnect asks snapd to disconnect a plug from a slot, returning the ID of the resulting change.
+func (c *Client) Disconnect(ctx context.Context, plug PlugRef, slot SlotRef) (string, error) {
+	return c.doAsync(ctx, "POST", "/v2/interfaces", interfaceAction{
+		Action: "disconnect", Plugs: []PlugRef{plug}, Slots: []SlotRef{slot},
+	})
+}
+
+// Connections lists the established connections involving the specified snap.
+func (c *Client) Connections(ctx context.Context, snap string) (*Connections, error) {
+	query := url.Values{"snap": []string{snap}}
+
+	resp, err := c.do(ctx, "GET", "/v2/connections?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var connections Connections
+	if err := json.Unmarshal(resp.Result, &connections); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal connections: %w", err)
+	}
+
+	return &connections, nil
+}
+
+// Change fetches the current state of a change.
+func (c *Client) Change(ctx context.Context, id string) (*Change, error) {
+	resp, err := c.do(ctx, "GET", "/v2/changes/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var change Change
+	if err := json.Unmarshal(resp.Result, &change); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal change: %w", err)
+	}
+
+	return &change, nil
+}
+
+// WaitChange polls a change until snapd reports that it is ready, calling report
+// (if not nil) with each update so that progress can be surfaced. The final state
+// of the change is returned; callers should check its Status.
+func (c *Client) WaitChange(ctx context.Context, id string, report func(*Change)) (*Change, error) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		change, err := c.Change(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of change %s: %w", id, err)
+		}
+
+		if report != nil {
+			report(change)
+		}
+
+		if change.Ready {
+			return change, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for change %s (%s): %w", id, change.Summary, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// doAsync sends a request that snapd handles asynchronously, returning the
+// ID of the change it creates.
+func (c *Client) doAsync(ctx context.Context, method, path string, body any) (string, error) {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Change, nil
+}
+
+// do sends a request to the snapd API and decodes the response. Error responses
+// are returned as *Error, carrying snapd's own message and error kind.
+func (c *Client) do(ctx context.Context, method, path string, body any) (*response, error) {
+	if body == nil {
+		return c.doRaw(ctx, method, path, "", nil)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	return c.doRaw(ctx, method, path, "application/json", bytes.NewReader(b))
+}
+
+// doRaw sends a request with a body of the specified content type to the snapd API,
+// and decodes the response.
+func (c *Client) doRaw(ctx context.Context, method, path, contentType string, body io.Reader) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }() // Read-only body; close error is not actionable
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var snapdResp response
+	if err := json.Unmarshal(respBody, &snapdResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if snapdResp.Type == "error" || resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		// The result of an error response describes the error; if it cannot
+		// be decoded, fall back to reporting the status code.
+		_ = json.Unmarshal(snapdResp.Result, apiErr)
+		return nil, apiErr
+	}
+
+	return &snapdResp, nil
+}
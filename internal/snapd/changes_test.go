@@ -0,0 +1,510 @@
+package snapd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeResponse encodes a snapd response with the specified result.
+func writeResponse(t *testing.T, w http.ResponseWriter, status int, resp response, result any) {
+	t.Helper()
+
+	if result != nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			t.Fatalf("failed to marshal result: %v", err)
+		}
+		resp.Result = b
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func TestSnapAction_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected method 'POST', got: %s", r.Method)
+		}
+		if r.URL.Path != "/v2/snaps/charmcraft" {
+			t.Errorf("Expected path '/v2/snaps/charmcraft', got: %s", r.URL.Path)
+		}
+
+		var body snapAction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		expected := snapAction{Action: "install", SnapOptions: SnapOptions{Channel: "latest/edge", Classic: true}}
+		if !reflect.DeepEqual(body, expected) {
+			t.Errorf("Expected body %+v, got: %+v", expected, body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "42"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	id, err := client.SnapAction(context.Background(), "install", "charmcraft", &SnapOptions{Channel: "latest/edge", Classic: true})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if id != "42" {
+		t.Errorf("Expected change ID '42', got: %s", id)
+	}
+}
+
+func TestSnapActionMany_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/snaps" {
+			t.Errorf("Expected path '/v2/snaps', got: %s", r.URL.Path)
+		}
+
+		var body snapAction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		if body.Action != "install" || !reflect.DeepEqual(body.Snaps, []string{"jq", "yq"}) {
+			t.Errorf("Unexpected request body: %+v", body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "7"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	id, err := client.SnapActionMany(context.Background(), "install", []string{"jq", "yq"})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if id != "7" {
+		t.Errorf("Expected change ID '7', got: %s", id)
+	}
+}
+
+func TestSnapAction_Error(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(t, w, http.StatusConflict, response{Type: "error", Status: "Conflict"}, map[string]string{
+			"kind":    ErrorKindChangeConflict,
+			"message": `snap "jq" has "install-snap" change in progress`,
+		})
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	_, err := client.SnapAction(context.Background(), "install", "jq", nil)
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *Error, got: %v", err)
+	}
+	if apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code %d, got: %d", http.StatusConflict, apiErr.StatusCode)
+	}
+	if apiErr.Kind != ErrorKindChangeConflict {
+		t.Errorf("Expected kind %q, got: %q", ErrorKindChangeConflict, apiErr.Kind)
+	}
+	if err.Error() != `snap "jq" has "install-snap" change in progress` {
+		t.Errorf("Expected snapd's error message, got: %v", err)
+	}
+}
+
+func TestWaitChange_PollsUntilReady(t *testing.T) {
+	var polls atomic.Int32
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/changes/42" {
+			t.Errorf("Expected path '/v2/changes/42', got: %s", r.URL.Path)
+		}
+
+		change := Change{
+			ID:      "42",
+			Summary: `Install "jq" snap`,
+			Status:  "Doing",
+			Tasks: []Task{
+				{ID: "1", Summary: "Download snap", Status: "Doing", Progress: TaskProgress{Done: 50, Total: 100}},
+			},
+		}
+
+		if polls.Add(1) == 3 {
+			change.Status = ChangeStatusDone
+			change.Ready = true
+			change.Tasks[0].Status = "Done"
+			change.Tasks[0].Progress.Done = 100
+		}
+
+		writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, change)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	client.pollInterval = time.Millisecond
+
+	reports := 0
+	change, err := client.WaitChange(context.Background(), "42", func(*Change) { reports++ })
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if change.Status != ChangeStatusDone {
+		t.Errorf("Expected status %q, got: %q", ChangeStatusDone, change.Status)
+	}
+	if reports != 3 {
+		t.Errorf("Expected 3 progress reports, got: %d", reports)
+	}
+}
+
+func TestWaitChange_Error(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, Change{
+			ID:     "42",
+			Status: ChangeStatusError,
+			Ready:  true,
+			Err:    "cannot perform the following tasks:\n- Download snap \"jq\" (no space left on device)",
+		})
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	change, err := client.WaitChange(context.Background(), "42", nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if change.Status != ChangeStatusError {
+		t.Errorf("Expected status %q, got: %q", ChangeStatusError, change.Status)
+	}
+	if change.Err == "" {
+		t.Error("Expected change to carry snapd's error")
+	}
+}
+
+func TestConnect_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/interfaces" {
+			t.Errorf("Expected path '/v2/interfaces', got: %s", r.URL.Path)
+		}
+
+		var body interfaceAction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		expected := interfaceAction{
+			Action: "connect",
+			Plugs:  []PlugRef{{Snap: "jhack", Plug: "dot-local-share-juju"}},
+			Slots:  []SlotRef{{}},
+		}
+		if !reflect.DeepEqual(body, expected) {
+			t.Errorf("Expected body %+v, got: %+v", expected, body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "9"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	id, err := client.Connect(context.Background(), PlugRef{Snap: "jhack", Plug: "dot-local-share-juju"}, SlotRef{})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if id != "9" {
+		t.Errorf("Expected change ID '9', got: %s", id)
+	}
+}
+
+func TestConnections_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/connections" {
+			t.Errorf("Expected path '/v2/connections', got: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("snap") != "jhack" {
+			t.Errorf("Expected snap query 'jhack', got: %s", r.URL.Query().Get("snap"))
+		}
+
+		writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, Connections{
+			Established: []Connection{{
+				Interface: "personal-files",
+				Plug:      PlugRef{Snap: "jhack", Plug: "dot-local-share-juju"},
+				Slot:      SlotRef{Snap: "snapd", Slot: "dot-local-share-juju"},
+				Manual:    true,
+			}},
+		})
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	connections, err := client.Connections(context.Background(), "jhack")
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(connections.Established) != 1 {
+		t.Fatalf("Expected 1 connection, got: %d", len(connections.Established))
+	}
+	if connections.Established[0].Plug.Plug != "dot-local-share-juju" {
+		t.Errorf("Expected plug 'dot-local-share-juju', got: %s", connections.Established[0].Plug.Plug)
+	}
+}
+
+func TestConf_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/snaps/lxd/conf" {
+			t.Errorf("Expected path '/v2/snaps/lxd/conf', got: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("keys") != "ui.enable,daemon.debug" {
+			t.Errorf("Expected keys 'ui.enable,daemon.debug', got: %s", r.URL.Query().Get("keys"))
+		}
+
+		writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, map[string]any{"ui.enable": true})
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	conf, err := client.Conf(context.Background(), "lxd", []string{"ui.enable", "daemon.debug"})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if conf["ui.enable"] != true {
+		t.Errorf("Expected ui.enable to be true, got: %v", conf["ui.enable"])
+	}
+}
+
+func TestSetConf_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/v2/snaps/lxd/conf" {
+			t.Errorf("Expected 'PUT /v2/snaps/lxd/conf', got: %s %s", r.Method, r.URL.Path)
+		}
+
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		expected := map[string]any{"ui.enable": true, "daemon.debug": nil}
+		if !reflect.DeepEqual(expected, body) {
+			t.Errorf("Expected body %v, got: %v", expected, body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "11"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	id, err := client.SetConf(context.Background(), "lxd", map[string]any{"ui.enable": true, "daemon.debug": nil})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if id != "11" {
+		t.Errorf("Expected change ID '11', got: %s", id)
+	}
+}
+
+func TestAlias_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/aliases" {
+			t.Errorf("Expected path '/v2/aliases', got: %s", r.URL.Path)
+		}
+
+		var body aliasAction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		expected := aliasAction{Action: "alias", Snap: "k8s", App: "kubectl", Alias: "kubectl"}
+		if body != expected {
+			t.Errorf("Expected body %+v, got: %+v", expected, body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "12"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	_, err := client.Alias(context.Background(), "k8s", "kubectl", "kubectl")
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+}
+
+func TestSideload_Success(t *testing.T) {
+	snapFile := filepath.Join(t.TempDir(), "foo.snap")
+	if err := os.WriteFile(snapFile, []byte("snap contents"), 0644); err != nil {
+		t.Fatalf("failed to write snap file: %v", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/snaps" {
+			t.Errorf("Expected path '/v2/snaps', got: %s", r.URL.Path)
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("failed to parse multipart form: %v", err)
+		}
+
+		if r.FormValue("dangerous") != "true" || r.FormValue("devmode") != "true" {
+			t.Errorf("Expected dangerous and devmode fields, got: %v", r.MultipartForm.Value)
+		}
+		if r.FormValue("classic") != "" {
+			t.Errorf("Expected no classic field, got: %s", r.FormValue("classic"))
+		}
+
+		file, header, err := r.FormFile("snap")
+		if err != nil {
+			t.Fatalf("Expected snap file in form: %v", err)
+		}
+		contents, _ := io.ReadAll(file)
+		if header.Filename != "foo.snap" || string(contents) != "snap contents" {
+			t.Errorf("Unexpected snap file %s: %q", header.Filename, contents)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "13"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	id, err := client.Sideload(context.Background(), snapFile, &SideloadOptions{Devmode: true, Dangerous: true})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if id != "13" {
+		t.Errorf("Expected change ID '13', got: %s", id)
+	}
+}
+
+func TestSnapshot_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body snapAction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		if r.URL.Path != "/v2/snaps" || body.Action != "snapshot" || !reflect.DeepEqual(body.Snaps, []string{"lxd"}) {
+			t.Errorf("Unexpected request: %s %+v", r.URL.Path, body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "14"}, map[string]any{
+			"set-id":     7,
+			"snap-names": []string{"lxd"},
+		})
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	set, id, err := client.Snapshot(context.Background(), []string{"lxd"})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if set != 7 || id != "14" {
+		t.Errorf("Expected set 7 and change '14', got: %d, %s", set, id)
+	}
+}
+
+func TestSnapshotAction_Success(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/snapshots" {
+			t.Errorf("Expected path '/v2/snapshots', got: %s", r.URL.Path)
+		}
+
+		var body snapshotAction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+
+		expected := snapshotAction{Action: "restore", Set: 7, Snaps: []string{"lxd"}}
+		if !reflect.DeepEqual(expected, body) {
+			t.Errorf("Expected body %+v, got: %+v", expected, body)
+		}
+
+		writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "15"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	id, err := client.SnapshotAction(context.Background(), "restore", 7, []string{"lxd"})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if id != "15" {
+		t.Errorf("Expected change ID '15', got: %s", id)
+	}
+}
+
+func TestAck_Success(t *testing.T) {
+	assertFile := filepath.Join(t.TempDir(), "foo.assert")
+	if err := os.WriteFile(assertFile, []byte("type: snap-declaration"), 0644); err != nil {
+		t.Fatalf("failed to write assertion file: %v", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v2/assertions" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/x.ubuntu.assertion" {
+			t.Errorf("Unexpected content type: %s", r.Header.Get("Content-Type"))
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "type: snap-declaration" {
+			t.Errorf("Unexpected body: %q", body)
+		}
+
+		writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, nil)
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	err := client.Ack(context.Background(), assertFile)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+}
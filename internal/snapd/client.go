@@ -20,8 +20,9 @@ const (
 
 // Client is a minimal client for the snapd REST API.
 type Client struct {
-	httpClient *http.Client
-	socketPath string
+	httpClient   *http.Client
+	socketPath   string
+	pollInterval time.Duration
 }
 
 // Config configures the snapd client.
@@ -51,7 +52,8 @@ func NewClient(config *Config) *Client {
 			},
 			Timeout: 60 * time.Second,
 		},
-		socketPath: socketPath,
+		socketPath:   socketPath,
+		pollInterval: defaultPollInterval,
 	}
 }
 
@@ -61,6 +63,8 @@ type response struct {
 	Type   string          `json:"type"`
 	Status string          `json:"status"`
 	Result json.RawMessage `json:"result"`
+	// Change is the ID of the change created by an asynchronous request.
+	Change string `json:"change,omitempty"`
 }
 
 // Snap represents information about a snap from the snapd API.
@@ -75,6 +79,8 @@ type Snap struct {
 	TrackingChannel string                 `json:"tracking-channel"`
 	Confinement     string                 `json:"confinement"`
 	Channels        map[string]ChannelInfo `json:"channels"`
+	// Hold is the time until which refreshes of the snap are held, if they are.
+	Hold string `json:"hold,omitempty"`
 }
 
 // ChannelInfo represents channel-specific information for a snap.
@@ -142,7 +148,7 @@ func (c *Client) FindOne(ctx context.Context, name string) (*Snap, error) {
 	defer func() { _ = resp.Body.Close() }() // Read-only body; close error is not actionable
 
 	if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("snap not found: %s", name)
+		return nil, &Error{StatusCode: resp.StatusCode, Kind: ErrorKindSnapNotFound, Message: "snap not found: " + name}
 	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("unexpected HTTP status code: %d", resp.StatusCode)
@@ -164,7 +170,7 @@ func (c *Client) FindOne(ctx context.Context, name string) (*Snap, error) {
 	}
 
 	if len(snaps) == 0 {
-		return nil, fmt.Errorf("snap not found: %s", name)
+		return nil, &Error{StatusCode: resp.StatusCode, Kind: ErrorKindSnapNotFound, Message: "snap not found: " + name}
 	}
 
 	// Return the first matching snap.
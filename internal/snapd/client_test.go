@@ -3,6 +3,7 @@ package snapd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -209,6 +210,9 @@ func TestFindOne_NotFound(t *testing.T) {
 	if err.Error() != "snap not found: nonexistent" {
 		t.Errorf("Expected 'snap not found' error, got: %v", err)
 	}
+	if apiErr := (*Error)(nil); !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindSnapNotFound {
+		t.Errorf("Expected error of kind %q, got: %v", ErrorKindSnapNotFound, err)
+	}
 }
 
 func TestFindOne_EmptyResults(t *testing.T) {
@@ -241,4 +245,7 @@ func TestFindOne_EmptyResults(t *testing.T) {
 	if err.Error() != "snap not found: nonexistent" {
 		t.Errorf("Expected 'snap not found' error, got: %v", err)
 	}
+	if apiErr := (*Error)(nil); !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindSnapNotFound {
+		t.Errorf("Expected error of kind %q, got: %v", ErrorKindSnapNotFound, err)
+	}
 }
@@ -0,0 +1,193 @@
+package snapd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errorKindOptionNotFound is returned when a snap has no value for a configuration
+// option, such as the system's seed.loaded before seeding has finished.
+const errorKindOptionNotFound = "option-not-found"
+
+// SystemInfo describes the snapd installation and its refresh schedule.
+// See https://snapcraft.io/docs/snapd-rest-api#heading--system-info
+type SystemInfo struct {
+	Version string      `json:"version"`
+	Refresh RefreshInfo `json:"refresh"`
+}
+
+// RefreshInfo is the auto-refresh schedule of snapd. Times are in RFC3339 format,
+// and Hold is empty unless refreshes are held.
+type RefreshInfo struct {
+	Timer string `json:"timer"`
+	Hold  string `json:"hold"`
+	Last  string `json:"last"`
+	Next  string `json:"next"`
+}
+
+// ReadyOptions configures WaitReady.
+type ReadyOptions struct {
+	// Snaps are the snaps about to be changed. WaitReady waits for changes in
+	// progress that touch any of them.
+	Snaps []string
+	// HoldRefreshes, if set, holds auto-refreshes for this long once snapd is ready,
+	// so that none starts part way through provisioning.
+	HoldRefreshes time.Duration
+	// Report (if not nil) is called with a description of what is being waited for,
+	// each time it changes.
+	Report func(string)
+}
+
+// SystemInfo fetches details of the snapd installation.
+func (c *Client) SystemInfo(ctx context.Context) (*SystemInfo, error) {
+	resp, err := c.do(ctx, "GET", "/v2/system-info", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info SystemInfo
+	if err := json.Unmarshal(resp.Result, &info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal system info: %w", err)
+	}
+
+	return &info, nil
+}
+
+// Seeded reports whether snapd has finished seeding, as `snap wait system seed.loaded` does.
+func (c *Client) Seeded(ctx context.Context) (bool, error) {
+	conf, err := c.Conf(ctx, "system", []string{"seed.loaded"})
+	if apiErr := (*Error)(nil); errors.As(err, &apiErr) && apiErr.Kind == errorKindOptionNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	loaded, _ := conf["seed.loaded"].(bool)
+	return loaded, nil
+}
+
+// Changes lists the changes that are still in progress.
+func (c *Client) Changes(ctx context.Context) ([]Change, error) {
+	resp, err := c.do(ctx, "GET", "/v2/changes?select=in-progress", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	if err := json.Unmarshal(resp.Result, &changes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal changes: %w", err)
+	}
+
+	return changes, nil
+}
+
+// WaitReady waits until snapd has finished seeding, and until no change in progress
+// touches the specified snaps, or snapd and the base snaps that every snap depends
+// on. On a freshly booted machine, this avoids racing seeding or an auto-refresh.
+// If requested, auto-refreshes are then held for a while.
+func (c *Client) WaitReady(ctx context.Context, opts *ReadyOptions) error {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	reason := ""
+	for {
+		waitingFor, err := c.notReady(ctx, opts.Snaps)
+		if err != nil && ctx.Err() != nil && reason != "" {
+			// The deadline passed part way through checking again.
+			return fmt.Errorf("timed out waiting for %s: %w", reason, ctx.Err())
+		} else if err != nil {
+			return err
+		}
+		if waitingFor == "" {
+			break
+		}
+
+		if waitingFor != reason && opts.Report != nil {
+			opts.Report(waitingFor)
+		}
+		reason = waitingFor
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s: %w", reason, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	if opts.HoldRefreshes > 0 {
+		return c.holdRefreshes(ctx, time.Now().Add(opts.HoldRefreshes))
+	}
+
+	return nil
+}
+
+// notReady describes what snapd is busy with, or returns an empty string if it is ready.
+func (c *Client) notReady(ctx context.Context, snaps []string) (string, error) {
+	seeded, err := c.Seeded(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to check whether snapd is seeded: %w", err)
+	}
+	if !seeded {
+		return "snapd to finish seeding", nil
+	}
+
+	changes, err := c.Changes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list snapd changes: %w", err)
+	}
+
+	for _, change := range changes {
+		if change.Ready {
+			continue
+		}
+		for _, name := range change.Data.SnapNames {
+			if slices.Contains(snaps, name) || isBaseSnap(name) {
+				return fmt.Sprintf("change %s (%s) to complete", change.ID, change.Summary), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// holdRefreshes holds auto-refreshes until the specified time, unless they are
+// already held for longer.
+func (c *Client) holdRefreshes(ctx context.Context, until time.Time) error {
+	info, err := c.SystemInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get snapd system info: %w", err)
+	}
+
+	if held, err := time.Parse(time.RFC3339, info.Refresh.Hold); err == nil && !held.Before(until) {
+		return nil
+	}
+
+	id, err := c.SetConf(ctx, "system", map[string]any{"refresh.hold": until.Format(time.RFC3339)})
+	if err != nil {
+		return fmt.Errorf("failed to hold snap refreshes: %w", err)
+	}
+	if id == "" {
+		return nil
+	}
+
+	change, err := c.WaitChange(ctx, id, nil)
+	if err != nil {
+		return err
+	}
+	if change.Status != ChangeStatusDone {
+		return fmt.Errorf("failed to hold snap refreshes: %s", change.Err)
+	}
+
+	return nil
+}
+
+// isBaseSnap reports whether a snap is snapd itself or a base snap, which changes to
+// any other snap may need.
+func isBaseSnap(name string) bool {
+	return name == "snapd" || strings.HasPrefix(name, "core")
+}
@@ -0,0 +1,135 @@
+package snapd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitReady_WaitsForSeedingAndChanges(t *testing.T) {
+	var seedPolls, changePolls atomic.Int32
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/snaps/system/conf":
+			if seedPolls.Add(1) < 3 {
+				writeResponse(t, w, http.StatusBadRequest, response{Type: "error"}, Error{Kind: errorKindOptionNotFound})
+				return
+			}
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, map[string]any{"seed.loaded": true})
+		case "/v2/changes":
+			if r.URL.Query().Get("select") != "in-progress" {
+				t.Errorf("Expected in-progress changes to be selected, got: %s", r.URL.RawQuery)
+			}
+
+			changes := []Change{
+				{ID: "7", Summary: `Install "hello" snap`, Data: ChangeData{SnapNames: []string{"hello"}}},
+			}
+			if changePolls.Add(1) < 3 {
+				changes = append(changes, Change{ID: "8", Summary: "Auto-refresh snap \"lxd\"", Data: ChangeData{SnapNames: []string{"lxd"}}})
+			}
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, changes)
+		default:
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	client.pollInterval = time.Millisecond
+
+	reports := []string{}
+	err := client.WaitReady(context.Background(), &ReadyOptions{
+		Snaps:  []string{"lxd", "juju"},
+		Report: func(reason string) { reports = append(reports, reason) },
+	})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{"snapd to finish seeding", `change 8 (Auto-refresh snap "lxd") to complete`}
+	if strings.Join(reports, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected: %v, got: %v", expected, reports)
+	}
+}
+
+func TestWaitReady_Timeout(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/snaps/system/conf":
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, map[string]any{"seed.loaded": true})
+		case "/v2/changes":
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, []Change{
+				{ID: "3", Summary: "Refresh snapd", Data: ChangeData{SnapNames: []string{"snapd"}}},
+			})
+		}
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	client.pollInterval = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := client.WaitReady(ctx, &ReadyOptions{Snaps: []string{"jq"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "change 3 (Refresh snapd)") {
+		t.Fatalf("Expected the error to name the change, got: %v", err)
+	}
+}
+
+func TestWaitReady_HoldRefreshes(t *testing.T) {
+	var held map[string]any
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/snaps/system/conf" && r.Method == "GET":
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, map[string]any{"seed.loaded": true})
+		case r.URL.Path == "/v2/snaps/system/conf" && r.Method == "PUT":
+			if err := json.NewDecoder(r.Body).Decode(&held); err != nil {
+				t.Fatalf("failed to decode request body: %v", err)
+			}
+			writeResponse(t, w, http.StatusAccepted, response{Type: "async", Status: "Accepted", Change: "9"}, nil)
+		case r.URL.Path == "/v2/changes":
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, []Change{})
+		case r.URL.Path == "/v2/changes/9":
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, Change{ID: "9", Status: ChangeStatusDone, Ready: true})
+		case r.URL.Path == "/v2/system-info":
+			writeResponse(t, w, http.StatusOK, response{Type: "sync", Status: "OK"}, SystemInfo{Refresh: RefreshInfo{Timer: "00:00~24:00/4"}})
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	server, socketPath := createTestServer(t, handler)
+	defer server.Close()
+
+	client := NewClient(&Config{Socket: socketPath})
+	client.pollInterval = time.Millisecond
+
+	err := client.WaitReady(context.Background(), &ReadyOptions{HoldRefreshes: time.Hour})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	until, err := time.Parse(time.RFC3339, held["refresh.hold"].(string))
+	if err != nil {
+		t.Fatalf("Expected refresh.hold to be a timestamp, got: %v", held)
+	}
+	if d := time.Until(until); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("Expected refreshes to be held for an hour, got: %v", d)
+	}
+}
@@ -0,0 +1,56 @@
+package system
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// CACertificate is a PEM encoded CA certificate that concierge installs into
+// the trust stores it configures.
+type CACertificate struct {
+	// Name identifies the certificate. It is derived from the certificate's
+	// position in the config, and is safe to use both as a file name and as
+	// a snapd option name.
+	Name string
+	// PEM is the PEM encoded certificate.
+	PEM []byte
+}
+
+// LoadCACertificates resolves the CA certificates listed in the config. Each entry
+// is either an inline PEM encoded certificate, or the path to a file containing one.
+// An error is returned if any entry does not contain a valid certificate.
+func LoadCACertificates(w Worker, entries []string) ([]CACertificate, error) {
+	certs := []CACertificate{}
+
+	for i, entry := range entries {
+		var contents []byte
+		if strings.Contains(entry, "-----BEGIN") {
+			contents = []byte(entry)
+		} else {
+			b, err := w.ReadFile(entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate '%s': %w", entry, err)
+			}
+			contents = b
+		}
+
+		block, _ := pem.Decode(contents)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("CA certificate %d is not a PEM encoded certificate", i)
+		}
+
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to parse CA certificate %d: %w", i, err)
+		}
+
+		certs = append(certs, CACertificate{
+			Name: fmt.Sprintf("concierge-%d", i),
+			PEM:  append(bytes.TrimSpace(contents), '\n'),
+		})
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,51 @@
+package system
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoadCACertificates(t *testing.T) {
+	type test struct {
+		entries   []string
+		expectErr bool
+		expected  []string
+	}
+
+	tests := []test{
+		{entries: nil, expected: []string{}},
+		{entries: []string{MockCACertificate}, expected: []string{"concierge-0"}},
+		{entries: []string{"corp-ca.pem", MockCACertificate}, expected: []string{"concierge-0", "concierge-1"}},
+		{entries: []string{"missing.pem"}, expectErr: true},
+		{entries: []string{"not-a-cert.pem"}, expectErr: true},
+		{entries: []string{"-----BEGIN CERTIFICATE-----\nZm9v\n-----END CERTIFICATE-----\n"}, expectErr: true},
+	}
+
+	system := NewMockSystem()
+	system.MockFile("corp-ca.pem", []byte(MockCACertificate))
+	system.MockFile("not-a-cert.pem", []byte("hello"))
+
+	for _, tc := range tests {
+		certs, err := LoadCACertificates(system, tc.entries)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("expected error loading %v", tc.entries)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error loading %v: %v", tc.entries, err)
+		}
+
+		names := []string{}
+		for _, c := range certs {
+			names = append(names, c.Name)
+			if string(c.PEM) != MockCACertificate {
+				t.Fatalf("expected: %v, got: %v", MockCACertificate, string(c.PEM))
+			}
+		}
+		if !slices.Equal(tc.expected, names) {
+			t.Fatalf("expected: %v, got: %v", tc.expected, names)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package system
 
 import (
+	"fmt"
 	"log/slog"
 	"os/exec"
 	"regexp"
@@ -30,8 +31,27 @@ type Command struct {
 	// executable, which works both for plain shell invocations and for commands
 	// run via `sudo`.
 	Env []string
+	// Dir, if set, is the working directory of the command, e.g. for tools such as
+	// `apt-get download` that write to the current directory.
+	Dir string
+	// Stdin, if set, is passed to the command on its standard input, e.g. for input
+	// that should not be left in a file or shown on the command line.
+	Stdin []byte
 }
 
+// CommandError is returned when a command fails, recording which command it was.
+type CommandError struct {
+	// Command is the command that failed, with any secrets redacted.
+	Command string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command '%s' failed: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
 // NewCommand constructs a command to be run as the current user/group.
 func NewCommand(executable string, args []string) *Command {
 	return &Command{
@@ -1,6 +1,8 @@
 package system
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -158,3 +160,21 @@ func TestCommandString(t *testing.T) {
 		}
 	}
 }
+
+func TestCommandError(t *testing.T) {
+	err := fmt.Errorf("failed to install snap: %w", &CommandError{Command: "snap install lxd", Err: ErrNotInstalled})
+
+	expected := "failed to install snap: command 'snap install lxd' failed: command not installed"
+	if err.Error() != expected {
+		t.Fatalf("expected: %v, got: %v", expected, err.Error())
+	}
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "snap install lxd" {
+		t.Fatalf("expected: %v, got: %v", "snap install lxd", cmdErr)
+	}
+
+	if !errors.Is(err, ErrNotInstalled) {
+		t.Fatalf("expected: %v, got: %v", ErrNotInstalled, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"time"
 )
 
 // ErrNotInstalled is returned by DryRunWorker when a read-only command's
@@ -46,16 +47,23 @@ func (d *DryRunWorker) runReadOnly(c *Command) ([]byte, error) {
 
 // Run prints the command that would be executed and returns success.
 // Read-only commands are delegated to the real system for accurate results.
+// Printed commands are redacted, so secrets never reach the dry-run output.
 // Note: Fprintln write errors are intentionally ignored throughout DryRunWorker
 // because dry-run output is best-effort and failures are not actionable.
 func (d *DryRunWorker) Run(c *Command) ([]byte, error) {
 	if c.ReadOnly {
 		return d.runReadOnly(c)
 	}
-	_, _ = fmt.Fprintln(d.out, c.CommandString())
+	_, _ = fmt.Fprintln(d.out, Redact(c.CommandString()))
 	return []byte{}, nil
 }
 
+// RunInteractive prints the command that would be run and returns success.
+func (d *DryRunWorker) RunInteractive(c *Command) error {
+	_, _ = fmt.Fprintln(d.out, Redact(c.CommandString()))
+	return nil
+}
+
 // WriteFile prints what file would be written and returns success.
 func (d *DryRunWorker) WriteFile(filePath string, contents []byte, perm os.FileMode) error {
 	_, _ = fmt.Fprintln(d.out, "# Write file:", filePath)
@@ -72,11 +80,42 @@ func (d *DryRunWorker) SnapInfo(snap string, channel string) (*SnapInfo, error)
 	return d.realSystem.SnapInfo(snap, channel)
 }
 
+// LocalSnapInfo delegates to real system for accurate conditional logic.
+func (d *DryRunWorker) LocalSnapInfo(snap string) (*SnapInfo, error) {
+	return d.realSystem.LocalSnapInfo(snap)
+}
+
 // SnapChannels delegates to real system for accurate conditional logic.
 func (d *DryRunWorker) SnapChannels(snap string) ([]string, error) {
 	return d.realSystem.SnapChannels(snap)
 }
 
+// SnapConnections delegates to real system for accurate conditional logic. A snap
+// that is not installed has no connections, since in a dry run it may only be
+// installed by an earlier (printed) step.
+func (d *DryRunWorker) SnapConnections(snap string) ([]*SnapConnection, error) {
+	connections, err := d.realSystem.SnapConnections(snap)
+	if errors.Is(err, ErrSnapNotInstalled) {
+		return []*SnapConnection{}, nil
+	}
+	return connections, err
+}
+
+// WaitSnapd prints the commands equivalent to waiting for snapd and returns success.
+func (d *DryRunWorker) WaitSnapd(snaps []string, hold time.Duration) error {
+	_, _ = fmt.Fprintln(d.out, "snap wait system seed.loaded")
+	if hold > 0 {
+		_, _ = fmt.Fprintln(d.out, "snap set system refresh.hold="+time.Now().Add(hold).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SnapChange prints the snap command equivalent to the change and returns success.
+func (d *DryRunWorker) SnapChange(op *SnapOp) error {
+	_, _ = fmt.Fprintln(d.out, Redact(op.Command().CommandString()))
+	return nil
+}
+
 // RemovePath prints what path would be removed and returns success.
 func (d *DryRunWorker) RemovePath(path string) error {
 	_, _ = fmt.Fprintln(d.out, "rm -rf", path)
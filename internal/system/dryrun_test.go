@@ -32,6 +32,24 @@ func TestDryRunWorkerAutoPrintsCommands(t *testing.T) {
 
 }
 
+func TestDryRunWorkerRedactsCommands(t *testing.T) {
+	RegisterSecret("hunter2-registry-password")
+	t.Cleanup(ResetSecrets)
+
+	var buf bytes.Buffer
+	drw := &DryRunWorker{realSystem: nil, out: &buf}
+
+	_, err := drw.Run(NewCommand("skopeo", []string{"login", "--password", "hunter2-registry-password", "registry.example.com"}))
+	if err != nil {
+		t.Fatalf("Run should not return error, got: %v", err)
+	}
+
+	expected := "skopeo login --password [REDACTED] registry.example.com\n"
+	if buf.String() != expected {
+		t.Fatalf("expected: %q, got: %q", expected, buf.String())
+	}
+}
+
 func TestDryRunWorkerAutoPrintsFileOperations(t *testing.T) {
 	var buf bytes.Buffer
 
@@ -124,3 +124,15 @@ func MkHomeSubdirectory(w Worker, subdirectory string) error {
 
 	return nil
 }
+
+// ParseOSRelease parses the KEY=value pairs of an os-release file.
+func ParseOSRelease(contents string) map[string]string {
+	fields := map[string]string{}
+	for line := range strings.SplitSeq(contents, "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok {
+			fields[k] = strings.Trim(v, `"'`)
+		}
+	}
+	return fields
+}
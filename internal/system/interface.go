@@ -3,6 +3,7 @@ package system
 import (
 	"os"
 	"os/user"
+	"time"
 )
 
 // Worker is an interface for a struct that can run commands on the underlying system.
@@ -12,6 +13,9 @@ type Worker interface {
 	User() *user.User
 	// Run takes a single command and runs it, returning the combined output and an error value.
 	Run(c *Command) ([]byte, error)
+	// RunInteractive runs a command attached to concierge's own standard input, output
+	// and error, returning once it exits.
+	RunInteractive(c *Command) error
 	// ReadFile reads a file with an arbitrary path from the system.
 	ReadFile(filePath string) ([]byte, error)
 	// WriteFile writes the given contents to the specified file path with the given permissions.
@@ -19,8 +23,19 @@ type Worker interface {
 	// SnapInfo returns information about a given snap, looking up details in the snap
 	// store using the snapd client API where necessary.
 	SnapInfo(snap string, channel string) (*SnapInfo, error)
+	// LocalSnapInfo returns information about a given snap as installed on the system,
+	// without consulting the snap store.
+	LocalSnapInfo(snap string) (*SnapInfo, error)
 	// SnapChannels returns the list of channels available for a given snap.
 	SnapChannels(snap string) ([]string, error)
+	// WaitSnapd waits until snapd is ready to make changes to the specified snaps,
+	// then holds auto-refreshes for the duration hold, if it is not zero.
+	WaitSnapd(snaps []string, hold time.Duration) error
+	// SnapChange asks snapd to make a change to one or more snaps, such as installing
+	// them or connecting their interfaces, and waits for the change to complete.
+	SnapChange(op *SnapOp) error
+	// SnapConnections returns the established interface connections involving a snap.
+	SnapConnections(snap string) ([]*SnapConnection, error)
 	// RemovePath recursively removes a path from the filesystem.
 	RemovePath(path string) error
 	// MkdirAll creates a directory and all parent directories with the specified permissions.
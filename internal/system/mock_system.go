@@ -4,17 +4,22 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // NewMockSystem constructs a new mock command
 func NewMockSystem() *MockSystem {
 	return &MockSystem{
 		CreatedFiles:     map[string]string{},
+		CommandInputs:    map[string]string{},
 		mockReturns:      map[string]MockCommandReturn{},
 		mockFiles:        map[string][]byte{},
 		mockSnapInfo:     map[string]*SnapInfo{},
 		mockSnapChannels: map[string][]string{},
+		mockSnapConns:    map[string]mockSnapConnections{},
 		mockPaths:        map[string]bool{},
 	}
 }
@@ -32,11 +37,14 @@ type MockSystem struct {
 	CreatedDirectories []string
 	Deleted            []string
 	RemovedPaths       []string
+	// CommandInputs maps each executed command that was given Stdin to that input.
+	CommandInputs map[string]string
 
 	mockFiles        map[string][]byte
 	mockReturns      map[string]MockCommandReturn
 	mockSnapInfo     map[string]*SnapInfo
 	mockSnapChannels map[string][]string
+	mockSnapConns    map[string]mockSnapConnections
 	mockPaths        map[string]bool
 
 	// Used to guard access to the ExecutedCommands list
@@ -69,11 +77,44 @@ func (r *MockSystem) MockSnapStoreLookup(name, channel string, classic, installe
 	return &Snap{Name: name, Channel: channel}
 }
 
+// MockSnapRevisions sets the installed and store revisions of a mocked snap, which
+// must first have been mocked with MockSnapStoreLookup.
+func (r *MockSystem) MockSnapRevisions(name, installed, store string) {
+	r.mockSnapInfo[name].Revision = installed
+	r.mockSnapInfo[name].StoreRevision = store
+}
+
+// MockSnapHeld marks a mocked snap, which must first have been mocked with
+// MockSnapStoreLookup, as having its refreshes held.
+func (r *MockSystem) MockSnapHeld(name string) {
+	r.mockSnapInfo[name].Held = true
+}
+
 // MockSnapChannels mocks the set of available channels for a snap in the store.
 func (r *MockSystem) MockSnapChannels(snap string, channels []string) {
 	r.mockSnapChannels[snap] = channels
 }
 
+// mockSnapConnections holds the mocked result of querying a snap's connections.
+type mockSnapConnections struct {
+	connections []*SnapConnection
+	err         error
+}
+
+// MockSnapConnections mocks the established connections of a snap, given in the
+// form accepted by `snap connect`, and an error to return when they are queried.
+func (r *MockSystem) MockSnapConnections(snap string, connections []string, err error) {
+	mock := mockSnapConnections{err: err}
+	for _, c := range connections {
+		conn, parseErr := ParseSnapConnection(c)
+		if parseErr != nil {
+			panic(parseErr)
+		}
+		mock.connections = append(mock.connections, conn)
+	}
+	r.mockSnapConns[snap] = mock
+}
+
 // User returns the user the system executes commands on behalf of.
 func (r *MockSystem) User() *user.User {
 	return &user.User{
@@ -97,6 +138,9 @@ func (r *MockSystem) Run(c *Command) ([]byte, error) {
 	cmd := c.CommandString()
 
 	r.ExecutedCommands = append(r.ExecutedCommands, cmd)
+	if c.Stdin != nil {
+		r.CommandInputs[cmd] = string(c.Stdin)
+	}
 	r.cmdMutex.Unlock()
 
 	val, ok := r.mockReturns[cmd]
@@ -106,11 +150,40 @@ func (r *MockSystem) Run(c *Command) ([]byte, error) {
 	return []byte{}, nil
 }
 
-// ReadFile takes a path and reads the content from the specified file.
+// RunInteractive records the command as an executed command, returning the error
+// mocked for it, if any.
+func (r *MockSystem) RunInteractive(c *Command) error {
+	_, err := r.Run(c)
+	return err
+}
+
+// WaitSnapd records `snap wait system seed.loaded` as an executed command, so that
+// waiting for snapd can be asserted on and mocked to fail.
+func (r *MockSystem) WaitSnapd(snaps []string, hold time.Duration) error {
+	_, err := r.Run(NewCommand("snap", []string{"wait", "system", "seed.loaded"}))
+	return err
+}
+
+// SnapChange records the snap command equivalent to the change as an executed
+// command, so that it can be asserted on and mocked like any other command.
+func (r *MockSystem) SnapChange(op *SnapOp) error {
+	output, err := r.Run(op.Command())
+	// The mocked output of a save is taken to be the ID of the snapshot set.
+	if err == nil && op.Action == SnapSave {
+		op.SetID = strings.TrimSpace(string(output))
+	}
+	return err
+}
+
+// ReadFile takes a path and reads the content from the specified file. Reading a
+// directory created with MkdirAll fails as it would on a real system.
 func (r *MockSystem) ReadFile(filePath string) ([]byte, error) {
+	if r.mockPaths[filePath] {
+		return nil, &os.PathError{Op: "read", Path: filePath, Err: syscall.EISDIR}
+	}
 	val, ok := r.mockFiles[filePath]
 	if !ok {
-		return nil, fmt.Errorf("file not found")
+		return nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
 	}
 	return val, nil
 }
@@ -135,6 +208,24 @@ func (r *MockSystem) SnapInfo(snap string, channel string) (*SnapInfo, error) {
 	}, nil
 }
 
+// LocalSnapInfo returns the installed details of a mocked snap, without the details
+// that would come from the snap store.
+func (r *MockSystem) LocalSnapInfo(snap string) (*SnapInfo, error) {
+	snapInfo, ok := r.mockSnapInfo[snap]
+	if !ok {
+		return &SnapInfo{}, nil
+	}
+
+	return &SnapInfo{
+		Installed:       snapInfo.Installed,
+		Active:          snapInfo.Active,
+		Classic:         snapInfo.Installed && snapInfo.Classic,
+		TrackingChannel: snapInfo.TrackingChannel,
+		Held:            snapInfo.Held,
+		Revision:        snapInfo.Revision,
+	}, nil
+}
+
 // SnapChannels returns the list of channels available for a given snap.
 func (r *MockSystem) SnapChannels(snap string) ([]string, error) {
 	val, ok := r.mockSnapChannels[snap]
@@ -145,6 +236,15 @@ func (r *MockSystem) SnapChannels(snap string) ([]string, error) {
 	return nil, fmt.Errorf("channels for snap '%s' not found", snap)
 }
 
+// SnapConnections returns the mocked connections of a snap, or none if not mocked.
+func (r *MockSystem) SnapConnections(snap string) ([]*SnapConnection, error) {
+	mock, ok := r.mockSnapConns[snap]
+	if !ok {
+		return []*SnapConnection{}, nil
+	}
+	return mock.connections, mock.err
+}
+
 // RemovePath recursively removes a path from the filesystem (mocked).
 func (r *MockSystem) RemovePath(path string) error {
 	r.RemovedPaths = append(r.RemovedPaths, path)
@@ -163,3 +263,17 @@ func (r *MockSystem) MkdirAll(path string, perm os.FileMode) error {
 func (r *MockSystem) ChownAll(path string, user *user.User) error {
 	return nil
 }
+
+// MockCACertificate is a valid, self-signed PEM encoded CA certificate for use in tests.
+const MockCACertificate = `-----BEGIN CERTIFICATE-----
+MIIBkDCCATWgAwIBAgIUY6eTvbTSiwJQwuRpEtshIm2Aq8EwCgYIKoZIzj0EAwIw
+HDEaMBgGA1UEAwwRQ29uY2llcmdlIFRlc3QgQ0EwIBcNMjYxMDE4MTM1MjM3WhgP
+MjEyNjA5MjQxMzUyMzdaMBwxGjAYBgNVBAMMEUNvbmNpZXJnZSBUZXN0IENBMFkw
+EwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEv1Nb2Tm6xF/hdMnZwaMOBlgNWICYVApq
+IYCslh5L3S4gCSfEdThcs2nuTvm1xqbGOZ58/ibf/5QV9ZENuf3zpKNTMFEwHQYD
+VR0OBBYEFNjGLWKgj97LNOMBKZi3ok6QBmWVMB8GA1UdIwQYMBaAFNjGLWKgj97L
+NOMBKZi3ok6QBmWVMA8GA1UdEwEB/wQFMAMBAf8wCgYIKoZIzj0EAwIDSQAwRgIh
+AIOb35tfXvqcSE45IW9/JUCUORds4PZmJ5VX+tq1D+FZAiEA3wFaM4pMoSiXZqdO
+CvjBt+OH4RIz6A5nMu2PPEszzcI=
+-----END CERTIFICATE-----
+`
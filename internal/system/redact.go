@@ -0,0 +1,84 @@
+package system
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// redactedPlaceholder is substituted for any secret value removed by Redact.
+const redactedPlaceholder = "[REDACTED]"
+
+// minSecretLength is the shortest value accepted by RegisterSecret. Masking
+// very short strings would mangle unrelated output (a password of "a" would
+// otherwise redact every "a" in every command), so they are ignored.
+const minSecretLength = 4
+
+// secrets holds the literal secret values registered for redaction, such as
+// image registry passwords taken from the config. It is package-level, like
+// cmdMutexes, because output is redacted from several independent call sites
+// (trace output, debug logs, dry-run output and security events).
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// secretPatterns match secret material that concierge never knows about up
+// front, but which commonly appears in command lines and command output.
+// Each pattern's first capture group (if any) is preserved, and the remainder
+// of the match is replaced with redactedPlaceholder.
+var secretPatterns = []*regexp.Regexp{
+	// PEM encoded private keys, e.g. in cloud credentials or TLS material.
+	regexp.MustCompile(`()-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	// Kubeconfig client keys and tokens, e.g. from `k8s kubectl config view --raw`, and
+	// other credentials in `key=value` or `key: value` form. The key must be a whole
+	// word, and a colon must be followed by a space, so that e.g. Juju secret URIs
+	// ("secret:...") are left alone.
+	regexp.MustCompile(`(?i)(\b(?:client-key-data|token|password|secret)["']?(?:=|:\s+)["']?)[^\s"',]+`),
+	// HTTP authorization headers, e.g. in containerd's hosts.toml.
+	regexp.MustCompile(`(?i)(Authorization["']?\s*[:=]\s*\[?\s*["']?(?:Basic|Bearer)\s+)[A-Za-z0-9+/=._~-]+`),
+	// Well-known access token formats (GitHub, GitLab).
+	regexp.MustCompile(`()\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|glpat-[A-Za-z0-9_-]{20,})`),
+}
+
+// RegisterSecret adds values that must never appear in concierge's output.
+// Empty values, and values shorter than minSecretLength, are ignored.
+func RegisterSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, v := range values {
+		if len(v) < minSecretLength || slices.Contains(secrets.values, v) {
+			continue
+		}
+		secrets.values = append(secrets.values, v)
+	}
+
+	// Replace longer values first, so that a secret which contains another
+	// registered secret is masked in its entirety.
+	slices.SortFunc(secrets.values, func(a, b string) int { return len(b) - len(a) })
+}
+
+// ResetSecrets forgets all registered secret values, e.g. between tests.
+func ResetSecrets() {
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values = nil
+}
+
+// Redact returns s with any registered secret values, and any text matching
+// a known secret pattern, replaced by a placeholder.
+func Redact(s string) string {
+	secrets.RLock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, redactedPlaceholder)
+	}
+	secrets.RUnlock()
+
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}"+redactedPlaceholder)
+	}
+
+	return s
+}
@@ -1,6 +1,7 @@
 package system
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -27,6 +28,7 @@ func NewSystem(trace bool) (*System, error) {
 		trace: trace,
 		user:  realUser,
 		snapd: snapd.NewClient(nil),
+		cache: newSnapCache(),
 	}, nil
 }
 
@@ -35,6 +37,7 @@ type System struct {
 	trace bool
 	user  *user.User
 	snapd *snapd.Client
+	cache *snapCache
 }
 
 // User returns a user struct containing details of the "real" user, which
@@ -43,6 +46,10 @@ func (s *System) User() *user.User { return s.user }
 
 // Run executes the command, returning the stdout/stderr where appropriate.
 func (s *System) Run(c *Command) ([]byte, error) {
+	// The snap CLI may change which snaps are installed.
+	if c.Executable == "snap" && !c.ReadOnly {
+		defer s.cache.invalidate(installedLookup)
+	}
 	return s.runOnce(c)
 }
 
@@ -63,22 +70,68 @@ func (s *System) runOnce(c *Command) ([]byte, error) {
 
 	commandString := c.CommandString()
 	cmd := exec.CommandContext(context.Background(), shell, "-c", commandString) //nolint:gosec // G204: concierge is a CLI tool designed to execute user-provided commands
+	cmd.Dir = c.Dir
+	if c.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(c.Stdin)
+	}
+
+	// Secrets (such as registry passwords) may be present in the command
+	// line, so only ever log the redacted form.
+	redactedCommand := Redact(commandString)
 
-	logger.Debug("Starting command", "command", commandString)
+	logger.Debug("Starting command", "command", redactedCommand)
 
 	start := time.Now()
 	output, err := cmd.CombinedOutput()
 
 	elapsed := time.Since(start)
-	logger.Debug("Finished command", "command", commandString, "elapsed", elapsed)
+	logger.Debug("Finished command", "command", redactedCommand, "elapsed", elapsed)
 
 	if s.trace || (err != nil && !c.IsExpectedError(output)) {
 		fmt.Print(generateTraceMessage(commandString, output))
 	}
 
-	s.logPrivilegedCommand(c, commandString, output, err, elapsed)
+	s.logPrivilegedCommand(c, redactedCommand, output, err, elapsed)
+
+	if err != nil {
+		return output, &CommandError{Command: redactedCommand, Err: err}
+	}
+	return output, nil
+}
+
+// RunInteractive runs a command attached to concierge's own standard input, output
+// and error, for commands that the user interacts with directly. Unlike Run, the
+// output is neither captured nor traced, and the command is not retried.
+func (s *System) RunInteractive(c *Command) error {
+	shell, err := getShellPath()
+	if err != nil {
+		return fmt.Errorf("unable to determine shell path to run command")
+	}
+
+	commandString := c.CommandString()
+	cmd := exec.CommandContext(context.Background(), shell, "-c", commandString) //nolint:gosec // G204: concierge is a CLI tool designed to execute user-provided commands
+	cmd.Dir = c.Dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	redactedCommand := Redact(commandString)
+	slog.Debug("Starting interactive command", "command", redactedCommand)
+
+	err = cmd.Run()
+
+	runAs := "root"
+	if c.User != "" {
+		runAs = c.User
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	securitylog.Emit(securitylog.EventAuthzAdmin, securitylog.UserID()+",exec", "interactive command executed",
+		"command", redactedCommand, "run_as", runAs, "outcome", outcome)
 
-	return output, err
+	return err
 }
 
 // logPrivilegedCommand emits an OWASP authz_admin security event for a
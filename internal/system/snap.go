@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/snapd"
 	retry "github.com/sethvargo/go-retry"
 )
@@ -20,6 +21,13 @@ type SnapInfo struct {
 	Active          bool
 	Classic         bool
 	TrackingChannel string
+	// Held reports whether refreshes of the installed snap are held.
+	Held bool
+	// Revision is the installed revision of the snap, if any.
+	Revision string
+	// StoreRevision is the revision of the snap in the store, at the requested
+	// channel if it is known, and otherwise at the snap's default channel.
+	StoreRevision string
 }
 
 // Snap represents a given snap on a given channel.
@@ -28,6 +36,23 @@ type Snap struct {
 	Channel     string
 	Revision    string
 	Connections []string
+	// After lists the snaps that must be installed before this one.
+	After []string
+	// Hold prevents the snap from being refreshed automatically.
+	Hold bool
+	// Devmode installs the snap in development mode.
+	Devmode bool
+	// Path, if set, is a local snap file to install instead of the store snap.
+	// Dangerous allows it to be installed without a signed assertion.
+	Path      string
+	Dangerous bool
+	// Config maps snap configuration options to their values, as for `snap set`.
+	Config map[string]string
+	// Aliases maps aliases to the apps of the snap they point to.
+	Aliases map[string]string
+	// RefreshPolicy decides whether the snap is refreshed if it is already
+	// installed. An empty policy always refreshes it.
+	RefreshPolicy config.RefreshPolicy
 }
 
 // NewSnap returns a new Snap package.
@@ -36,28 +61,55 @@ func NewSnap(name, channel string, connections []string) *Snap {
 }
 
 // NewSnapFromString returns a constructed snap instance, where the snap is
-// specified in shorthand form, i.e. `charmcraft/latest/edge`.
+// specified in shorthand form, i.e. `charmcraft/latest/edge`. A revision may
+// follow an `@`, and the `hold` and `devmode` flags may be appended with `+`,
+// e.g. `lxd/5.21/stable@33110+hold`.
 func NewSnapFromString(snap string) *Snap {
-	before, after, found := strings.Cut(snap, "/")
-	if found {
-		return NewSnap(before, after, []string{})
-	} else {
-		return NewSnap(before, "", []string{})
+	spec, flags, _ := strings.Cut(snap, "+")
+	spec, revision, _ := strings.Cut(spec, "@")
+	name, channel, _ := strings.Cut(spec, "/")
+
+	s := NewSnap(name, channel, []string{})
+	s.Revision = revision
+
+	for _, flag := range strings.Split(flags, "+") {
+		switch flag {
+		case "":
+		case "hold":
+			s.Hold = true
+		case "devmode":
+			s.Devmode = true
+		default:
+			slog.Warn("Ignoring unknown snap flag", "snap", name, "flag", flag)
+		}
 	}
+
+	return s
 }
 
 // SnapInfo returns information about a given snap, looking up details in the snap
 // store using the snapd client API where necessary.
 func (s *System) SnapInfo(snap string, channel string) (*SnapInfo, error) {
-	classic, err := s.snapIsClassic(snap, channel)
+	classic, storeRevision, err := s.snapStoreInfo(snap, channel)
 	if err != nil {
 		return nil, err
 	}
 
-	installed, active, trackingChannel := s.snapInstalledInfo(snap)
+	info := s.snapInstalledInfo(snap)
+	info.Classic = classic
+	info.StoreRevision = storeRevision
+
+	slog.Debug("Queried snapd API", "snap", snap, "installed", info.Installed, "active", info.Active, "classic", classic, "tracking", info.TrackingChannel, "revision", info.Revision, "held", info.Held, "store-revision", storeRevision)
+	return info, nil
+}
+
+// LocalSnapInfo returns information about a given snap as installed on the system,
+// without looking up any details in the snap store.
+func (s *System) LocalSnapInfo(snap string) (*SnapInfo, error) {
+	info := s.snapInstalledInfo(snap)
 
-	slog.Debug("Queried snapd API", "snap", snap, "installed", installed, "active", active, "classic", classic, "tracking", trackingChannel)
-	return &SnapInfo{Installed: installed, Active: active, Classic: classic, TrackingChannel: trackingChannel}, nil
+	slog.Debug("Queried snapd API", "snap", snap, "installed", info.Installed, "active", info.Active, "classic", info.Classic, "tracking", info.TrackingChannel, "revision", info.Revision, "held", info.Held)
+	return info, nil
 }
 
 // SnapChannels returns the list of channels available for a given snap.
@@ -67,17 +119,7 @@ func (s *System) SnapChannels(snap string) ([]string, error) {
 		return nil, err
 	}
 
-	snapInfo, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
-		snap, err := s.snapd.FindOne(ctx, snap)
-		if err != nil {
-			if strings.Contains(err.Error(), "snap not found") {
-				return nil, err
-			}
-			return nil, retry.RetryableError(err)
-
-		}
-		return snap, nil
-	})
+	snapInfo, err := s.storeSnap(snap)
 	if err != nil {
 		return nil, err
 	}
@@ -97,21 +139,14 @@ func (s *System) SnapChannels(snap string) ([]string, error) {
 }
 
 // snapInstalledInfo is a helper that reports if the snap is currently installed
-// and returns its tracking channel. The tracking channel is the channel the snap
-// is currently following (e.g., "latest/stable"). Returns empty string if the
-// snap is not installed or if the tracking channel cannot be determined.
-func (s *System) snapInstalledInfo(name string) (installed bool, active bool, trackingChannel string) {
-	snap, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
-		snap, err := s.snapd.Snap(ctx, name)
-		if err != nil && strings.Contains(err.Error(), "snap not installed") {
-			return snap, nil
-		} else if err != nil {
-			return nil, retry.RetryableError(err)
-		}
-		return snap, nil
-	})
+// and returns its tracking channel, revision, confinement and whether its
+// refreshes are held.
+// The tracking channel is the channel the snap is currently following (e.g.,
+// "latest/stable"). Returns empty details if the snap is not installed.
+func (s *System) snapInstalledInfo(name string) *SnapInfo {
+	snap, err := s.installedSnap(name)
 	if err != nil || snap == nil {
-		return false, false, ""
+		return &SnapInfo{}
 	}
 
 	if snap.Status == snapd.StatusActive || snap.Status == snapd.StatusInstalled {
@@ -119,35 +154,64 @@ func (s *System) snapInstalledInfo(name string) (installed bool, active bool, tr
 		if tc == "" {
 			tc = snap.Channel
 		}
-		return true, snap.Status == snapd.StatusActive, tc
+		return &SnapInfo{
+			Installed:       true,
+			Active:          snap.Status == snapd.StatusActive,
+			TrackingChannel: tc,
+			Revision:        snap.Revision,
+			Held:            snap.Hold != "",
+			Classic:         snap.Confinement == "classic",
+		}
 	}
 
-	return false, false, ""
+	return &SnapInfo{}
 }
 
-// snapIsClassic reports whether or not the snap at the tip of the specified channel uses
-// Classic confinement or not.
-func (s *System) snapIsClassic(name, channel string) (bool, error) {
-	snap, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
-		snap, err := s.snapd.FindOne(ctx, name)
-		if err != nil {
-			if strings.Contains(err.Error(), "snap not found") {
-				return nil, err
-			}
-			return nil, retry.RetryableError(err)
-		}
-		return snap, nil
-	})
+// snapStoreInfo reports whether or not the snap at the tip of the specified channel uses
+// Classic confinement, and the revision at the tip of that channel.
+func (s *System) snapStoreInfo(name, channel string) (bool, string, error) {
+	snap, err := s.storeSnap(name)
 	if err != nil {
-		return false, fmt.Errorf("failed to find snap: %w", err)
+		return false, "", fmt.Errorf("failed to find snap: %w", err)
 	}
 
 	c, ok := snap.Channels[channel]
+	if !ok {
+		c, ok = snap.Channels[NormalizeChannel(channel)]
+	}
 	if ok {
-		return c.Confinement == "classic", nil
+		return c.Confinement == "classic", c.Revision, nil
+	}
+
+	// The store revision is only known for the default channel.
+	revision := ""
+	if channel == "" {
+		revision = snap.Revision
+	}
+
+	return snap.Confinement == "classic", revision, nil
+}
+
+// snapRisks are the risk levels a snap channel may have.
+var snapRisks = []string{"stable", "candidate", "beta", "edge"}
+
+// NormalizeChannel expands a snap channel to its full "<track>/<risk>[/<branch>]"
+// form, e.g. "stable" to "latest/stable", and "5.21" to "5.21/stable", so that
+// channels can be compared. An empty channel is returned unchanged.
+func NormalizeChannel(channel string) string {
+	if channel == "" {
+		return ""
+	}
+
+	parts := strings.Split(channel, "/")
+	if slices.Contains(snapRisks, parts[0]) {
+		parts = append([]string{"latest"}, parts...)
+	}
+	if len(parts) == 1 {
+		parts = append(parts, "stable")
 	}
 
-	return snap.Confinement == "classic", nil
+	return strings.Join(parts, "/")
 }
 
 func (s *System) withRetry(f func(ctx context.Context) (*snapd.Snap, error)) (*snapd.Snap, error) {
@@ -0,0 +1,237 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/snapd"
+	retry "github.com/sethvargo/go-retry"
+	"golang.org/x/sync/singleflight"
+)
+
+// storeCacheFile is the path, relative to the user's home directory, of the on-disk
+// cache of snap store metadata.
+var storeCacheFile = path.Join(".cache", "concierge", "snap-store.json")
+
+// Kinds of snapd lookup held in the snap cache.
+const (
+	installedLookup = "installed"
+	storeLookup     = "store"
+)
+
+// snapCache holds the results of snapd lookups for the duration of a run, so that
+// each snap is only looked up (and retried) once, however many times and from however
+// many goroutines it is asked for. Store metadata may also be kept on disk between
+// runs, for up to ttl.
+type snapCache struct {
+	group   singleflight.Group
+	mu      sync.Mutex
+	results map[string]snapLookup
+	// generation counts invalidations, so that a lookup that was in flight when
+	// its results were invalidated does not store what may now be stale.
+	generation int
+
+	// ttl is how long store metadata is kept on disk. Zero disables the disk cache.
+	ttl time.Duration
+	// refresh ignores the metadata on disk, replacing it with fresh lookups.
+	refresh bool
+
+	diskMu sync.Mutex
+	disk   map[string]storeCacheEntry
+}
+
+// snapLookup is the result of a single snapd lookup.
+type snapLookup struct {
+	snap *snapd.Snap
+	err  error
+}
+
+// storeCacheEntry is the store metadata of a snap kept in the on-disk cache.
+type storeCacheEntry struct {
+	Fetched time.Time   `json:"fetched"`
+	Snap    *snapd.Snap `json:"snap"`
+}
+
+// newSnapCache constructs an empty snap cache, without a disk cache.
+func newSnapCache() *snapCache {
+	return &snapCache{results: map[string]snapLookup{}}
+}
+
+// lookup returns the cached result of a lookup of the given kind, calling fetch if
+// there is none. Concurrent lookups of the same snap share a single call to fetch.
+func (c *snapCache) lookup(kind, name string, fetch func() (*snapd.Snap, error)) (*snapd.Snap, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	key := kind + "/" + name
+
+	c.mu.Lock()
+	result, ok := c.results[key]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		return result.snap, result.err
+	}
+
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		snap, err := fetch()
+
+		c.mu.Lock()
+		if c.generation == generation {
+			c.results[key] = snapLookup{snap: snap, err: err}
+		}
+		c.mu.Unlock()
+
+		return snap, err
+	})
+
+	snap, _ := v.(*snapd.Snap)
+	return snap, err
+}
+
+// invalidate forgets the results of lookups of the given kind, e.g. once snaps
+// have been installed or removed.
+func (c *snapCache) invalidate(kind string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key := range c.results {
+		if strings.HasPrefix(key, kind+"/") {
+			delete(c.results, key)
+		}
+	}
+}
+
+// UseStoreCache keeps snap store metadata on disk for up to ttl, so that repeated
+// runs need not look it up again. If refresh is set, metadata already on disk is
+// ignored and replaced.
+func (s *System) UseStoreCache(ttl time.Duration, refresh bool) {
+	s.cache.ttl = ttl
+	s.cache.refresh = refresh
+}
+
+// installedSnap looks up the installed snap with the given name, once per run until
+// a snap change is made. A nil snap is returned if it is not installed.
+func (s *System) installedSnap(name string) (*snapd.Snap, error) {
+	return s.cache.lookup(installedLookup, name, func() (*snapd.Snap, error) {
+		return s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
+			snap, err := s.snapd.Snap(ctx, name)
+			if err != nil && strings.Contains(err.Error(), "snap not installed") {
+				return nil, nil
+			} else if err != nil {
+				return nil, retry.RetryableError(err)
+			}
+			return snap, nil
+		})
+	})
+}
+
+// storeSnap looks up the snap with the given name in the store, once per run, using
+// the on-disk cache where enabled.
+func (s *System) storeSnap(name string) (*snapd.Snap, error) {
+	return s.cache.lookup(storeLookup, name, func() (*snapd.Snap, error) {
+		if snap := s.readStoreCache(name); snap != nil {
+			slog.Debug("Using cached snap store metadata", "snap", name)
+			return snap, nil
+		}
+
+		snap, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
+			snap, err := s.snapd.FindOne(ctx, name)
+			if err != nil {
+				// A snap that is not in the store will not appear by retrying.
+				if apiErr := (*snapd.Error)(nil); errors.As(err, &apiErr) && apiErr.Kind == snapd.ErrorKindSnapNotFound {
+					return nil, err
+				}
+				return nil, retry.RetryableError(err)
+			}
+			return snap, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.writeStoreCache(name, snap)
+		return snap, nil
+	})
+}
+
+// readStoreCache returns the store metadata of a snap from the on-disk cache, or nil
+// if it is not there, has expired or the disk cache is disabled.
+func (s *System) readStoreCache(name string) *snapd.Snap {
+	if s.cache == nil || s.cache.ttl <= 0 || s.cache.refresh {
+		return nil
+	}
+
+	s.cache.diskMu.Lock()
+	defer s.cache.diskMu.Unlock()
+
+	entry, ok := s.loadStoreCache()[name]
+	if !ok || time.Since(entry.Fetched) > s.cache.ttl {
+		return nil
+	}
+	return entry.Snap
+}
+
+// writeStoreCache records the store metadata of a snap in the on-disk cache, if it is
+// enabled. Failures are logged, since the cache is only an optimisation.
+func (s *System) writeStoreCache(name string, snap *snapd.Snap) {
+	if s.cache == nil || s.cache.ttl <= 0 {
+		return
+	}
+
+	s.cache.diskMu.Lock()
+	defer s.cache.diskMu.Unlock()
+
+	entries := s.loadStoreCache()
+	entries[name] = storeCacheEntry{Fetched: time.Now(), Snap: snap}
+
+	// Expired entries are dropped, so that the file does not grow without bound.
+	for key, entry := range entries {
+		if time.Since(entry.Fetched) > s.cache.ttl {
+			delete(entries, key)
+		}
+	}
+
+	contents, err := json.Marshal(entries)
+	if err == nil {
+		err = WriteHomeDirFile(s, storeCacheFile, contents)
+	}
+	if err != nil {
+		slog.Debug("Failed to write snap store cache", "path", storeCacheFile, "error", err)
+	}
+}
+
+// loadStoreCache reads the on-disk cache the first time it is needed. The caller must
+// hold diskMu.
+func (s *System) loadStoreCache() map[string]storeCacheEntry {
+	if s.cache.disk != nil {
+		return s.cache.disk
+	}
+
+	s.cache.disk = map[string]storeCacheEntry{}
+
+	contents, err := ReadHomeDirFile(s, storeCacheFile)
+	if err != nil {
+		return s.cache.disk
+	}
+
+	err = json.Unmarshal(contents, &s.cache.disk)
+	if err != nil {
+		slog.Debug("Ignoring invalid snap store cache", "path", storeCacheFile, "error", err)
+		s.cache.disk = map[string]storeCacheEntry{}
+	}
+
+	return s.cache.disk
+}
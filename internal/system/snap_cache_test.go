@@ -0,0 +1,110 @@
+package system
+
+import (
+	"errors"
+	"os/user"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/snapd"
+)
+
+func TestSnapCacheDeduplicatesLookups(t *testing.T) {
+	cache := newSnapCache()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func() (*snapd.Snap, error) {
+		calls.Add(1)
+		<-release
+		return &snapd.Snap{Name: "lxd"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			snap, err := cache.lookup(storeLookup, "lxd", fetch)
+			if err != nil || snap.Name != "lxd" {
+				t.Errorf("expected: %v, got: %v, %v", "lxd", snap, err)
+			}
+		})
+	}
+
+	// Give the lookups time to join the one in flight before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	_, _ = cache.lookup(storeLookup, "lxd", fetch)
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected: %v, got: %v", 1, calls.Load())
+	}
+}
+
+func TestSnapCacheInvalidate(t *testing.T) {
+	cache := newSnapCache()
+
+	calls := 0
+	fetch := func() (*snapd.Snap, error) {
+		calls++
+		return nil, errors.New("store unavailable")
+	}
+
+	for range 3 {
+		_, err := cache.lookup(installedLookup, "lxd", fetch)
+		if err == nil {
+			t.Fatalf("expected an error")
+		}
+		_, _ = cache.lookup(storeLookup, "lxd", fetch)
+	}
+
+	// Failed lookups are cached too, so a flaky store is only retried once per run.
+	if calls != 2 {
+		t.Fatalf("expected: %v, got: %v", 2, calls)
+	}
+
+	cache.invalidate(installedLookup)
+	_, _ = cache.lookup(installedLookup, "lxd", fetch)
+	_, _ = cache.lookup(storeLookup, "lxd", fetch)
+
+	if calls != 3 {
+		t.Fatalf("expected: %v, got: %v", 3, calls)
+	}
+}
+
+func TestStoreCacheOnDisk(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	current.HomeDir = t.TempDir()
+
+	newSystem := func(ttl time.Duration, refresh bool) *System {
+		s := &System{user: current, cache: newSnapCache()}
+		s.UseStoreCache(ttl, refresh)
+		return s
+	}
+
+	s := newSystem(time.Hour, false)
+	s.writeStoreCache("lxd", &snapd.Snap{Name: "lxd", Revision: "33110"})
+
+	tests := []struct {
+		system   *System
+		expected *snapd.Snap
+	}{
+		{system: newSystem(time.Hour, false), expected: &snapd.Snap{Name: "lxd", Revision: "33110"}},
+		{system: newSystem(time.Hour, true), expected: nil},
+		{system: newSystem(0, false), expected: nil},
+		{system: newSystem(time.Nanosecond, false), expected: nil},
+	}
+
+	for _, tc := range tests {
+		got := tc.system.readStoreCache("lxd")
+		if (tc.expected == nil) != (got == nil) || (got != nil && got.Revision != tc.expected.Revision) {
+			t.Fatalf("expected: %v, got: %v", tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/concierge/internal/snapd"
+	retry "github.com/sethvargo/go-retry"
+)
+
+// snapChangeTimeout bounds how long concierge waits for a single snapd change,
+// such as installing a large snap on a slow connection.
+const snapChangeTimeout = 30 * time.Minute
+
+// snapConflictTimeout bounds how long a snap operation is retried while it
+// conflicts with another change in progress on the same snap.
+const snapConflictTimeout = 10 * time.Minute
+
+// snapdReadyTimeout bounds how long concierge waits for snapd to finish seeding, and
+// for changes already in progress, before making any snap changes of its own.
+const snapdReadyTimeout = 10 * time.Minute
+
+// Snap actions understood by SnapChange.
+const (
+	SnapInstall    = "install"
+	SnapRefresh    = "refresh"
+	SnapRemove     = "remove"
+	SnapEnable     = "enable"
+	SnapDisable    = "disable"
+	SnapConnect    = "connect"
+	SnapDisconnect = "disconnect"
+	SnapHold       = "hold"
+	SnapUnhold     = "unhold"
+	SnapSet        = "set"
+	SnapUnset      = "unset"
+	SnapAlias      = "alias"
+	SnapUnalias    = "unalias"
+	SnapSave       = "save"
+	SnapRestore    = "restore"
+	SnapForget     = "forget"
+	SnapAck        = "ack"
+)
+
+// SnapOp describes a change that snapd should make to one or more snaps.
+type SnapOp struct {
+	// Action is one of the Snap* action constants.
+	Action string
+	// Snaps are the snaps to act on. Acting on more than one snap at once is only
+	// supported for install, refresh and remove, and without any options.
+	Snaps []string
+	// Channel, Revision, Classic and Devmode are options for install and refresh.
+	Channel  string
+	Revision string
+	Classic  bool
+	Devmode  bool
+	// Path installs the snap from a local file rather than the store. Dangerous
+	// allows the file to be installed without a signed assertion. For ack, Path
+	// is the assertion file to add.
+	Path      string
+	Dangerous bool
+	// Purge removes a snap without saving a snapshot of its data.
+	Purge bool
+	// Config maps configuration options to set to their values, given as for
+	// `snap set`. Keys are the configuration options to unset.
+	Config map[string]string
+	Keys   []string
+	// App and Alias are the snap's app and the alias to create for it. Only
+	// Alias is needed to remove an alias.
+	App   string
+	Alias string
+	// Plug and Slot are the endpoints for connect and disconnect, in the form
+	// accepted by `snap connect`, e.g. "jhack:dot-local-share-juju". An empty
+	// Slot lets snapd choose a matching slot.
+	Plug string
+	Slot string
+	// SetID identifies the snapshot set to restore or forget. It is set by
+	// SnapChange to the ID of the snapshot set created by a save.
+	SetID string
+}
+
+// Command returns the `snap` command equivalent to the operation. It is used for
+// dry-run output, logging and tests, rather than being executed.
+func (o *SnapOp) Command() *Command {
+	args := []string{o.Action}
+
+	switch o.Action {
+	case SnapConnect, SnapDisconnect:
+		args = append(args, o.Plug)
+		if o.Slot != "" {
+			args = append(args, o.Slot)
+		}
+		return NewCommand("snap", args)
+	case SnapHold, SnapUnhold:
+		return NewCommand("snap", append([]string{"refresh", "--" + o.Action}, o.Snaps...))
+	case SnapSet:
+		args = append(args, o.Snaps...)
+		for _, key := range slices.Sorted(maps.Keys(o.Config)) {
+			args = append(args, key+"="+o.Config[key])
+		}
+		return NewCommand("snap", args)
+	case SnapUnset:
+		return NewCommand("snap", append(append(args, o.Snaps...), o.Keys...))
+	case SnapAlias:
+		return NewCommand("snap", append(args, o.Snaps[0]+"."+o.App, o.Alias))
+	case SnapUnalias:
+		return NewCommand("snap", append(args, o.Alias))
+	case SnapSave:
+		return NewCommand("snap", append(args, o.Snaps...))
+	case SnapRestore:
+		return NewCommand("snap", append(append(args, o.SetID), o.Snaps...))
+	case SnapForget:
+		return NewCommand("snap", append(args, o.SetID))
+	case SnapAck:
+		return NewCommand("snap", append(args, o.Path))
+	}
+
+	if o.Path != "" {
+		args = append(args, o.Path)
+	} else {
+		args = append(args, o.Snaps...)
+	}
+
+	if o.Channel != "" {
+		args = append(args, "--channel", o.Channel)
+	}
+	if o.Revision != "" {
+		args = append(args, "--revision", o.Revision)
+	}
+	if o.Classic {
+		args = append(args, "--classic")
+	}
+	if o.Devmode {
+		args = append(args, "--devmode")
+	}
+	if o.Dangerous {
+		args = append(args, "--dangerous")
+	}
+	if o.Purge {
+		args = append(args, "--purge")
+	}
+
+	return NewCommand("snap", args)
+}
+
+// WaitSnapd waits for snapd to finish seeding, and for changes in progress that touch
+// the specified snaps, such as an auto-refresh, to complete. Auto-refreshes are then
+// held for the duration hold, if it is not zero.
+func (s *System) WaitSnapd(snaps []string, hold time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapdReadyTimeout)
+	defer cancel()
+
+	err := s.snapd.WaitReady(ctx, &snapd.ReadyOptions{
+		Snaps:         snaps,
+		HoldRefreshes: hold,
+		Report: func(reason string) {
+			slog.Info("Waiting for snapd", "for", reason)
+		},
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("snapd was not ready after %s: %w", snapdReadyTimeout, err)
+	}
+	return err
+}
+
+// SnapChange asks snapd to carry out the operation via its REST API, and waits for
+// the resulting change to complete. Operations that conflict with a change already
+// in progress are retried. If the change fails, snapd's own error is returned.
+func (s *System) SnapChange(op *SnapOp) error {
+	cmd := op.Command()
+	commandString := Redact(cmd.CommandString())
+
+	slog.Debug("Starting snapd change", "command", commandString)
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapChangeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	summary, err := s.runSnapChange(ctx, op)
+	elapsed := time.Since(start)
+
+	// Even a failed change may have installed or removed snaps.
+	s.cache.invalidate(installedLookup)
+
+	slog.Debug("Finished snapd change", "command", commandString, "elapsed", elapsed)
+
+	var output []byte
+	if err != nil {
+		output = []byte(err.Error())
+	} else {
+		output = []byte(summary)
+	}
+
+	if s.trace || err != nil {
+		fmt.Print(generateTraceMessage(cmd.CommandString(), output))
+	}
+
+	s.logPrivilegedCommand(cmd, commandString, output, err, elapsed)
+
+	if err != nil {
+		return &CommandError{Command: commandString, Err: err}
+	}
+	return nil
+}
+
+// runSnapChange submits the operation to snapd, and waits for the change to complete.
+// It returns a summary of the change.
+func (s *System) runSnapChange(ctx context.Context, op *SnapOp) (string, error) {
+	backoff := retry.WithMaxDuration(snapConflictTimeout, retry.NewExponential(1*time.Second))
+
+	id, err := retry.DoValue(ctx, backoff, func(ctx context.Context) (string, error) {
+		id, err := s.submitSnapOp(ctx, op)
+
+		var apiErr *snapd.Error
+		if errors.As(err, &apiErr) && apiErr.Kind == snapd.ErrorKindChangeConflict {
+			slog.Debug("Snap operation conflicts with a change in progress, retrying", "snaps", op.Snaps, "error", err)
+			return "", retry.RetryableError(err)
+		}
+
+		return id, err
+	})
+	if err != nil {
+		var apiErr *snapd.Error
+		if errors.As(err, &apiErr) && isBenignSnapError(op.Action, apiErr.Kind) {
+			slog.Debug("Snap operation not needed", "action", op.Action, "snaps", op.Snaps, "reason", apiErr.Message)
+			return apiErr.Message, nil
+		}
+		return "", err
+	}
+
+	// snapd completed the request synchronously, so there's no change to wait for.
+	if id == "" {
+		return "", nil
+	}
+
+	change, err := s.snapd.WaitChange(ctx, id, newChangeReporter())
+	if err != nil {
+		return "", err
+	}
+
+	if change.Status != snapd.ChangeStatusDone {
+		return "", fmt.Errorf("snapd change %s failed: %s", change.ID, strings.TrimSpace(change.Err))
+	}
+
+	return change.Summary, nil
+}
+
+// submitSnapOp sends the operation to the relevant snapd API endpoint.
+func (s *System) submitSnapOp(ctx context.Context, op *SnapOp) (string, error) {
+	switch op.Action {
+	case SnapSave:
+		set, id, err := s.snapd.Snapshot(ctx, op.Snaps)
+		if err != nil {
+			return "", err
+		}
+		op.SetID = strconv.FormatUint(set, 10)
+		return id, nil
+	case SnapRestore, SnapForget:
+		set, err := strconv.ParseUint(op.SetID, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid snapshot set ID '%s': %w", op.SetID, err)
+		}
+		return s.snapd.SnapshotAction(ctx, op.Action, set, op.Snaps)
+	case SnapUnalias:
+		return s.snapd.Unalias(ctx, op.Alias)
+	case SnapAck:
+		return "", s.snapd.Ack(ctx, op.Path)
+	case SnapConnect, SnapDisconnect:
+		plug, slot, err := parseConnection(op.Plug, op.Slot)
+		if err != nil {
+			return "", err
+		}
+		if op.Action == SnapConnect {
+			return s.snapd.Connect(ctx, plug, slot)
+		}
+		return s.snapd.Disconnect(ctx, plug, slot)
+	}
+
+	if len(op.Snaps) > 1 {
+		return s.snapd.SnapActionMany(ctx, op.Action, op.Snaps)
+	}
+
+	if len(op.Snaps) == 0 {
+		return "", fmt.Errorf("no snaps specified for '%s'", op.Action)
+	}
+
+	name := op.Snaps[0]
+
+	switch op.Action {
+	case SnapHold:
+		return s.snapd.SnapAction(ctx, op.Action, name, &snapd.SnapOptions{Time: "forever", HoldLevel: "general"})
+	case SnapSet, SnapUnset:
+		return s.snapd.SetConf(ctx, name, snapConf(op))
+	case SnapAlias:
+		return s.snapd.Alias(ctx, name, op.App, op.Alias)
+	}
+
+	if op.Path != "" {
+		return s.snapd.Sideload(ctx, op.Path, &snapd.SideloadOptions{
+			Classic:   op.Classic,
+			Devmode:   op.Devmode,
+			Dangerous: op.Dangerous,
+		})
+	}
+
+	return s.snapd.SnapAction(ctx, op.Action, name, &snapd.SnapOptions{
+		Channel:  op.Channel,
+		Revision: op.Revision,
+		Classic:  op.Classic,
+		Devmode:  op.Devmode,
+		Purge:    op.Purge,
+	})
+}
+
+// snapConf converts the options of a set or unset operation into a snapd configuration
+// patch. As with `snap set`, values that are valid JSON are set as JSON, and any other
+// value as a string. Unset options map to nil.
+func snapConf(op *SnapOp) map[string]any {
+	conf := map[string]any{}
+
+	for key, value := range op.Config {
+		if json.Valid([]byte(value)) {
+			conf[key] = json.RawMessage(value)
+		} else {
+			conf[key] = value
+		}
+	}
+
+	for _, key := range op.Keys {
+		conf[key] = nil
+	}
+
+	return conf
+}
+
+// isBenignSnapError reports whether an error from snapd means that the operation
+// was simply not needed, matching the behaviour of the snap CLI.
+func isBenignSnapError(action, kind string) bool {
+	switch action {
+	case SnapInstall:
+		return kind == snapd.ErrorKindAlreadyInstalled
+	case SnapRefresh:
+		return kind == snapd.ErrorKindNoUpdateAvailable
+	case SnapRemove:
+		return kind == snapd.ErrorKindNotInstalled
+	default:
+		return false
+	}
+}
+
+// parseConnection converts plug and slot endpoints in `snap connect` form into
+// snapd API references. A slot given as ":<slot>", or omitted, refers to the
+// system snap.
+func parseConnection(plug, slot string) (snapd.PlugRef, snapd.SlotRef, error) {
+	plugSnap, plugName, ok := strings.Cut(plug, ":")
+	if !ok || plugSnap == "" || plugName == "" {
+		return snapd.PlugRef{}, snapd.SlotRef{}, fmt.Errorf("invalid plug '%s', expected <snap>:<plug>", plug)
+	}
+
+	slotRef := snapd.SlotRef{}
+	if slot != "" {
+		slotSnap, slotName, found := strings.Cut(slot, ":")
+		if found {
+			slotRef = snapd.SlotRef{Snap: slotSnap, Slot: slotName}
+		} else {
+			// A bare name refers to a snap, whose slot snapd picks.
+			slotRef = snapd.SlotRef{Snap: slotSnap}
+		}
+	}
+
+	return snapd.PlugRef{Snap: plugSnap, Plug: plugName}, slotRef, nil
+}
+
+// newChangeReporter returns a function that logs the progress of a change's tasks
+// as they start, finish and make progress, e.g. while downloading a snap.
+func newChangeReporter() func(*snapd.Change) {
+	seen := map[string]string{}
+
+	return func(change *snapd.Change) {
+		for _, task := range change.Tasks {
+			progress := ""
+			if task.Progress.Total > 1 {
+				progress = fmt.Sprintf("%d%%", task.Progress.Done*100/task.Progress.Total)
+			}
+
+			state := task.Status + progress
+			if seen[task.ID] == state {
+				continue
+			}
+			seen[task.ID] = state
+
+			slog.Debug("snapd task", "change", change.ID, "task", task.Summary, "status", task.Status, "progress", progress)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package system
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/canonical/concierge/internal/snapd"
+)
+
+func TestSnapOpCommand(t *testing.T) {
+	type test struct {
+		op       *SnapOp
+		expected string
+	}
+
+	tests := []test{
+		{
+			op:       &SnapOp{Action: SnapInstall, Snaps: []string{"jq", "yq"}},
+			expected: "snap install jq yq",
+		},
+		{
+			op:       &SnapOp{Action: SnapRefresh, Snaps: []string{"juju"}, Channel: "3.6/stable", Revision: "30000", Classic: true},
+			expected: "snap refresh juju --channel 3.6/stable --revision 30000 --classic",
+		},
+		{
+			op:       &SnapOp{Action: SnapRemove, Snaps: []string{"jq"}, Purge: true},
+			expected: "snap remove jq --purge",
+		},
+		{
+			op:       &SnapOp{Action: SnapConnect, Plug: "jhack:dot-local-share-juju"},
+			expected: "snap connect jhack:dot-local-share-juju",
+		},
+		{
+			op:       &SnapOp{Action: SnapConnect, Plug: "k8s:home", Slot: ":home"},
+			expected: "snap connect k8s:home :home",
+		},
+		{
+			op:       &SnapOp{Action: SnapInstall, Snaps: []string{"foo"}, Path: "/tmp/foo.snap", Dangerous: true, Devmode: true},
+			expected: "snap install /tmp/foo.snap --devmode --dangerous",
+		},
+		{
+			op:       &SnapOp{Action: SnapHold, Snaps: []string{"lxd"}},
+			expected: "snap refresh --hold lxd",
+		},
+		{
+			op:       &SnapOp{Action: SnapUnhold, Snaps: []string{"lxd"}},
+			expected: "snap refresh --unhold lxd",
+		},
+		{
+			op:       &SnapOp{Action: SnapSet, Snaps: []string{"lxd"}, Config: map[string]string{"ui.enable": "true", "daemon.debug": "false"}},
+			expected: "snap set lxd daemon.debug=false ui.enable=true",
+		},
+		{
+			op:       &SnapOp{Action: SnapUnset, Snaps: []string{"lxd"}, Keys: []string{"ui.enable"}},
+			expected: "snap unset lxd ui.enable",
+		},
+		{
+			op:       &SnapOp{Action: SnapAlias, Snaps: []string{"k8s"}, App: "kubectl", Alias: "kubectl"},
+			expected: "snap alias k8s.kubectl kubectl",
+		},
+		{
+			op:       &SnapOp{Action: SnapUnalias, Alias: "kubectl"},
+			expected: "snap unalias kubectl",
+		},
+		{
+			op:       &SnapOp{Action: SnapSave, Snaps: []string{"lxd"}},
+			expected: "snap save lxd",
+		},
+		{
+			op:       &SnapOp{Action: SnapRestore, Snaps: []string{"lxd"}, SetID: "7"},
+			expected: "snap restore 7 lxd",
+		},
+		{
+			op:       &SnapOp{Action: SnapForget, SetID: "7"},
+			expected: "snap forget 7",
+		},
+		{
+			op:       &SnapOp{Action: SnapAck, Path: "/srv/artifacts/lxd_33110.assert"},
+			expected: "snap ack /srv/artifacts/lxd_33110.assert",
+		},
+	}
+
+	for _, tc := range tests {
+		got := tc.op.Command().CommandString()
+		if got != tc.expected {
+			t.Fatalf("expected: %v, got: %v", tc.expected, got)
+		}
+	}
+}
+
+func TestParseConnection(t *testing.T) {
+	type test struct {
+		plug, slot   string
+		expectedPlug snapd.PlugRef
+		expectedSlot snapd.SlotRef
+		expectErr    bool
+	}
+
+	tests := []test{
+		{
+			plug:         "jhack:dot-local-share-juju",
+			expectedPlug: snapd.PlugRef{Snap: "jhack", Plug: "dot-local-share-juju"},
+		},
+		{
+			plug:         "jhack:ssh-read",
+			slot:         ":ssh-keys",
+			expectedPlug: snapd.PlugRef{Snap: "jhack", Plug: "ssh-read"},
+			expectedSlot: snapd.SlotRef{Slot: "ssh-keys"},
+		},
+		{
+			plug:         "charm:lxd",
+			slot:         "lxd",
+			expectedPlug: snapd.PlugRef{Snap: "charm", Plug: "lxd"},
+			expectedSlot: snapd.SlotRef{Snap: "lxd"},
+		},
+		{plug: "jhack", expectErr: true},
+	}
+
+	for _, tc := range tests {
+		plug, slot, err := parseConnection(tc.plug, tc.slot)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("expected error for plug '%s'", tc.plug)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plug != tc.expectedPlug || slot != tc.expectedSlot {
+			t.Fatalf("expected: %v %v, got: %v %v", tc.expectedPlug, tc.expectedSlot, plug, slot)
+		}
+	}
+}
+
+func TestIsBenignSnapError(t *testing.T) {
+	if !isBenignSnapError(SnapInstall, snapd.ErrorKindAlreadyInstalled) {
+		t.Fatal("expected already-installed to be benign for install")
+	}
+	if !isBenignSnapError(SnapRemove, snapd.ErrorKindNotInstalled) {
+		t.Fatal("expected not-installed to be benign for remove")
+	}
+	if isBenignSnapError(SnapConnect, snapd.ErrorKindChangeConflict) {
+		t.Fatal("expected change conflict not to be benign")
+	}
+}
+
+func TestSnapConf(t *testing.T) {
+	conf := snapConf(&SnapOp{
+		Action: SnapSet,
+		Config: map[string]string{"count": "3", "name": "foo", "obj": `{"a": 1}`},
+		Keys:   []string{"old"},
+	})
+
+	if string(conf["count"].(json.RawMessage)) != "3" {
+		t.Fatalf("expected count to be set as JSON, got: %#v", conf["count"])
+	}
+	if conf["name"] != "foo" {
+		t.Fatalf("expected name to be set as a string, got: %#v", conf["name"])
+	}
+	if _, ok := conf["obj"].(json.RawMessage); !ok {
+		t.Fatalf("expected obj to be set as JSON, got: %#v", conf["obj"])
+	}
+	if v, ok := conf["old"]; !ok || v != nil {
+		t.Fatalf("expected old to be unset, got: %#v", v)
+	}
+}
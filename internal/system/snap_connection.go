@@ -0,0 +1,154 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/snapd"
+)
+
+// ErrSnapNotInstalled is returned when querying the connections of a snap that
+// is not installed.
+var ErrSnapNotInstalled = errors.New("snap not installed")
+
+// snapNameRegex and interfaceNameRegex match valid snap names and valid plug or
+// slot names respectively, as defined by snapd.
+var (
+	snapNameRegex      = regexp.MustCompile(`^(?:[a-z0-9]+-?)*[a-z](?:-?[a-z0-9])*$`)
+	interfaceNameRegex = regexp.MustCompile(`^[a-z](?:-?[a-z0-9])*$`)
+)
+
+// systemSnapNames are the names by which snapd may refer to the snap providing
+// the system's slots.
+var systemSnapNames = []string{"", "system", "snapd", "core"}
+
+// SnapConnection is a connection between a snap's plug and a slot, as given to
+// `snap connect`: "<snap>:<plug> [<snap>][:<slot>]".
+type SnapConnection struct {
+	PlugSnap string
+	Plug     string
+	// SlotSnap is the snap providing the slot. An empty SlotSnap refers to the
+	// system snap when Slot is set, and otherwise lets snapd choose the slot.
+	SlotSnap string
+	Slot     string
+}
+
+// ParseSnapConnection parses a connection string in the form accepted by `snap connect`,
+// validating the snap, plug and slot names.
+func ParseSnapConnection(connection string) (*SnapConnection, error) {
+	fields := strings.Fields(connection)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty snap connection string")
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("too many arguments in snap connection string '%s'", connection)
+	}
+
+	plugSnap, plug, ok := strings.Cut(fields[0], ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid plug '%s' in snap connection '%s', expected <snap>:<plug>", fields[0], connection)
+	}
+
+	c := &SnapConnection{PlugSnap: plugSnap, Plug: plug}
+
+	if len(fields) == 2 {
+		slotSnap, slot, found := strings.Cut(fields[1], ":")
+		c.SlotSnap = slotSnap
+		if found {
+			if slot == "" {
+				return nil, fmt.Errorf("invalid slot '%s' in snap connection '%s'", fields[1], connection)
+			}
+			c.Slot = slot
+		}
+	}
+
+	if !snapNameRegex.MatchString(c.PlugSnap) {
+		return nil, fmt.Errorf("invalid snap name '%s' in snap connection '%s'", c.PlugSnap, connection)
+	}
+	if !interfaceNameRegex.MatchString(c.Plug) {
+		return nil, fmt.Errorf("invalid plug name '%s' in snap connection '%s'", c.Plug, connection)
+	}
+	if c.SlotSnap != "" && !snapNameRegex.MatchString(c.SlotSnap) {
+		return nil, fmt.Errorf("invalid snap name '%s' in snap connection '%s'", c.SlotSnap, connection)
+	}
+	if c.Slot != "" && !interfaceNameRegex.MatchString(c.Slot) {
+		return nil, fmt.Errorf("invalid slot name '%s' in snap connection '%s'", c.Slot, connection)
+	}
+
+	return c, nil
+}
+
+// String returns the connection in the form accepted by `snap connect`.
+func (c *SnapConnection) String() string {
+	slot := c.slotString()
+	if slot == "" {
+		return c.plugString()
+	}
+	return c.plugString() + " " + slot
+}
+
+// Op returns the snapd operation that connects (or disconnects) the plug and slot.
+func (c *SnapConnection) Op(action string) *SnapOp {
+	return &SnapOp{Action: action, Plug: c.plugString(), Slot: c.slotString()}
+}
+
+// Satisfies reports whether an established connection fulfils the requested
+// connection c, taking into account the slot details that c leaves to snapd.
+func (c *SnapConnection) Satisfies(established *SnapConnection) bool {
+	if c.PlugSnap != established.PlugSnap || c.Plug != established.Plug {
+		return false
+	}
+
+	if c.Slot != "" && c.Slot != established.Slot {
+		return false
+	}
+
+	switch {
+	case c.SlotSnap != "":
+		return c.SlotSnap == established.SlotSnap
+	case c.Slot != "":
+		return slices.Contains(systemSnapNames, established.SlotSnap)
+	default:
+		return true
+	}
+}
+
+func (c *SnapConnection) plugString() string {
+	return c.PlugSnap + ":" + c.Plug
+}
+
+func (c *SnapConnection) slotString() string {
+	if c.Slot == "" {
+		return c.SlotSnap
+	}
+	return c.SlotSnap + ":" + c.Slot
+}
+
+// SnapConnections returns the established connections involving the specified snap.
+// If the snap is not installed, an error wrapping ErrSnapNotInstalled is returned.
+func (s *System) SnapConnections(snap string) ([]*SnapConnection, error) {
+	result, err := s.snapd.Connections(context.Background(), snap)
+	if err != nil {
+		var apiErr *snapd.Error
+		if errors.As(err, &apiErr) && apiErr.Kind == snapd.ErrorKindSnapNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrSnapNotInstalled, snap)
+		}
+		return nil, fmt.Errorf("failed to query connections of snap '%s': %w", snap, err)
+	}
+
+	connections := []*SnapConnection{}
+	for _, c := range result.Established {
+		connections = append(connections, &SnapConnection{
+			PlugSnap: c.Plug.Snap,
+			Plug:     c.Plug.Plug,
+			SlotSnap: c.Slot.Snap,
+			Slot:     c.Slot.Slot,
+		})
+	}
+
+	return connections, nil
+}
@@ -0,0 +1,115 @@
+package system
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSnapConnection(t *testing.T) {
+	type test struct {
+		input     string
+		expected  *SnapConnection
+		expectErr bool
+	}
+
+	tests := []test{
+		{
+			input:    "jhack:dot-local-share-juju",
+			expected: &SnapConnection{PlugSnap: "jhack", Plug: "dot-local-share-juju"},
+		},
+		{
+			input:    "jhack:ssh-read  :ssh-keys",
+			expected: &SnapConnection{PlugSnap: "jhack", Plug: "ssh-read", Slot: "ssh-keys"},
+		},
+		{
+			input:    "charm:lxd lxd",
+			expected: &SnapConnection{PlugSnap: "charm", Plug: "lxd", SlotSnap: "lxd"},
+		},
+		{
+			input:    "k8s:kubeconfig k8s:kubeconfig-slot",
+			expected: &SnapConnection{PlugSnap: "k8s", Plug: "kubeconfig", SlotSnap: "k8s", Slot: "kubeconfig-slot"},
+		},
+		{input: "", expectErr: true},
+		{input: "jhack", expectErr: true},
+		{input: ":plug", expectErr: true},
+		{input: "jhack:", expectErr: true},
+		{input: "JHack:plug", expectErr: true},
+		{input: "jhack:plug_name", expectErr: true},
+		{input: "jhack:plug lxd:", expectErr: true},
+		{input: "jhack:plug lxd:lxd extra", expectErr: true},
+	}
+
+	for _, tc := range tests {
+		conn, err := ParseSnapConnection(tc.input)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("expected error for connection '%s', got: %v", tc.input, conn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for connection '%s': %v", tc.input, err)
+		}
+		if !reflect.DeepEqual(tc.expected, conn) {
+			t.Fatalf("expected: %v, got: %v", tc.expected, conn)
+		}
+	}
+}
+
+func TestSnapConnectionString(t *testing.T) {
+	for _, input := range []string{"jhack:dot-local-share-juju", "jhack:ssh-read :ssh-keys", "charm:lxd lxd", "k8s:a k8s:b"} {
+		conn, err := ParseSnapConnection(input)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if conn.String() != input {
+			t.Fatalf("expected: %v, got: %v", input, conn.String())
+		}
+	}
+}
+
+func TestSnapConnectionSatisfies(t *testing.T) {
+	type test struct {
+		requested   string
+		established *SnapConnection
+		expected    bool
+	}
+
+	tests := []test{
+		{
+			requested:   "jhack:dot-local-share-juju",
+			established: &SnapConnection{PlugSnap: "jhack", Plug: "dot-local-share-juju", SlotSnap: "snapd", Slot: "dot-local-share-juju"},
+			expected:    true,
+		},
+		{
+			requested:   "jhack:ssh-read :ssh-keys",
+			established: &SnapConnection{PlugSnap: "jhack", Plug: "ssh-read", SlotSnap: "core", Slot: "ssh-keys"},
+			expected:    true,
+		},
+		{
+			requested:   "jhack:ssh-read :ssh-keys",
+			established: &SnapConnection{PlugSnap: "jhack", Plug: "ssh-read", SlotSnap: "other", Slot: "ssh-keys"},
+			expected:    false,
+		},
+		{
+			requested:   "charm:lxd lxd",
+			established: &SnapConnection{PlugSnap: "charm", Plug: "lxd", SlotSnap: "lxd", Slot: "lxd"},
+			expected:    true,
+		},
+		{
+			requested:   "charm:lxd lxd",
+			established: &SnapConnection{PlugSnap: "charm", Plug: "other", SlotSnap: "lxd", Slot: "lxd"},
+			expected:    false,
+		},
+	}
+
+	for _, tc := range tests {
+		conn, err := ParseSnapConnection(tc.requested)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got := conn.Satisfies(tc.established); got != tc.expected {
+			t.Fatalf("%s satisfied by %v; expected: %v, got: %v", tc.requested, tc.established, tc.expected, got)
+		}
+	}
+}
@@ -14,6 +14,8 @@ func TestNewSnapFromString(t *testing.T) {
 		{input: "juju", expected: &Snap{Name: "juju"}},
 		{input: "juju/latest/edge", expected: &Snap{Name: "juju", Channel: "latest/edge"}},
 		{input: "juju/stable", expected: &Snap{Name: "juju", Channel: "stable"}},
+		{input: "lxd/5.21/stable@33110+hold", expected: &Snap{Name: "lxd", Channel: "5.21/stable", Revision: "33110", Hold: true}},
+		{input: "foo+devmode+hold", expected: &Snap{Name: "foo", Hold: true, Devmode: true}},
 	}
 
 	for _, tc := range tests {
@@ -25,5 +27,26 @@ func TestNewSnapFromString(t *testing.T) {
 		if tc.expected.Name != snap.Name {
 			t.Fatalf("incorrect snap name; expected: %v, got: %v", tc.expected, snap)
 		}
+		if tc.expected.Revision != snap.Revision || tc.expected.Hold != snap.Hold || tc.expected.Devmode != snap.Devmode {
+			t.Fatalf("incorrect snap options; expected: %v, got: %v", tc.expected, snap)
+		}
+	}
+}
+
+func TestNormalizeChannel(t *testing.T) {
+	tests := map[string]string{
+		"":                  "",
+		"stable":            "latest/stable",
+		"edge/fix-123":      "latest/edge/fix-123",
+		"5.21":              "5.21/stable",
+		"5.21/stable":       "5.21/stable",
+		"latest/edge":       "latest/edge",
+		"1.32-classic/beta": "1.32-classic/beta",
+	}
+
+	for input, expected := range tests {
+		if got := NormalizeChannel(input); got != expected {
+			t.Fatalf("expected: %v, got: %v", expected, got)
+		}
 	}
 }
@@ -0,0 +1,20 @@
+package system
+
+import "os/user"
+
+// NewUserWorker constructs a Worker that acts on behalf of the specified user.
+func NewUserWorker(w Worker, u *user.User) *UserWorker {
+	return &UserWorker{Worker: w, user: u}
+}
+
+// UserWorker runs everything through another Worker, but on behalf of a different
+// user, so that helpers such as WriteHomeDirFile act on that user's home directory.
+type UserWorker struct {
+	Worker
+	user *user.User
+}
+
+// User returns the user the worker acts on behalf of.
+func (w *UserWorker) User() *user.User {
+	return w.user
+}
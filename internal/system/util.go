@@ -41,11 +41,12 @@ func ansi(s, code string) string {
 }
 
 // generateTraceMessage creates a formatted string that is written to stdout, representing
-// a command and it's output when concierge is run with `--trace`.
+// a command and it's output when concierge is run with `--trace`. Both the command and its
+// output are redacted, since either may contain secrets.
 func generateTraceMessage(cmd string, output []byte) string {
-	result := fmt.Sprintf("%s %s\n", ansi("Command:", ansiBoldGreenUnderline), ansi(cmd, ansiBold))
+	result := fmt.Sprintf("%s %s\n", ansi("Command:", ansiBoldGreenUnderline), ansi(Redact(cmd), ansiBold))
 	if len(output) > 0 {
-		result = fmt.Sprintf("%s%s\n%s", result, ansi("Output:", ansiBoldGreenUnderline), string(output))
+		result = fmt.Sprintf("%s%s\n%s", result, ansi("Output:", ansiBoldGreenUnderline), Redact(string(output)))
 	}
 	return result
 }
@@ -85,14 +86,20 @@ func realUser() (*user.User, error) {
 		return user.Lookup("root")
 	}
 
-	u, err := user.Lookup(realUser)
+	return LookupUser(realUser)
+}
+
+// LookupUser looks up a user by name, falling back to `getent` for users that are
+// not in /etc/passwd.
+func LookupUser(username string) (*user.User, error) {
+	u, err := user.Lookup(username)
 	if err == nil {
 		return u, nil
 	}
 
 	var unknownUserErr user.UnknownUserError
 	if errors.As(err, &unknownUserErr) {
-		return lookupUserGetent(realUser)
+		return lookupUserGetent(username)
 	}
 
 	return nil, err
@@ -123,6 +130,24 @@ func lookupUserGetent(username string) (*user.User, error) {
 		return nil, fmt.Errorf("getent passwd %s: %w", username, err)
 	}
 
+	return parsePasswdEntry(username, out)
+}
+
+// LookupUserWith looks up a user with `getent passwd`, run by the worker, so that
+// users provided by NSS sources such as LDAP are found too.
+func LookupUserWith(w Worker, username string) (*user.User, error) {
+	cmd := NewCommand("getent", []string{"passwd", username})
+	cmd.ReadOnly = true
+	out, err := w.Run(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePasswdEntry(username, out)
+}
+
+// parsePasswdEntry parses the output of `getent passwd` for the named user.
+func parsePasswdEntry(username string, out []byte) (*user.User, error) {
 	// getent passwd format: username:password:uid:gid:gecos:home:shell
 	parts := strings.SplitN(strings.TrimSpace(string(out)), ":", 7)
 	if len(parts) < 6 {
@@ -68,3 +68,18 @@ func TestLookupUserGetentBinaryMissing(t *testing.T) {
 		t.Fatalf("missing binary should not surface as UnknownUserError, got %v", err)
 	}
 }
+
+func TestLookupUserWith(t *testing.T) {
+	r := NewMockSystem()
+	r.MockCommandReturn("getent passwd ci-runner", []byte("ci-runner:x:1001:1002:CI Runner:/home/ci-runner:/bin/bash\n"), nil)
+
+	got, err := LookupUserWith(r, "ci-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &user.User{Username: "ci-runner", Uid: "1001", Gid: "1002", Name: "CI Runner", HomeDir: "/home/ci-runner"}
+	if *got != *expected {
+		t.Fatalf("expected: %v, got: %v", expected, got)
+	}
+}